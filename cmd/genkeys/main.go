@@ -9,14 +9,22 @@ If run with the "-sig" flag, it generates signing keys instead.
 A "better" signing key means one with a higher TreeID.
 This only matters if it's high enough to make you the root of the tree.
 
+If run with the "-prefix" or "-regex" flags, it instead searches for a key
+whose IPv6 address is within the given prefix (e.g. 200:dead::/32) or matches
+the given regular expression, prints it and exits.
+
 */
 package main
 
 import (
+	"context"
 	"crypto/ed25519"
 	"encoding/hex"
+	"flag"
 	"fmt"
 	"net"
+	"os"
+	"regexp"
 	"runtime"
 
 	"github.com/yggdrasil-network/yggdrasil-go/src/address"
@@ -29,6 +37,30 @@ type keySet struct {
 
 func main() {
 	threads := runtime.GOMAXPROCS(0)
+	prefix := flag.String("prefix", "", "search for a key with an address in this prefix, e.g. 200:dead::/32")
+	pattern := flag.String("regex", "", "search for a key with an address matching this regular expression")
+	flag.Parse()
+	switch {
+	case *prefix != "":
+		_, snet, err := net.ParseCIDR(*prefix)
+		if err == nil {
+			err = checkPrefix(snet)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid prefix:", err)
+			os.Exit(1)
+		}
+		search(address.MatchPrefix(snet), threads)
+		return
+	case *pattern != "":
+		re, err := regexp.Compile(*pattern)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid regular expression:", err)
+			os.Exit(1)
+		}
+		search(address.MatchRegexp(re), threads)
+		return
+	}
 	var currentBest ed25519.PublicKey
 	newKeys := make(chan keySet, threads)
 	for i := 0; i < threads; i++ {
@@ -47,6 +79,36 @@ func main() {
 	}
 }
 
+// checkPrefix returns an error if no node address is in the prefix, as a
+// search for one would then never finish.
+func checkPrefix(prefix *net.IPNet) error {
+	ours := address.GetPrefix()
+	nodes := &net.IPNet{
+		IP:   make(net.IP, net.IPv6len),
+		Mask: net.CIDRMask(8*len(ours), 8*net.IPv6len),
+	}
+	copy(nodes.IP, ours[:])
+	if !nodes.Contains(prefix.IP) && !prefix.Contains(nodes.IP) {
+		return fmt.Errorf("%s is outside of %s, where the addresses of nodes are", prefix, nodes)
+	}
+	return nil
+}
+
+func search(match address.KeyMatcher, threads int) {
+	pub, priv, err := address.SearchKeys(context.Background(), match, threads, func(tried uint64) {
+		fmt.Fprintf(os.Stderr, "\rTried %d keys", tried)
+	})
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Search failed:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Priv:", hex.EncodeToString(priv))
+	fmt.Println("Pub:", hex.EncodeToString(pub))
+	addr := address.AddrForKey(pub)
+	fmt.Println("IP:", net.IP(addr[:]).String())
+}
+
 func isBetter(oldPub, newPub ed25519.PublicKey) bool {
 	for idx := range oldPub {
 		if newPub[idx] < oldPub[idx] {
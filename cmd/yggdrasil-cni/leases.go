@@ -0,0 +1,120 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// The lease file maps container IDs to the address allocated to them. It is
+// locked for the lifetime of the leases object, since the runtime may invoke
+// the plugin for several pods at the same time.
+type leases struct {
+	file   *os.File
+	byID   map[string]string
+	byAddr map[string]string
+}
+
+func openLeases(dir string) (*leases, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filepath.Join(dir, "leases.json"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Flock(int(file.Fd()), unix.LOCK_EX); err != nil {
+		file.Close()
+		return nil, err
+	}
+	l := &leases{
+		file:   file,
+		byID:   make(map[string]string),
+		byAddr: make(map[string]string),
+	}
+	bs, err := ioutil.ReadAll(file)
+	if err != nil {
+		l.close()
+		return nil, err
+	}
+	if len(bs) > 0 {
+		if err := json.Unmarshal(bs, &l.byID); err != nil {
+			l.close()
+			return nil, err
+		}
+	}
+	for id, addr := range l.byID {
+		l.byAddr[addr] = id
+	}
+	return l, nil
+}
+
+func (l *leases) close() {
+	_ = unix.Flock(int(l.file.Fd()), unix.LOCK_UN)
+	l.file.Close()
+}
+
+func (l *leases) save() error {
+	bs, err := json.MarshalIndent(l.byID, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := l.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := l.file.WriteAt(bs, 0); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+func (l *leases) lookup(containerID string) net.IP {
+	if addr, ok := l.byID[containerID]; ok {
+		return net.ParseIP(addr)
+	}
+	return nil
+}
+
+// allocate returns the address already leased to the container, or the
+// lowest free address in the subnet. Addresses ending ::0 and ::1 are never
+// handed out, as ::1 is commonly given to the host itself.
+func (l *leases) allocate(subnet *net.IPNet, containerID string) (net.IP, error) {
+	if ip := l.lookup(containerID); ip != nil {
+		if subnet.Contains(ip) {
+			return ip, nil
+		}
+		// The node's key has changed since the lease was made
+		delete(l.byAddr, ip.String())
+	}
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, subnet.IP.To16())
+	// Only the bottom 32 bits are searched, which is plenty for one host
+	for n := uint32(2); n != 0; n++ {
+		ip[12], ip[13], ip[14], ip[15] = byte(n>>24), byte(n>>16), byte(n>>8), byte(n)
+		if _, ok := l.byAddr[ip.String()]; ok {
+			continue
+		}
+		l.byID[containerID] = ip.String()
+		l.byAddr[ip.String()] = containerID
+		return ip, l.save()
+	}
+	return nil, errors.New("no free addresses in subnet")
+}
+
+func (l *leases) release(containerID string) error {
+	addr, ok := l.byID[containerID]
+	if !ok {
+		return nil
+	}
+	delete(l.byID, containerID)
+	delete(l.byAddr, addr)
+	return l.save()
+}
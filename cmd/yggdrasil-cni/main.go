@@ -0,0 +1,349 @@
+//go:build linux
+// +build linux
+
+/*
+This is a CNI plugin which connects Kubernetes (or any other CNI runtime) pods
+to the Yggdrasil network. Each pod is given an address from the /64 subnet of
+the Yggdrasil node running on the host, along with routes to 200::/7 through
+a veth pair, so pods can reach each other across clusters over the mesh.
+
+The plugin is configured with a network configuration such as:
+
+	{
+	  "cniVersion": "1.0.0",
+	  "name": "yggdrasil",
+	  "type": "yggdrasil-cni",
+	  "endpoint": "unix:///var/run/yggdrasil.sock",
+	  "mtu": 65535
+	}
+
+The node's subnet is found by asking the admin socket at the given endpoint.
+If the node has an AdminToken and the endpoint is over TCP, it must be given
+as "token", or in the YGGDRASIL_ADMIN_TOKEN environment variable.
+IPv6 forwarding must be enabled on the host, which the plugin does on ADD.
+*/
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/address"
+	"github.com/yggdrasil-network/yggdrasil-go/src/defaults"
+)
+
+const (
+	defaultDataDir = "/var/lib/cni/yggdrasil"
+	defaultMTU     = 1500
+)
+
+// The host side of every veth pair has this link-local address, which is the
+// next hop for all overlay routes inside the pod.
+var gatewayAddr = net.ParseIP("fe80::1")
+
+var supportedVersions = []string{"0.3.0", "0.3.1", "0.4.0", "1.0.0"}
+
+type netConf struct {
+	CNIVersion string `json:"cniVersion"`
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Endpoint   string `json:"endpoint"`
+	Token      string `json:"token"`
+	DataDir    string `json:"dataDir"`
+	MTU        int    `json:"mtu"`
+}
+
+type cniArgs struct {
+	command     string
+	containerID string
+	netns       string
+	ifname      string
+}
+
+type cniError struct {
+	CNIVersion string `json:"cniVersion"`
+	Code       int    `json:"code"`
+	Msg        string `json:"msg"`
+}
+
+func main() {
+	args := cniArgs{
+		command:     os.Getenv("CNI_COMMAND"),
+		containerID: os.Getenv("CNI_CONTAINERID"),
+		netns:       os.Getenv("CNI_NETNS"),
+		ifname:      os.Getenv("CNI_IFNAME"),
+	}
+	if args.command == "VERSION" {
+		_ = json.NewEncoder(os.Stdout).Encode(map[string]interface{}{
+			"cniVersion":        supportedVersions[len(supportedVersions)-1],
+			"supportedVersions": supportedVersions,
+		})
+		return
+	}
+	conf := &netConf{
+		Endpoint: defaults.GetDefaults().DefaultAdminListen,
+		Token:    os.Getenv("YGGDRASIL_ADMIN_TOKEN"),
+		DataDir:  defaultDataDir,
+		MTU:      defaultMTU,
+	}
+	stdin, err := ioutil.ReadAll(os.Stdin)
+	if err == nil {
+		err = json.Unmarshal(stdin, conf)
+	}
+	if err == nil {
+		switch args.command {
+		case "ADD":
+			err = cmdAdd(conf, args)
+		case "DEL":
+			err = cmdDel(conf, args)
+		case "CHECK":
+			err = cmdCheck(conf, args)
+		default:
+			err = fmt.Errorf("unknown CNI_COMMAND %q", args.command)
+		}
+	}
+	if err != nil {
+		_ = json.NewEncoder(os.Stdout).Encode(&cniError{
+			CNIVersion: conf.CNIVersion,
+			Code:       999,
+			Msg:        err.Error(),
+		})
+		os.Exit(1)
+	}
+}
+
+// hostVethName returns a stable name for the host side of the veth pair, so
+// that DEL can find it again from the container ID alone.
+func hostVethName(containerID string) string {
+	sum := sha256.Sum256([]byte(containerID))
+	return "ygg" + hex.EncodeToString(sum[:])[:12]
+}
+
+func cmdAdd(conf *netConf, args cniArgs) error {
+	if args.containerID == "" || args.netns == "" || args.ifname == "" {
+		return errors.New("CNI_CONTAINERID, CNI_NETNS and CNI_IFNAME are required")
+	}
+	subnet, err := nodeSubnet(conf.Endpoint, conf.Token)
+	if err != nil {
+		return err
+	}
+	leases, err := openLeases(filepath.Join(conf.DataDir, conf.Name))
+	if err != nil {
+		return err
+	}
+	defer leases.close()
+	ip, err := leases.allocate(subnet, args.containerID)
+	if err != nil {
+		return err
+	}
+	if err := setupVeth(conf, args, ip, subnet); err != nil {
+		_ = leases.release(args.containerID)
+		return err
+	}
+	_ = ioutil.WriteFile("/proc/sys/net/ipv6/conf/all/forwarding", []byte("1"), 0644)
+	return json.NewEncoder(os.Stdout).Encode(map[string]interface{}{
+		"cniVersion": conf.CNIVersion,
+		"interfaces": []map[string]interface{}{
+			{"name": hostVethName(args.containerID)},
+			{"name": args.ifname, "sandbox": args.netns},
+		},
+		"ips": []map[string]interface{}{
+			{"version": "6", "address": fmt.Sprintf("%s/128", ip), "gateway": gatewayAddr.String(), "interface": 1},
+		},
+		"routes": []map[string]interface{}{
+			{"dst": overlayPrefix().String(), "gw": gatewayAddr.String()},
+		},
+	})
+}
+
+func cmdDel(conf *netConf, args cniArgs) error {
+	// DEL must succeed even if ADD never completed, so missing state is fine
+	if link, err := netlink.LinkByName(hostVethName(args.containerID)); err == nil {
+		if err := netlink.LinkDel(link); err != nil {
+			return err
+		}
+	}
+	leases, err := openLeases(filepath.Join(conf.DataDir, conf.Name))
+	if err != nil {
+		return err
+	}
+	defer leases.close()
+	return leases.release(args.containerID)
+}
+
+func cmdCheck(conf *netConf, args cniArgs) error {
+	if _, err := netlink.LinkByName(hostVethName(args.containerID)); err != nil {
+		return fmt.Errorf("host interface is missing: %w", err)
+	}
+	leases, err := openLeases(filepath.Join(conf.DataDir, conf.Name))
+	if err != nil {
+		return err
+	}
+	defer leases.close()
+	if leases.lookup(args.containerID) == nil {
+		return errors.New("no address is allocated to this container")
+	}
+	return nil
+}
+
+func overlayPrefix() *net.IPNet {
+	prefix := address.GetPrefix()
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, prefix[:])
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(8*len(prefix)-1, 128)}
+}
+
+// setupVeth creates a veth pair, moves one end into the pod and configures
+// the address and routes on both ends.
+func setupVeth(conf *netConf, args cniArgs, ip net.IP, subnet *net.IPNet) error {
+	ns, err := netns.GetFromPath(args.netns)
+	if err != nil {
+		return err
+	}
+	defer ns.Close()
+	nsh, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		return err
+	}
+	defer nsh.Delete()
+
+	hostName := hostVethName(args.containerID)
+	peerName := "ygp" + strings.TrimPrefix(hostName, "ygg") // Renamed once in the pod
+	veth := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: hostName, MTU: conf.MTU},
+		PeerName:  peerName,
+	}
+	if err := netlink.LinkAdd(veth); err != nil {
+		return err
+	}
+	cleanup := func(err error) error {
+		_ = netlink.LinkDel(veth)
+		return err
+	}
+	host, err := netlink.LinkByName(hostName)
+	if err != nil {
+		return cleanup(err)
+	}
+	peer, err := netlink.LinkByName(peerName)
+	if err != nil {
+		return cleanup(err)
+	}
+	if err := netlink.LinkSetNsFd(peer, int(ns)); err != nil {
+		return cleanup(err)
+	}
+
+	// Host side of the pair
+	gateway := &netlink.Addr{
+		IPNet: &net.IPNet{IP: gatewayAddr, Mask: net.CIDRMask(64, 128)},
+		Flags: unix.IFA_F_NODAD,
+	}
+	if err := netlink.AddrAdd(host, gateway); err != nil {
+		return cleanup(err)
+	}
+	if err := netlink.LinkSetUp(host); err != nil {
+		return cleanup(err)
+	}
+
+	// Pod side of the pair
+	if peer, err = nsh.LinkByName(peerName); err != nil {
+		return cleanup(err)
+	}
+	if err := nsh.LinkSetName(peer, args.ifname); err != nil {
+		return cleanup(err)
+	}
+	podAddr := &netlink.Addr{
+		IPNet: &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)},
+		Flags: unix.IFA_F_NODAD,
+	}
+	if err := nsh.AddrAdd(peer, podAddr); err != nil {
+		return cleanup(err)
+	}
+	if err := nsh.LinkSetUp(peer); err != nil {
+		return cleanup(err)
+	}
+	for _, dst := range []*net.IPNet{overlayPrefix(), subnet} {
+		route := &netlink.Route{
+			LinkIndex: peer.Attrs().Index,
+			Dst:       dst,
+			Gw:        gatewayAddr,
+		}
+		if err := nsh.RouteAdd(route); err != nil {
+			return cleanup(err)
+		}
+	}
+
+	// Send traffic for the pod address from the TUN adapter to the pod
+	route := &netlink.Route{
+		LinkIndex: host.Attrs().Index,
+		Dst:       podAddr.IPNet,
+	}
+	if err := netlink.RouteAdd(route); err != nil {
+		return cleanup(err)
+	}
+	return nil
+}
+
+// nodeSubnet asks the local Yggdrasil node for its /64 subnet, answering the
+// challenge of the admin socket with the token if there is one.
+func nodeSubnet(endpoint, token string) (*net.IPNet, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	var conn net.Conn
+	switch strings.ToLower(u.Scheme) {
+	case "unix":
+		conn, err = net.DialTimeout("unix", endpoint[7:], 5*time.Second)
+	case "tcp":
+		conn, err = net.DialTimeout("tcp", u.Host, 5*time.Second)
+	default:
+		err = errors.New("admin endpoint protocol not supported")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't connect to Yggdrasil admin socket: %w", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	decoder, encoder := json.NewDecoder(conn), json.NewEncoder(conn)
+	send := map[string]interface{}{"request": "getSelf"}
+	if err := authenticate(decoder, encoder, token, send); err != nil {
+		return nil, fmt.Errorf("can't authenticate with the admin socket: %w", err)
+	}
+	if err := encoder.Encode(send); err != nil {
+		return nil, err
+	}
+	var recv struct {
+		Status   string `json:"status"`
+		Response struct {
+			Error string `json:"error"`
+			Self  map[string]struct {
+				Subnet string `json:"subnet"`
+			} `json:"self"`
+		} `json:"response"`
+	}
+	if err := decoder.Decode(&recv); err != nil {
+		return nil, err
+	}
+	if recv.Status != "success" {
+		return nil, fmt.Errorf("admin socket returned an error: %s", recv.Response.Error)
+	}
+	for _, self := range recv.Response.Self {
+		_, subnet, err := net.ParseCIDR(self.Subnet)
+		return subnet, err
+	}
+	return nil, errors.New("admin socket did not return a subnet")
+}
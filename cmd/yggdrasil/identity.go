@@ -0,0 +1,31 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+
+	"github.com/hjson/hjson-go/v4"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/admin"
+)
+
+// identityPersister returns the function that writes an identity imported
+// over the admin socket to the config file, replacing PrivateKey and, if the
+// file has it, PublicKey. The running node keeps its own key until it is
+// restarted.
+func (n *node) identityPersister() admin.IdentityPersister {
+	return func(secret ed25519.PrivateKey) error {
+		return n.editConfig(func(root *hjson.Node) error {
+			if _, _, err := root.SetKey("PrivateKey", hex.EncodeToString(secret)); err != nil {
+				return err
+			}
+			if root.NK("PublicKey") != nil {
+				pub := secret.Public().(ed25519.PublicKey)
+				if _, _, err := root.SetKey("PublicKey", hex.EncodeToString(pub)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gologme/log"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/core"
+)
+
+// jsonLogWriter writes each line of the log as a JSON object, for -logformat
+// json. The events about links are written with their fields as well.
+type jsonLogWriter struct {
+	mutex sync.Mutex // protects out
+	out   io.Writer
+}
+
+// jsonLogLine is a line of the log, where the link fields are only set for the
+// events about links.
+type jsonLogLine struct {
+	Time      string `json:"time"`
+	Level     string `json:"level,omitempty"`
+	Message   string `json:"message"`
+	Event     string `json:"event,omitempty"`
+	LinkType  string `json:"linkType,omitempty"`
+	Local     string `json:"local,omitempty"`
+	Remote    string `json:"remote,omitempty"`
+	Key       string `json:"key,omitempty"`
+	Direction string `json:"direction,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+func (w *jsonLogWriter) write(line jsonLogLine) error {
+	line.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	bs, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	_, err = w.out.Write(append(bs, '\n'))
+	return err
+}
+
+// Write is called by the logger with each line, without a timestamp.
+func (w *jsonLogWriter) Write(p []byte) (int, error) {
+	for _, message := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		if err := w.write(jsonLogLine{Message: string(message)}); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// linkLogger returns the logger for the events about links, which only writes
+// those at the levels that are enabled on the logger.
+func (w *jsonLogWriter) linkLogger(logger *log.Logger) core.LinkLogger {
+	return func(e core.LinkLogEvent) {
+		if !logger.GetLevel(e.Level) {
+			return
+		}
+		_ = w.write(jsonLogLine{
+			Level:     e.Level,
+			Message:   e.Message,
+			Event:     e.Event,
+			LinkType:  e.LinkType,
+			Local:     e.Local,
+			Remote:    e.Remote,
+			Key:       e.Key,
+			Direction: e.Direction,
+			Error:     e.Error,
+		})
+	}
+}
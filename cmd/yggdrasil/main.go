@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"os"
@@ -19,7 +20,7 @@ import (
 
 	"github.com/gologme/log"
 	gsyslog "github.com/hashicorp/go-syslog"
-	"github.com/hjson/hjson-go"
+	"github.com/hjson/hjson-go/v4"
 	"github.com/kardianos/minwinsvc"
 	"github.com/mitchellh/mapstructure"
 
@@ -29,8 +30,12 @@ import (
 	"github.com/yggdrasil-network/yggdrasil-go/src/defaults"
 
 	"github.com/yggdrasil-network/yggdrasil-go/src/core"
+	"github.com/yggdrasil-network/yggdrasil-go/src/dhcpv6pd"
 	"github.com/yggdrasil-network/yggdrasil-go/src/ipv6rwc"
+	"github.com/yggdrasil-network/yggdrasil-go/src/metrics"
 	"github.com/yggdrasil-network/yggdrasil-go/src/multicast"
+	"github.com/yggdrasil-network/yggdrasil-go/src/radv"
+	"github.com/yggdrasil-network/yggdrasil-go/src/stats"
 	"github.com/yggdrasil-network/yggdrasil-go/src/tuntap"
 	"github.com/yggdrasil-network/yggdrasil-go/src/version"
 )
@@ -41,6 +46,14 @@ type node struct {
 	tuntap    *tuntap.TunAdapter
 	multicast *multicast.Multicast
 	admin     *admin.AdminSocket
+	dhcpv6pd  *dhcpv6pd.Server
+	radv      *radv.Advertiser
+	stats     *stats.Reporter
+	metrics   *metrics.Exporter
+	stopTrace func() // Flushes the traces, see tracing.go
+
+	configFile string // Path to reload the config from, if any
+	reloaded   reloadStatus
 }
 
 func readConfig(log *log.Logger, useconf bool, useconffile string, normaliseconf bool) *config.NodeConfig {
@@ -59,17 +72,27 @@ func readConfig(log *log.Logger, useconf bool, useconffile string, normaliseconf
 	if err != nil {
 		panic(err)
 	}
+	cfg, err := parseConfig(log, conf)
+	if err != nil {
+		panic(err)
+	}
+	return cfg
+}
+
+// parseConfig decodes an HJSON or JSON config, updating any old field names.
+func parseConfig(log *log.Logger, conf []byte) (*config.NodeConfig, error) {
+	var err error
 	// If there's a byte order mark - which Windows 10 is now incredibly fond of
 	// throwing everywhere when it's converting things into UTF-16 for the hell
 	// of it - remove it and decode back down into UTF-8. This is necessary
 	// because hjson doesn't know what to do with UTF-16 and will panic
-	if bytes.Equal(conf[0:2], []byte{0xFF, 0xFE}) ||
-		bytes.Equal(conf[0:2], []byte{0xFE, 0xFF}) {
+	if len(conf) >= 2 && (bytes.Equal(conf[0:2], []byte{0xFF, 0xFE}) ||
+		bytes.Equal(conf[0:2], []byte{0xFE, 0xFF})) {
 		utf := unicode.UTF16(unicode.BigEndian, unicode.UseBOM)
 		decoder := utf.NewDecoder()
 		conf, err = decoder.Bytes(conf)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 	}
 	// Generate a new configuration - this gives us a set of sane defaults -
@@ -79,7 +102,7 @@ func readConfig(log *log.Logger, useconf bool, useconffile string, normaliseconf
 	cfg := defaults.GenerateConfig()
 	var dat map[string]interface{}
 	if err := hjson.Unmarshal(conf, &dat); err != nil {
-		panic(err)
+		return nil, err
 	}
 	// Check if we have old field names
 	if _, ok := dat["TunnelRouting"]; ok {
@@ -126,17 +149,17 @@ func readConfig(log *log.Logger, useconf bool, useconffile string, normaliseconf
 	// Sanitise the config
 	confJson, err := json.Marshal(dat)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	if err := json.Unmarshal(confJson, &cfg); err != nil {
-		panic(err)
+		return nil, err
 	}
 	// Overlay our newly mapped configuration onto the autoconf node config that
 	// we generated above.
 	if err = mapstructure.Decode(dat, &cfg); err != nil {
-		panic(err)
+		return nil, err
 	}
-	return cfg
+	return cfg, nil
 }
 
 // Generates a new configuration and returns it in HJSON format. This is used
@@ -191,9 +214,11 @@ type yggArgs struct {
 	ver           bool
 	getaddr       bool
 	getsnet       bool
+	exportenv     bool
 	useconffile   string
 	logto         string
 	loglevel      string
+	logformat     string
 }
 
 func getArgs() yggArgs {
@@ -207,7 +232,9 @@ func getArgs() yggArgs {
 	logto := flag.String("logto", "stdout", "file path to log to, \"syslog\" or \"stdout\"")
 	getaddr := flag.Bool("address", false, "returns the IPv6 address as derived from the supplied configuration")
 	getsnet := flag.Bool("subnet", false, "returns the IPv6 subnet as derived from the supplied configuration")
+	exportenv := flag.Bool("exportenv", false, "prints the IPv6 address, subnet and public key derived from the supplied configuration as shell variables, or as JSON with -json")
 	loglevel := flag.String("loglevel", "info", "loglevel to enable")
+	logformat := flag.String("logformat", "text", "log format, \"text\" or \"json\" for a JSON object per line")
 	flag.Parse()
 	return yggArgs{
 		genconf:       *genconf,
@@ -220,31 +247,47 @@ func getArgs() yggArgs {
 		logto:         *logto,
 		getaddr:       *getaddr,
 		getsnet:       *getsnet,
+		exportenv:     *exportenv,
 		loglevel:      *loglevel,
+		logformat:     *logformat,
 	}
 }
 
 // The main function is responsible for configuring and starting Yggdrasil.
-func run(args yggArgs, ctx context.Context, done chan struct{}) {
+func run(args yggArgs, ctx context.Context, reload <-chan os.Signal, done chan struct{}) {
 	defer close(done)
 	// Create a new logger that logs output to stdout.
-	var logger *log.Logger
+	var out io.Writer
 	switch args.logto {
 	case "stdout":
-		logger = log.New(os.Stdout, "", log.Flags())
+		out = os.Stdout
 	case "syslog":
 		if syslogger, err := gsyslog.NewLogger(gsyslog.LOG_NOTICE, "DAEMON", version.BuildName()); err == nil {
-			logger = log.New(syslogger, "", log.Flags())
+			out = syslogger
 		}
 	default:
 		if logfd, err := os.OpenFile(args.logto, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err == nil {
-			logger = log.New(logfd, "", log.Flags())
+			out = logfd
 		}
 	}
-	if logger == nil {
-		logger = log.New(os.Stdout, "", log.Flags())
+	defaulted := out == nil
+	if defaulted {
+		out = os.Stdout
+	}
+	var jsonLog *jsonLogWriter
+	flags := log.Flags()
+	if args.logformat == "json" {
+		// The JSON has a timestamp of its own
+		jsonLog, flags = &jsonLogWriter{out: out}, 0
+		out = jsonLog
+	}
+	logger := log.New(out, "", flags)
+	if defaulted {
 		logger.Warnln("Logging defaulting to stdout")
 	}
+	if args.logformat != "text" && args.logformat != "json" {
+		logger.Warnln("Unknown log format", args.logformat, "so logging as text")
+	}
 
 	if args.normaliseconf {
 		setLogLevel("error", logger)
@@ -322,12 +365,26 @@ func run(args yggArgs, ctx context.Context, done chan struct{}) {
 			fmt.Println(ipnet.String())
 		}
 		return
+	case args.exportenv:
+		if key := getNodeKey(); key != nil {
+			fmt.Println(doExportEnv(key, args.confjson))
+		}
+		return
 	default:
 	}
 
 	// Setup the Yggdrasil node itself. The node{} type includes a Core, so we
 	// don't need to create this manually.
-	n := node{config: cfg}
+	n := node{config: cfg, configFile: args.useconffile}
+	var linkLogger core.LinkLogger
+	if jsonLog != nil {
+		linkLogger = jsonLog.linkLogger(logger)
+		n.core.SetLinkLogger(linkLogger)
+	}
+	if n.stopTrace, err = startTracing(cfg, logger); err != nil {
+		logger.Errorln("An error occurred starting tracing:", err)
+		n.stopTrace = func() {}
+	}
 	// Now start Yggdrasil - this starts the DHT, router, switch and other core
 	// components needed for Yggdrasil to operate
 	if err = n.core.Start(cfg, logger); err != nil {
@@ -339,6 +396,10 @@ func run(args yggArgs, ctx context.Context, done chan struct{}) {
 	n.admin = &admin.AdminSocket{}
 	n.multicast = &multicast.Multicast{}
 	n.tuntap = &tuntap.TunAdapter{}
+	n.dhcpv6pd = &dhcpv6pd.Server{}
+	n.radv = &radv.Advertiser{}
+	n.stats = &stats.Reporter{}
+	n.metrics = &metrics.Exporter{}
 	// Start the admin socket
 	if err := n.admin.Init(&n.core, cfg, logger, nil); err != nil {
 		logger.Errorln("An error occurred initialising admin socket:", err)
@@ -346,6 +407,8 @@ func run(args yggArgs, ctx context.Context, done chan struct{}) {
 		logger.Errorln("An error occurred starting admin socket:", err)
 	}
 	n.admin.SetupAdminHandlers(n.admin)
+	// Tell admin subscribers about links that fail, as well as logging them
+	n.core.SetLinkLogger(n.admin.LinkLogger(linkLogger))
 	// Start the multicast interface
 	if err := n.multicast.Init(&n.core, cfg, logger, nil); err != nil {
 		logger.Errorln("An error occurred initialising multicast:", err)
@@ -361,6 +424,35 @@ func run(args yggArgs, ctx context.Context, done chan struct{}) {
 		logger.Errorln("An error occurred starting TUN/TAP:", err)
 	}
 	n.tuntap.SetupAdminHandlers(n.admin)
+	// Start the DHCPv6 prefix delegation server
+	if err := n.dhcpv6pd.Init(&n.core, cfg, logger, nil); err != nil {
+		logger.Errorln("An error occurred initialising DHCPv6 prefix delegation:", err)
+	} else if err := n.dhcpv6pd.Start(); err != nil {
+		logger.Errorln("An error occurred starting DHCPv6 prefix delegation:", err)
+	}
+	n.dhcpv6pd.SetupAdminHandlers(n.admin)
+	// Start sending router advertisements
+	if err := n.radv.Init(&n.core, cfg, logger, nil); err != nil {
+		logger.Errorln("An error occurred initialising router advertisements:", err)
+	} else if err := n.radv.Start(); err != nil {
+		logger.Errorln("An error occurred starting router advertisements:", err)
+	}
+	// Start sending anonymous statistics, if enabled
+	if err := n.stats.Init(&n.core, cfg, logger, nil); err != nil {
+		logger.Errorln("An error occurred initialising statistics reporting:", err)
+	} else if err := n.stats.Start(); err != nil {
+		logger.Errorln("An error occurred starting statistics reporting:", err)
+	}
+	n.stats.SetupAdminHandlers(n.admin)
+	// Serve Prometheus metrics, if enabled
+	if err := n.metrics.Init(&n.core, cfg, logger, nil); err != nil {
+		logger.Errorln("An error occurred initialising metrics:", err)
+	} else if err := n.metrics.Start(); err != nil {
+		logger.Errorln("An error occurred starting metrics:", err)
+	}
+	n.setupReloadHandlers(logger)
+	n.admin.SetPeerPersister(n.peerPersister())
+	n.admin.SetIdentityPersister(n.identityPersister())
 	// Make some nice output that tells us what our IPv6 address and subnet are.
 	// This is just logged to stdout for the user.
 	address := n.core.Address()
@@ -369,30 +461,77 @@ func run(args yggArgs, ctx context.Context, done chan struct{}) {
 	logger.Infof("Your public key is %s", hex.EncodeToString(public[:]))
 	logger.Infof("Your IPv6 address is %s", address.String())
 	logger.Infof("Your IPv6 subnet is %s", subnet.String())
-	// Catch interrupts from the operating system to exit gracefully.
-	<-ctx.Done()
+	// Catch interrupts from the operating system to exit gracefully, and
+	// reload the config on SIGHUP.
+	for running := true; running; {
+		select {
+		case <-ctx.Done():
+			running = false
+		case <-reload:
+			_, _ = n.reload(logger)
+		}
+	}
 	// Capture the service being stopped on Windows.
 	minwinsvc.SetOnExit(n.shutdown)
 	n.shutdown()
 }
 
+// doExportEnv formats the identity of the node so that it can be consumed by
+// scripts, e.g. with eval "$(yggdrasil -useconffile ... -exportenv)".
+func doExportEnv(key ed25519.PublicKey, isjson bool) string {
+	addr := address.AddrForKey(key)
+	snet := address.SubnetForKey(key)
+	ipnet := net.IPNet{
+		IP:   append(snet[:], 0, 0, 0, 0, 0, 0, 0, 0),
+		Mask: net.CIDRMask(len(snet)*8, 128),
+	}
+	env := [][2]string{
+		{"YGGDRASIL_ADDRESS", net.IP(addr[:]).String()},
+		{"YGGDRASIL_SUBNET", ipnet.String()},
+		{"YGGDRASIL_PUBLIC_KEY", hex.EncodeToString(key)},
+	}
+	if isjson {
+		m := make(map[string]string, len(env))
+		for _, kv := range env {
+			m[kv[0]] = kv[1]
+		}
+		bs, err := json.MarshalIndent(m, "", "  ")
+		if err != nil {
+			panic(err)
+		}
+		return string(bs)
+	}
+	var lines []string
+	for _, kv := range env {
+		lines = append(lines, fmt.Sprintf("export %s=%s", kv[0], kv[1]))
+	}
+	return strings.Join(lines, "\n")
+}
+
 func (n *node) shutdown() {
 	_ = n.admin.Stop()
 	_ = n.multicast.Stop()
 	_ = n.tuntap.Stop()
+	_ = n.dhcpv6pd.Stop()
+	_ = n.radv.Stop()
+	_ = n.stats.Stop()
+	_ = n.metrics.Stop()
 	n.core.Stop()
+	n.stopTrace()
 }
 
 func main() {
 	args := getArgs()
 	hup := make(chan os.Signal, 1)
 	//signal.Notify(hup, os.Interrupt, syscall.SIGHUP)
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
 	term := make(chan os.Signal, 1)
 	signal.Notify(term, os.Interrupt, syscall.SIGTERM)
 	for {
 		done := make(chan struct{})
 		ctx, cancel := context.WithCancel(context.Background())
-		go run(args, ctx, done)
+		go run(args, ctx, reload, done)
 		select {
 		case <-hup:
 			cancel()
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/gologme/log"
+	"github.com/hjson/hjson-go/v4"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/admin"
+)
+
+// peerPersister returns the function that writes the peers which are added or
+// removed over the admin socket to the config file, so that the changes last
+// past a restart. Only Peers and InterfacePeers are changed, and the rest of
+// the file, including its comments and the order of its settings, is left as
+// it was.
+func (n *node) peerPersister() admin.PeerPersister {
+	return func(uri, intf string, add bool) error {
+		return n.editConfig(func(root *hjson.Node) error {
+			if add {
+				peers := root.NKC("Peers")
+				if intf != "" {
+					peers = root.NKC("InterfacePeers").NKC(intf)
+				}
+				if peers == nil {
+					return errors.New("the peers in the config are not a list")
+				}
+				for i := 0; i < peers.Len(); i++ {
+					if peer, ok := peers.NI(i).Value.(string); ok && samePeerURI(peer, uri) {
+						return nil
+					}
+				}
+				if peers.Value == nil {
+					peers.Value = []interface{}{}
+				}
+				return peers.Append(uri)
+			}
+			remove := func(peers *hjson.Node) error {
+				for i := peers.Len() - 1; i >= 0; i-- {
+					if peer, ok := peers.NI(i).Value.(string); ok && samePeerURI(peer, uri) {
+						if _, _, err := peers.DeleteIndex(i); err != nil {
+							return err
+						}
+					}
+				}
+				return nil
+			}
+			if peers := root.NK("Peers"); peers != nil {
+				if err := remove(peers); err != nil {
+					return err
+				}
+			}
+			if intfs := root.NK("InterfacePeers"); intfs != nil {
+				for i := intfs.Len() - 1; i >= 0; i-- {
+					peers := intfs.NI(i)
+					if err := remove(peers); err != nil {
+						return err
+					}
+					if peers.Len() == 0 {
+						if _, _, err := intfs.DeleteIndex(i); err != nil {
+							return err
+						}
+					}
+				}
+			}
+			return nil
+		})
+	}
+}
+
+// editConfig reads the config file, lets edit change its settings and then
+// writes it back, in HJSON or JSON as it was before. Since the file is edited
+// as a tree of HJSON nodes rather than parsed into a NodeConfig, everything
+// that edit doesn't change is kept, including comments, though lists are
+// written with one item on each line. The edited config must still be valid,
+// and it replaces the old one in a single rename, so that the file is never
+// left half written.
+func (n *node) editConfig(edit func(root *hjson.Node) error) error {
+	if n.configFile == "" {
+		return errors.New("the config can only be written when started with -useconffile")
+	}
+	conf, err := ioutil.ReadFile(n.configFile)
+	if err != nil {
+		return err
+	}
+	var root hjson.Node
+	if err := hjson.Unmarshal(conf, &root); err != nil {
+		return err
+	}
+	if _, ok := root.Value.(*hjson.OrderedMap); !ok {
+		return errors.New("the config is not an object")
+	}
+	if err := edit(&root); err != nil {
+		return err
+	}
+	var bs []byte
+	if json.Valid(conf) {
+		bs, err = json.MarshalIndent(root, "", "  ")
+	} else {
+		bs, err = hjson.Marshal(root)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := parseConfig(log.New(ioutil.Discard, "", 0), bs); err != nil {
+		return fmt.Errorf("the edited config is not valid: %w", err)
+	}
+	return writeFileAtomic(n.configFile, append(bytes.TrimRight(bs, "\r\n"), '\n'))
+}
+
+// samePeerURI returns true if the two peer URIs are the same once parsed.
+func samePeerURI(a, b string) bool {
+	if a == b {
+		return true
+	}
+	ua, erra := url.Parse(a)
+	ub, errb := url.Parse(b)
+	return erra == nil && errb == nil && ua.String() == ub.String()
+}
+
+// writeFileAtomic replaces the file at the path, or at the target of the path
+// if it is a symlink, with the data. The data is written to a temporary file
+// in the same directory, with the same permissions, which is then renamed
+// over the old file.
+func writeFileAtomic(path string, data []byte) error {
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // Does nothing once it has been renamed
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gologme/log"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/admin"
+)
+
+// reloadStatus is the result of the last attempt to reload the config, which
+// is reported by the getReloadStatus admin call.
+type reloadStatus struct {
+	mutex   sync.Mutex // protects the below
+	when    time.Time
+	err     error
+	restart []string
+}
+
+type ReloadConfigRequest struct{}
+type ReloadConfigResponse struct {
+	RestartRequired []string `json:"restart_required"`
+}
+
+type GetReloadStatusRequest struct{}
+type GetReloadStatusResponse struct {
+	Reloaded        bool      `json:"reloaded"`
+	Time            time.Time `json:"time,omitempty"`
+	Success         bool      `json:"success"`
+	Error           string    `json:"error,omitempty"`
+	RestartRequired []string  `json:"restart_required"`
+}
+
+// reload reads the config file again and applies it to the running node. If
+// it can't be read or applied, then the node keeps running with the config it
+// already had. The names of any changed options which need a restart to take
+// effect are returned.
+func (n *node) reload(logger *log.Logger) ([]string, error) {
+	restart, err := n.reconfigure(logger)
+	n.reloaded.mutex.Lock()
+	n.reloaded.when = time.Now()
+	n.reloaded.err = err
+	n.reloaded.restart = restart
+	n.reloaded.mutex.Unlock()
+	n.admin.Publish(admin.EventConfigReloaded, n.reloadStatus())
+	switch {
+	case err != nil:
+		logger.Errorln("Failed to reload config, keeping the previous config:", err)
+	case len(restart) > 0:
+		logger.Warnln("Reloaded config, but a restart is needed for changes to:", strings.Join(restart, ", "))
+	default:
+		logger.Infoln("Reloaded config")
+	}
+	return restart, err
+}
+
+func (n *node) reconfigure(logger *log.Logger) ([]string, error) {
+	if n.configFile == "" {
+		return nil, errors.New("the config can only be reloaded when started with -useconffile")
+	}
+	conf, err := ioutil.ReadFile(n.configFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := parseConfig(logger, conf)
+	if err != nil {
+		return nil, err
+	}
+	return n.core.Reconfigure(cfg)
+}
+
+func (n *node) setupReloadHandlers(logger *log.Logger) {
+	_ = n.admin.AddHandler("reloadConfig", []string{}, func(in json.RawMessage) (interface{}, error) {
+		req := &ReloadConfigRequest{}
+		res := &ReloadConfigResponse{}
+		if err := json.Unmarshal(in, &req); err != nil {
+			return nil, err
+		}
+		restart, err := n.reload(logger)
+		if err != nil {
+			return nil, err
+		}
+		res.RestartRequired = append([]string{}, restart...)
+		return res, nil
+	})
+	_ = n.admin.AddHandler("getReloadStatus", []string{}, func(in json.RawMessage) (interface{}, error) {
+		req := &GetReloadStatusRequest{}
+		res := &GetReloadStatusResponse{}
+		if err := json.Unmarshal(in, &req); err != nil {
+			return nil, err
+		}
+		*res = *n.reloadStatus()
+		return res, nil
+	})
+}
+
+// reloadStatus returns the result of the last attempt to reload the config.
+func (n *node) reloadStatus() *GetReloadStatusResponse {
+	n.reloaded.mutex.Lock()
+	defer n.reloaded.mutex.Unlock()
+	res := &GetReloadStatusResponse{}
+	res.Reloaded = !n.reloaded.when.IsZero()
+	res.Time = n.reloaded.when
+	res.Success = res.Reloaded && n.reloaded.err == nil
+	if n.reloaded.err != nil {
+		res.Error = n.reloaded.err.Error()
+	}
+	res.RestartRequired = append([]string{}, n.reloaded.restart...)
+	return res
+}
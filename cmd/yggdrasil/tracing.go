@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/gologme/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/config"
+	"github.com/yggdrasil-network/yggdrasil-go/src/version"
+)
+
+const traceShutdownTimeout = 5 * time.Second // How long to wait for the last spans to be sent
+
+// startTracing sends the traces of the node to the TraceEndpoint from the
+// config, if there is one, and returns the function that flushes them when
+// the node stops.
+func startTracing(cfg *config.NodeConfig, logger *log.Logger) (func(), error) {
+	if cfg.TraceEndpoint == "" {
+		return func() {}, nil
+	}
+	u, err := url.Parse(cfg.TraceEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TraceEndpoint: %w", err)
+	}
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host)}
+	switch u.Scheme {
+	case "http":
+		options = append(options, otlptracehttp.WithInsecure())
+	case "https":
+	default:
+		return nil, fmt.Errorf("TraceEndpoint must be an HTTP or HTTPS URL, not %q", cfg.TraceEndpoint)
+	}
+	if u.Path != "" && u.Path != "/" {
+		options = append(options, otlptracehttp.WithURLPath(u.Path))
+	}
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", "yggdrasil"),
+			attribute.String("service.version", version.BuildVersion()),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	logger.Infoln("Sending traces to", cfg.TraceEndpoint)
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), traceShutdownTimeout)
+		defer cancel()
+		_ = provider.Shutdown(ctx)
+	}, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// authenticate asks the admin socket for a challenge, if there is a token,
+// and adds the answer to the request, which must then be sent on the same
+// connection. The token itself is never sent.
+func authenticate(decoder *json.Decoder, encoder *json.Encoder, token string, send admin_info) error {
+	if token == "" {
+		return nil
+	}
+	if err := encoder.Encode(admin_info{"request": "challenge", "keepalive": true}); err != nil {
+		return err
+	}
+	var recv struct {
+		Status   string `json:"status"`
+		Response struct {
+			Nonce string `json:"nonce"`
+			Error string `json:"error"`
+		} `json:"response"`
+	}
+	if err := decoder.Decode(&recv); err != nil {
+		return err
+	}
+	if recv.Status != "success" {
+		return fmt.Errorf("can't get a challenge: %s", recv.Response.Error)
+	}
+	nonce, err := hex.DecodeString(recv.Response.Nonce)
+	if err != nil || len(nonce) == 0 {
+		return errors.New("malformed challenge")
+	}
+	mac := hmac.New(sha256.New, []byte(token))
+	_, _ = mac.Write(nonce)
+	send["hmac"] = hex.EncodeToString(mac.Sum(nil))
+	return nil
+}
@@ -7,8 +7,9 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"time"
 
-	"github.com/hjson/hjson-go"
+	"github.com/hjson/hjson-go/v4"
 	"golang.org/x/text/encoding/unicode"
 
 	"github.com/yggdrasil-network/yggdrasil-go/src/defaults"
@@ -17,7 +18,9 @@ import (
 type CmdLineEnv struct {
 	args                 []string
 	endpoint, server     string
+	token                string
 	injson, verbose, ver bool
+	watch                time.Duration
 }
 
 func newCmdLineEnv() CmdLineEnv {
@@ -35,6 +38,9 @@ func (cmdLineEnv *CmdLineEnv) parseFlagsAndArgs() {
 		fmt.Println("Please note that options must always specified BEFORE the command\non the command line or they will be ignored.")
 		fmt.Println()
 		fmt.Println("Commands:\n  - Use \"list\" for a list of available commands")
+		fmt.Println("  - Shortcuts: self, peers, sessions, paths (or routes), dht, tun, multicast")
+		fmt.Println("  - Use \"healthcheck\" to exit non-zero if the node is not responding, e.g. in a container")
+		fmt.Println("  - Use \"subscribe\" to print events such as peers connecting as they happen")
 		fmt.Println()
 		fmt.Println("Examples:")
 		fmt.Println("  - ", os.Args[0], "list")
@@ -43,20 +49,28 @@ func (cmdLineEnv *CmdLineEnv) parseFlagsAndArgs() {
 		fmt.Println("  - ", os.Args[0], "setTunTap name=auto mtu=1500 tap_mode=false")
 		fmt.Println("  - ", os.Args[0], "-endpoint=tcp://localhost:9001 getDHT")
 		fmt.Println("  - ", os.Args[0], "-endpoint=unix:///var/run/ygg.sock getDHT")
+		fmt.Println("  - ", os.Args[0], "-endpoint=tcp://10.0.0.1:9001 -token=secret getPeers")
+		fmt.Println("  - ", os.Args[0], "-watch=2s peers")
+		fmt.Println("  - ", os.Args[0], "healthcheck min_peers=1")
+		fmt.Println("  - ", os.Args[0], "subscribe events=peer_up,peer_down")
 	}
 
 	server := flag.String("endpoint", cmdLineEnv.endpoint, "Admin socket endpoint")
 	injson := flag.Bool("json", false, "Output in JSON format (as opposed to pretty-print)")
 	verbose := flag.Bool("v", false, "Verbose output (includes public keys)")
 	ver := flag.Bool("version", false, "Prints the version of this build")
+	token := flag.String("token", os.Getenv("YGGDRASIL_ADMIN_TOKEN"), "AdminToken of the node, if it has one (default $YGGDRASIL_ADMIN_TOKEN)")
+	watch := flag.Duration("watch", 0, "Repeat the command at this interval, e.g. 2s, to show live stats")
 
 	flag.Parse()
 
 	cmdLineEnv.args = flag.Args()
 	cmdLineEnv.server = *server
+	cmdLineEnv.token = *token
 	cmdLineEnv.injson = *injson
 	cmdLineEnv.verbose = *verbose
 	cmdLineEnv.ver = *ver
+	cmdLineEnv.watch = *watch
 }
 
 func (cmdLineEnv *CmdLineEnv) setEndpoint(logger *log.Logger) {
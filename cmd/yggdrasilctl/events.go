@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// subscribeArgs turns the events=a,b argument of a subscribe request into the
+// list that the admin socket expects.
+func subscribeArgs(send admin_info) {
+	if events, ok := send["events"].(string); ok {
+		send["events"] = strings.Split(events, ",")
+	}
+}
+
+// streamEvents prints the events that follow the response to a subscribe
+// request, one per line, until the connection is closed.
+func streamEvents(decoder *json.Decoder, injson bool) int {
+	for {
+		var e struct {
+			Event string          `json:"event"`
+			Time  time.Time       `json:"time"`
+			Data  json.RawMessage `json:"data"`
+		}
+		if err := decoder.Decode(&e); err != nil {
+			fmt.Println("Event stream closed:", err)
+			return 1
+		}
+		if injson {
+			if bs, err := json.Marshal(e); err == nil {
+				fmt.Println(string(bs))
+			}
+			continue
+		}
+		fmt.Println(e.Time.Local().Format("2006/01/02 15:04:05"), e.Event, string(e.Data))
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// healthcheckTimeout bounds how long the healthcheck waits for the node, so
+// that a wedged node is reported as unhealthy rather than hanging.
+const healthcheckTimeout = 5 * time.Second
+
+// healthcheck checks that the node is answering on the admin socket and, if
+// min_peers=N was given, that it has at least that many peers. It is meant
+// to be used as a container healthcheck, so unlike other commands it never
+// panics and always returns a non-zero exit code on failure.
+func healthcheck(cmdLineEnv CmdLineEnv, send admin_info, logger *log.Logger) int {
+	minPeers, _ := send["min_peers"].(int)
+	conn, err := dial(cmdLineEnv.endpoint, logger)
+	if err != nil {
+		fmt.Println("Unhealthy: can't connect to admin socket:", err)
+		return 1
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(healthcheckTimeout))
+
+	var recv struct {
+		Status   string `json:"status"`
+		Error    string `json:"error"`
+		Response struct {
+			Peers map[string]interface{} `json:"peers"`
+		} `json:"response"`
+	}
+	decoder, encoder := json.NewDecoder(conn), json.NewEncoder(conn)
+	req := admin_info{"request": "getPeers"}
+	if err := authenticate(decoder, encoder, cmdLineEnv.token, req); err != nil {
+		fmt.Println("Unhealthy: can't authenticate with admin socket:", err)
+		return 1
+	}
+	if err := encoder.Encode(req); err != nil {
+		fmt.Println("Unhealthy: can't send request:", err)
+		return 1
+	}
+	if err := decoder.Decode(&recv); err != nil {
+		fmt.Println("Unhealthy: no response from admin socket:", err)
+		return 1
+	}
+	if recv.Status != "success" {
+		fmt.Println("Unhealthy: admin socket returned an error:", recv.Error)
+		return 1
+	}
+	if peers := len(recv.Response.Peers); peers < minPeers {
+		fmt.Printf("Unhealthy: %d peers connected, %d required\n", peers, minPeers)
+		return 1
+	}
+	fmt.Println("Healthy:", len(recv.Response.Peers), "peers connected")
+	return 0
+}
@@ -13,6 +13,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/yggdrasil-network/yggdrasil-go/src/version"
 )
@@ -54,14 +55,7 @@ func run() int {
 
 	cmdLineEnv.setEndpoint(logger)
 
-	conn := connect(cmdLineEnv.endpoint, logger)
-	logger.Println("Connected")
-	defer conn.Close()
-
-	decoder := json.NewDecoder(conn)
-	encoder := json.NewEncoder(conn)
 	send := make(admin_info)
-	recv := make(admin_info)
 
 	for c, a := range cmdLineEnv.args {
 		if c == 0 {
@@ -69,6 +63,9 @@ func run() int {
 				logger.Printf("Ignoring flag %s as it should be specified before other parameters\n", a)
 				continue
 			}
+			if alias, ok := commandAliases[strings.ToLower(a)]; ok {
+				a = alias
+			}
 			logger.Printf("Sending request: %v\n", a)
 			send["request"] = a
 			continue
@@ -96,6 +93,53 @@ func run() int {
 		}
 	}
 
+	if send["request"] == "subscribe" {
+		subscribeArgs(send)
+	}
+
+	if send["request"] == "healthcheck" {
+		return healthcheck(cmdLineEnv, send, logger)
+	}
+
+	for {
+		if status := request(cmdLineEnv, send, logger); status != 0 || cmdLineEnv.watch <= 0 {
+			return status
+		}
+		time.Sleep(cmdLineEnv.watch)
+		// Clear the terminal before printing the next set of results
+		fmt.Print("\033[H\033[2J")
+	}
+}
+
+// Friendly names for some of the more commonly used admin requests.
+var commandAliases = map[string]string{
+	"self":      "getSelf",
+	"peers":     "getPeers",
+	"links":     "getLinks",
+	"sessions":  "getSessions",
+	"paths":     "getPaths",
+	"routes":    "getPaths",
+	"dht":       "getDHT",
+	"tun":       "getTunTap",
+	"multicast": "getMulticastInterfaces",
+}
+
+// request sends a single request to the admin socket and prints the response,
+// returning the exit code that should be reported to the OS.
+func request(cmdLineEnv CmdLineEnv, send admin_info, logger *log.Logger) int {
+	conn := connect(cmdLineEnv.endpoint, logger)
+	logger.Println("Connected")
+	defer conn.Close()
+
+	decoder := json.NewDecoder(conn)
+	encoder := json.NewEncoder(conn)
+	recv := make(admin_info)
+
+	if err := authenticate(decoder, encoder, cmdLineEnv.token, send); err != nil {
+		fmt.Println("Failed to authenticate with the admin socket:", err)
+		return 1
+	}
+
 	if err := encoder.Encode(&send); err != nil {
 		panic(err)
 	}
@@ -107,6 +151,8 @@ func run() int {
 		if recv["status"] == "error" {
 			if err, ok := recv["error"]; ok {
 				fmt.Println("Admin socket returned an error:", err)
+			} else if err, ok := recv["response"].(map[string]interface{})["error"]; ok {
+				fmt.Println("Admin socket returned an error:", err)
 			} else {
 				fmt.Println("Admin socket returned an error but didn't specify any error text")
 			}
@@ -122,6 +168,13 @@ func run() int {
 		}
 		res := recv["response"].(map[string]interface{})
 
+		if send["request"] == "subscribe" {
+			if !cmdLineEnv.injson {
+				fmt.Println("Subscribed to:", res["events"])
+			}
+			return streamEvents(decoder, cmdLineEnv.injson)
+		}
+
 		if cmdLineEnv.injson {
 			if json, err := json.MarshalIndent(res, "", "  "); err == nil {
 				fmt.Println(string(json))
@@ -142,6 +195,14 @@ func run() int {
 }
 
 func connect(endpoint string, logger *log.Logger) net.Conn {
+	conn, err := dial(endpoint, logger)
+	if err != nil {
+		panic(err)
+	}
+	return conn
+}
+
+func dial(endpoint string, logger *log.Logger) (net.Conn, error) {
 	var conn net.Conn
 
 	u, err := url.Parse(endpoint)
@@ -163,11 +224,7 @@ func connect(endpoint string, logger *log.Logger) net.Conn {
 		conn, err = net.Dial("tcp", endpoint)
 	}
 
-	if err != nil {
-		panic(err)
-	}
-
-	return conn
+	return conn, err
 }
 
 func handleAll(recv map[string]interface{}, verbose bool) {
@@ -177,7 +234,7 @@ func handleAll(recv map[string]interface{}, verbose bool) {
 	switch strings.ToLower(req["request"].(string)) {
 	case "dot":
 		handleDot(res)
-	case "list", "getpeers", "getswitchpeers", "getdht", "getsessions", "dhtping":
+	case "list", "getpeers", "getswitchpeers", "getdht", "getsessions", "getpaths", "dhtping":
 		handleVariousInfo(res, verbose)
 	case "gettuntap", "settuntap":
 		handleGetAndSetTunTap(res)
@@ -388,6 +445,9 @@ func handleAddsAndRemoves(res map[string]interface{}) {
 			fmt.Println("Not removed:", fmt.Sprint(v))
 		}
 	}
+	if persisted, ok := res["persisted"].(bool); ok && persisted {
+		fmt.Println("Written to the config file")
+	}
 }
 
 func handleGetAllowedEncryptionPublicKeys(res map[string]interface{}) {
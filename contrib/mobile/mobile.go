@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"os"
 
 	"github.com/gologme/log"
 
@@ -30,6 +31,7 @@ type Yggdrasil struct {
 	config    *config.NodeConfig
 	multicast multicast.Multicast
 	log       MobileLogger
+	tun       *os.File // Set by TakeOverTUN
 }
 
 // StartAutoconfigure starts a node with a randomly generated config
@@ -102,6 +104,10 @@ func (m *Yggdrasil) Stop() error {
 		return err
 	}
 	m.core.Stop()
+	if m.tun != nil {
+		_ = m.tun.Close()
+		m.tun = nil
+	}
 	return nil
 }
 
@@ -0,0 +1,72 @@
+package mobile
+
+import (
+	"errors"
+	"os"
+)
+
+// TakeOverTUN runs the data plane over an already-open TUN file descriptor,
+// such as the one returned by VpnService.Builder.establish() on Android, so
+// that the app doesn't need to shuttle packets through Send and Recv itself.
+// The node must already have been started. The descriptor is closed when the
+// node is stopped.
+func (m *Yggdrasil) TakeOverTUN(fd int) error {
+	if m.iprwc == nil {
+		return errors.New("node is not started")
+	}
+	if m.tun != nil {
+		return errors.New("a TUN descriptor is already in use")
+	}
+	file := os.NewFile(uintptr(fd), "tun")
+	if file == nil {
+		return errors.New("invalid TUN descriptor")
+	}
+	m.tun = file
+	go func() {
+		var buf [65535]byte
+		for {
+			n, err := file.Read(buf[:])
+			if err != nil {
+				return
+			}
+			_, _ = m.iprwc.Write(buf[:n])
+		}
+	}()
+	go func() {
+		var buf [65535]byte
+		for {
+			n, err := m.iprwc.Read(buf[:])
+			if err != nil {
+				return
+			}
+			if _, err := file.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// SetBatterySaving should be called by the app when the device enters or
+// leaves a low power state. While enabled, multicast peer discovery, which
+// sends a beacon on every interface each second, is stopped. Existing peers,
+// including multicast ones, stay connected.
+func (m *Yggdrasil) SetBatterySaving(enabled bool) error {
+	if m.config == nil || len(m.config.MulticastInterfaces) == 0 {
+		return nil
+	}
+	if enabled {
+		return m.multicast.Stop()
+	}
+	if m.multicast.IsStarted() {
+		return nil
+	}
+	return m.multicast.Start()
+}
+
+// RetryPeers should be called by the app when network connectivity changes,
+// e.g. after switching from mobile data to Wi-Fi, so that the configured
+// peers are reconnected straight away instead of on the next retry timer.
+func (m *Yggdrasil) RetryPeers() {
+	m.core.RetryPeers()
+}
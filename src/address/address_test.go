@@ -2,8 +2,10 @@ package address
 
 import (
 	"bytes"
+	"context"
 	"crypto/ed25519"
 	"math/rand"
+	"net"
 	"testing"
 )
 
@@ -112,3 +114,17 @@ func TestAddress_Subnet_GetKey(t *testing.T) {
 		t.Fatal("invalid public key returned")
 	}
 }
+
+func TestSearchKeys(t *testing.T) {
+	_, prefix, _ := net.ParseCIDR("200::/16")
+	pub, priv, err := SearchKeys(context.Background(), MatchPrefix(prefix), 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(priv.Public().(ed25519.PublicKey), pub) {
+		t.Fatal("private key does not match public key")
+	}
+	if addr := AddrForKey(pub); !prefix.Contains(net.IP(addr[:])) {
+		t.Fatal("address", net.IP(addr[:]), "is not in", prefix)
+	}
+}
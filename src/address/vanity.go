@@ -0,0 +1,89 @@
+package address
+
+import (
+	"context"
+	"crypto/ed25519"
+	"net"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// KeyMatcher reports whether an address is acceptable when searching for keys.
+type KeyMatcher func(addr *Address) bool
+
+// MatchPrefix returns a KeyMatcher that accepts addresses within the given
+// IPv6 prefix, e.g. 200:dead::/32.
+func MatchPrefix(prefix *net.IPNet) KeyMatcher {
+	return func(addr *Address) bool {
+		return prefix.Contains(net.IP(addr[:]))
+	}
+}
+
+// MatchRegexp returns a KeyMatcher that accepts addresses whose string form,
+// e.g. 200:1234:5678::1, matches the given regular expression.
+func MatchRegexp(re *regexp.Regexp) KeyMatcher {
+	return func(addr *Address) bool {
+		return re.MatchString(net.IP(addr[:]).String())
+	}
+}
+
+// SearchKeys generates keys across the given number of goroutines until it
+// finds one whose address is accepted by the matcher, or until the context is
+// cancelled. If progress is not nil, it is called about once a second with the
+// total number of keys tried so far. Note that each additional leading 1 bit
+// required in the address roughly doubles the time the search will take.
+func SearchKeys(ctx context.Context, match KeyMatcher, threads int, progress func(tried uint64)) (ed25519.PublicKey, ed25519.PrivateKey, error) {
+	if threads < 1 {
+		threads = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var tried uint64
+	var once sync.Once
+	var pub ed25519.PublicKey
+	var priv ed25519.PrivateKey
+	var wg sync.WaitGroup
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				p, s, err := ed25519.GenerateKey(nil)
+				if err != nil {
+					return
+				}
+				atomic.AddUint64(&tried, 1)
+				if match(AddrForKey(p)) {
+					once.Do(func() {
+						pub, priv = p, s
+						cancel()
+					})
+					return
+				}
+			}
+		}()
+	}
+	if progress != nil {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			ticker := time.NewTicker(time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					progress(atomic.LoadUint64(&tried))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if pub == nil {
+		return nil, nil, ctx.Err()
+	}
+	return pub, priv, nil
+}
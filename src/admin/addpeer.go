@@ -0,0 +1,91 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+type AddPeerRequest struct {
+	URI       string `json:"uri"`
+	Interface string `json:"interface,omitempty"`
+	Persist   bool   `json:"persist,omitempty"`
+}
+
+type AddPeerResponse struct {
+	Added     []string `json:"added"`
+	Persisted bool     `json:"persisted"`
+}
+
+type RemovePeerRequest struct {
+	URI     string `json:"uri"`
+	Persist bool   `json:"persist,omitempty"`
+}
+
+type RemovePeerResponse struct {
+	Removed   []string `json:"removed"`
+	Persisted bool     `json:"persisted"`
+}
+
+// PeerPersister writes a peer that was added or removed with addPeer or
+// removePeer to the config file, when the request asks for that with persist.
+// The interface is empty for peers in Peers, and for any removed peer. It is
+// only called once at a time.
+type PeerPersister func(uri, intf string, add bool) error
+
+// SetPeerPersister sets the function that writes peers to the config file, or
+// nil if they can't be, in which case requests that ask to persist fail.
+func (a *AdminSocket) SetPeerPersister(persist PeerPersister) {
+	a.persistMutex.Lock()
+	defer a.persistMutex.Unlock()
+	a.persist = persist
+}
+
+func (a *AdminSocket) persistPeer(uri, intf string, add bool) error {
+	a.persistMutex.Lock()
+	defer a.persistMutex.Unlock()
+	if a.persist == nil {
+		return errors.New("peers can't be written to the config")
+	}
+	return a.persist(uri, intf, add)
+}
+
+func (a *AdminSocket) addPeerHandler(req *AddPeerRequest, res *AddPeerResponse) error {
+	u, err := url.Parse(req.URI)
+	if err != nil {
+		return fmt.Errorf("unable to parse peering URI: %w", err)
+	} else if req.URI == "" {
+		return errors.New("no peering URI given")
+	}
+	if err := a.core.AddPeer(u, req.Interface); err != nil {
+		return err
+	}
+	res.Added = []string{req.URI}
+	if req.Persist {
+		if err := a.persistPeer(req.URI, req.Interface, true); err != nil {
+			return fmt.Errorf("peer was added, but not written to the config: %w", err)
+		}
+		res.Persisted = true
+	}
+	return nil
+}
+
+func (a *AdminSocket) removePeerHandler(req *RemovePeerRequest, res *RemovePeerResponse) error {
+	u, err := url.Parse(req.URI)
+	if err != nil {
+		return fmt.Errorf("unable to parse peering URI: %w", err)
+	} else if req.URI == "" {
+		return errors.New("no peering URI given")
+	}
+	if err := a.core.RemovePeer(u); err != nil {
+		return err
+	}
+	res.Removed = []string{req.URI}
+	if req.Persist {
+		if err := a.persistPeer(req.URI, "", false); err != nil {
+			return fmt.Errorf("peer was removed, but not from the config: %w", err)
+		}
+		res.Persisted = true
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package admin
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/gologme/log"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/core"
+	"github.com/yggdrasil-network/yggdrasil-go/src/defaults"
+)
+
+func TestAddPeer(t *testing.T) {
+	logger := log.New(ioutil.Discard, "", 0)
+	cfg := defaults.GenerateConfig()
+	cfg.AdminListen = "none"
+	cfg.Listen = nil
+	cfg.IfName = "none"
+	var c core.Core
+	if err := c.Start(cfg, logger); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	var a AdminSocket
+	if err := a.Init(&c, cfg, logger, nil); err != nil {
+		t.Fatal(err)
+	}
+	const uri = "tcp://127.0.0.1:1"
+	if err := a.addPeerHandler(&AddPeerRequest{URI: uri, Persist: true}, &AddPeerResponse{}); err == nil {
+		t.Fatal("Peer was persisted without a persister")
+	}
+	var persisted []string
+	a.SetPeerPersister(func(uri, intf string, add bool) error {
+		if !add {
+			return errors.New("read-only")
+		}
+		persisted = append(persisted, uri)
+		return nil
+	})
+	if err := a.addPeerHandler(&AddPeerRequest{URI: uri}, &AddPeerResponse{}); err == nil {
+		t.Fatal("Peer was added twice")
+	}
+	if err := a.removePeerHandler(&RemovePeerRequest{URI: uri}, &RemovePeerResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	res := &AddPeerResponse{}
+	if err := a.addPeerHandler(&AddPeerRequest{URI: uri, Persist: true}, res); err != nil {
+		t.Fatal(err)
+	}
+	if !res.Persisted || len(persisted) != 1 || persisted[0] != uri {
+		t.Fatal("Peer was not persisted:", persisted)
+	}
+	if err := a.removePeerHandler(&RemovePeerRequest{URI: uri, Persist: true}, &RemovePeerResponse{}); err == nil {
+		t.Fatal("Failure to persist was not reported")
+	}
+	if err := a.removePeerHandler(&RemovePeerRequest{URI: uri}, &RemovePeerResponse{}); err == nil {
+		t.Fatal("Peer was removed twice")
+	}
+}
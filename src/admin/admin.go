@@ -1,14 +1,17 @@
 package admin
 
 import (
+	"bufio"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
+	"net/http"
 	"net/url"
 	"os"
 
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gologme/log"
@@ -17,15 +20,23 @@ import (
 	"github.com/yggdrasil-network/yggdrasil-go/src/core"
 )
 
-// TODO: Add authentication
-
 type AdminSocket struct {
-	core       *core.Core
-	log        *log.Logger
-	listenaddr string
-	listener   net.Listener
-	handlers   map[string]handler
-	done       chan struct{}
+	core            *core.Core
+	log             *log.Logger
+	listenaddr      string
+	httpaddr        string
+	token           string
+	listener        net.Listener
+	rest            *http.Server
+	handlers        map[string]handler
+	done            chan struct{}
+	web             bool
+	dashboard       *dashboard
+	events          eventHub             // For subscribe, see events.go
+	peerCallbacks   core.PeerCallbacksID // For the peer_up and peer_down events
+	persistMutex    sync.Mutex           // protects the persisters
+	persist         PeerPersister
+	persistIdentity IdentityPersister
 }
 
 type AdminSocketResponse struct {
@@ -69,6 +80,9 @@ func (a *AdminSocket) Init(c *core.Core, nc *config.NodeConfig, log *log.Logger,
 	a.handlers = make(map[string]handler)
 	nc.RLock()
 	a.listenaddr = nc.AdminListen
+	a.web = nc.AdminDashboard
+	a.httpaddr = nc.AdminHTTPListen
+	a.token = nc.AdminToken
 	nc.RUnlock()
 	a.done = make(chan struct{})
 	close(a.done) // Start in a done / not-started state
@@ -142,6 +156,127 @@ func (a *AdminSocket) SetupAdminHandlers(na *AdminSocket) {
 		}
 		return res, nil
 	})
+	_ = a.AddHandler("getMaintenance", []string{}, func(in json.RawMessage) (interface{}, error) {
+		req := &GetMaintenanceRequest{}
+		res := &GetMaintenanceResponse{}
+		if err := json.Unmarshal(in, &req); err != nil {
+			return nil, err
+		}
+		if err := a.getMaintenanceHandler(req, res); err != nil {
+			return nil, err
+		}
+		return res, nil
+	})
+	_ = a.AddHandler("setMaintenance", []string{"refuse_sessions"}, func(in json.RawMessage) (interface{}, error) {
+		req := &SetMaintenanceRequest{}
+		res := &SetMaintenanceResponse{}
+		if err := json.Unmarshal(in, &req); err != nil {
+			return nil, err
+		}
+		if err := a.setMaintenanceHandler(req, res); err != nil {
+			return nil, err
+		}
+		return res, nil
+	})
+	_ = a.AddHandler("getQuotas", []string{}, func(in json.RawMessage) (interface{}, error) {
+		req := &GetQuotasRequest{}
+		res := &GetQuotasResponse{}
+		if err := json.Unmarshal(in, &req); err != nil {
+			return nil, err
+		}
+		if err := a.getQuotasHandler(req, res); err != nil {
+			return nil, err
+		}
+		return res, nil
+	})
+	_ = a.AddHandler("getPeerRetries", []string{}, func(in json.RawMessage) (interface{}, error) {
+		req := &GetPeerRetriesRequest{}
+		res := &GetPeerRetriesResponse{}
+		if err := json.Unmarshal(in, &req); err != nil {
+			return nil, err
+		}
+		if err := a.getPeerRetriesHandler(req, res); err != nil {
+			return nil, err
+		}
+		return res, nil
+	})
+	_ = a.AddHandler("getLinks", []string{}, func(in json.RawMessage) (interface{}, error) {
+		req := &GetLinksRequest{}
+		res := &GetLinksResponse{}
+		if err := json.Unmarshal(in, &req); err != nil {
+			return nil, err
+		}
+		if err := a.getLinksHandler(req, res); err != nil {
+			return nil, err
+		}
+		return res, nil
+	})
+	_ = a.AddHandler("getImpairments", []string{}, func(in json.RawMessage) (interface{}, error) {
+		req := &GetImpairmentsRequest{}
+		res := &GetImpairmentsResponse{}
+		if err := json.Unmarshal(in, &req); err != nil {
+			return nil, err
+		}
+		if err := a.getImpairmentsHandler(req, res); err != nil {
+			return nil, err
+		}
+		return res, nil
+	})
+	_ = a.AddHandler("setImpairment", []string{"remote", "latency", "jitter", "bandwidth", "loss"}, func(in json.RawMessage) (interface{}, error) {
+		req := &SetImpairmentRequest{}
+		res := &SetImpairmentResponse{}
+		if err := json.Unmarshal(in, &req); err != nil {
+			return nil, err
+		}
+		if err := a.setImpairmentHandler(req, res); err != nil {
+			return nil, err
+		}
+		return res, nil
+	})
+	_ = a.AddHandler("addPeer", []string{"uri", "interface", "persist"}, func(in json.RawMessage) (interface{}, error) {
+		req := &AddPeerRequest{}
+		res := &AddPeerResponse{}
+		if err := json.Unmarshal(in, &req); err != nil {
+			return nil, err
+		}
+		if err := a.addPeerHandler(req, res); err != nil {
+			return nil, err
+		}
+		return res, nil
+	})
+	_ = a.AddHandler("removePeer", []string{"uri", "persist"}, func(in json.RawMessage) (interface{}, error) {
+		req := &RemovePeerRequest{}
+		res := &RemovePeerResponse{}
+		if err := json.Unmarshal(in, &req); err != nil {
+			return nil, err
+		}
+		if err := a.removePeerHandler(req, res); err != nil {
+			return nil, err
+		}
+		return res, nil
+	})
+	_ = a.AddHandler("exportIdentity", []string{"password"}, func(in json.RawMessage) (interface{}, error) {
+		req := &ExportIdentityRequest{}
+		res := &ExportIdentityResponse{}
+		if err := json.Unmarshal(in, &req); err != nil {
+			return nil, err
+		}
+		if err := a.exportIdentityHandler(req, res); err != nil {
+			return nil, err
+		}
+		return res, nil
+	})
+	_ = a.AddHandler("importIdentity", []string{"identity", "password", "force"}, func(in json.RawMessage) (interface{}, error) {
+		req := &ImportIdentityRequest{}
+		res := &ImportIdentityResponse{}
+		if err := json.Unmarshal(in, &req); err != nil {
+			return nil, err
+		}
+		if err := a.importIdentityHandler(req, res); err != nil {
+			return nil, err
+		}
+		return res, nil
+	})
 	//_ = a.AddHandler("getNodeInfo", []string{"key"}, t.proto.nodeinfo.nodeInfoAdminHandler)
 	//_ = a.AddHandler("debug_remoteGetSelf", []string{"key"}, t.proto.getSelfHandler)
 	//_ = a.AddHandler("debug_remoteGetPeers", []string{"key"}, t.proto.getPeersHandler)
@@ -152,8 +287,16 @@ func (a *AdminSocket) SetupAdminHandlers(na *AdminSocket) {
 func (a *AdminSocket) Start() error {
 	if a.listenaddr != "none" && a.listenaddr != "" {
 		a.done = make(chan struct{})
+		if a.web {
+			a.dashboard = &dashboard{}
+			a.dashboard.init(a)
+		}
+		a.peerCallbacks = a.core.AddPeerCallbacks(a.peerUp, a.peerDown)
 		go a.listen()
 	}
+	if a.httpaddr != "" {
+		return a.startREST(a.httpaddr)
+	}
 	return nil
 }
 
@@ -171,12 +314,21 @@ func (a *AdminSocket) IsStarted() bool {
 
 // Stop will stop the admin API and close the socket.
 func (a *AdminSocket) Stop() error {
+	if a.rest != nil {
+		_ = a.rest.Close()
+		a.rest = nil
+	}
 	if a.listener != nil {
 		select {
 		case <-a.done:
 		default:
 			close(a.done)
 		}
+		if a.dashboard != nil {
+			a.dashboard.stop()
+		}
+		a.core.RemovePeerCallbacks(a.peerCallbacks)
+		a.unsubscribeAll()
 		return a.listener.Close()
 	}
 	return nil
@@ -228,6 +380,9 @@ func (a *AdminSocket) listen() {
 	a.log.Infof("%s admin socket listening on %s",
 		strings.ToUpper(a.listener.Addr().Network()),
 		a.listener.Addr().String())
+	if addr, ok := a.listener.Addr().(*net.TCPAddr); ok && a.token == "" && !addr.IP.IsLoopback() {
+		a.log.Warnln("WARNING: The admin socket is reachable from other hosts but AdminToken is not set!")
+	}
 	defer a.listener.Close()
 	for {
 		conn, err := a.listener.Accept()
@@ -247,7 +402,13 @@ func (a *AdminSocket) listen() {
 
 // handleRequest calls the request handler for each request sent to the admin API.
 func (a *AdminSocket) handleRequest(conn net.Conn) {
-	decoder := json.NewDecoder(conn)
+	reader := bufio.NewReader(conn)
+	if a.dashboard != nil && isHTTP(reader) {
+		a.dashboard.listener.push(&bufferedConn{conn, reader})
+		return
+	}
+
+	decoder := json.NewDecoder(reader)
 	decoder.DisallowUnknownFields()
 
 	encoder := json.NewEncoder(conn)
@@ -255,6 +416,8 @@ func (a *AdminSocket) handleRequest(conn net.Conn) {
 
 	defer conn.Close()
 
+	auth := a.newAuth(conn)
+
 	defer func() {
 		r := recover()
 		if r != nil {
@@ -280,6 +443,26 @@ func (a *AdminSocket) handleRequest(conn net.Conn) {
 				resp.Response = &ErrorResponse{
 					Error: "No request specified",
 				}
+			} else if strings.EqualFold(resp.Request.Name, "challenge") {
+				resp.Response, err = auth.challenge()
+				if err != nil {
+					resp.Status = "error"
+					resp.Response = &ErrorResponse{
+						Error: err.Error(),
+					}
+				}
+			} else if err = auth.check(buf); err != nil {
+				if err == errAuthFailed {
+					a.log.Warnln("Admin connection from", conn.RemoteAddr(), "failed to authenticate")
+					resp.Request.KeepAlive = false
+				}
+				resp.Status = "error"
+				resp.Response = &ErrorResponse{
+					Error: err.Error(),
+				}
+			} else if strings.EqualFold(resp.Request.Name, "subscribe") {
+				a.streamEvents(conn, encoder, buf, &resp)
+				return
 			} else if h, ok := a.handlers[strings.ToLower(resp.Request.Name)]; ok {
 				resp.Response, err = h.handler(buf)
 				if err != nil {
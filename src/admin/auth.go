@@ -0,0 +1,139 @@
+package admin
+
+// This file contains the authentication of admin connections, so that the
+// admin socket can listen on a TCP address that is reachable from containers
+// or other hosts. With AdminToken set, a connection over TCP must prove that
+// it knows the token before anything but a challenge is answered. Either a
+// request carries the token itself in its token field, or the client asks for
+// a challenge and then sends the HMAC-SHA256 of the nonce keyed with the token
+// in the hmac field of a request, which keeps the token off the wire. Once a
+// request on a connection has authenticated, the rest of the requests on it
+// need not. Connections over a UNIX socket are protected by the permissions of
+// the socket, so they are never asked for the token.
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	authNonceSize   = 32
+	dashboardCookie = "yggdrasil_token"
+)
+
+var (
+	errAuthRequired = errors.New("authentication required, send a token or answer a challenge")
+	errAuthFailed   = errors.New("authentication failed")
+)
+
+type ChallengeResponse struct {
+	Nonce string `json:"nonce"`
+}
+
+// adminAuth holds the authentication state of an admin connection.
+type adminAuth struct {
+	token string
+	done  bool   // The connection has authenticated, or doesn't need to
+	nonce []byte // The last challenge sent on the connection, until it is answered
+}
+
+// newAuth returns the authentication state of a new connection, which only
+// needs to authenticate if there is a token and it came in over TCP.
+func (a *AdminSocket) newAuth(conn net.Conn) *adminAuth {
+	_, tcp := conn.LocalAddr().(*net.TCPAddr)
+	return &adminAuth{
+		token: a.token,
+		done:  a.token == "" || !tcp,
+	}
+}
+
+// challengeHMAC returns the answer to a challenge with the given nonce.
+func challengeHMAC(token string, nonce []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(token))
+	_, _ = mac.Write(nonce)
+	return mac.Sum(nil)
+}
+
+// challenge returns a new nonce for the client to answer, replacing any that
+// was sent before.
+func (x *adminAuth) challenge() (*ChallengeResponse, error) {
+	nonce := make([]byte, authNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	x.nonce = nonce
+	return &ChallengeResponse{Nonce: hex.EncodeToString(nonce)}, nil
+}
+
+// check returns nil if the connection has authenticated, either earlier or
+// with the token or hmac field of this request. A challenge can only be
+// answered once, whether the answer is right or not.
+func (x *adminAuth) check(buf json.RawMessage) error {
+	if x.done {
+		return nil
+	}
+	var req struct {
+		Token string `json:"token"`
+		HMAC  string `json:"hmac"`
+	}
+	_ = json.Unmarshal(buf, &req)
+	switch {
+	case req.Token != "":
+		x.done = subtle.ConstantTimeCompare([]byte(req.Token), []byte(x.token)) == 1
+	case req.HMAC != "":
+		sum, err := hex.DecodeString(req.HMAC)
+		x.done = err == nil && x.nonce != nil && hmac.Equal(sum, challengeHMAC(x.token, x.nonce))
+		x.nonce = nil
+	default:
+		return errAuthRequired
+	}
+	if !x.done {
+		return errAuthFailed
+	}
+	return nil
+}
+
+// authorize wraps the handler of the dashboard or the HTTP API so that, with a
+// token set, it only serves requests that carry the token, either as a bearer
+// token in the Authorization header or in the cookie that is set when the
+// dashboard is opened with the token in a query parameter, e.g. /?token=X.
+func (a *AdminSocket) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := a.token
+		if token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		got := r.URL.Query().Get("token")
+		query := got != ""
+		if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+			got, query = strings.TrimPrefix(h, "Bearer "), false
+		} else if c, err := r.Cookie(dashboardCookie); err == nil && !query {
+			got = c.Value
+		}
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			a.log.Debugln("HTTP request from", r.RemoteAddr, "failed to authenticate")
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, errAuthRequired.Error(), http.StatusUnauthorized)
+			return
+		}
+		if query {
+			http.SetCookie(w, &http.Cookie{
+				Name:     dashboardCookie,
+				Value:    got,
+				Path:     "/",
+				HttpOnly: true,
+				SameSite: http.SameSiteStrictMode,
+			})
+		}
+		next.ServeHTTP(w, r)
+	})
+}
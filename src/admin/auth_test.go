@@ -0,0 +1,87 @@
+package admin
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/gologme/log"
+)
+
+func TestAuth(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	a := &AdminSocket{
+		log:      log.New(ioutil.Discard, "", 0),
+		token:    "secret",
+		handlers: make(map[string]handler),
+	}
+	_ = a.AddHandler("ping", nil, func(_ json.RawMessage) (interface{}, error) {
+		return "pong", nil
+	})
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go a.handleRequest(conn)
+		}
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	encoder, decoder := json.NewEncoder(conn), json.NewDecoder(conn)
+	call := func(req map[string]interface{}) AdminSocketResponse {
+		req["keepalive"] = true
+		if err := encoder.Encode(req); err != nil {
+			t.Fatal(err)
+		}
+		var resp AdminSocketResponse
+		if err := decoder.Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	if resp := call(map[string]interface{}{"request": "ping"}); resp.Status != "error" {
+		t.Fatal("Request without a token was answered")
+	}
+	resp := call(map[string]interface{}{"request": "challenge"})
+	nonce, err := hex.DecodeString(resp.Response.(map[string]interface{})["nonce"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer := hex.EncodeToString(challengeHMAC("secret", nonce))
+	if resp := call(map[string]interface{}{"request": "ping", "hmac": answer}); resp.Status != "success" {
+		t.Fatal("Answered challenge was refused:", resp.Response)
+	}
+	if resp := call(map[string]interface{}{"request": "ping"}); resp.Status != "success" {
+		t.Fatal("Authenticated connection was asked to authenticate again")
+	}
+
+	// A challenge can't be answered twice, and a wrong token is refused
+	x := a.newAuth(conn)
+	if x.done {
+		t.Fatal("TCP connection was not asked to authenticate")
+	}
+	_, _ = x.challenge()
+	replay, _ := json.Marshal(map[string]string{"hmac": answer})
+	if err := x.check(replay); err != errAuthFailed {
+		t.Fatal("Answer to another challenge was accepted")
+	}
+	wrong, _ := json.Marshal(map[string]string{"token": "wrong"})
+	if err := x.check(wrong); err != errAuthFailed {
+		t.Fatal("Wrong token was accepted")
+	}
+	right, _ := json.Marshal(map[string]string{"token": "secret"})
+	if err := x.check(right); err != nil {
+		t.Fatal("Token was refused:", err)
+	}
+}
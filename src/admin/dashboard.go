@@ -0,0 +1,280 @@
+package admin
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// The dashboard shares the admin listener with the JSON admin protocol. Any
+// connection that starts with an HTTP GET or POST is handed over to the web
+// server instead of being treated as an admin request.
+//
+// The admin listener is usually only reachable from the machine itself, but a
+// browser on it will still send requests there for any web page that it has
+// open. So the dashboard only serves requests with a Host that is either an
+// IP address or the name in AdminListen, as a page whose name has been rebound
+// to the node's address has its own name as the Host. GET requests can only
+// run the handlers that read the state of the node. The few actions are POST
+// requests that must carry the CSRF token from the page, which other pages
+// can't read, and the node's identity is never available at all.
+type dashboard struct {
+	admin    *AdminSocket
+	listener *connListener
+	server   *http.Server
+	host     string // The host and port in AdminListen
+	csrf     string
+	sub      *subscriber
+	mutex    sync.Mutex // protects events
+	events   []*Event   // The most recent events, oldest first
+}
+
+const dashboardEvents = 50 // How many recent events the dashboard shows
+
+// dashboardReads are the admin handlers that the dashboard runs for GET
+// requests, which only read the state of the node.
+var dashboardReads = map[string]struct{}{
+	"getself":     {},
+	"getpeers":    {},
+	"getlinks":    {},
+	"getdht":      {},
+	"getpaths":    {},
+	"getsessions": {},
+}
+
+// dashboardActions are the admin handlers that the dashboard runs for POST
+// requests, with the arguments in a JSON body.
+var dashboardActions = map[string]struct{}{
+	"addpeer":    {},
+	"removepeer": {},
+}
+
+func (d *dashboard) init(a *AdminSocket) {
+	d.admin = a
+	d.listener = newConnListener()
+	d.host = a.listenaddr
+	if u, err := url.Parse(a.listenaddr); err == nil && u.Host != "" {
+		d.host = u.Host
+	}
+	csrf := make([]byte, 16)
+	_, _ = rand.Read(csrf)
+	d.csrf = hex.EncodeToString(csrf)
+	if sub, err := a.subscribe(nil); err == nil {
+		d.sub = sub
+		go d.collectEvents(sub)
+	}
+	d.server = &http.Server{Handler: d.handler()}
+	go func() {
+		_ = d.server.Serve(d.listener)
+	}()
+}
+
+func (d *dashboard) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", d.handleIndex)
+	mux.HandleFunc("/api/", d.handleAPI)
+	return d.checkHost(d.admin.authorize(mux))
+}
+
+func (d *dashboard) stop() {
+	if d.sub != nil {
+		d.admin.unsubscribe(d.sub)
+	}
+	_ = d.server.Close()
+}
+
+// isHTTP returns true if the connection starts with a letter, as an HTTP
+// method does, rather than the JSON of a request. Only one byte is peeked, so
+// that short requests such as {} aren't held up waiting for more.
+func isHTTP(reader *bufio.Reader) bool {
+	peek, err := reader.Peek(1)
+	if err != nil {
+		return false
+	}
+	b := peek[0]
+	return ('A' <= b && b <= 'Z') || ('a' <= b && b <= 'z')
+}
+
+// collectEvents keeps the most recent events, until the subscriber is removed.
+func (d *dashboard) collectEvents(sub *subscriber) {
+	for {
+		select {
+		case e := <-sub.queue:
+			d.mutex.Lock()
+			d.events = append(d.events, e)
+			if len(d.events) > dashboardEvents {
+				d.events = d.events[len(d.events)-dashboardEvents:]
+			}
+			d.mutex.Unlock()
+		case <-sub.closed:
+			return
+		}
+	}
+}
+
+// checkHost refuses requests with a Host that is a name other than the one in
+// AdminListen.
+func (d *dashboard) checkHost(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if net.ParseIP(strings.Trim(host, "[]")) == nil && !strings.EqualFold(r.Host, d.host) {
+			d.admin.log.Debugln("Dashboard request from", r.RemoteAddr, "for host", r.Host, "refused")
+			http.Error(w, "unknown host", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (d *dashboard) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	_, _ = w.Write(bytes.Replace(dashboardHTML, []byte("{{csrf}}"), []byte(d.csrf), 1))
+}
+
+// handleAPI runs the admin handler named in the path, e.g. /api/getPeers. For
+// GET requests the query parameters are the arguments, and for POST requests
+// the JSON body is. The recent events are at /api/events.
+func (d *dashboard) handleAPI(w http.ResponseWriter, r *http.Request) {
+	name := strings.ToLower(strings.TrimPrefix(r.URL.Path, "/api/"))
+	var in json.RawMessage
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		if name == "events" {
+			d.mutex.Lock()
+			events := append([]*Event{}, d.events...)
+			d.mutex.Unlock()
+			writeJSON(w, http.StatusOK, events)
+			return
+		}
+		if _, ok := dashboardReads[name]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		in, err = queryArgs(r)
+	case http.MethodPost:
+		if _, ok := dashboardActions[name]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if err := d.checkCSRF(r); err != nil {
+			writeError(w, http.StatusForbidden, err)
+			return
+		}
+		in, err = ioutil.ReadAll(io.LimitReader(r.Body, 1<<16))
+		if err == nil && !json.Valid(in) {
+			err = errors.New("the body must be a JSON object")
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	h, ok := d.admin.handlers[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	res, err := h.handler(in)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// checkCSRF returns an error unless a POST request comes from the dashboard
+// itself, which sends the token from the page in a header, and as JSON, which
+// other pages can't do without the browser asking the dashboard first.
+func (d *dashboard) checkCSRF(r *http.Request) error {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-CSRF-Token")), []byte(d.csrf)) != 1 {
+		return errors.New("missing or wrong CSRF token")
+	}
+	if origin := r.Header.Get("Origin"); origin != "" && origin != "http://"+r.Host {
+		return fmt.Errorf("request from origin %s refused", origin)
+	}
+	if ct := r.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		return errors.New("the body must be application/json")
+	}
+	return nil
+}
+
+// bufferedConn is a net.Conn that reads through a bufio.Reader, so that any
+// bytes that were peeked at are not lost.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// connListener is a net.Listener that hands out connections which have been
+// accepted elsewhere, in this case by the admin socket.
+type connListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newConnListener() *connListener {
+	return &connListener{
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+func (l *connListener) push(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.closed:
+		conn.Close()
+	}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, errors.New("listener closed")
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: "admin", Net: "admin"}
+}
@@ -0,0 +1,128 @@
+package admin
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gologme/log"
+)
+
+func TestDashboard(t *testing.T) {
+	a := &AdminSocket{
+		log:      log.New(ioutil.Discard, "", 0),
+		handlers: make(map[string]handler),
+	}
+	var added []string
+	_ = a.AddHandler("getPeers", nil, func(_ json.RawMessage) (interface{}, error) {
+		return &GetPeersResponse{}, nil
+	})
+	_ = a.AddHandler("addPeer", nil, func(in json.RawMessage) (interface{}, error) {
+		req := &AddPeerRequest{}
+		if err := json.Unmarshal(in, req); err != nil {
+			return nil, err
+		}
+		added = append(added, req.URI)
+		return &AddPeerResponse{Added: []string{req.URI}}, nil
+	})
+	_ = a.AddHandler("exportIdentity", nil, func(_ json.RawMessage) (interface{}, error) {
+		t.Fatal("The identity was exported")
+		return nil, nil
+	})
+	d := &dashboard{admin: a, host: "localhost:9001", csrf: "token"}
+	d.events = []*Event{{Event: EventPeerUp}}
+	server := httptest.NewServer(d.handler())
+	defer server.Close()
+	do := func(method, path, host, body string, header map[string]string) int {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if host != "" {
+			req.Host = host
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	csrf := map[string]string{"X-CSRF-Token": "token", "Content-Type": "application/json"}
+	for _, test := range []struct {
+		method, path, host string
+		header             map[string]string
+		status             int
+	}{
+		{"GET", "/", "", nil, http.StatusOK},
+		{"GET", "/", "localhost:9001", nil, http.StatusOK},
+		{"GET", "/", "rebound.example:9001", nil, http.StatusForbidden},
+		{"GET", "/api/getPeers", "", nil, http.StatusOK},
+		{"GET", "/api/events", "", nil, http.StatusOK},
+		{"GET", "/api/exportIdentity", "", nil, http.StatusNotFound},
+		{"GET", "/api/addPeer", "", nil, http.StatusNotFound},
+		{"POST", "/api/exportIdentity", "", csrf, http.StatusNotFound},
+		{"POST", "/api/addPeer", "", nil, http.StatusForbidden},
+		{"POST", "/api/addPeer", "", map[string]string{"X-CSRF-Token": "wrong", "Content-Type": "application/json"}, http.StatusForbidden},
+		{"POST", "/api/addPeer", "", map[string]string{"X-CSRF-Token": "token", "Content-Type": "text/plain"}, http.StatusForbidden},
+		{"POST", "/api/addPeer", "", map[string]string{"X-CSRF-Token": "token", "Content-Type": "application/json", "Origin": "http://rebound.example"}, http.StatusForbidden},
+		{"POST", "/api/addPeer", "", csrf, http.StatusOK},
+		{"DELETE", "/api/addPeer", "", csrf, http.StatusMethodNotAllowed},
+	} {
+		if status := do(test.method, test.path, test.host, `{"uri":"tcp://[::1]:1"}`, test.header); status != test.status {
+			t.Errorf("%s %s for %q returned %d, expected %d", test.method, test.path, test.host, status, test.status)
+		}
+	}
+	if len(added) != 1 || added[0] != "tcp://[::1]:1" {
+		t.Fatal("Peer was not added exactly once:", added)
+	}
+
+	resp, err := http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if !strings.Contains(string(body), `content="token"`) {
+		t.Fatal("The page doesn't contain the CSRF token")
+	}
+}
+
+func TestIsHTTP(t *testing.T) {
+	for _, test := range []struct {
+		start  string
+		isHTTP bool
+	}{
+		{"GET / HTTP/1.1\r\n", true},
+		{"POST /api/addPeer HTTP/1.1\r\n", true},
+		{`{"request":"getSelf"}`, false},
+		{"{}\n", false},
+		{" {}\n", false},
+	} {
+		// Nothing more is sent, so a short request would block if isHTTP
+		// waited for more than it needs
+		client, server := net.Pipe()
+		go func() { _, _ = client.Write([]byte(test.start)) }()
+		result := make(chan bool, 1)
+		go func() { result <- isHTTP(bufio.NewReader(server)) }()
+		select {
+		case got := <-result:
+			if got != test.isHTTP {
+				t.Errorf("isHTTP(%q) returned %v", test.start, got)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("isHTTP(%q) blocked", test.start)
+		}
+		client.Close()
+		server.Close()
+	}
+}
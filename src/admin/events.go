@@ -0,0 +1,299 @@
+package admin
+
+// This file contains the event stream of the admin socket, so that tooling
+// can be told about changes as they happen instead of polling for them. After
+// a subscribe request, the admin socket sends its usual response and then an
+// Event on a line of its own whenever something happens, until the connection
+// is closed. The request can list the events to send, which are otherwise all
+// of them. Ironwood doesn't say when sessions are created, so while anything
+// is subscribed, the sessions are compared with those from a second before.
+// A subscriber that falls too far behind is disconnected, so that it knows
+// that it has missed events and should fetch the state again.
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/address"
+	"github.com/yggdrasil-network/yggdrasil-go/src/core"
+)
+
+// The events that can be subscribed to.
+const (
+	EventPeerUp         = "peer_up"
+	EventPeerDown       = "peer_down"
+	EventLinkError      = "link_error"
+	EventSessionCreated = "session_created"
+	EventSessionClosed  = "session_closed"
+	EventConfigReloaded = "config_reloaded"
+)
+
+var eventNames = []string{
+	EventPeerUp,
+	EventPeerDown,
+	EventLinkError,
+	EventSessionCreated,
+	EventSessionClosed,
+	EventConfigReloaded,
+}
+
+const (
+	eventQueueSize       = 64 // Events that a subscriber can fall behind by before it is disconnected
+	eventSessionInterval = time.Second
+)
+
+type SubscribeRequest struct {
+	Events []string `json:"events"`
+}
+
+type SubscribeResponse struct {
+	Events []string `json:"events"`
+}
+
+// Event is sent to subscribers whenever something happens. The data of
+// peer_up and peer_down events is a LinkEntry, of link_error events a
+// LinkErrorEntry, and of session events a SessionEventEntry.
+type Event struct {
+	Event string      `json:"event"`
+	Time  time.Time   `json:"time"`
+	Data  interface{} `json:"data"`
+}
+
+type LinkErrorEntry struct {
+	Reason    string `json:"reason"` // e.g. refused, forbidden or incompatible
+	Type      string `json:"type"`
+	Local     string `json:"local"`
+	Remote    string `json:"remote"`
+	PublicKey string `json:"key,omitempty"`
+	Direction string `json:"direction"`
+	Error     string `json:"error,omitempty"`
+	Message   string `json:"message"`
+}
+
+type SessionEventEntry struct {
+	Address   string `json:"address"`
+	PublicKey string `json:"key"`
+}
+
+// subscriber is a connection that is streaming events.
+type subscriber struct {
+	events map[string]struct{} // The events to send, or nil for all of them
+	queue  chan *Event
+	closed chan struct{} // Closed once the subscriber has been removed
+}
+
+// eventHub holds the subscribers of an admin socket.
+type eventHub struct {
+	mutex sync.Mutex // protects the below
+	subs  map[*subscriber]struct{}
+	stop  chan struct{} // Stops watching the sessions, while there are subscribers
+}
+
+// Publish sends an event to everything that is subscribed to it.
+func (a *AdminSocket) Publish(event string, data interface{}) {
+	e := &Event{Event: event, Time: time.Now(), Data: data}
+	h := &a.events
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for s := range h.subs {
+		if _, ok := s.events[event]; s.events != nil && !ok {
+			continue
+		}
+		select {
+		case s.queue <- e:
+		default:
+			a.log.Debugln("Disconnecting admin subscriber as it fell behind")
+			h._remove(s)
+		}
+	}
+}
+
+// subscribe adds a subscriber for the given events, or for all of them if
+// there are none.
+func (a *AdminSocket) subscribe(events []string) (*subscriber, error) {
+	s := &subscriber{
+		queue:  make(chan *Event, eventQueueSize),
+		closed: make(chan struct{}),
+	}
+	if len(events) > 0 {
+		s.events = make(map[string]struct{}, len(events))
+	}
+	for _, event := range events {
+		known := false
+		for _, name := range eventNames {
+			known = known || event == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown event %q", event)
+		}
+		s.events[event] = struct{}{}
+	}
+	h := &a.events
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.subs == nil {
+		h.subs = make(map[*subscriber]struct{})
+	}
+	h.subs[s] = struct{}{}
+	if h.stop == nil {
+		h.stop = make(chan struct{})
+		go a.watchSessions(h.stop)
+	}
+	return s, nil
+}
+
+func (a *AdminSocket) unsubscribe(s *subscriber) {
+	a.events.mutex.Lock()
+	defer a.events.mutex.Unlock()
+	a.events._remove(s)
+}
+
+// unsubscribeAll disconnects every subscriber, when the admin socket stops.
+func (a *AdminSocket) unsubscribeAll() {
+	a.events.mutex.Lock()
+	defer a.events.mutex.Unlock()
+	for s := range a.events.subs {
+		a.events._remove(s)
+	}
+}
+
+func (h *eventHub) _remove(s *subscriber) {
+	if _, ok := h.subs[s]; !ok {
+		return
+	}
+	delete(h.subs, s)
+	close(s.closed)
+	if len(h.subs) == 0 && h.stop != nil {
+		close(h.stop)
+		h.stop = nil
+	}
+}
+
+// streamEvents answers a subscribe request, and then sends the events that
+// were subscribed to until the connection closes or the subscriber is removed.
+func (a *AdminSocket) streamEvents(conn net.Conn, encoder *json.Encoder, buf json.RawMessage, resp *AdminSocketResponse) {
+	req := &SubscribeRequest{}
+	var s *subscriber
+	err := json.Unmarshal(buf, &req)
+	if err == nil {
+		s, err = a.subscribe(req.Events)
+	}
+	if err != nil {
+		resp.Status = "error"
+		resp.Response = &ErrorResponse{
+			Error: err.Error(),
+		}
+		_ = encoder.Encode(resp)
+		return
+	}
+	defer a.unsubscribe(s)
+	res := &SubscribeResponse{Events: req.Events}
+	if len(res.Events) == 0 {
+		res.Events = eventNames
+	}
+	resp.Response = res
+	if err := encoder.Encode(resp); err != nil {
+		return
+	}
+	// Nothing else is read from the connection, so this only finds out when
+	// the subscriber goes away
+	gone := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(ioutil.Discard, conn)
+		close(gone)
+	}()
+	stream := json.NewEncoder(conn) // Not indented, so that each event is one line
+	for {
+		select {
+		case e := <-s.queue:
+			if err := stream.Encode(e); err != nil {
+				return
+			}
+		case <-s.closed:
+			return
+		case <-gone:
+			return
+		}
+	}
+}
+
+// watchSessions publishes the sessions that have been created or closed since
+// the last time that it looked, until stopped.
+func (a *AdminSocket) watchSessions(stop chan struct{}) {
+	known := a.sessions()
+	ticker := time.NewTicker(eventSessionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		current := a.sessions()
+		for key, entry := range current {
+			if _, ok := known[key]; !ok {
+				a.Publish(EventSessionCreated, entry)
+			}
+		}
+		for key, entry := range known {
+			if _, ok := current[key]; !ok {
+				a.Publish(EventSessionClosed, entry)
+			}
+		}
+		known = current
+	}
+}
+
+func (a *AdminSocket) sessions() map[string]SessionEventEntry {
+	sessions := make(map[string]SessionEventEntry)
+	for _, s := range a.core.GetSessions() {
+		addr := address.AddrForKey(s.Key)
+		key := hex.EncodeToString(s.Key[:])
+		sessions[key] = SessionEventEntry{
+			Address:   net.IP(addr[:]).String(),
+			PublicKey: key,
+		}
+	}
+	return sessions
+}
+
+func (a *AdminSocket) peerUp(e core.PeerEvent) {
+	a.Publish(EventPeerUp, newLinkEntry(e.Link))
+}
+
+func (a *AdminSocket) peerDown(e core.PeerEvent) {
+	a.Publish(EventPeerDown, newLinkEntry(e.Link))
+}
+
+// LinkLogger returns a link logger that publishes the links which fail to be
+// set up as link_error events. Every event is also passed on to next, or just
+// logged if next is nil.
+func (a *AdminSocket) LinkLogger(next core.LinkLogger) core.LinkLogger {
+	return func(e core.LinkLogEvent) {
+		switch e.Event {
+		case "connected", "disconnected", "evicted":
+		default:
+			a.Publish(EventLinkError, LinkErrorEntry{
+				Reason:    e.Event,
+				Type:      e.LinkType,
+				Local:     e.Local,
+				Remote:    e.Remote,
+				PublicKey: e.Key,
+				Direction: e.Direction,
+				Error:     e.Error,
+				Message:   e.Message,
+			})
+		}
+		if next != nil {
+			next(e)
+		} else {
+			_ = a.log.Output(e.Level, e.Message)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package admin
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gologme/log"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/core"
+	"github.com/yggdrasil-network/yggdrasil-go/src/defaults"
+)
+
+func TestEvents(t *testing.T) {
+	logger := log.New(ioutil.Discard, "", 0)
+	var nodes [2]core.Core
+	for i := range nodes {
+		cfg := defaults.GenerateConfig()
+		cfg.AdminListen = "none"
+		cfg.Listen = nil
+		cfg.IfName = "none"
+		if err := nodes[i].Start(cfg, logger); err != nil {
+			t.Fatal(err)
+		}
+		defer nodes[i].Stop()
+	}
+	var a AdminSocket
+	if err := a.Init(&nodes[0], defaults.GenerateConfig(), logger, nil); err != nil {
+		t.Fatal(err)
+	}
+	nodes[0].AddPeerCallbacks(a.peerUp, a.peerDown)
+	defer a.unsubscribeAll()
+
+	subscribe := func(events ...string) (*json.Decoder, net.Conn) {
+		client, server := net.Pipe()
+		go a.handleRequest(server)
+		decoder := json.NewDecoder(client)
+		req := map[string]interface{}{"request": "subscribe", "events": events}
+		if err := json.NewEncoder(client).Encode(req); err != nil {
+			t.Fatal(err)
+		}
+		var resp AdminSocketResponse
+		if err := decoder.Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != "success" {
+			t.Fatal("Subscribe failed:", resp.Response)
+		}
+		return decoder, client
+	}
+	next := func(decoder *json.Decoder) (string, map[string]interface{}) {
+		var e struct {
+			Event string                 `json:"event"`
+			Data  map[string]interface{} `json:"data"`
+		}
+		if err := decoder.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		return e.Event, e.Data
+	}
+
+	decoder, conn := subscribe(EventPeerUp, EventConfigReloaded)
+	defer conn.Close()
+	listener, err := nodes[0].Listen(&url.URL{Scheme: "tcp", Host: "127.0.0.1:0"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Stop()
+	u, _ := url.Parse("tcp://" + listener.Listener.Addr().String())
+	if err := nodes[1].CallPeer(u, ""); err != nil {
+		t.Fatal(err)
+	}
+	event, data := next(decoder)
+	if event != EventPeerUp || data["key"] != hex.EncodeToString(nodes[1].PublicKey()) {
+		t.Fatal("Unexpected event:", event, data)
+	}
+
+	// Events that weren't subscribed to are not sent
+	a.Publish(EventPeerDown, nil)
+	a.Publish(EventConfigReloaded, map[string]bool{"success": true})
+	if event, data := next(decoder); event != EventConfigReloaded || data["success"] != true {
+		t.Fatal("Unexpected event:", event, data)
+	}
+
+	// Closing the connection unsubscribes it
+	conn.Close()
+	for i := 0; ; i++ {
+		a.events.mutex.Lock()
+		subs := len(a.events.subs)
+		a.events.mutex.Unlock()
+		if subs == 0 {
+			break
+		} else if i == 100 {
+			t.Fatal("Subscriber was not removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go a.handleRequest(server)
+	_ = json.NewEncoder(client).Encode(map[string]interface{}{"request": "subscribe", "events": []string{"nope"}})
+	var resp AdminSocketResponse
+	if err := json.NewDecoder(client).Decode(&resp); err != nil || resp.Status != "error" {
+		t.Fatal("Unknown event was subscribed to")
+	}
+}
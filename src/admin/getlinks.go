@@ -0,0 +1,68 @@
+package admin
+
+import (
+	"encoding/hex"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/core"
+)
+
+type GetLinksRequest struct{}
+
+type GetLinksResponse struct {
+	Links []LinkEntry `json:"links"`
+}
+
+type LinkEntry struct {
+	Type       string    `json:"type"`
+	Name       string    `json:"name"`
+	Local      string    `json:"local"`
+	Remote     string    `json:"remote"`
+	PublicKey  string    `json:"key"`
+	Incoming   bool      `json:"incoming"`
+	Forced     bool      `json:"forced"`
+	Up         bool      `json:"up"`
+	Penalty    uint64    `json:"penalty"`
+	RXBytes    uint64    `json:"bytes_recvd"`
+	TXBytes    uint64    `json:"bytes_sent"`
+	RXPackets  uint64    `json:"packets_recvd"`
+	TXPackets  uint64    `json:"packets_sent"`
+	Handshake  float64   `json:"handshake"`
+	Latency    float64   `json:"latency,omitempty"`
+	RTTSamples []float64 `json:"rtt_samples,omitempty"`
+	Uptime     float64   `json:"uptime"`
+	LastError  string    `json:"last_error,omitempty"`
+}
+
+func (a *AdminSocket) getLinksHandler(req *GetLinksRequest, res *GetLinksResponse) error {
+	res.Links = []LinkEntry{}
+	for _, l := range a.core.GetLinks() {
+		res.Links = append(res.Links, newLinkEntry(l))
+	}
+	return nil
+}
+
+func newLinkEntry(l core.Link) LinkEntry {
+	entry := LinkEntry{
+		Type:      l.Type,
+		Name:      l.Name,
+		Local:     l.Local,
+		Remote:    l.Remote,
+		PublicKey: hex.EncodeToString(l.Key),
+		Incoming:  l.Incoming,
+		Forced:    l.Forced,
+		Up:        l.Up,
+		Penalty:   l.Penalty,
+		RXBytes:   l.RXBytes,
+		TXBytes:   l.TXBytes,
+		RXPackets: l.RXPackets,
+		TXPackets: l.TXPackets,
+		Handshake: l.Handshake.Seconds(),
+		Latency:   l.Latency.Seconds(),
+		Uptime:    l.Uptime.Seconds(),
+		LastError: l.LastError,
+	}
+	for _, rtt := range l.RTTSamples {
+		entry.RTTSamples = append(entry.RTTSamples, rtt.Seconds())
+	}
+	return entry
+}
@@ -0,0 +1,61 @@
+package admin
+
+import (
+	"time"
+)
+
+type GetPeerRetriesRequest struct{}
+
+type GetPeerRetriesResponse struct {
+	Peers map[string]PeerRetryEntry `json:"peers"`
+}
+
+type PeerRetryEntry struct {
+	Interface    string     `json:"interface,omitempty"`
+	Tier         int        `json:"tier,omitempty"`
+	Connected    bool       `json:"connected"`
+	Standby      bool       `json:"standby,omitempty"`
+	Failures     int        `json:"failures"`
+	Next         *time.Time `json:"next,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	Score        int        `json:"score"`
+	Availability float64    `json:"availability"`
+	Flaps        int        `json:"flaps,omitempty"`
+	Latency      float64    `json:"latency,omitempty"`
+	Throughput   uint64     `json:"throughput,omitempty"`
+	Demoted      *time.Time `json:"demoted_until,omitempty"`
+}
+
+func (a *AdminSocket) getPeerRetriesHandler(req *GetPeerRetriesRequest, res *GetPeerRetriesResponse) error {
+	res.Peers = map[string]PeerRetryEntry{}
+	for _, p := range a.core.GetPeerRetries() {
+		entry := PeerRetryEntry{
+			Interface: p.Interface,
+			Tier:      p.Tier,
+			Connected: p.Connected,
+			Standby:   p.Standby,
+			Failures:  p.Failures,
+			LastError: p.LastError,
+
+			Score:        p.Score,
+			Availability: p.Availability,
+			Flaps:        p.Flaps,
+			Latency:      p.Latency.Seconds(),
+			Throughput:   p.Throughput,
+		}
+		if !p.Next.IsZero() {
+			next := p.Next
+			entry.Next = &next
+		}
+		if !p.Demoted.IsZero() {
+			demoted := p.Demoted
+			entry.Demoted = &demoted
+		}
+		name := p.URI
+		if p.Interface != "" {
+			name = p.Interface + "/" + p.URI
+		}
+		res.Peers[name] = entry
+	}
+	return nil
+}
@@ -22,6 +22,9 @@ type PeerEntry struct {
 	RXBytes   uint64   `json:"bytes_recvd"`
 	TXBytes   uint64   `json:"bytes_sent"`
 	Uptime    float64  `json:"uptime"`
+	Penalty   uint64   `json:"penalty"`
+	Latency   float64  `json:"latency,omitempty"`
+	Loss      float64  `json:"loss,omitempty"`
 }
 
 func (a *AdminSocket) getPeersHandler(req *GetPeersRequest, res *GetPeersResponse) error {
@@ -37,6 +40,9 @@ func (a *AdminSocket) getPeersHandler(req *GetPeersRequest, res *GetPeersRespons
 			RXBytes:   p.RXBytes,
 			TXBytes:   p.TXBytes,
 			Uptime:    p.Uptime.Seconds(),
+			Penalty:   p.Penalty,
+			Latency:   p.Latency.Seconds(),
+			Loss:      p.Loss,
 		}
 	}
 	return nil
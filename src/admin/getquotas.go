@@ -0,0 +1,34 @@
+package admin
+
+import (
+	"encoding/hex"
+	"time"
+)
+
+type GetQuotasRequest struct{}
+
+type GetQuotasResponse struct {
+	Quotas map[string]QuotaEntry `json:"quotas"`
+}
+
+type QuotaEntry struct {
+	Period string    `json:"period"`
+	Start  time.Time `json:"start"`
+	Bytes  uint64    `json:"bytes"`
+	Soft   uint64    `json:"soft,omitempty"`
+	Hard   uint64    `json:"hard,omitempty"`
+}
+
+func (a *AdminSocket) getQuotasHandler(req *GetQuotasRequest, res *GetQuotasResponse) error {
+	res.Quotas = map[string]QuotaEntry{}
+	for _, q := range a.core.GetQuotas() {
+		res.Quotas[hex.EncodeToString(q.Key)] = QuotaEntry{
+			Period: q.Period,
+			Start:  q.Start,
+			Bytes:  q.Bytes,
+			Soft:   q.Soft,
+			Hard:   q.Hard,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package admin
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/address"
+	"github.com/yggdrasil-network/yggdrasil-go/src/core"
+)
+
+// identityLiveTimeout is how long importIdentity waits for a reply from a
+// node that is already running with the imported key.
+const identityLiveTimeout = 5 * time.Second
+
+type ExportIdentityRequest struct {
+	Password string `json:"password"`
+}
+
+type ExportIdentityResponse struct {
+	Identity  string `json:"identity"`
+	PublicKey string `json:"key"`
+	IPAddress string `json:"address"`
+}
+
+type ImportIdentityRequest struct {
+	Identity string `json:"identity"`
+	Password string `json:"password"`
+	Force    bool   `json:"force"`
+}
+
+type ImportIdentityResponse struct {
+	PublicKey       string   `json:"key"`
+	IPAddress       string   `json:"address"`
+	RestartRequired []string `json:"restart_required"`
+}
+
+// IdentityPersister writes the private key of an imported identity to the
+// config file, in place of the node's own key, so that the node has the
+// imported identity once it is restarted. The key is never sent back over the
+// admin socket.
+type IdentityPersister func(secret ed25519.PrivateKey) error
+
+// SetIdentityPersister sets the function that writes an imported identity to
+// the config file, or nil if it can't be, in which case imports fail.
+func (a *AdminSocket) SetIdentityPersister(persist IdentityPersister) {
+	a.persistMutex.Lock()
+	defer a.persistMutex.Unlock()
+	a.persistIdentity = persist
+}
+
+func (a *AdminSocket) exportIdentityHandler(req *ExportIdentityRequest, res *ExportIdentityResponse) error {
+	identity, err := a.core.ExportIdentity(req.Password)
+	if err != nil {
+		return err
+	}
+	key := a.core.PublicKey()
+	res.Identity = base64.StdEncoding.EncodeToString(identity)
+	res.PublicKey = hex.EncodeToString(key)
+	res.IPAddress = a.core.Address().String()
+	return nil
+}
+
+func (a *AdminSocket) importIdentityHandler(req *ImportIdentityRequest, res *ImportIdentityResponse) error {
+	identity, err := base64.StdEncoding.DecodeString(req.Identity)
+	if err != nil {
+		return err
+	}
+	secret, err := core.ImportIdentity(identity, req.Password)
+	if err != nil {
+		return err
+	}
+	key := secret.Public().(ed25519.PublicKey)
+	if !req.Force && a.core.IsKeyLive(key, identityLiveTimeout) {
+		return errors.New("a node with this identity is still running, stop it first or use force=true")
+	}
+	a.persistMutex.Lock()
+	defer a.persistMutex.Unlock()
+	if a.persistIdentity == nil {
+		return errors.New("the identity can't be written to the config")
+	}
+	if err := a.persistIdentity(secret); err != nil {
+		return fmt.Errorf("the identity was not written to the config: %w", err)
+	}
+	addr := address.AddrForKey(key)
+	res.PublicKey = hex.EncodeToString(key)
+	res.RestartRequired = []string{"PrivateKey"}
+	res.IPAddress = net.IP(addr[:]).String()
+	return nil
+}
@@ -0,0 +1,71 @@
+package admin
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/gologme/log"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/core"
+	"github.com/yggdrasil-network/yggdrasil-go/src/defaults"
+)
+
+func TestImportIdentity(t *testing.T) {
+	logger := log.New(ioutil.Discard, "", 0)
+	cfg := defaults.GenerateConfig()
+	cfg.AdminListen = "none"
+	cfg.Listen = nil
+	cfg.IfName = "none"
+	var c core.Core
+	if err := c.Start(cfg, logger); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	var a AdminSocket
+	if err := a.Init(&c, cfg, logger, nil); err != nil {
+		t.Fatal(err)
+	}
+	identity, err := c.ExportIdentity("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &ImportIdentityRequest{
+		Identity: base64.StdEncoding.EncodeToString(identity),
+		Password: "hunter2",
+	}
+	if err := a.importIdentityHandler(req, &ImportIdentityResponse{}); err == nil {
+		t.Fatal("Identity of the running node was imported")
+	}
+	req.Force = true
+	if err := a.importIdentityHandler(req, &ImportIdentityResponse{}); err == nil {
+		t.Fatal("Identity was imported without a persister")
+	}
+	var persisted ed25519.PrivateKey
+	a.SetIdentityPersister(func(secret ed25519.PrivateKey) error {
+		persisted = secret
+		return nil
+	})
+	res := &ImportIdentityResponse{}
+	if err := a.importIdentityHandler(req, res); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(persisted.Public().(ed25519.PublicKey), c.PublicKey()) {
+		t.Fatal("The imported key was not persisted")
+	}
+	bs, err := json.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(bs), hex.EncodeToString(persisted.Seed())) {
+		t.Fatal("The private key was sent back:", string(bs))
+	}
+	if res.PublicKey != hex.EncodeToString(c.PublicKey()) || len(res.RestartRequired) != 1 {
+		t.Fatal("Unexpected response:", string(bs))
+	}
+}
@@ -0,0 +1,57 @@
+package admin
+
+import (
+	"errors"
+	"time"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/core"
+)
+
+type GetImpairmentsRequest struct{}
+
+type GetImpairmentsResponse struct {
+	Impairments map[string]ImpairmentEntry `json:"impairments"`
+}
+
+type ImpairmentEntry struct {
+	Latency   uint64  `json:"latency"` // Milliseconds
+	Jitter    uint64  `json:"jitter"`  // Milliseconds
+	Bandwidth uint64  `json:"bandwidth"`
+	Loss      float64 `json:"loss"` // Percent
+}
+
+type SetImpairmentRequest struct {
+	Remote string `json:"remote"`
+	ImpairmentEntry
+}
+
+type SetImpairmentResponse GetImpairmentsResponse
+
+func (a *AdminSocket) getImpairmentsHandler(req *GetImpairmentsRequest, res *GetImpairmentsResponse) error {
+	res.Impairments = map[string]ImpairmentEntry{}
+	for name, imp := range a.core.GetImpairments() {
+		res.Impairments[name] = ImpairmentEntry{
+			Latency:   uint64(imp.Latency / time.Millisecond),
+			Jitter:    uint64(imp.Jitter / time.Millisecond),
+			Bandwidth: imp.Bandwidth,
+			Loss:      imp.Loss * 100,
+		}
+	}
+	return nil
+}
+
+func (a *AdminSocket) setImpairmentHandler(req *SetImpairmentRequest, res *SetImpairmentResponse) error {
+	if req.Remote == "" {
+		return errors.New("the remote of the link must be given, as shown by getPeers")
+	}
+	if req.Loss < 0 || req.Loss > 100 {
+		return errors.New("loss must be a percentage")
+	}
+	a.core.SetImpairment(req.Remote, core.Impairment{
+		Latency:   time.Duration(req.Latency) * time.Millisecond,
+		Jitter:    time.Duration(req.Jitter) * time.Millisecond,
+		Bandwidth: req.Bandwidth,
+		Loss:      req.Loss / 100,
+	})
+	return a.getImpairmentsHandler(&GetImpairmentsRequest{}, (*GetImpairmentsResponse)(res))
+}
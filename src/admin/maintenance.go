@@ -0,0 +1,24 @@
+package admin
+
+type GetMaintenanceRequest struct{}
+
+type GetMaintenanceResponse struct {
+	RefuseSessions bool `json:"refuse_sessions"`
+}
+
+type SetMaintenanceRequest struct {
+	RefuseSessions bool `json:"refuse_sessions"`
+}
+
+type SetMaintenanceResponse GetMaintenanceResponse
+
+func (a *AdminSocket) getMaintenanceHandler(req *GetMaintenanceRequest, res *GetMaintenanceResponse) error {
+	res.RefuseSessions = a.core.RefusingNewSessions()
+	return nil
+}
+
+func (a *AdminSocket) setMaintenanceHandler(req *SetMaintenanceRequest, res *SetMaintenanceResponse) error {
+	a.core.SetRefuseNewSessions(req.RefuseSessions)
+	res.RefuseSessions = a.core.RefusingNewSessions()
+	return nil
+}
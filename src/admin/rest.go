@@ -0,0 +1,123 @@
+package admin
+
+// This file contains the HTTP admin API, which serves the same handlers as
+// the admin socket as JSON over HTTP, for web dashboards and automation that
+// would rather not speak the socket protocol. Each resource under /api/v1/ is
+// backed by one of the admin handlers, e.g. /api/v1/peers by getPeers, and
+// any query parameters are passed to the handler as its arguments. Responses
+// are the same structs that the socket sends in its response field, without
+// the envelope around them, and failures are reported with the status code
+// and an ErrorResponse.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const restPrefix = "/api/v1/"
+
+// restResources maps the resources of the HTTP API to the names of the admin
+// handlers that serve them.
+var restResources = map[string]string{
+	"self":     "getself",
+	"peers":    "getpeers",
+	"links":    "getlinks",
+	"sessions": "getsessions",
+	"paths":    "getpaths",
+	"dht":      "getdht",
+}
+
+type RESTIndexResponse struct {
+	Resources []string `json:"resources"`
+}
+
+// startREST starts serving the HTTP API on the given address.
+func (a *AdminSocket) startREST(listen string) error {
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen for the HTTP admin API: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(restPrefix, a.handleREST)
+	a.rest = &http.Server{Handler: a.authorize(mux), ReadHeaderTimeout: 10 * time.Second}
+	go func(server *http.Server) {
+		_ = server.Serve(listener)
+	}(a.rest)
+	a.log.Infof("Serving the HTTP admin API at http://%s%s", listener.Addr(), restPrefix)
+	if addr := listener.Addr().(*net.TCPAddr); a.token == "" && !addr.IP.IsLoopback() {
+		a.log.Warnln("WARNING: The HTTP admin API is reachable from other hosts but AdminToken is not set!")
+	}
+	return nil
+}
+
+// queryArgs returns the query parameters of a request as the arguments of an
+// admin handler.
+func queryArgs(r *http.Request) (json.RawMessage, error) {
+	args := map[string]interface{}{}
+	for k, v := range r.URL.Query() {
+		if len(v) > 0 {
+			args[k] = v[0]
+		}
+	}
+	return json.Marshal(args)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, &ErrorResponse{Error: err.Error()})
+}
+
+// handleREST serves a resource of the HTTP API, or the list of resources at
+// /api/v1/.
+func (a *AdminSocket) handleREST(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	resource := strings.Trim(strings.TrimPrefix(r.URL.Path, restPrefix), "/")
+	if resource == "" {
+		res := &RESTIndexResponse{}
+		for resource, name := range restResources {
+			if _, ok := a.handlers[name]; ok {
+				res.Resources = append(res.Resources, restPrefix+resource)
+			}
+		}
+		sort.Strings(res.Resources)
+		writeJSON(w, http.StatusOK, res)
+		return
+	}
+	h, ok := a.handlers[restResources[resource]]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown resource %q", resource))
+		return
+	}
+	in, err := queryArgs(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	res, err := h.handler(in)
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &typeErr):
+		writeError(w, http.StatusBadRequest, err)
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+	default:
+		writeJSON(w, http.StatusOK, res)
+	}
+}
@@ -0,0 +1,78 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gologme/log"
+)
+
+func TestREST(t *testing.T) {
+	a := &AdminSocket{
+		log:      log.New(ioutil.Discard, "", 0),
+		token:    "secret",
+		handlers: make(map[string]handler),
+	}
+	_ = a.AddHandler("getPeers", nil, func(_ json.RawMessage) (interface{}, error) {
+		return &GetPeersResponse{Peers: map[string]PeerEntry{"200::1": {Port: 1}}}, nil
+	})
+	_ = a.AddHandler("getSessions", nil, func(_ json.RawMessage) (interface{}, error) {
+		return nil, errors.New("broken")
+	})
+	server := httptest.NewServer(a.authorize(http.HandlerFunc(a.handleREST)))
+	defer server.Close()
+	get := func(method, path, token string) *http.Response {
+		req, err := http.NewRequest(method, server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	for _, test := range []struct {
+		method, path, token string
+		status              int
+	}{
+		{"GET", "/api/v1/peers", "", http.StatusUnauthorized},
+		{"GET", "/api/v1/peers", "wrong", http.StatusUnauthorized},
+		{"GET", "/api/v1/peers", "secret", http.StatusOK},
+		{"POST", "/api/v1/peers", "secret", http.StatusMethodNotAllowed},
+		{"GET", "/api/v1/links", "secret", http.StatusNotFound},
+		{"GET", "/api/v1/sessions", "secret", http.StatusInternalServerError},
+	} {
+		resp := get(test.method, test.path, test.token)
+		resp.Body.Close()
+		if resp.StatusCode != test.status {
+			t.Errorf("%s %s returned %d, expected %d", test.method, test.path, resp.StatusCode, test.status)
+		}
+	}
+
+	resp := get("GET", "/api/v1/peers", "secret")
+	defer resp.Body.Close()
+	var peers GetPeersResponse
+	if err := json.NewDecoder(resp.Body).Decode(&peers); err != nil {
+		t.Fatal(err)
+	}
+	if peers.Peers["200::1"].Port != 1 {
+		t.Fatal("Unexpected response:", peers)
+	}
+	index := get("GET", "/api/v1/", "secret")
+	defer index.Body.Close()
+	var res RESTIndexResponse
+	if err := json.NewDecoder(index.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Resources) != 2 || res.Resources[0] != "/api/v1/peers" {
+		t.Fatal("Unexpected resources:", res.Resources)
+	}
+}
@@ -27,18 +27,72 @@ import (
 // supply one of these structs to the Yggdrasil core when starting a node.
 type NodeConfig struct {
 	sync.RWMutex        `json:"-"`
-	Peers               []string                   `comment:"List of connection strings for outbound peer connections in URI format,\ne.g. tls://a.b.c.d:e or socks://a.b.c.d:e/f.g.h.i:j. These connections\nwill obey the operating system routing table, therefore you should\nuse this section when you may connect via different interfaces."`
-	InterfacePeers      map[string][]string        `comment:"List of connection strings for outbound peer connections in URI format,\narranged by source interface, e.g. { \"eth0\": [ tls://a.b.c.d:e ] }.\nNote that SOCKS peerings will NOT be affected by this option and should\ngo in the \"Peers\" section instead."`
-	Listen              []string                   `comment:"Listen addresses for incoming connections. You will need to add\nlisteners in order to accept incoming peerings from non-local nodes.\nMulticast peer discovery will work regardless of any listeners set\nhere. Each listener should be specified in URI format as above, e.g.\ntls://0.0.0.0:0 or tls://[::]:0 to listen on all interfaces."`
-	AdminListen         string                     `comment:"Listen address for admin connections. Default is to listen for local\nconnections either on TCP/9001 or a UNIX socket depending on your\nplatform. Use this value for yggdrasilctl -endpoint=X. To disable\nthe admin socket, use the value \"none\" instead."`
-	MulticastInterfaces []MulticastInterfaceConfig `comment:"Configuration for which interfaces multicast peer discovery should be\nenabled on. Each entry in the list should be a json object which may\ncontain Regex, Beacon, Listen, and Port. Regex is a regular expression\nwhich is matched against an interface name, and interfaces use the\nfirst configuration that they match gainst. Beacon configures whether\nor not the node should send link-local multicast beacons to advertise\ntheir presence, while listening for incoming connections on Port.\nListen controls whether or not the node listens for multicast beacons\nand opens outgoing connections."`
-	AllowedPublicKeys   []string                   `comment:"List of peer public keys to allow incoming peering connections\nfrom. If left empty/undefined then all connections will be allowed\nby default. This does not affect outgoing peerings, nor does it\naffect link-local peers discovered via multicast."`
-	PublicKey           string                     `comment:"Your public key. Your peers may ask you for this to put\ninto their AllowedPublicKeys configuration."`
-	PrivateKey          string                     `comment:"Your private key. DO NOT share this with anyone!"`
-	IfName              string                     `comment:"Local network interface name for TUN adapter, or \"auto\" to select\nan interface automatically, or \"none\" to run without TUN."`
-	IfMTU               uint64                     `comment:"Maximum Transmission Unit (MTU) size for your local TUN interface.\nDefault is the largest supported size for your platform. The lowest\npossible value is 1280."`
-	NodeInfoPrivacy     bool                       `comment:"By default, nodeinfo contains some defaults including the platform,\narchitecture and Yggdrasil version. These can help when surveying\nthe network and diagnosing network routing problems. Enabling\nnodeinfo privacy prevents this, so that only items specified in\n\"NodeInfo\" are sent back if specified."`
-	NodeInfo            map[string]interface{}     `comment:"Optional node info. This must be a { \"key\": \"value\", ... } map\nor set as null. This is entirely optional but, if set, is visible\nto the whole network on request."`
+	Peers               []string                       `comment:"List of connection strings for outbound peer connections in URI format,\ne.g. tls://a.b.c.d:e or socks://a.b.c.d:e/f.g.h.i:j. These connections\nwill obey the operating system routing table, therefore you should\nuse this section when you may connect via different interfaces. See\ndocs/peering.md for the other transports and the options of peers."`
+	InterfacePeers      map[string][]string            `comment:"List of connection strings for outbound peer connections in URI format,\narranged by source interface, e.g. { \"eth0\": [ tls://a.b.c.d:e ] }.\nNote that SOCKS peerings will NOT be affected by this option and should\ngo in the \"Peers\" section instead."`
+	Listen              []string                       `comment:"Listen addresses for incoming connections. You will need to add\nlisteners in order to accept incoming peerings from non-local nodes.\nMulticast peer discovery will work regardless of any listeners set\nhere. Each listener should be specified in URI format as above, e.g.\ntls://0.0.0.0:0 or tls://[::]:0 to listen on all interfaces. See\ndocs/peering.md for the other transports and the options of listeners."`
+	ListenFilters       []string                       `comment:"Filters on the source addresses of incoming connections to any of the\nlisteners, e.g. [ \"allow 192.0.2.0/24\", \"deny ::/0\" ]. The first filter\nwith a prefix that contains the address decides whether the connection\nis accepted, before any handshake, and those that match no filter are.\nTo only accept some addresses, end with \"deny 0.0.0.0/0\" and \"deny ::/0\"."`
+	MaxHandshakes       uint64                         `comment:"The most incoming connections to the listeners that may be handshaking\nat once, or 0 for no limit. Connections over the limit are closed\nstraight away, and those that take more than a minute to finish their\nhandshake are closed, so that a flood of connections can't use up\nmemory before AllowedPublicKeys is checked."`
+	ListenRate          uint64                         `comment:"The most incoming connections to the listeners that each address may\nopen per second, with bursts of twice as many, or 0 for no limit. IPv6\naddresses count against their /64."`
+	MultipathTCP        bool                           `comment:"Use Multipath TCP for the tcp://, tls:// and ws:// peers and listeners,\nso that links survive a node moving between networks, e.g. from Wi-Fi\nto LTE, without having to handshake again. Links fall back to TCP if\nthe kernel or the remote node doesn't support it. Currently only\nsupported on Linux."`
+	PenaltyHook         string                         `comment:"The path of a program to ask for the penalty of each link as it comes\nup, to set penalties by policy, e.g. to keep links on eth1 over others\nwhen MaxPeers evicts links. It is run with YGGDRASIL_LINK_TYPE, _NAME,\n_LOCAL, _REMOTE, _INTERFACE, _KEY, _INCOMING and _PENALTY set in its\nenvironment, and prints the penalty to use, from 0 to 255, or nothing\nto leave the penalty of the link as it is. The link waits for it for\nup to 5 seconds."`
+	TCPSocket           TCPSocketConfig                `comment:"Socket options for links over TCP, including tls://, ws:// and socks://.\nNoDelay sends small writes straight away rather than combining them,\nwhich suits interactive traffic, and is on by default. KeepAliveInterval\nis the number of seconds between TCP keepalives and KeepAliveCount the\nnumber that may go unanswered, where 0 leaves the operating system's\ndefaults. SendBuffer and ReceiveBuffer set the socket buffer sizes in\nbytes, which bulk transfers over fast, distant links need to be large.\nPeers and listeners can override these with the nodelay, tcpkeepalive,\ntcpkeepcount, sndbuf and rcvbuf options, e.g.\ntls://a.b.c.d:e?nodelay=false&tcpkeepalive=30s&sndbuf=4194304."`
+	LinkMark            uint32                         `comment:"On Linux, the firewall mark (SO_MARK) to set on the sockets of links,\nso that policy routing can keep them out of routes that go through\nYggdrasil itself, such as when it is the default gateway, where they\nwould loop. This needs CAP_NET_ADMIN. It applies to tcp://, tls://,\nws://, socks://, ssh://, h2://, udp://, kcp://, dns://, wg:// and\nsctp:// links. The default of 0 leaves sockets unmarked."`
+	ReadTimeout         uint64                         `comment:"Close links that have received nothing, not even a keepalive, for this\nmany milliseconds. The shortest timeout is 6000, as idle links only\ncarry keepalives every four seconds. The default of 0 leaves it to\nthe routing protocol and the operating system to notice dead links."`
+	WriteTimeout        uint64                         `comment:"Close links that have been unable to send anything for this many\nmilliseconds, as the remote node has stopped reading, rather than\nleaving what is queued for them stuck until the operating system gives\nup. The default of 0 waits for as long as it takes."`
+	AdminListen         string                         `comment:"Listen address for admin connections. Default is to listen for local\nconnections either on TCP/9001 or a UNIX socket depending on your\nplatform. Use this value for yggdrasilctl -endpoint=X. To disable\nthe admin socket, use the value \"none\" instead."`
+	AdminDashboard      bool                           `comment:"Serve a web dashboard showing peers, traffic, the routing tree and\nrecent events on the admin listener, from which peers can be added\nand removed. This is only reachable from a browser when AdminListen\nis a TCP address, e.g. tcp://localhost:9001, and only when it is\nopened with that host name or an IP address."`
+	AdminHTTPListen     string                         `comment:"Optionally serve the admin API as JSON over HTTP on this address, e.g.\n127.0.0.1:9002, for web dashboards and automation. GET /api/v1/ lists\nthe resources, such as /api/v1/peers, /api/v1/links, /api/v1/sessions\nand /api/v1/self, and query parameters are passed on as arguments. If\nAdminToken is set, requests must send it as a bearer token. This is\noff unless an address is set."`
+	AdminToken          string                         `comment:"A token that connections to a TCP admin socket must authenticate with\nbefore their requests are answered, either by sending it in the token\nfield of a request or by answering a challenge with it, as yggdrasilctl\n-token does. Connections over a UNIX socket are not asked for it. Set\nthis whenever AdminListen is reachable from other hosts or containers."`
+	MulticastInterfaces []MulticastInterfaceConfig     `comment:"Configuration for which interfaces multicast peer discovery should be\nenabled on. Each entry in the list should be a json object which may\ncontain Regex, Beacon, Listen, and Port. Regex is a regular expression\nwhich is matched against an interface name, and interfaces use the\nfirst configuration that they match gainst. Beacon configures whether\nor not the node should send link-local multicast beacons to advertise\ntheir presence, while listening for incoming connections on Port.\nListen controls whether or not the node listens for multicast beacons\nand opens outgoing connections."`
+	AllowedPublicKeys   []string                       `comment:"List of peer public keys to allow incoming peering connections\nfrom. If left empty/undefined then all connections will be allowed\nby default. This does not affect outgoing peerings, nor does it\naffect link-local peers discovered via multicast."`
+	MaxPeers            uint64                         `comment:"The most links that the node keeps up at once, or 0 for no limit. Once\nthere are this many, each new link evicts an incoming one, starting\nwith those with the highest penalty and then those that have been idle\nthe longest. Outgoing peerings and link-local peers are never evicted,\nand are let in over the limit if there is nothing that can be."`
+	ActivePeers         uint64                         `comment:"The most peers from Peers and InterfacePeers to be called at once, or\n0 to call all of them. With more peers than this, a random few of them\nare called, and each one that fails or drops is swapped for another,\nso that many public peers can be listed without connecting to all."`
+	PeerRotation        uint64                         `comment:"With ActivePeers set, also swap out the peer that has been connected\nthe longest every this many seconds, to spread the load over all of\nthe peers. The default of 0 keeps peers for as long as they stay up."`
+	PeerSchedules       map[string]string              `comment:"Times at which peerings with particular nodes may be up, by public\nkey, e.g. { \"<key>\": \"Mon-Fri/22:00-06:00,Sat-Sun/00:00-24:00\" }, for\npeers over metered links. Times are local. Links outside of their\nschedule are refused or closed. Outbound peers can also be given a\nschedule in their URI, e.g. tls://a.b.c.d:e?schedule=22:00-06:00,\nwhich controls when they are dialled."`
+	PeerQuotas          map[string]PeerQuotaConfig     `comment:"Traffic quotas for peerings with particular nodes, by public key, for\npeers over metered links. Bytes sent and received over all links with\nthe node are counted over each Period, which is daily, weekly or\nmonthly. Over the Soft quota, sending is limited to SoftRate bytes per\nsecond, which is the only way that the Soft quota limits traffic, so\nit needs SoftRate to be set to have any effect. The link penalty is\nraised too, which shows in getPeers and makes MaxPeers evict the link\nfirst. Over the Hard quota,\nlinks are closed and refused until the next period. Usage is saved to\nQuotaFile, if set, so that it survives restarts."`
+	QuotaFile           string                         `comment:"File in which to save traffic quota usage."`
+	PeerRateLimits      map[string]PeerRateLimitConfig `comment:"Bandwidth limits for peerings with particular nodes, by public key, e.g.\n{ \"<key>\": { \"Up\": 1000000, \"Down\": 4000000 } }, in bits per second,\nfor peers over metered or slow uplinks. Limits are shared by all links\nwith the node, and 0 is unlimited. Peers and listeners can also be\nlimited in their URI, e.g. tls://a.b.c.d:e?maxbps=1000000, which limits\neach of their links, or with maxbpsup and maxbpsdown for each direction."`
+	PublicKey           string                         `comment:"Your public key. Your peers may ask you for this to put\ninto their AllowedPublicKeys configuration."`
+	PrivateKey          string                         `comment:"Your private key. DO NOT share this with anyone!"`
+	IfName              string                         `comment:"Local network interface name for TUN adapter, or \"auto\" to select\nan interface automatically, or \"none\" to run without TUN."`
+	IfMTU               uint64                         `comment:"Maximum Transmission Unit (MTU) size for your local TUN interface.\nDefault is the largest supported size for your platform. The lowest\npossible value is 1280."`
+	IfRoutes            bool                           `comment:"Install and maintain kernel routes for the Yggdrasil prefix and for\neach directly connected peer on the TUN adapter, and remove them\nagain on shutdown. Currently only supported on Linux."`
+	IfExportTable       int                            `comment:"If set to a kernel routing table number, routes for the Yggdrasil\nprefix, your own subnet and the subnets of directly connected peers\n(only those in AllowedPublicKeys, if set) are kept in that table, so\nthat a routing daemon such as BIRD or FRR can redistribute them.\nCurrently only supported on Linux."`
+	IfIPv4Address       string                         `comment:"Optionally give the TUN adapter an IPv4 address within a private range,\ne.g. 10.64.0.1/10, so that IPv4-only applications can reach the mesh.\nIPv4 packets sent to other addresses in the range are translated into\nIPv6 packets to the Yggdrasil addresses they are mapped to, and sent\nfrom an address in your subnet. Currently only supported on Linux."`
+	IfIPv4Map           map[string]string              `comment:"Static mappings of IPv4 addresses within IfIPv4Address to Yggdrasil\naddresses, e.g. { \"10.64.0.2\": \"200:1234::1\" }. Further mappings can\nbe added at runtime with the addIPv4Mapping admin call, e.g. by a DNS\nresolver that answers A queries for mesh names."`
+	DHCPv6PD            DHCPv6PDConfig                 `comment:"Optionally run a DHCPv6 prefix delegation server on a LAN interface,\nhanding out prefixes from your subnet to downstream routers. Set\nInterface to enable it. PrefixLength is the length of each delegated\nprefix, between 65 and 128, defaulting to 72. Leases are saved to\nLeaseFile, if set, so that they survive restarts."`
+	RAInterface         string                         `comment:"Optionally send IPv6 router advertisements on a LAN interface, so that\nunmodified devices on the LAN take an address from your subnet and\nreach the Yggdrasil prefix through this node. Devices must accept\nroute information options, e.g. accept_ra_rt_info_max_plen on Linux.\nIP forwarding must be enabled on this node."`
+	StatsCollector      string                         `comment:"Optionally send anonymous statistics about this node to a collector,\ne.g. https://stats.example.net/report, by HTTP POST every six hours.\nThis is off unless a collector is set. Reports contain only the build\nversion and platform, the number of peers and the uptime in hours,\nand can be previewed with the getStatsReport admin call."`
+	MetricsListen       string                         `comment:"Optionally serve Prometheus metrics over HTTP at /metrics on this\naddress, e.g. 127.0.0.1:9464. These include the byte and frame counts\nof each link, handshakes that failed, and the numbers of peers, DHT\nentries, paths and sessions. The link labels include the addresses\nand keys of peers, so this should only be reachable from a trusted\nnetwork. This is off unless an address is set."`
+	TraceEndpoint       string                         `comment:"Optionally send OpenTelemetry traces of links being set up, such as\nhow long each call spent dialling and handshaking, by OTLP over HTTP\nto this collector, e.g. http://localhost:4318. This is off unless an\nendpoint is set."`
+	NodeInfoPrivacy     bool                           `comment:"By default, nodeinfo contains some defaults including the platform,\narchitecture and Yggdrasil version. These can help when surveying\nthe network and diagnosing network routing problems. Enabling\nnodeinfo privacy prevents this, so that only items specified in\n\"NodeInfo\" are sent back if specified."`
+	NodeInfo            map[string]interface{}         `comment:"Optional node info. This must be a { \"key\": \"value\", ... } map\nor set as null. This is entirely optional but, if set, is visible\nto the whole network on request."`
+}
+
+type DHCPv6PDConfig struct {
+	Interface    string
+	PrefixLength int
+	LeaseFile    string
+}
+
+type PeerQuotaConfig struct {
+	Period   string
+	Soft     uint64
+	Hard     uint64
+	SoftRate uint64
+}
+
+type TCPSocketConfig struct {
+	NoDelay           bool
+	KeepAliveInterval uint64
+	KeepAliveCount    uint64
+	SendBuffer        uint64
+	ReceiveBuffer     uint64
+}
+
+type PeerRateLimitConfig struct {
+	Up   uint64
+	Down uint64
 }
 
 type MulticastInterfaceConfig struct {
@@ -7,7 +7,7 @@ import (
 
 	//"encoding/hex"
 	"encoding/json"
-	//"errors"
+	"errors"
 	//"fmt"
 	"net"
 	"net/url"
@@ -15,6 +15,7 @@ import (
 	//"sort"
 	//"time"
 
+	"github.com/Arceliar/phony"
 	"github.com/gologme/log"
 	"github.com/yggdrasil-network/yggdrasil-go/src/address"
 	//"github.com/yggdrasil-network/yggdrasil-go/src/crypto"
@@ -36,6 +37,9 @@ type Peer struct {
 	RXBytes uint64
 	TXBytes uint64
 	Uptime  time.Duration
+	Penalty uint64
+	Latency time.Duration // Smoothed round trip time, if the link measures it
+	Loss    float64       // Smoothed fraction of segments retransmitted, if the link measures it
 }
 
 type DHTEntry struct {
@@ -65,9 +69,15 @@ func (c *Core) GetSelf() Self {
 func (c *Core) GetPeers() []Peer {
 	var peers []Peer
 	names := make(map[net.Conn]string)
+	penalties := make(map[net.Conn]uint64)
+	latencies := make(map[net.Conn]time.Duration)
+	losses := make(map[net.Conn]float64)
 	c.links.mutex.Lock()
 	for _, info := range c.links.links {
 		names[info.conn] = info.lname
+		penalties[info.conn] = info.penalty.total()
+		latencies[info.conn] = time.Duration(atomic.LoadInt64(&info.penalty.latency))
+		losses[info.conn] = info.penalty.smoothedLoss()
 	}
 	c.links.mutex.Unlock()
 	ps := c.PacketConn.PacketConn.Debug.GetPeers()
@@ -81,6 +91,9 @@ func (c *Core) GetPeers() []Peer {
 		if name := names[p.Conn]; name != "" {
 			info.Remote = name
 		}
+		info.Penalty = penalties[p.Conn]
+		info.Latency = latencies[p.Conn]
+		info.Loss = losses[p.Conn]
 		if linkconn, ok := p.Conn.(*linkConn); ok {
 			info.RXBytes = atomic.LoadUint64(&linkconn.rx)
 			info.TXBytes = atomic.LoadUint64(&linkconn.tx)
@@ -167,75 +180,79 @@ func (c *Core) SetLogger(log *log.Logger) {
 // 		tcp://a.b.c.d:e
 //		socks://a.b.c.d:e/f.g.h.i:j
 // This adds the peer to the peer list, so that they will be called again if the
-// connection drops.
-/*
-func (c *Core) AddPeer(addr string, sintf string) error {
-	if err := c.CallPeer(addr, sintf); err != nil {
-		// TODO: We maybe want this to write the peer to the persistent
-		// configuration even if a connection attempt fails, but first we'll need to
-		// move the code to check the peer URI so that we don't deliberately save a
-		// peer with a known bad URI. Loading peers from config should really do the
-		// same thing too but I don't think that happens today
+// connection drops. The peer is called straight away, from the given source
+// interface if it isn't empty, as though it were in InterfacePeers.
+func (c *Core) AddPeer(u *url.URL, sintf string) error {
+	addr := u.String()
+	if err := checkPeerURI(addr); err != nil {
 		return err
 	}
-	c.config.Mutex.Lock()
-	defer c.config.Mutex.Unlock()
-	if sintf == "" {
-		for _, peer := range c.config.Current.Peers {
-			if peer == addr {
-				return errors.New("peer already added")
-			}
+	c.config.Lock()
+	peers := c.config.Peers
+	if sintf != "" {
+		peers = c.config.InterfacePeers[sintf]
+	}
+	for _, peer := range peers {
+		if samePeer(peer, u) {
+			c.config.Unlock()
+			return errors.New("peer already added")
 		}
-		c.config.Current.Peers = append(c.config.Current.Peers, addr)
+	}
+	if sintf == "" {
+		c.config.Peers = append(c.config.Peers, addr)
 	} else {
-		if _, ok := c.config.Current.InterfacePeers[sintf]; ok {
-			for _, peer := range c.config.Current.InterfacePeers[sintf] {
-				if peer == addr {
-					return errors.New("peer already added")
-				}
-			}
-		}
-		if _, ok := c.config.Current.InterfacePeers[sintf]; !ok {
-			c.config.Current.InterfacePeers[sintf] = []string{addr}
-		} else {
-			c.config.Current.InterfacePeers[sintf] = append(c.config.Current.InterfacePeers[sintf], addr)
+		if c.config.InterfacePeers == nil {
+			c.config.InterfacePeers = map[string][]string{}
 		}
+		c.config.InterfacePeers[sintf] = append(c.config.InterfacePeers[sintf], addr)
 	}
+	c.config.Unlock()
+	c.Act(nil, func() {
+		c.config.RLock()
+		defer c.config.RUnlock()
+		c._callConfiguredPeers(false)
+	})
 	return nil
 }
-*/
 
-/*
-func (c *Core) RemovePeer(addr string, sintf string) error {
-	if sintf == "" {
-		for i, peer := range c.config.Current.Peers {
-			if peer == addr {
-				c.config.Current.Peers = append(c.config.Current.Peers[:i], c.config.Current.Peers[i+1:]...)
-				break
-			}
-		}
-	} else if _, ok := c.config.Current.InterfacePeers[sintf]; ok {
-		for i, peer := range c.config.Current.InterfacePeers[sintf] {
-			if peer == addr {
-				c.config.Current.InterfacePeers[sintf] = append(c.config.Current.InterfacePeers[sintf][:i], c.config.Current.InterfacePeers[sintf][i+1:]...)
-				break
+// RemovePeer removes a peer that was added with AddPeer or that is in the
+// config, from the Peers section and from every interface in InterfacePeers.
+// Any links to the peer are closed, and it isn't called again, even if a call
+// to it is already under way.
+func (c *Core) RemovePeer(u *url.URL) error {
+	found := false
+	remove := func(peers []string) []string {
+		kept := peers[:0]
+		for _, peer := range peers {
+			if samePeer(peer, u) {
+				found = true
+				continue
 			}
+			kept = append(kept, peer)
 		}
+		return kept
 	}
-
-	panic("TODO") // Get the net.Conn to this peer (if any) and close it
-	c.peers.Act(nil, func() {
-		ports := c.peers.ports
-		for _, peer := range ports {
-			if addr == peer.intf.name() {
-				c.peers._removePeer(peer)
-			}
-		}
-	})
-
+	c.config.Lock()
+	c.config.Peers = remove(c.config.Peers)
+	for intf, peers := range c.config.InterfacePeers {
+		c.config.InterfacePeers[intf] = remove(peers)
+	}
+	c.config.Unlock()
+	if !found {
+		return errors.New("peer not found")
+	}
+	c.links.closePeers(c.peers.remove(u), "its peer has been removed")
 	return nil
 }
-*/
+
+// samePeer returns true if the peer URI from the config is the given one.
+func samePeer(peer string, u *url.URL) bool {
+	if peer == u.String() {
+		return true
+	}
+	pu, err := url.Parse(peer)
+	return err == nil && pu.String() == u.String()
+}
 
 // CallPeer calls a peer once. This should be specified in the peer URI format,
 // e.g.:
@@ -244,7 +261,46 @@ func (c *Core) RemovePeer(addr string, sintf string) error {
 // This does not add the peer to the peer list, so if the connection drops, the
 // peer will not be called again automatically.
 func (c *Core) CallPeer(u *url.URL, sintf string) error {
-	return c.links.call(u, sintf)
+	return c.links.call(u, sintf, nil)
+}
+
+// AddConn runs a link with a peer over an already established connection,
+// such as an in-memory pipe, blocking until the link closes. The name is used
+// to identify the link in GetPeers. If incoming is set, then the peer is
+// subject to AllowedPublicKeys as though it had connected to a listener. If
+// the node already has a link with the same name to the same key, then the
+// connection isn't used and ErrLinkAlreadyExists is returned.
+func (c *Core) AddConn(conn net.Conn, name string, incoming bool) error {
+	intf, err := c.links.create(conn, name, "conn", "", name, incoming, false, linkOptions{})
+	if err != nil {
+		return err
+	}
+	_, err = intf.handler()
+	return err
+}
+
+// DialMemory links the node with another node in the same process over an
+// in-memory pipe, as though it had called a listener on the other node. The
+// link runs in the background and isn't called again if it drops. This is
+// much cheaper than going through a listener on the loopback interface, so it
+// suits tests and simulations.
+func (c *Core) DialMemory(other *Core) error {
+	if other == c {
+		return errors.New("a node cannot link to itself")
+	}
+	c.links.tcp.mem.link(&other.links.tcp.mem)
+	return nil
+}
+
+// RetryPeers immediately tries to connect to any configured peers that are
+// not already connected, rather than waiting for the next retry interval. This
+// is useful when the network connectivity of the host has changed.
+func (c *Core) RetryPeers() {
+	phony.Block(c, func() {
+		c.config.RLock()
+		defer c.config.RUnlock()
+		c._callConfiguredPeers(true)
+	})
 }
 
 func (c *Core) PublicKey() ed25519.PublicKey {
@@ -265,6 +321,9 @@ func (c *Core) SetAdmin(a AddHandler) error {
 	if err := a.AddHandler("getNodeInfo", []string{"key"}, c.proto.nodeinfo.nodeInfoAdminHandler); err != nil {
 		return err
 	}
+	if err := a.AddHandler("lookupService", []string{"name"}, c.proto.services.lookupAdminHandler); err != nil {
+		return err
+	}
 	if err := a.AddHandler("debug_remoteGetSelf", []string{"key"}, c.proto.getSelfHandler); err != nil {
 		return err
 	}
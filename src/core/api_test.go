@@ -0,0 +1,51 @@
+package core
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// TestCore_Drain checks that draining a node closes its links and stops it.
+func TestCore_AddRemovePeer(t *testing.T) {
+	cfgA := GenerateConfig()
+	cfgA.Listen = []string{"mem://test-peers"}
+	nodeA := new(Core)
+	if err := nodeA.Start(cfgA, GetLoggerWithPrefix("A: ", false)); err != nil {
+		t.Fatal(err)
+	}
+	defer nodeA.Stop()
+	cfgB := GenerateConfig()
+	cfgB.Listen = nil
+	nodeB := new(Core)
+	if err := nodeB.Start(cfgB, GetLoggerWithPrefix("B: ", false)); err != nil {
+		t.Fatal(err)
+	}
+	defer nodeB.Stop()
+
+	u, _ := url.Parse("mem://test-peers")
+	if err := nodeB.AddPeer(u, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := nodeB.AddPeer(u, ""); err == nil {
+		t.Fatal("peer was added twice")
+	}
+	if !WaitConnected(nodeA, nodeB) {
+		t.Fatal("nodes did not connect")
+	}
+	if err := nodeB.RemovePeer(u); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50 && len(nodeB.GetPeers()) > 0; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if l := len(nodeB.GetPeers()); l != 0 {
+		t.Fatal("unexpected number of peers after removing", l)
+	}
+	if l := len(nodeB.GetPeerRetries()); l != 0 {
+		t.Fatal("removed peer is still being retried")
+	}
+	if err := nodeB.RemovePeer(u); err == nil {
+		t.Fatal("peer was removed twice")
+	}
+}
@@ -0,0 +1,80 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// awdlInterface is the interface of the Apple Wireless Direct Link.
+const awdlInterface = "awdl0"
+
+// tcpawdl hangs the AWDL transport off of the TCP one. AWDL links are TCP
+// over the link-local addresses of the awdl0 interface, which lets nearby
+// Apple devices peer with no network at all, but the sockets have to be bound
+// to awdl0 and allowed to use it. Listeners are also advertised over Bonjour
+// with peer-to-peer enabled, as awdl0 otherwise only comes up when something
+// is browsing or advertising on it.
+type tcpawdl struct {
+	tcp     *tcp
+	upgrade *TcpUpgrade
+}
+
+func (a *tcpawdl) init(tcp *tcp) {
+	a.tcp = tcp
+	a.upgrade = &TcpUpgrade{
+		upgrade: func(c net.Conn, _ *tcpOptions) (net.Conn, error) { return c, nil },
+		name:    "awdl",
+		dial:    a.dial,
+	}
+}
+
+// awdlHost returns the link-local address of a peer URI, with the zone of the
+// AWDL interface.
+func awdlHost(u *url.URL) (string, error) {
+	ip := net.ParseIP(strings.Split(u.Hostname(), "%")[0])
+	if ip == nil || !ip.IsLinkLocalUnicast() || ip.To4() != nil {
+		return "", fmt.Errorf("awdl peer %s must have an IPv6 link-local address", u.String())
+	}
+	if u.Port() == "" {
+		return "", fmt.Errorf("awdl peer %s must give a port", u.String())
+	}
+	return net.JoinHostPort(ip.String()+"%"+awdlInterface, u.Port()), nil
+}
+
+func (a *tcpawdl) dial(ctx context.Context, saddr string, _ *tcpOptions) (net.Conn, error) {
+	dialer := net.Dialer{Control: awdlControl}
+	ctx, done := context.WithTimeout(ctx, default_timeout)
+	defer done()
+	return dialer.DialContext(ctx, "tcp6", saddr)
+}
+
+// awdlListener stops advertising the listener when it is closed.
+type awdlListener struct {
+	net.Listener
+	stopAdvertising func()
+}
+
+func (l *awdlListener) Close() error {
+	l.stopAdvertising()
+	return l.Listener.Close()
+}
+
+func (a *tcpawdl) listen(u *url.URL) (*TcpListener, error) {
+	lc := net.ListenConfig{Control: awdlControl}
+	listener, err := lc.Listen(a.tcp.links.core.ctx, "tcp6", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	l := TcpListener{
+		Listener: &awdlListener{listener, advertiseAWDL(p)},
+		opts:     tcpOptions{upgrade: a.upgrade},
+		stop:     make(chan struct{}),
+	}
+	return &l, nil
+}
@@ -0,0 +1,35 @@
+//go:build darwin
+// +build darwin
+
+package core
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// awdlControl binds a socket to the AWDL interface. Sockets can't use awdl0
+// unless they ask to receive on any interface, even when bound to it.
+func awdlControl(network, address string, c syscall.RawConn) error {
+	intf, err := net.InterfaceByName(awdlInterface)
+	if err != nil {
+		return fmt.Errorf("no %s interface: %w", awdlInterface, err)
+	}
+	var bound, recvanyif error
+	control := c.Control(func(fd uintptr) {
+		bound = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_BOUND_IF, intf.Index)
+		// sys/socket.h: #define	SO_RECV_ANYIF	0x1104
+		recvanyif = unix.SetsockoptInt(int(fd), syscall.SOL_SOCKET, 0x1104, 1)
+	})
+	switch {
+	case bound != nil:
+		return bound
+	case recvanyif != nil:
+		return recvanyif
+	default:
+		return control
+	}
+}
@@ -0,0 +1,43 @@
+//go:build darwin && cgo
+// +build darwin,cgo
+
+package core
+
+/*
+#cgo CFLAGS: -x objective-c
+#cgo LDFLAGS: -framework Foundation
+#import <Foundation/Foundation.h>
+static void *startAWDLService(int port) {
+	@autoreleasepool {
+		NSNetService *service = [[NSNetService alloc] initWithDomain:@"" type:@"_yggdrasil._tcp" name:@"" port:port];
+		service.includesPeerToPeer = YES;
+		// The calling thread has no run loop, so the service is run by the
+		// main one instead
+		[service scheduleInRunLoop:[NSRunLoop mainRunLoop] forMode:NSRunLoopCommonModes];
+		[service publish];
+		return service;
+	}
+}
+static void stopAWDLService(void *handle) {
+	@autoreleasepool {
+		NSNetService *service = (NSNetService *)handle;
+		[service stop];
+		[service removeFromRunLoop:[NSRunLoop mainRunLoop] forMode:NSRunLoopCommonModes];
+		[service release];
+	}
+}
+*/
+import "C"
+
+import "sync"
+
+// advertiseAWDL advertises the port over Bonjour, including on awdl0, and
+// returns a function to stop. Each listener has a service of its own, so that
+// closing one doesn't stop the others from being advertised.
+func advertiseAWDL(port int) func() {
+	service := C.startAWDLService(C.int(port))
+	var once sync.Once
+	return func() {
+		once.Do(func() { C.stopAWDLService(service) })
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !darwin || !cgo
+// +build !darwin !cgo
+
+package core
+
+// advertiseAWDL does nothing without cgo, as Bonjour is only available through
+// Foundation, so awdl0 must be brought up by something else, such as
+// multicast discovery built with cgo.
+func advertiseAWDL(port int) func() {
+	return func() {}
+}
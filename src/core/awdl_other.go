@@ -0,0 +1,13 @@
+//go:build !darwin
+// +build !darwin
+
+package core
+
+import (
+	"errors"
+	"syscall"
+)
+
+func awdlControl(network, address string, c syscall.RawConn) error {
+	return errors.New("awdl links are only supported on macOS and iOS")
+}
@@ -0,0 +1,89 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+)
+
+// tcpbt hangs the Bluetooth transport off of the TCP one, in the same way as
+// the serial transport. Links run over RFCOMM, which gives a reliable stream
+// between two nearby devices, so that they can peer without an IP network.
+// Nothing in Bluetooth is trusted: the link handshake authenticates the peer
+// just as it does over TCP, so the devices don't need to be paired.
+type tcpbt struct {
+	tcp     *tcp
+	upgrade *TcpUpgrade
+}
+
+func (b *tcpbt) init(tcp *tcp) {
+	b.tcp = tcp
+	b.upgrade = &TcpUpgrade{
+		upgrade: func(c net.Conn, _ *tcpOptions) (net.Conn, error) { return c, nil },
+		name:    "bt",
+		dial:    b.dial,
+	}
+}
+
+// btAddr is a Bluetooth device address and RFCOMM channel.
+type btAddr struct {
+	mac     [6]byte // In the order it is written, not the little-endian bdaddr_t
+	channel uint8
+}
+
+func (a *btAddr) Network() string { return "bt" }
+
+func (a *btAddr) String() string {
+	return fmt.Sprintf("%02X:%02X:%02X:%02X:%02X:%02X:%d",
+		a.mac[0], a.mac[1], a.mac[2], a.mac[3], a.mac[4], a.mac[5], a.channel)
+}
+
+// parseBTAddr reads the device address and RFCOMM channel from a URI such as
+// bt://AA:BB:CC:DD:EE:FF:3. Listeners can use 00:00:00:00:00:00 to listen on
+// all adapters.
+func parseBTAddr(u *url.URL) (*btAddr, error) {
+	mac, err := net.ParseMAC(u.Hostname())
+	if err != nil || len(mac) != 6 {
+		return nil, fmt.Errorf("%s must give a Bluetooth address, e.g. bt://AA:BB:CC:DD:EE:FF:3", u.String())
+	}
+	channel, err := strconv.ParseUint(u.Port(), 10, 8)
+	if err != nil || channel < 1 || channel > 30 {
+		return nil, fmt.Errorf("%s must give an RFCOMM channel from 1 to 30", u.String())
+	}
+	addr := &btAddr{channel: uint8(channel)}
+	copy(addr.mac[:], mac)
+	return addr, nil
+}
+
+func (b *tcpbt) dial(ctx context.Context, saddr string, _ *tcpOptions) (net.Conn, error) {
+	u, err := url.Parse("bt://" + saddr)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := parseBTAddr(u)
+	if err != nil {
+		return nil, err
+	}
+	ctx, done := context.WithTimeout(ctx, default_timeout)
+	defer done()
+	return dialBT(ctx, addr)
+}
+
+func (b *tcpbt) listen(u *url.URL) (*TcpListener, error) {
+	addr, err := parseBTAddr(u)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := listenBT(addr)
+	if err != nil {
+		return nil, err
+	}
+	l := TcpListener{
+		Listener: listener,
+		opts:     tcpOptions{upgrade: b.upgrade},
+		stop:     make(chan struct{}),
+	}
+	return &l, nil
+}
@@ -0,0 +1,164 @@
+//go:build linux
+// +build linux
+
+package core
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// The standard library can't make a net.Conn from a Bluetooth socket, so the
+// sockets are wrapped in an os.File instead, which is non-blocking and so
+// still supports deadlines.
+
+func (a *btAddr) sockaddr() *unix.SockaddrRFCOMM {
+	sa := &unix.SockaddrRFCOMM{Channel: a.channel}
+	for i := range a.mac {
+		sa.Addr[i] = a.mac[len(a.mac)-1-i]
+	}
+	return sa
+}
+
+func btAddrFrom(sa unix.Sockaddr) *btAddr {
+	addr := &btAddr{}
+	if rc, ok := sa.(*unix.SockaddrRFCOMM); ok {
+		for i := range addr.mac {
+			addr.mac[i] = rc.Addr[len(rc.Addr)-1-i]
+		}
+		addr.channel = rc.Channel
+	}
+	return addr
+}
+
+func btSocket() (int, error) {
+	return unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_STREAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, unix.BTPROTO_RFCOMM)
+}
+
+// btConn is an RFCOMM connection.
+type btConn struct {
+	*os.File
+	local  *btAddr
+	remote *btAddr
+}
+
+func newBTConn(fd int, remote *btAddr) *btConn {
+	c := &btConn{File: os.NewFile(uintptr(fd), "bt:"+remote.String()), remote: remote}
+	if sa, err := unix.Getsockname(fd); err == nil {
+		c.local = btAddrFrom(sa)
+	} else {
+		c.local = &btAddr{}
+	}
+	return c
+}
+
+func (c *btConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *btConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func dialBT(ctx context.Context, addr *btAddr) (net.Conn, error) {
+	fd, err := btSocket()
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err = unix.Connect(fd, addr.sockaddr()); err != nil && err != unix.EINPROGRESS {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("connect", err)
+	}
+	c := newBTConn(fd, addr)
+	if err == unix.EINPROGRESS {
+		// Wait for the socket to become writable, which it does once the
+		// connection either succeeds or fails
+		if deadline, ok := ctx.Deadline(); ok {
+			_ = c.SetWriteDeadline(deadline)
+		}
+		raw, err := c.SyscallConn()
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		var serr error
+		if err := raw.Write(func(fd uintptr) bool {
+			var errno int
+			errno, serr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_ERROR)
+			if serr == nil && errno != 0 {
+				serr = unix.Errno(errno)
+			}
+			return true
+		}); err != nil {
+			c.Close()
+			return nil, err
+		}
+		if serr != nil {
+			c.Close()
+			return nil, os.NewSyscallError("connect", serr)
+		}
+		_ = c.SetWriteDeadline(time.Time{})
+		if sa, err := unix.Getsockname(fd); err == nil {
+			c.local = btAddrFrom(sa)
+		}
+	}
+	return c, nil
+}
+
+// btListener accepts RFCOMM connections.
+type btListener struct {
+	file  *os.File
+	local *btAddr
+	once  sync.Once
+}
+
+func listenBT(addr *btAddr) (net.Listener, error) {
+	fd, err := btSocket()
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err := unix.Bind(fd, addr.sockaddr()); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	if err := unix.Listen(fd, unix.SOMAXCONN); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("listen", err)
+	}
+	return &btListener{file: os.NewFile(uintptr(fd), "bt:"+addr.String()), local: addr}, nil
+}
+
+func (l *btListener) Accept() (net.Conn, error) {
+	raw, err := l.file.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var nfd int
+	var sa unix.Sockaddr
+	var aerr error
+	if err := raw.Read(func(fd uintptr) bool {
+		nfd, sa, aerr = unix.Accept4(int(fd), unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC)
+		return aerr != unix.EAGAIN
+	}); err != nil {
+		return nil, err
+	}
+	if aerr != nil {
+		return nil, os.NewSyscallError("accept", aerr)
+	}
+	return newBTConn(nfd, btAddrFrom(sa)), nil
+}
+
+func (l *btListener) Close() error {
+	var err error
+	l.once.Do(func() { err = l.file.Close() })
+	return err
+}
+
+func (l *btListener) Addr() net.Addr {
+	return l.local
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package core
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// Bluetooth sockets on macOS are only available through IOBluetooth, which
+// would need cgo, so for now only Linux is supported.
+var errNoBT = errors.New("bluetooth links are only supported on Linux")
+
+func dialBT(ctx context.Context, addr *btAddr) (net.Conn, error) {
+	return nil, errNoBT
+}
+
+func listenBT(addr *btAddr) (net.Listener, error) {
+	return nil, errNoBT
+}
@@ -0,0 +1,396 @@
+package core
+
+// This file contains multipath bundling, which runs several links to the same
+// node, such as over two interfaces or two transports, as a single peering.
+// Otherwise ironwood sees each link as a separate peer and only ever routes
+// over one of them. Links opt in with the bundle option, on both the peer and
+// the listener, which is either "packet", to spread traffic over the links one
+// frame at a time, or "flow", to keep the traffic between each pair of nodes
+// on one link so that it isn't reordered. Flows can't be told apart any more
+// finely than that, such as by the IPv6 flow label, as the packets are
+// encrypted end to end by ironwood before they reach the links, and only the
+// keys of the nodes at either end are visible in the frames.
+//
+// Ironwood sends exactly one frame per write, with a 2 byte length first, so
+// the bundle passes whole frames to the links that it picks. Protocol frames
+// are always sent on the oldest link in the bundle, as the tree and the DHT
+// are sensitive to reordering, and only traffic is spread out.
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	bundleKeepaliveInterval = time.Second
+	bundleLinkTimeout       = 10 * time.Second
+	bundleFrameQueue        = 256 // Frames that may wait to be read per bundle
+)
+
+// bundleMarker is the first frame sent on a link that asks to be bundled. It
+// is a dummy frame, which ironwood ignores, so it does no harm if the remote
+// node doesn't bundle links. Each side only bundles a link if it receives a
+// marker as the first frame from the other.
+var bundleMarker = []byte{0x00, 0x07, 0x00, 'b', 'u', 'n', 'd', 'l', 'e'}
+
+// bundleKeepalive is a dummy frame that is sent on any link in a bundle that
+// has sent nothing else for a while, as ironwood only sends keepalives on the
+// bundle as a whole.
+var bundleKeepalive = []byte{0x00, 0x01, 0x00}
+
+// The types of the ironwood frames that carry traffic.
+const (
+	bundleDHTTraffic  = 9
+	bundlePathTraffic = 10
+)
+
+// parseBundleMode checks the bundle option of a peer or a listener.
+func parseBundleMode(mode string) error {
+	switch mode {
+	case "", "packet", "flow":
+		return nil
+	default:
+		return errors.New("bundle must be packet or flow")
+	}
+}
+
+// readBundleFrame reads a whole frame, including its length.
+func readBundleFrame(r io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	frame := make([]byte, 2+int(binary.BigEndian.Uint16(length[:])))
+	copy(frame, length[:])
+	if _, err := io.ReadFull(r, frame[2:]); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// bundleFlow returns the source and destination keys of a traffic frame, or
+// nil if it doesn't carry traffic.
+func bundleFlow(frame []byte) []byte {
+	if len(frame) < 3 {
+		return nil
+	}
+	msg := frame[3:]
+	switch frame[2] {
+	case bundleDHTTraffic:
+	case bundlePathTraffic:
+		// The path is a list of uvarint ports, ending with a zero
+		for {
+			port, l := binary.Uvarint(msg)
+			if l <= 0 {
+				return nil
+			}
+			msg = msg[l:]
+			if port == 0 {
+				break
+			}
+		}
+	default:
+		return nil
+	}
+	if len(msg) < 2*ed25519.PublicKeySize {
+		return nil
+	}
+	return msg[:2*ed25519.PublicKeySize]
+}
+
+// linkBundle is the connection that ironwood is given for the links in a
+// bundle, which are its members.
+type linkBundle struct {
+	links   *links
+	key     keyArray
+	flow    bool
+	conn    *linkConn // The bundle as ironwood sees it
+	frames  chan []byte
+	readBuf []byte     // Only used by Read
+	mutex   sync.Mutex // protects the below
+	members []*bundleMember
+	next    int // The member that the next frame of traffic is sent on
+	rdl     time.Time
+	closed  bool
+	done    chan struct{}
+}
+
+// bundleMember is a link in a bundle.
+type bundleMember struct {
+	intf     *link
+	wmutex   sync.Mutex // Only one frame may be written at once
+	lastSent time.Time  // Protected by wmutex
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+func (m *bundleMember) write(frame []byte) error {
+	m.wmutex.Lock()
+	defer m.wmutex.Unlock()
+	_, err := m.intf.conn.Write(frame)
+	if err == nil {
+		m.lastSent = time.Now()
+	}
+	return err
+}
+
+// bundle runs a link that asked to be bundled, once its metadata has been
+// exchanged. The link joins the bundle for its key if the remote node asked
+// to bundle it too, or else runs on its own.
+func (l *links) bundle(intf *link) error {
+	var frame []byte
+	var err error
+	if !funcTimeout(l.core.clock, linkHandshakeTimeout, func() {
+		if _, err = intf.conn.Write(bundleMarker); err == nil {
+			frame, err = readBundleFrame(intf.conn)
+		}
+	}) {
+		return errors.New("timeout on bundle negotiation")
+	}
+	if err != nil {
+		return err
+	}
+	if intf.options.cover > 0 {
+		go intf.sendCover(intf.options.cover)
+	}
+	if !bytes.Equal(frame, bundleMarker) {
+		// The frame is the first that ironwood sent, so ironwood must read it
+		intf.conn.unread = frame
+		return l.core.HandleConn(ed25519.PublicKey(intf.info.key[:]), intf.conn)
+	}
+	m := &bundleMember{
+		intf:     intf,
+		lastSent: time.Now(),
+		done:     make(chan struct{}),
+	}
+	l.mutex.Lock()
+	b := l.bundles[intf.info.key]
+	if old := b; old != nil {
+		old.mutex.Lock()
+		if !old.closed {
+			old.members = append(old.members, m)
+		} else {
+			b = nil // Still on its way out, so start another
+		}
+		old.mutex.Unlock()
+	}
+	if b == nil {
+		b = &linkBundle{
+			links:  l,
+			key:    intf.info.key,
+			flow:   intf.options.bundle == "flow",
+			frames: make(chan []byte, bundleFrameQueue),
+			done:   make(chan struct{}),
+		}
+		b.conn = &linkConn{Conn: b, clock: l.core.clock, up: l.core.clock.Now()}
+		b.members = append(b.members, m)
+		if l.bundles == nil {
+			l.bundles = make(map[keyArray]*linkBundle)
+		}
+		l.bundles[intf.info.key] = b
+		go b.handle()
+	}
+	l.mutex.Unlock()
+	l.core.log.Debugln("Bundled link", intf.name(), "as", b.conn.RemoteAddr().String())
+	return b.run(m)
+}
+
+// handle runs ironwood on the bundle, until either ironwood closes it or its
+// last member leaves.
+func (b *linkBundle) handle() {
+	err := b.links.core.HandleConn(ed25519.PublicKey(b.key[:]), b.conn)
+	b.links.core.log.Debugln("Stopped bundle", b.conn.RemoteAddr().String(), err)
+	b.links.mutex.Lock()
+	b.Close()
+	if b.links.bundles[b.key] == b {
+		delete(b.links.bundles, b.key)
+	}
+	b.links.mutex.Unlock()
+}
+
+// run reads frames from a member until it fails or the bundle closes.
+func (b *linkBundle) run(m *bundleMember) error {
+	defer b.remove(m)
+	go b.keepalive(m)
+	for {
+		_ = m.intf.conn.SetReadDeadline(time.Now().Add(bundleLinkTimeout))
+		frame, err := readBundleFrame(m.intf.conn)
+		if err != nil {
+			return err
+		}
+		select {
+		case b.frames <- frame:
+		case <-b.done:
+			return net.ErrClosed
+		}
+	}
+}
+
+func (b *linkBundle) keepalive(m *bundleMember) {
+	ticker := time.NewTicker(bundleKeepaliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+		}
+		m.wmutex.Lock()
+		idle := time.Since(m.lastSent) >= bundleKeepaliveInterval
+		m.wmutex.Unlock()
+		if idle {
+			_ = m.write(bundleKeepalive)
+		}
+	}
+}
+
+// remove takes a member out of the bundle and closes it, closing the bundle
+// too if that was the last member.
+func (b *linkBundle) remove(m *bundleMember) {
+	b.mutex.Lock()
+	for i, member := range b.members {
+		if member == m {
+			b.members = append(b.members[:i], b.members[i+1:]...)
+			break
+		}
+	}
+	empty := len(b.members) == 0
+	b.mutex.Unlock()
+	m.doneOnce.Do(func() {
+		close(m.done)
+		m.intf.close()
+	})
+	if empty {
+		b.Close()
+	}
+}
+
+// pick returns the member to send a frame on, or nil if there are none.
+func (b *linkBundle) pick(frame []byte) *bundleMember {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if len(b.members) == 0 {
+		return nil
+	}
+	flow := bundleFlow(frame)
+	switch {
+	case flow == nil:
+		return b.members[0]
+	case b.flow:
+		h := fnv.New32a()
+		_, _ = h.Write(flow)
+		return b.members[h.Sum32()%uint32(len(b.members))]
+	default:
+		b.next = (b.next + 1) % len(b.members)
+		return b.members[b.next]
+	}
+}
+
+func (b *linkBundle) Read(p []byte) (int, error) {
+	if len(b.readBuf) == 0 {
+		b.mutex.Lock()
+		deadline := b.rdl
+		b.mutex.Unlock()
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			timer := time.NewTimer(time.Until(deadline))
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case b.readBuf = <-b.frames:
+		case <-b.done:
+			return 0, io.EOF
+		case <-timeout:
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+	n := copy(p, b.readBuf)
+	b.readBuf = b.readBuf[n:]
+	return n, nil
+}
+
+// Write sends a frame on one of the members, trying the others if that fails.
+func (b *linkBundle) Write(p []byte) (int, error) {
+	for {
+		select {
+		case <-b.done:
+			return 0, net.ErrClosed
+		default:
+		}
+		m := b.pick(p)
+		if m == nil {
+			return 0, net.ErrClosed
+		}
+		if err := m.write(p); err == nil {
+			return len(p), nil
+		}
+		b.remove(m)
+	}
+}
+
+func (b *linkBundle) Close() error {
+	b.mutex.Lock()
+	if b.closed {
+		b.mutex.Unlock()
+		return nil
+	}
+	b.closed = true
+	close(b.done)
+	members := append([]*bundleMember(nil), b.members...)
+	b.mutex.Unlock()
+	for _, m := range members {
+		m.intf.close()
+	}
+	return nil
+}
+
+func (b *linkBundle) LocalAddr() net.Addr {
+	return bundleAddr{b}
+}
+
+func (b *linkBundle) RemoteAddr() net.Addr {
+	return bundleAddr{b}
+}
+
+func (b *linkBundle) SetDeadline(t time.Time) error {
+	return b.SetReadDeadline(t)
+}
+
+func (b *linkBundle) SetReadDeadline(t time.Time) error {
+	b.mutex.Lock()
+	b.rdl = t
+	b.mutex.Unlock()
+	return nil
+}
+
+// SetWriteDeadline is ignored, as each member has its own deadlines.
+func (b *linkBundle) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// bundleAddr names a bundle by the names of its members.
+type bundleAddr struct {
+	b *linkBundle
+}
+
+func (a bundleAddr) Network() string { return "bundle" }
+
+func (a bundleAddr) String() string {
+	a.b.mutex.Lock()
+	defer a.b.mutex.Unlock()
+	names := make([]string, 0, len(a.b.members))
+	for _, m := range a.b.members {
+		names = append(names, m.intf.name())
+	}
+	return "bundle:" + strings.Join(names, "+")
+}
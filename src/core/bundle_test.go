@@ -0,0 +1,66 @@
+package core
+
+import (
+	"bytes"
+	"math/rand"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCore_Bundle checks that two links between the same nodes are bundled
+// into a single peering, which still carries traffic.
+func TestCore_Bundle(t *testing.T) {
+	skipIfSessionRace(t)
+	cfgA := GenerateConfig()
+	cfgA.Listen = []string{"mem://bundle-a1?bundle=packet", "mem://bundle-a2?bundle=packet"}
+	nodeA := new(Core)
+	if err := nodeA.Start(cfgA, GetLoggerWithPrefix("A: ", false)); err != nil {
+		t.Fatal(err)
+	}
+	defer nodeA.Stop()
+	cfgB := GenerateConfig()
+	cfgB.Listen = nil
+	nodeB := new(Core)
+	if err := nodeB.Start(cfgB, GetLoggerWithPrefix("B: ", false)); err != nil {
+		t.Fatal(err)
+	}
+	defer nodeB.Stop()
+	for _, peer := range []string{"mem://bundle-a1?bundle=packet", "mem://bundle-a2?bundle=flow"} {
+		u, _ := url.Parse(peer)
+		if err := nodeB.CallPeer(u, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(time.Second)
+	for _, node := range []*Core{nodeA, nodeB} {
+		peers := node.GetPeers()
+		if len(peers) != 1 {
+			t.Fatal("unexpected number of peers", len(peers))
+		}
+		if remote := peers[0].Remote; !strings.HasPrefix(remote, "bundle:") || strings.Count(remote, "+") != 1 {
+			t.Fatal("links were not bundled:", remote)
+		}
+	}
+	msgLen, repeats := 1500, 8
+	done := CreateEchoListener(t, nodeA, msgLen, repeats)
+	for i := 0; i < repeats; i++ {
+		msg := make([]byte, msgLen)
+		rand.Read(msg[40:])
+		msg[0] = 0x60
+		copy(msg[8:24], nodeB.Address())
+		copy(msg[24:40], nodeA.Address())
+		if _, err := nodeB.WriteTo(msg, nodeA.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, msgLen)
+		if _, _, err := nodeB.ReadFrom(buf); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(msg[40:], buf[40:]) {
+			t.Fatal("expected echo")
+		}
+	}
+	<-done
+}
@@ -0,0 +1,113 @@
+package core
+
+// This file contains hooks to inject faults into links, so that the behaviour
+// of sessions and routing over bad links can be tested. They can only be
+// enabled through SetChaos, and are not reachable from the config or the
+// admin socket.
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Chaos describes the faults to inject into the frames sent on a link. Each
+// field other than MaxDelay is the probability, from 0 to 1, of that fault
+// happening to a given frame. Faults are only injected after the metadata
+// exchange, so that the link still comes up. Note that ironwood closes a link
+// when a protocol frame fails to decode or to verify, so corruption tends to
+// test recovery from lost links rather than from bad traffic.
+type Chaos struct {
+	Drop      float64       // The frame is not sent
+	Duplicate float64       // The frame is sent twice
+	Corrupt   float64       // A random bit of the frame payload is flipped
+	Delay     float64       // The frame, and those queued behind it, are held back
+	MaxDelay  time.Duration // The longest a delayed frame is held back for
+}
+
+func (ch *Chaos) enabled() bool {
+	return ch.Drop > 0 || ch.Duplicate > 0 || ch.Corrupt > 0 || (ch.Delay > 0 && ch.MaxDelay > 0)
+}
+
+// linkChaos is the chaos in effect for a single link.
+type linkChaos struct {
+	mutex sync.Mutex // protects the below
+	chaos Chaos
+	rand  *rand.Rand
+}
+
+func newLinkChaos(chaos Chaos) *linkChaos {
+	return &linkChaos{
+		chaos: chaos,
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (lc *linkChaos) set(chaos Chaos) {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+	lc.chaos = chaos
+}
+
+// write sends a single frame through the given function, with any faults
+// applied. Every write from ironwood is a single frame, made up of a 2 byte
+// length and then the payload, which starts with a 1 byte packet type. Only
+// the rest of the payload is corrupted, as otherwise the stream would lose
+// its framing and the link would just be closed.
+func (lc *linkChaos) write(p []byte, write func([]byte) (int, error)) (int, error) {
+	lc.mutex.Lock()
+	chaos := lc.chaos
+	drop := chaos.Drop > 0 && lc.rand.Float64() < chaos.Drop
+	duplicate := chaos.Duplicate > 0 && lc.rand.Float64() < chaos.Duplicate
+	corrupt := chaos.Corrupt > 0 && len(p) > 3 && lc.rand.Float64() < chaos.Corrupt
+	var delay time.Duration
+	if chaos.Delay > 0 && chaos.MaxDelay > 0 && lc.rand.Float64() < chaos.Delay {
+		delay = time.Duration(lc.rand.Int63n(int64(chaos.MaxDelay)))
+	}
+	var bit int
+	if corrupt {
+		bit = lc.rand.Intn((len(p) - 3) * 8)
+	}
+	lc.mutex.Unlock()
+	if drop {
+		return len(p), nil
+	}
+	if corrupt {
+		p = append([]byte(nil), p...)
+		p[3+bit/8] ^= 1 << (bit % 8)
+	}
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	n, err := write(p)
+	if err == nil && duplicate {
+		_, err = write(p)
+	}
+	return n, err
+}
+
+// SetChaos injects faults into the frames sent on links with the given name,
+// which is the Remote shown by GetPeers, including any that connect later.
+// Setting a zero Chaos stops injecting faults. This is intended only for
+// testing, such as with the sim package, and should not be used on nodes
+// that are part of a real network.
+func (c *Core) SetChaos(name string, chaos Chaos) {
+	c.links.mutex.Lock()
+	defer c.links.mutex.Unlock()
+	if c.links.chaos == nil {
+		c.links.chaos = make(map[string]*linkChaos)
+	}
+	lc := c.links.chaos[name]
+	switch {
+	case lc != nil:
+		lc.set(chaos)
+	case chaos.enabled():
+		lc = newLinkChaos(chaos)
+		c.links.chaos[name] = lc
+		for _, intf := range c.links.links {
+			if intf.lname == name {
+				intf.conn.setChaos(lc)
+			}
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package core
+
+import "time"
+
+// clock is the source of time for the link handshake, peer reconnection,
+// link scheduling, idle link and keepalive timeouts, traffic quotas, latency
+// probes, loss sampling, cover traffic, UDP sessions and shutting links down.
+// It is normally the system clock, but tests can swap in one that they
+// advance by hand, so that timeouts and backoff can be checked without
+// actually waiting for them.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	AfterFunc(d time.Duration, f func()) timer
+	NewTicker(d time.Duration) ticker
+}
+
+// timer is the part of *time.Timer that is needed by the core.
+type timer interface {
+	Stop() bool
+}
+
+// ticker is the part of *time.Ticker that is needed by the core, with the
+// channel behind a method so that it can be an interface.
+type ticker interface {
+	Chan() <-chan time.Time
+	Stop()
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) timer {
+	return time.AfterFunc(d, f)
+}
+
+func (systemClock) NewTicker(d time.Duration) ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+type systemTicker struct {
+	*time.Ticker
+}
+
+func (t systemTicker) Chan() <-chan time.Time {
+	return t.C
+}
+
+// funcTimeout runs the function in a goroutine, returning true if it finished
+// before the timeout, as util.FuncTimeout does but using the given clock.
+func funcTimeout(clk clock, timeout time.Duration, f func()) bool {
+	success := make(chan struct{})
+	go func() {
+		defer close(success)
+		f()
+	}()
+	select {
+	case <-success:
+		return true
+	case <-clk.After(timeout):
+		return false
+	}
+}
@@ -0,0 +1,133 @@
+package core
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock that only moves when advanced by the test.
+type fakeClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	when time.Time
+	fire func(time.Time)
+}
+
+type fakeTimer struct {
+	clock   *fakeClock
+	stopped bool
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.mutex.Lock()
+	c.waiters = append(c.waiters, fakeWaiter{c.now.Add(d), func(t time.Time) { ch <- t }})
+	c.mutex.Unlock()
+	return ch
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) timer {
+	t := &fakeTimer{clock: c}
+	c.mutex.Lock()
+	c.waiters = append(c.waiters, fakeWaiter{c.now.Add(d), func(time.Time) {
+		c.mutex.Lock()
+		stopped := t.stopped
+		t.stopped = true // So that Stop returns false once it has fired
+		c.mutex.Unlock()
+		if !stopped {
+			go f()
+		}
+	}})
+	c.mutex.Unlock()
+	return t
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	stopped := t.stopped
+	t.stopped = true
+	return !stopped
+}
+
+// NewTicker returns a ticker that ticks each time that the clock is advanced
+// past the next tick, dropping ticks that aren't read in time, as a
+// time.Ticker does.
+func (c *fakeClock) NewTicker(d time.Duration) ticker {
+	t := &fakeTicker{timer: fakeTimer{clock: c}, ch: make(chan time.Time, 1)}
+	var tick func(time.Time)
+	next := func(when time.Time) fakeWaiter {
+		return fakeWaiter{when, tick}
+	}
+	tick = func(now time.Time) {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		if t.timer.stopped {
+			return
+		}
+		select {
+		case t.ch <- now:
+		default:
+		}
+		c.waiters = append(c.waiters, next(now.Add(d)))
+	}
+	c.mutex.Lock()
+	c.waiters = append(c.waiters, next(c.now.Add(d)))
+	c.mutex.Unlock()
+	return t
+}
+
+type fakeTicker struct {
+	timer fakeTimer
+	ch    chan time.Time
+}
+
+func (t *fakeTicker) Chan() <-chan time.Time { return t.ch }
+
+func (t *fakeTicker) Stop() { t.timer.Stop() }
+
+// Advance moves the clock forward, firing anything that was due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	c.now = c.now.Add(d)
+	var due, pending []fakeWaiter
+	for _, w := range c.waiters {
+		if w.when.After(c.now) {
+			pending = append(pending, w)
+		} else {
+			due = append(due, w)
+		}
+	}
+	c.waiters = pending
+	now := c.now
+	c.mutex.Unlock()
+	for _, w := range due {
+		w.fire(now)
+	}
+}
+
+// WaitForWaiters blocks until something is waiting on the clock, so that the
+// test doesn't advance it before the code under test has started waiting.
+func (c *fakeClock) WaitForWaiters(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		c.mutex.Lock()
+		n := len(c.waiters)
+		c.mutex.Unlock()
+		if n > 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("nothing waited on the clock")
+}
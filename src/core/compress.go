@@ -0,0 +1,213 @@
+package core
+
+// This file contains the link compression, for slow WAN peerings. Peers and
+// listeners with the compress option offer the algorithms in it, in order of
+// preference, as a dummy frame that is sent straight after the metadata, so
+// that ironwood ignores it if the remote node doesn't compress. Each side
+// then compresses the frames that it sends with the first of its algorithms
+// that the other side offered, if any. Frames that are small, or that don't
+// get any smaller, are sent as they are. Overlay traffic is encrypted end to
+// end, which leaves little in it to compress, so this mostly pays off where
+// the protocol traffic of a node is a large share of a slow link.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+const compressMinSize = 128 // Frames with less than this many bytes are never compressed
+
+// compressOffer starts the dummy frame that offers compression, which is
+// followed by the names of the algorithms separated by commas.
+var compressOffer = []byte{0x00, 0x00, 0x00, 'c', 'o', 'm', 'p', 'r', 'e', 's', 's', ':'}
+
+// A compressed frame is a dummy frame starting with compressTag, then the
+// algorithm and then the compressed frame, without its length.
+const compressTag = 'z'
+
+// The algorithms, by the byte that they have in compressed frames.
+const (
+	compressLZ4  = 1
+	compressZSTD = 2
+)
+
+var compressAlgorithms = map[string]byte{
+	"lz4":  compressLZ4,
+	"zstd": compressZSTD,
+}
+
+// The zstd encoder and decoder are safe to share between links.
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+func zstdInit() {
+	zstdOnce.Do(func() {
+		zstdEncoder, _ = zstd.NewWriter(nil,
+			zstd.WithEncoderLevel(zstd.SpeedFastest),
+			zstd.WithEncoderConcurrency(1),
+			zstd.WithLowerEncoderMem(true),
+		)
+		zstdDecoder, _ = zstd.NewReader(nil,
+			zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderMaxMemory(1<<16),
+		)
+	})
+}
+
+// parseCompress reads the compress option of a peer or listener URI, which
+// lists the algorithms to offer, e.g. lz4,zstd, in order of preference.
+func parseCompress(u *url.URL) ([]string, error) {
+	s := u.Query().Get("compress")
+	if s == "" {
+		return nil, nil
+	}
+	names := strings.Split(s, ",")
+	for _, name := range names {
+		if _, ok := compressAlgorithms[name]; !ok {
+			return nil, fmt.Errorf("unknown compression %q", name)
+		}
+	}
+	return names, nil
+}
+
+// linkCodec compresses the frames that are sent on a link, with the algorithm
+// that was negotiated for sending, if any, and expands the frames that are
+// received, with any of the algorithms that were offered.
+type linkCodec struct {
+	send    byte // The algorithm to compress with, or 0 to send frames as they are
+	offered map[byte]struct{}
+	expbuf  []byte     // Only used by expand, which is only called by Read
+	mutex   sync.Mutex // protects lz4
+	lz4     lz4.Compressor
+}
+
+// negotiateCompression sends the offer of the link, and reads the offer of the
+// remote node, which is the first frame that it sends if it compresses at all.
+// Anything else is left to be read again by ironwood.
+func (intf *link) negotiateCompression() error {
+	offer := append(append([]byte(nil), compressOffer...), strings.Join(intf.options.compress, ",")...)
+	binary.BigEndian.PutUint16(offer, uint16(len(offer)-2))
+	var frame []byte
+	var err error
+	if !funcTimeout(intf.links.core.clock, linkHandshakeTimeout, func() {
+		if _, err = intf.conn.Write(offer); err == nil {
+			frame, err = readBundleFrame(intf.conn)
+		}
+	}) {
+		return errors.New("timeout on compression negotiation")
+	}
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(frame[2:], compressOffer[2:]) {
+		intf.conn.unread = frame
+		return nil
+	}
+	theirs := make(map[string]struct{})
+	for _, name := range strings.Split(string(frame[len(compressOffer):]), ",") {
+		theirs[name] = struct{}{}
+	}
+	codec := &linkCodec{offered: make(map[byte]struct{})}
+	for _, name := range intf.options.compress {
+		algorithm := compressAlgorithms[name]
+		codec.offered[algorithm] = struct{}{}
+		if _, ok := theirs[name]; ok && codec.send == 0 {
+			codec.send = algorithm
+		}
+	}
+	zstdInit()
+	intf.conn.codec = codec
+	if codec.send != 0 {
+		intf.links.core.log.Debugf("Compressing link %s with %s", intf.name(), compressName(codec.send))
+	}
+	return nil
+}
+
+func compressName(algorithm byte) string {
+	for name, a := range compressAlgorithms {
+		if a == algorithm {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// compress returns the frame to send in place of the given one, which is the
+// frame itself if it isn't worth compressing.
+func (k *linkCodec) compress(frame []byte) []byte {
+	if k.send == 0 || len(frame) < 3+compressMinSize || frame[2] == 0x00 ||
+		int(binary.BigEndian.Uint16(frame)) != len(frame)-2 {
+		// Too small, a dummy frame, or not a whole frame
+		return frame
+	}
+	src := frame[2:]
+	out := make([]byte, 5, 5+lz4.CompressBlockBound(len(src)))
+	out[2], out[3], out[4] = 0x00, compressTag, k.send
+	switch k.send {
+	case compressLZ4:
+		k.mutex.Lock()
+		n, err := k.lz4.CompressBlock(src, out[5:cap(out)])
+		k.mutex.Unlock()
+		if err != nil || n == 0 {
+			return frame
+		}
+		out = out[:5+n]
+	case compressZSTD:
+		out = zstdEncoder.EncodeAll(src, out)
+	}
+	if len(out) >= len(frame) {
+		return frame
+	}
+	binary.BigEndian.PutUint16(out, uint16(len(out)-2))
+	return out
+}
+
+// compressed returns true if the frame was compressed by the remote node.
+func (k *linkCodec) compressed(frame []byte) bool {
+	return len(frame) >= 5 && frame[2] == 0x00 && frame[3] == compressTag
+}
+
+// expand returns the frame that a compressed frame holds. The frame is only
+// valid until the next call.
+func (k *linkCodec) expand(frame []byte) ([]byte, error) {
+	algorithm := frame[4]
+	if _, ok := k.offered[algorithm]; !ok {
+		return nil, fmt.Errorf("frame compressed with %s, which wasn't offered", compressName(algorithm))
+	}
+	if k.expbuf == nil {
+		k.expbuf = make([]byte, 2, 2+1<<16)
+	}
+	out := k.expbuf[:2]
+	switch algorithm {
+	case compressLZ4:
+		n, err := lz4.UncompressBlock(frame[5:], out[2:2+int(^uint16(0))])
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand frame: %w", err)
+		}
+		out = out[:2+n]
+	case compressZSTD:
+		var err error
+		if out, err = zstdDecoder.DecodeAll(frame[5:], out); err != nil {
+			return nil, fmt.Errorf("failed to expand frame: %w", err)
+		}
+	}
+	if len(out)-2 > int(^uint16(0)) {
+		return nil, errors.New("expanded frame is too large")
+	}
+	binary.BigEndian.PutUint16(out, uint16(len(out)-2))
+	if cap(out) <= 2+1<<16 {
+		k.expbuf = out
+	}
+	return out, nil
+}
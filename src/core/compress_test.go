@@ -0,0 +1,65 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+)
+
+func TestCore_Compression(t *testing.T) {
+	cfgA, cfgB := GenerateConfig(), GenerateConfig()
+	cfgA.Listen = []string{"mem://compress?compress=zstd,lz4"}
+	cfgB.Peers = []string{"mem://compress?compress=lz4"}
+	nodeA, nodeB := new(Core), new(Core)
+	if err := nodeA.Start(cfgA, GetLoggerWithPrefix("A: ", false)); err != nil {
+		t.Fatal(err)
+	}
+	defer nodeA.Stop()
+	if err := nodeB.Start(cfgB, GetLoggerWithPrefix("B: ", false)); err != nil {
+		t.Fatal(err)
+	}
+	defer nodeB.Stop()
+	if !WaitConnected(nodeA, nodeB) {
+		t.Fatal("nodes did not connect")
+	}
+	// A prefers zstd, but B only offers lz4, so both sides send with lz4
+	for _, node := range []*Core{nodeA, nodeB} {
+		node.links.mutex.RLock()
+		for _, intf := range node.links.links {
+			if intf.conn.codec == nil || intf.conn.codec.send != compressLZ4 {
+				t.Error("link is not compressed with lz4")
+			}
+		}
+		node.links.mutex.RUnlock()
+	}
+	CheckEcho(t, nodeA, nodeB)
+}
+
+func TestLinkCodec(t *testing.T) {
+	zstdInit()
+	frame := make([]byte, 2+1400)
+	binary.BigEndian.PutUint16(frame, 1400)
+	frame[2] = bundlePathTraffic
+	copy(frame[3:], bytes.Repeat([]byte("compressible "), 100))
+	for name, algorithm := range compressAlgorithms {
+		k := &linkCodec{send: algorithm, offered: map[byte]struct{}{algorithm: {}}}
+		compressed := k.compress(frame)
+		if len(compressed) >= len(frame) || !k.compressed(compressed) {
+			t.Fatal(name, "did not compress the frame")
+		}
+		expanded, err := k.expand(compressed)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if !bytes.Equal(expanded, frame) {
+			t.Fatal(name, "expanded frame is different")
+		}
+	}
+	// Frames that don't get smaller are sent as they are
+	k := &linkCodec{send: compressLZ4, offered: map[byte]struct{}{compressLZ4: {}}}
+	rand.Read(frame[3:])
+	if sent := k.compress(frame); !bytes.Equal(sent, frame) {
+		t.Fatal("incompressible frame was compressed")
+	}
+}
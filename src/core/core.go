@@ -34,14 +34,24 @@ type Core struct {
 	public       ed25519.PublicKey
 	links        links
 	proto        protoHandler
+	maintenance  maintenance
+	quotas       quotas
+	rates        rateLimits
 	log          *log.Logger
-	addPeerTimer *time.Timer
+	clock        clock // The system clock, unless replaced by a test
+	addPeerTimer timer
+	peers        peerSupervisor
 	ctx          context.Context
 	ctxCancel    context.CancelFunc
 }
 
+const preferredPeerInterval = 5 * time.Second // The longest backoff for preferred peers
+
 func (c *Core) _init() error {
 	// TODO separate init and start functions
+	if c.clock == nil {
+		c.clock = systemClock{}
+	}
 	//  Init sets up structs
 	//  Start launches goroutines that depend on structs being set up
 	// This is pretty much required to completely avoid race conditions
@@ -66,6 +76,12 @@ func (c *Core) _init() error {
 	c.PacketConn, err = iwe.NewPacketConn(c.secret)
 	c.ctx, c.ctxCancel = context.WithCancel(context.Background())
 	c.proto.init(c)
+	if err := c.quotas.init(c, c.config.PeerQuotas, c.config.QuotaFile); err != nil {
+		return err
+	}
+	if err := c.rates.init(c.config.PeerRateLimits); err != nil {
+		return err
+	}
 	if err := c.proto.nodeinfo.setNodeInfo(c.config.NodeInfo, c.config.NodeInfoPrivacy); err != nil {
 		return fmt.Errorf("setNodeInfo: %w", err)
 	}
@@ -74,7 +90,7 @@ func (c *Core) _init() error {
 
 // If any static peers were provided in the configuration above then we should
 // configure them. The loop ensures that disconnected peers will eventually
-// be reconnected with.
+// be reconnected with, once they have waited out their backoff.
 func (c *Core) _addPeerLoop() {
 	c.config.RLock()
 	defer c.config.RUnlock()
@@ -83,37 +99,84 @@ func (c *Core) _addPeerLoop() {
 		return
 	}
 
+	c._callConfiguredPeers(false)
+
+	c.addPeerTimer = c.clock.AfterFunc(peerRetryInterval, func() {
+		c.Act(nil, c._addPeerLoop)
+	})
+}
+
+// Calls the peers from the Peers and InterfacePeers sections of the config
+// that aren't connected and are due to be called, or all of those that aren't
+// connected if retryNow is set. Peers marked with "?preferred=true" are
+// considered to be important transit peers, so their backoff is much shorter.
+// Peers in a tier, given with "?tier=n", are only called while no peers in a
+// lower tier are connected, and are disconnected once one of those is. Of the
+// rest, demoted peers are skipped, and only ActivePeers are called at a time,
+// if it is set.
+func (c *Core) _callConfiguredPeers(retryNow bool) {
+	now := c.clock.Now()
+	configured := make(map[peerTarget]struct{})
+	var peers []*peerState
+	add := func(peer string, intf string) {
+		target := peerTarget{peer, intf}
+		configured[target] = struct{}{}
+		peers = append(peers, c.peers.get(target, c.clock))
+	}
+
 	// Add peers from the Peers section
 	for _, peer := range c.config.Peers {
-		go func(peer string, intf string) {
-			u, err := url.Parse(peer)
-			if err != nil {
-				c.log.Errorln("Failed to parse peer url:", peer, err)
-			}
-			if err := c.CallPeer(u, intf); err != nil {
-				c.log.Errorln("Failed to add peer:", err)
-			}
-		}(peer, "") // TODO: this should be acted and not in a goroutine?
+		add(peer, "")
 	}
 
 	// Add peers from the InterfacePeers section
 	for intf, intfpeers := range c.config.InterfacePeers {
 		for _, peer := range intfpeers {
-			go func(peer string, intf string) {
-				u, err := url.Parse(peer)
-				if err != nil {
-					c.log.Errorln("Failed to parse peer url:", peer, err)
-				}
-				if err := c.CallPeer(u, intf); err != nil {
-					c.log.Errorln("Failed to add peer:", err)
-				}
-			}(peer, intf) // TODO: this should be acted and not in a goroutine?
+			add(peer, intf)
 		}
 	}
+	c.peers.prune(configured)
 
-	c.addPeerTimer = time.AfterFunc(time.Minute, func() {
-		c.Act(nil, c._addPeerLoop)
-	})
+	demoted := c._reviewPeers(peers, now)
+	tier, ok := c.peers.activeTier()
+	eligible := make([]*peerState, 0, len(peers))
+	for _, state := range peers {
+		if ok && state.tier > tier {
+			state.setActive(false, now)
+			continue // A lower tier is connected
+		}
+		if _, ok := demoted[state]; ok {
+			state.setActive(false, now)
+			continue // The peer has scored badly, see quality.go
+		}
+		eligible = append(eligible, state)
+	}
+	for _, state := range c._selectPeers(eligible, now) {
+		state.want(now)
+		if !state.due(now) && (!retryNow || state.connected()) {
+			continue
+		}
+		u, err := url.Parse(state.target.uri)
+		if err != nil {
+			c.log.Errorln("Failed to parse peer url:", state.target.uri, err)
+			continue
+		}
+		max, err := peerBackoff(u)
+		if err != nil {
+			c.log.Errorln("Failed to add peer:", err)
+			continue
+		}
+		state.calling(now, max)
+		go func(state *peerState) {
+			if err := c.links.call(u, state.target.intf, state); err != nil {
+				state.failed(err)
+				c.log.Errorln("Failed to add peer:", err)
+			}
+		}(state) // TODO: this should be acted and not in a goroutine?
+	}
+	if ok {
+		c.links.closePeers(c.peers.aboveTier(tier), "a peer in a lower tier is connected")
+	}
 }
 
 // Start starts up Yggdrasil using the provided config.NodeConfig, and outputs
@@ -151,7 +214,7 @@ func (c *Core) _start(nc *config.NodeConfig, log *log.Logger) error {
 		return err
 	}
 
-	c.addPeerTimer = time.AfterFunc(0, func() {
+	c.addPeerTimer = c.clock.AfterFunc(0, func() {
 		c.Act(nil, c._addPeerLoop)
 	})
 
@@ -159,7 +222,9 @@ func (c *Core) _start(nc *config.NodeConfig, log *log.Logger) error {
 	return nil
 }
 
-// Stop shuts down the Yggdrasil node.
+// Stop shuts down the Yggdrasil node. Links are given a couple of seconds to
+// send what is already queued on them and to tell the remote nodes that they
+// are closing, before they are closed anyway.
 func (c *Core) Stop() {
 	phony.Block(c, func() {
 		c.log.Infoln("Stopping...")
@@ -168,6 +233,29 @@ func (c *Core) Stop() {
 	})
 }
 
+// Drain gracefully shuts down the Yggdrasil node. No new peerings will be
+// accepted or dialed, and existing links are then closed one at a time over
+// the given timeout, giving the network a chance to route around this node
+// before it disappears completely. Drain blocks until the node has stopped.
+//
+// Neighbours are not told in advance that the node is leaving, and it keeps
+// carrying transit traffic until each link closes, as the routing protocol
+// has no way to announce either. Each link closing is the only signal, so
+// traffic that is routed over a link may still be lost while the network
+// converges after it closes, if only for less time than if every link closed
+// at once.
+func (c *Core) Drain(timeout time.Duration) {
+	phony.Block(c, func() {
+		c.log.Infoln("Draining...")
+		if c.addPeerTimer != nil {
+			c.addPeerTimer.Stop()
+			c.addPeerTimer = nil
+		}
+	})
+	c.links.drain(timeout)
+	c.Stop()
+}
+
 func (c *Core) Close() error {
 	var err error
 	phony.Block(c, func() {
@@ -179,12 +267,15 @@ func (c *Core) Close() error {
 // This function is unsafe and should only be ran by the core actor.
 func (c *Core) _close() error {
 	c.ctxCancel()
-	err := c.PacketConn.Close()
 	if c.addPeerTimer != nil {
 		c.addPeerTimer.Stop()
 		c.addPeerTimer = nil
 	}
+	// The links are flushed before ironwood stops, so that it can still send
+	// whatever it has already handed to them
 	_ = c.links.stop()
+	err := c.PacketConn.Close()
+	c.quotas.stop()
 	return err
 }
 
@@ -204,12 +295,15 @@ func (c *Core) ReadFrom(p []byte) (n int, from net.Addr, err error) {
 		if n == 0 {
 			continue
 		}
+		var key keyArray
+		copy(key[:], from.(iwt.Addr))
 		switch bs[0] {
 		case typeSessionTraffic:
 			// This is what we want to handle here
+			if !c.maintenance.isAllowed(key) {
+				continue
+			}
 		case typeSessionProto:
-			var key keyArray
-			copy(key[:], from.(iwt.Addr))
 			data := append([]byte(nil), bs[1:n]...)
 			c.proto.handleProto(nil, key, data)
 			continue
@@ -228,6 +322,11 @@ func (c *Core) ReadFrom(p []byte) (n int, from net.Addr, err error) {
 }
 
 func (c *Core) WriteTo(p []byte, addr net.Addr) (n int, err error) {
+	if dest, ok := addr.(iwt.Addr); ok {
+		var key keyArray
+		copy(key[:], dest)
+		c.maintenance.allow(key)
+	}
 	buf := make([]byte, 0, 65535)
 	buf = append(buf, typeSessionTraffic)
 	buf = append(buf, p...)
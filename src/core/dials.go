@@ -0,0 +1,75 @@
+package core
+
+// This file contains the coalescing of outbound calls. Multicast, the peer
+// supervisor and the admin socket can all call the same node within moments of
+// each other, often under different URIs, such as a link-local address from
+// multicast and a hostname from the config. Each call would otherwise open its
+// own connection, and all but one of the links would then fail the check for
+// duplicate links after handshaking for nothing. Instead, a call is dropped if
+// a call to the same URI, or one that pins the same key, is still in progress,
+// which lasts until its link is up or it fails. Bundled links are only
+// coalesced by URI, as they are meant to have several links to one node.
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// errCallInProgress is recorded for a configured peer whose call was dropped.
+var errCallInProgress = errors.New("a call to the same node is already in progress")
+
+// linkDials holds the outbound calls that are in progress, by URI and by the
+// keys that they pin.
+type linkDials struct {
+	mutex sync.Mutex        // protects the below
+	calls map[string]string // The URI of the call, by what it is coalesced on
+}
+
+// dialTargets returns what a call is coalesced on.
+func dialTargets(u *url.URL, sintf string, options linkOptions) []string {
+	target := u.Scheme + "://" + strings.ToLower(u.Host) + u.Path
+	if sintf != "" {
+		target += "%" + sintf
+	} else if via := u.Query().Get("via"); via != "" {
+		target += "%" + via
+	}
+	targets := []string{target}
+	if options.bundle == "" {
+		for key := range options.pinnedEd25519Keys {
+			targets = append(targets, "key:"+string(key[:]))
+		}
+	}
+	return targets
+}
+
+// start records a call, and returns the function to call once its link is up
+// or it has failed, which may be called more than once. If a call to the same
+// node is already in progress then its URI is returned instead.
+func (d *linkDials) start(u *url.URL, sintf string, options linkOptions) (func(), string) {
+	targets := dialTargets(u, sintf, options)
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for _, target := range targets {
+		if uri, ok := d.calls[target]; ok {
+			return nil, uri
+		}
+	}
+	if d.calls == nil {
+		d.calls = make(map[string]string)
+	}
+	for _, target := range targets {
+		d.calls[target] = u.String()
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			d.mutex.Lock()
+			defer d.mutex.Unlock()
+			for _, target := range targets {
+				delete(d.calls, target)
+			}
+		})
+	}, ""
+}
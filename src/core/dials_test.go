@@ -0,0 +1,48 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"net/url"
+	"testing"
+)
+
+func TestLinkDials(t *testing.T) {
+	var d linkDials
+	parse := func(uri string) (*url.URL, linkOptions) {
+		u, err := url.Parse(uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		options, err := parseLinkOptions(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u, options
+	}
+	key := hex.EncodeToString(make([]byte, ed25519.PublicKeySize))
+	u, options := parse("tls://[fe80::1]:1234?key=" + key)
+	done, _ := d.start(u, "eth0", options)
+	if done == nil {
+		t.Fatal("first call was coalesced")
+	}
+	// Calls to the same URI or pinning the same key are coalesced
+	for _, uri := range []string{"tls://[FE80::1]:1234", "tls://node.example:1234?key=" + key} {
+		u, options := parse(uri)
+		if _, inProgress := d.start(u, "eth0", options); inProgress == "" {
+			t.Error("call to", uri, "was not coalesced")
+		}
+	}
+	// Others, and bundled links to the same key, are not
+	for _, uri := range []string{"tls://[fe80::1]:1235", "tls://node.example:1234?bundle=packet&key=" + key} {
+		u, options := parse(uri)
+		if other, _ := d.start(u, "eth0", options); other == nil {
+			t.Error("call to", uri, "was coalesced")
+		}
+	}
+	done()
+	done()
+	if other, _ := d.start(u, "eth0", options); other == nil {
+		t.Fatal("call was coalesced after the first one ended")
+	}
+}
@@ -0,0 +1,759 @@
+package core
+
+// This file contains the DNS transport, a last resort for captive portals and
+// networks that filter everything but DNS. Links run over the session layer
+// of the UDP transport, with its datagrams cut into pieces that are carried
+// in DNS queries and responses, through any resolver, to a listener that is
+// the authoritative name server for a zone, e.g. dns://a.b.c.d/t.example.com
+// for a peer using the resolver at a.b.c.d to reach the listener for the zone
+// t.example.com, which must be delegated to it with an NS record.
+//
+// Each piece has a 2 byte datagram sequence number, the 1 byte index of the
+// piece and the 1 byte piece count, followed by a part of the datagram.
+//
+//  - Pieces go up in the names of TXT queries, in base32, after a 4 byte ID
+//    that the caller picks at random and a 2 byte query sequence number that
+//    stops resolvers from answering from their caches. A query without a
+//    piece is a poll. Resolvers can send from many addresses, so callers are
+//    told apart by their IDs rather than by where their queries come from.
+//  - Pieces come down in the TXT responses to those queries, several to each
+//    response, each after its 2 byte length. The listener only ever answers,
+//    so the caller keeps a few queries outstanding, polling when it has
+//    nothing to send, and the listener holds onto polls for a little while
+//    until it has something to send back.
+//
+// Pieces are lost if they don't fit in the queues at either end, or if their
+// query or response is, which the links above live with as they do for UDP.
+//
+// Anyone can send queries to a listener, from spoofed addresses and with any
+// caller ID, so it only holds a limited number of polls at once, answering the
+// rest straight away, and only knows a limited number of callers, forgetting
+// the one that was heard from least recently to make room for a new one.
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	dnsDefaultPort   = "53"
+	dnsPieceHeader   = 4
+	dnsQueryHeader   = 6   // Caller ID and query sequence number
+	dnsDownPiece     = 160 // Bytes of a datagram per piece sent down, which fits in any response
+	dnsMaxResponse   = 1232
+	dnsMinResponse   = 512
+	dnsInflight      = 4 // Queries that each caller keeps outstanding
+	dnsQueryTimeout  = 2 * time.Second
+	dnsPollInterval  = time.Second
+	dnsHoldTime      = 200 * time.Millisecond
+	dnsQueueSize     = 512 // Pieces that may wait to be sent per caller
+	dnsDatagramQueue = 256 // Datagrams that may wait to be read per listener
+	dnsRecentQueries = 256 // Queries per caller that are remembered, to ignore resends
+	dnsCallerExpiry  = time.Minute
+	dnsMaxHeld       = 256  // Queries that each listener holds onto at once
+	dnsMaxCallers    = 1024 // Callers that each listener knows at once
+)
+
+var dnsEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// tcpdns hangs the DNS transport off of the TCP one, in the same way as the
+// UDP transport.
+type tcpdns struct {
+	tcp     *tcp
+	upgrade *TcpUpgrade
+}
+
+// dnsOptions are the options for a DNS peer or listener, from its URI.
+type dnsOptions struct {
+	addr    string // The resolver of a peer, or the address of a listener
+	zone    string // In lower case, with a trailing dot
+	upPiece int    // Bytes of a datagram per piece sent up
+}
+
+func (d *tcpdns) init(tcp *tcp) {
+	d.tcp = tcp
+	d.upgrade = &TcpUpgrade{
+		upgrade: func(c net.Conn, _ *tcpOptions) (net.Conn, error) { return c, nil },
+		name:    "dns",
+		dial:    d.dial,
+	}
+}
+
+// parseDNSOptions reads the address and the zone from a DNS peer or listener
+// URI, and works out how much of a datagram fits in the name of a query.
+func parseDNSOptions(u *url.URL) (dnsOptions, error) {
+	options := dnsOptions{addr: u.Host}
+	if u.Hostname() == "" {
+		return options, fmt.Errorf("dns URI %s must give an address, e.g. dns://a.b.c.d/t.example.com", u.String())
+	}
+	if u.Port() == "" {
+		options.addr = net.JoinHostPort(u.Hostname(), dnsDefaultPort)
+	}
+	zone := strings.ToLower(strings.Trim(u.Path, "/."))
+	if zone == "" || strings.Contains(zone, "/") {
+		return options, fmt.Errorf("dns URI %s must give a zone, e.g. dns://a.b.c.d/t.example.com", u.String())
+	}
+	options.zone = zone + "."
+	// Names may be up to 253 characters, before the trailing dot, and each
+	// label of the encoded query takes a dot as well as up to 63 characters
+	room := 253 - len(options.zone)
+	chars := room - (room+63)/64
+	options.upPiece = chars*5/8 - dnsQueryHeader - dnsPieceHeader
+	if options.upPiece < 16 {
+		return options, fmt.Errorf("dns URI %s has a zone that is too long", u.String())
+	}
+	return options, nil
+}
+
+// dnsAddr names the far end of a DNS session.
+type dnsAddr string
+
+func (a dnsAddr) Network() string { return "dns" }
+func (a dnsAddr) String() string  { return string(a) }
+
+// dnsPieces cuts a datagram into pieces, each with at most size bytes of it.
+func dnsPieces(seq uint16, datagram []byte, size int) [][]byte {
+	count := (len(datagram) + size - 1) / size
+	if count == 0 || count > 255 {
+		return nil
+	}
+	pieces := make([][]byte, 0, count)
+	for index := 0; index < count; index++ {
+		part := datagram[index*size:]
+		if len(part) > size {
+			part = part[:size]
+		}
+		piece := make([]byte, dnsPieceHeader, dnsPieceHeader+len(part))
+		binary.BigEndian.PutUint16(piece, seq)
+		piece[2], piece[3] = byte(index), byte(count)
+		pieces = append(pieces, append(piece, part...))
+	}
+	return pieces
+}
+
+// dnsReassembler puts datagrams back together from their pieces.
+type dnsReassembler struct {
+	mutex    sync.Mutex
+	partials map[uint16]*udpPartial
+	order    []uint16 // The partials, oldest first
+}
+
+// add takes a piece, returning the datagram that it completes, if any.
+func (r *dnsReassembler) add(piece []byte) []byte {
+	if len(piece) <= dnsPieceHeader {
+		return nil
+	}
+	seq := binary.BigEndian.Uint16(piece)
+	index, count := int(piece[2]), int(piece[3])
+	part := piece[dnsPieceHeader:]
+	if index >= count {
+		return nil
+	}
+	if count == 1 {
+		return append([]byte(nil), part...)
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.partials == nil {
+		r.partials = make(map[uint16]*udpPartial)
+	}
+	partial := r.partials[seq]
+	if partial == nil {
+		if len(r.order) >= udpMaxPartial {
+			// Give up on the oldest datagram, as a piece has probably been lost
+			delete(r.partials, r.order[0])
+			r.order = r.order[1:]
+		}
+		partial = &udpPartial{frags: make([][]byte, count)}
+		r.partials[seq] = partial
+		r.order = append(r.order, seq)
+	}
+	if len(partial.frags) != count || partial.frags[index] != nil {
+		return nil
+	}
+	partial.frags[index] = append([]byte(nil), part...)
+	partial.have++
+	if partial.have < count {
+		return nil
+	}
+	delete(r.partials, seq)
+	for i, o := range r.order {
+		if o == seq {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+	var datagram []byte
+	for _, f := range partial.frags {
+		datagram = append(datagram, f...)
+	}
+	return datagram
+}
+
+// dnsCaller is the end of a DNS session that sends the queries.
+type dnsCaller struct {
+	options  dnsOptions
+	id       [4]byte
+	upstream chan []byte // Pieces waiting to be sent up
+	session  *udpSession
+	reasm    dnsReassembler
+	mutex    sync.Mutex // protects the below
+	dseq     uint16
+	qseq     uint16
+	done     chan struct{}
+	once     sync.Once
+}
+
+func (d *tcpdns) dial(ctx context.Context, _ string, options *tcpOptions) (net.Conn, error) {
+	c := &dnsCaller{
+		options:  options.dns,
+		upstream: make(chan []byte, dnsQueueSize),
+		done:     make(chan struct{}),
+	}
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(c.id[:]); err != nil {
+		return nil, err
+	}
+	conns := make([]*net.UDPConn, 0, dnsInflight)
+	for i := 0; i < dnsInflight; i++ {
+		raddr, err := net.ResolveUDPAddr("udp", c.options.addr)
+		var conn *net.UDPConn
+		if err == nil {
+			conn, err = d.tcp.dialUDP(raddr)
+		}
+		if err != nil {
+			for _, conn := range conns {
+				conn.Close()
+			}
+			return nil, err
+		}
+		conns = append(conns, conn)
+	}
+	remote := dnsAddr(c.options.addr + "/" + strings.TrimSuffix(c.options.zone, "."))
+	c.session = newUDPSession(d.tcp.links.core.clock, binary.BigEndian.Uint64(id[:]), conns[0].LocalAddr(), remote,
+		c.send,
+		func() {
+			c.once.Do(func() { close(c.done) })
+			for _, conn := range conns {
+				conn.Close()
+			}
+		},
+	)
+	for _, conn := range conns {
+		go c.worker(conn)
+	}
+	s := c.session
+	ctx, done := context.WithTimeout(ctx, default_timeout)
+	defer done()
+	hello := s.header(udpHello)
+	for {
+		if err := s.send(hello); err != nil {
+			s.Close()
+			return nil, err
+		}
+		select {
+		case <-s.established:
+			return s, nil
+		case <-s.closed:
+			return nil, errors.New("dns session closed by listener")
+		case <-ctx.Done():
+			s.shutdown(false)
+			return nil, ctx.Err()
+		case <-time.After(udpHelloInterval):
+		}
+	}
+}
+
+// send queues the pieces of a datagram to be sent up.
+func (c *dnsCaller) send(datagram []byte) error {
+	c.mutex.Lock()
+	c.dseq++
+	seq := c.dseq
+	c.mutex.Unlock()
+	for _, piece := range dnsPieces(seq, datagram, c.options.upPiece) {
+		select {
+		case c.upstream <- piece:
+		case <-c.done:
+			return net.ErrClosed
+		default:
+			// The queries have fallen behind, so drop the piece as the network would
+		}
+	}
+	return nil
+}
+
+// worker sends queries one at a time until the session closes, sending pieces
+// as they come and polling if there are none.
+func (c *dnsCaller) worker(conn *net.UDPConn) {
+	idle := false
+	for {
+		var piece []byte
+		if idle {
+			select {
+			case piece = <-c.upstream:
+			case <-time.After(dnsPollInterval):
+			case <-c.done:
+				return
+			}
+		} else {
+			select {
+			case piece = <-c.upstream:
+			case <-c.done:
+				return
+			default:
+			}
+		}
+		got, err := c.exchange(conn, piece)
+		idle = err != nil || !got
+	}
+}
+
+// exchange sends a query with the given piece, if any, and passes the pieces
+// in the response to the session. It returns whether there were any.
+func (c *dnsCaller) exchange(conn *net.UDPConn, piece []byte) (bool, error) {
+	c.mutex.Lock()
+	c.qseq++
+	payload := make([]byte, dnsQueryHeader, dnsQueryHeader+len(piece))
+	copy(payload, c.id[:])
+	binary.BigEndian.PutUint16(payload[4:], c.qseq)
+	c.mutex.Unlock()
+	payload = append(payload, piece...)
+	encoded := strings.ToLower(dnsEncoding.EncodeToString(payload))
+	var labels []string
+	for len(encoded) > 63 {
+		labels = append(labels, encoded[:63])
+		encoded = encoded[63:]
+	}
+	labels = append(labels, encoded, c.options.zone)
+	name, err := dnsmessage.NewName(strings.Join(labels, "."))
+	if err != nil {
+		return false, err
+	}
+	var qid [2]byte
+	if _, err := rand.Read(qid[:]); err != nil {
+		return false, err
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:               binary.BigEndian.Uint16(qid[:]),
+		RecursionDesired: true,
+	})
+	_ = b.StartQuestions()
+	_ = b.Question(dnsmessage.Question{Name: name, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET})
+	_ = b.StartAdditionals()
+	var opt dnsmessage.ResourceHeader
+	_ = opt.SetEDNS0(dnsMaxResponse, dnsmessage.RCodeSuccess, false)
+	_ = b.OPTResource(opt, dnsmessage.OPTResource{})
+	query, err := b.Finish()
+	if err != nil {
+		return false, err
+	}
+	if _, err := conn.Write(query); err != nil {
+		return false, err
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(dnsQueryTimeout))
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return false, err
+		}
+		var p dnsmessage.Parser
+		header, err := p.Start(buf[:n])
+		if err != nil || !header.Response || header.ID != binary.BigEndian.Uint16(qid[:]) {
+			continue
+		}
+		if header.RCode != dnsmessage.RCodeSuccess {
+			return false, fmt.Errorf("dns query failed: %s", header.RCode)
+		}
+		return c.receive(&p), nil
+	}
+}
+
+// receive passes the pieces in the TXT answers of a response to the session.
+func (c *dnsCaller) receive(p *dnsmessage.Parser) bool {
+	if err := p.SkipAllQuestions(); err != nil {
+		return false
+	}
+	var data []byte
+	for {
+		header, err := p.AnswerHeader()
+		if err != nil {
+			break
+		}
+		if header.Type != dnsmessage.TypeTXT {
+			_ = p.SkipAnswer()
+			continue
+		}
+		txt, err := p.TXTResource()
+		if err != nil {
+			break
+		}
+		for _, s := range txt.TXT {
+			data = append(data, s...)
+		}
+	}
+	got := false
+	for len(data) >= 2 {
+		length := int(binary.BigEndian.Uint16(data))
+		if 2+length > len(data) {
+			break
+		}
+		if datagram := c.reasm.add(data[2 : 2+length]); datagram != nil {
+			c.session.receive(datagram)
+		}
+		data = data[2+length:]
+		got = true
+	}
+	return got
+}
+
+func (d *tcpdns) listen(u *url.URL) (*TcpListener, error) {
+	options, err := parseDNSOptions(u)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := net.ResolveUDPAddr("udp", options.addr)
+	if err != nil {
+		return nil, err
+	}
+	sock, err := d.tcp.listenUDP(addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := &dnsListenConn{
+		sock:      sock,
+		zone:      options.zone,
+		callers:   make(map[dnsAddr]*dnsCallerState),
+		datagrams: make(chan dnsDatagram, dnsDatagramQueue),
+		held:      make(chan struct{}, dnsMaxHeld),
+		done:      make(chan struct{}),
+	}
+	go conn.run()
+	ul := &udpListener{
+		clock:    d.tcp.links.core.clock,
+		sock:     conn,
+		accept:   make(chan *udpSession, udpAcceptQueue),
+		sessions: make(map[udpSessionKey]*udpSession),
+		done:     make(chan struct{}),
+	}
+	go ul.run()
+	l := TcpListener{
+		Listener: ul,
+		opts:     tcpOptions{upgrade: d.upgrade},
+		stop:     make(chan struct{}),
+	}
+	return &l, nil
+}
+
+type dnsDatagram struct {
+	from dnsAddr
+	data []byte
+}
+
+// dnsCallerState is what a listener knows about a caller.
+type dnsCallerState struct {
+	reasm    dnsReassembler
+	mutex    sync.Mutex // protects the below
+	queue    [][]byte   // Pieces waiting to be sent down
+	dseq     uint16
+	recent   map[uint16]struct{}
+	order    []uint16 // The recent queries, oldest first
+	lastSeen time.Time
+	notify   chan struct{} // Signalled when pieces are queued
+}
+
+// seen records a query sequence number, returning whether it was seen already.
+func (s *dnsCallerState) seen(qseq uint16) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lastSeen = time.Now()
+	if _, isIn := s.recent[qseq]; isIn {
+		return true
+	}
+	if len(s.order) >= dnsRecentQueries {
+		delete(s.recent, s.order[0])
+		s.order = s.order[1:]
+	}
+	s.recent[qseq] = struct{}{}
+	s.order = append(s.order, qseq)
+	return false
+}
+
+// take removes pieces from the queue, with their lengths, up to the given
+// number of bytes.
+func (s *dnsCallerState) take(room int) []byte {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var data []byte
+	for len(s.queue) > 0 && len(data)+2+len(s.queue[0]) <= room {
+		piece := s.queue[0]
+		s.queue = s.queue[1:]
+		data = append(data, byte(len(piece)>>8), byte(len(piece)))
+		data = append(data, piece...)
+	}
+	return data
+}
+
+// dnsListenConn is the packet socket of a DNS listener, which answers the
+// queries for its zone, reading datagrams from them and sending datagrams
+// back in the responses.
+type dnsListenConn struct {
+	sock      *net.UDPConn
+	zone      string
+	datagrams chan dnsDatagram
+	mutex     sync.Mutex // protects the below
+	callers   map[dnsAddr]*dnsCallerState
+	pruned    time.Time
+	held      chan struct{} // A slot for each query being held
+	done      chan struct{}
+	once      sync.Once
+}
+
+func (l *dnsListenConn) run() {
+	defer l.Close()
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := l.sock.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		l.handle(append([]byte(nil), buf[:n]...), addr)
+	}
+}
+
+// handle reads a query, passes on any piece in it, and then answers it, once
+// there is something to send back or it has been held for long enough.
+func (l *dnsListenConn) handle(query []byte, addr *net.UDPAddr) {
+	var p dnsmessage.Parser
+	header, err := p.Start(query)
+	if err != nil || header.Response {
+		return
+	}
+	q, err := p.Question()
+	if err != nil {
+		return
+	}
+	size, edns := dnsMinResponse, false
+	if err := p.SkipAllQuestions(); err == nil && p.SkipAllAnswers() == nil && p.SkipAllAuthorities() == nil {
+		if additionals, err := p.AllAdditionals(); err == nil {
+			for _, rr := range additionals {
+				if rr.Header.Type != dnsmessage.TypeOPT {
+					continue
+				}
+				edns = true
+				if int(rr.Header.Class) > size {
+					size = int(rr.Header.Class)
+				}
+			}
+		}
+	}
+	if size > dnsMaxResponse {
+		size = dnsMaxResponse
+	}
+	name := strings.ToLower(q.Name.String())
+	if name != l.zone && !strings.HasSuffix(name, "."+l.zone) {
+		l.respond(addr, header, q, edns, dnsmessage.RCodeRefused, nil)
+		return
+	}
+	// Anything else in the zone gets an empty answer, including the queries
+	// for shorter names that resolvers send when minimising them
+	var payload []byte
+	if q.Type == dnsmessage.TypeTXT && name != l.zone {
+		encoded := strings.ToUpper(strings.ReplaceAll(strings.TrimSuffix(name, "."+l.zone), ".", ""))
+		payload, _ = dnsEncoding.DecodeString(encoded)
+	}
+	if len(payload) < dnsQueryHeader {
+		l.respond(addr, header, q, edns, dnsmessage.RCodeSuccess, nil)
+		return
+	}
+	from := dnsAddr(hex.EncodeToString(payload[:4]))
+	caller := l.caller(from)
+	if caller.seen(binary.BigEndian.Uint16(payload[4:])) {
+		// A resend from a resolver, which may take the answer to the original
+		l.respond(addr, header, q, edns, dnsmessage.RCodeSuccess, []byte{})
+		return
+	}
+	if datagram := caller.reasm.add(payload[dnsQueryHeader:]); datagram != nil {
+		select {
+		case l.datagrams <- dnsDatagram{from, datagram}:
+		default:
+			// The listener has fallen behind, so drop the datagram as the network would
+		}
+	}
+	room := size - 64 - len(q.Name.String())
+	room -= room / 255 // Each TXT string has a length byte
+	if data := caller.take(room); len(data) > 0 {
+		l.respond(addr, header, q, edns, dnsmessage.RCodeSuccess, data)
+		return
+	}
+	select {
+	case l.held <- struct{}{}:
+	default:
+		// Holding too many already, so answer with nothing
+		l.respond(addr, header, q, edns, dnsmessage.RCodeSuccess, []byte{})
+		return
+	}
+	go func() {
+		defer func() { <-l.held }()
+		select {
+		case <-caller.notify:
+		case <-time.After(dnsHoldTime):
+		case <-l.done:
+			return
+		}
+		l.respond(addr, header, q, edns, dnsmessage.RCodeSuccess, caller.take(room))
+	}()
+}
+
+// respond sends a response to a query, with the given data in a TXT answer
+// unless it is nil.
+func (l *dnsListenConn) respond(addr *net.UDPAddr, query dnsmessage.Header, q dnsmessage.Question, edns bool, rcode dnsmessage.RCode, data []byte) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:               query.ID,
+		Response:         true,
+		Authoritative:    true,
+		RecursionDesired: query.RecursionDesired,
+		RCode:            rcode,
+	})
+	b.EnableCompression()
+	_ = b.StartQuestions()
+	_ = b.Question(q)
+	if data != nil && q.Type == dnsmessage.TypeTXT {
+		var txt []string
+		for len(data) > 255 {
+			txt = append(txt, string(data[:255]))
+			data = data[255:]
+		}
+		txt = append(txt, string(data))
+		_ = b.StartAnswers()
+		_ = b.TXTResource(dnsmessage.ResourceHeader{
+			Name:  q.Name,
+			Class: dnsmessage.ClassINET,
+			TTL:   0,
+		}, dnsmessage.TXTResource{TXT: txt})
+	}
+	if edns {
+		var opt dnsmessage.ResourceHeader
+		_ = opt.SetEDNS0(dnsMaxResponse, dnsmessage.RCodeSuccess, false)
+		_ = b.StartAdditionals()
+		_ = b.OPTResource(opt, dnsmessage.OPTResource{})
+	}
+	if msg, err := b.Finish(); err == nil {
+		_, _ = l.sock.WriteToUDP(msg, addr)
+	}
+}
+
+// caller returns the state of a caller, adding it if it is new and forgetting
+// any that haven't been heard from in a while, or the one that was heard from
+// least recently if there are too many.
+func (l *dnsListenConn) caller(from dnsAddr) *dnsCallerState {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	if now.Sub(l.pruned) > dnsCallerExpiry {
+		for addr, caller := range l.callers {
+			caller.mutex.Lock()
+			expired := now.Sub(caller.lastSeen) > dnsCallerExpiry
+			caller.mutex.Unlock()
+			if expired {
+				delete(l.callers, addr)
+			}
+		}
+		l.pruned = now
+	}
+	caller := l.callers[from]
+	if caller == nil {
+		if len(l.callers) >= dnsMaxCallers {
+			var oldest dnsAddr
+			var oldestSeen time.Time
+			for addr, other := range l.callers {
+				other.mutex.Lock()
+				seen := other.lastSeen
+				other.mutex.Unlock()
+				if oldest == "" || seen.Before(oldestSeen) {
+					oldest, oldestSeen = addr, seen
+				}
+			}
+			delete(l.callers, oldest)
+		}
+		caller = &dnsCallerState{
+			recent:   make(map[uint16]struct{}),
+			lastSeen: now,
+			notify:   make(chan struct{}, 1),
+		}
+		l.callers[from] = caller
+	}
+	return caller
+}
+
+func (l *dnsListenConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	select {
+	case d := <-l.datagrams:
+		return copy(b, d.data), d.from, nil
+	case <-l.done:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+// WriteTo queues the pieces of a datagram for a caller, to be sent down in
+// the responses to its queries.
+func (l *dnsListenConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	l.mutex.Lock()
+	caller := l.callers[dnsAddr(addr.String())]
+	l.mutex.Unlock()
+	if caller == nil {
+		return 0, fmt.Errorf("no queries from %s to answer", addr)
+	}
+	caller.mutex.Lock()
+	caller.dseq++
+	caller.queue = append(caller.queue, dnsPieces(caller.dseq, b, dnsDownPiece)...)
+	if drop := len(caller.queue) - dnsQueueSize; drop > 0 {
+		// The queries have fallen behind, so drop the oldest pieces as the network would
+		caller.queue = caller.queue[drop:]
+	}
+	caller.mutex.Unlock()
+	select {
+	case caller.notify <- struct{}{}:
+	default:
+	}
+	return len(b), nil
+}
+
+func (l *dnsListenConn) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+		l.sock.Close()
+	})
+	return nil
+}
+
+func (l *dnsListenConn) LocalAddr() net.Addr {
+	return l.sock.LocalAddr()
+}
+
+func (l *dnsListenConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (l *dnsListenConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (l *dnsListenConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
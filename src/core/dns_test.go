@@ -0,0 +1,103 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestDNSPieces(t *testing.T) {
+	u, _ := url.Parse("dns://127.0.0.1/t.example.com")
+	options, err := parseDNSOptions(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	datagrams := [][]byte{
+		bytes.Repeat([]byte{1}, udpDatagramSize),
+		bytes.Repeat([]byte{2}, 10),
+		bytes.Repeat([]byte{3}, options.upPiece+1),
+	}
+	var pieces [][]byte
+	for i, datagram := range datagrams {
+		pieces = append(pieces, dnsPieces(uint16(i), datagram, options.upPiece)...)
+	}
+	// Pieces may arrive in any order, and twice if a resolver resends them
+	rand.Shuffle(len(pieces), func(i, j int) { pieces[i], pieces[j] = pieces[j], pieces[i] })
+	pieces = append(pieces, pieces[0])
+	var reasm dnsReassembler
+	got := map[byte][]byte{}
+	for _, piece := range pieces {
+		if datagram := reasm.add(piece); datagram != nil {
+			if _, isIn := got[datagram[0]]; isIn && len(datagram) > options.upPiece {
+				t.Fatal("datagram was put back together twice")
+			}
+			got[datagram[0]] = datagram
+		}
+	}
+	for _, datagram := range datagrams {
+		if !bytes.Equal(got[datagram[0]], datagram) {
+			t.Fatalf("datagram of %d bytes came out as %d bytes", len(datagram), len(got[datagram[0]]))
+		}
+	}
+}
+
+// TestDNSListenConn_Limits checks that a listener answers polls straight away
+// once it is holding as many as it may, and forgets the caller that it heard
+// from least recently once it knows as many as it may.
+func TestDNSListenConn_Limits(t *testing.T) {
+	sock, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &dnsListenConn{
+		sock:    sock,
+		zone:    "t.example.com.",
+		callers: make(map[dnsAddr]*dnsCallerState),
+		held:    make(chan struct{}, dnsMaxHeld),
+		done:    make(chan struct{}),
+	}
+	defer l.Close()
+	client, err := net.DialUDP("udp", nil, sock.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	for i := 0; i < dnsMaxHeld; i++ {
+		l.held <- struct{}{}
+	}
+	payload := []byte{1, 2, 3, 4, 0, 1}
+	name := dnsmessage.MustNewName(strings.ToLower(dnsEncoding.EncodeToString(payload)) + "." + l.zone)
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 1})
+	_ = b.StartQuestions()
+	_ = b.Question(dnsmessage.Question{Name: name, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET})
+	query, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.handle(query, client.LocalAddr().(*net.UDPAddr))
+	_ = client.SetReadDeadline(time.Now().Add(dnsHoldTime / 2))
+	if _, err := client.Read(make([]byte, dnsMaxResponse)); err != nil {
+		t.Fatal("poll was not answered straight away:", err)
+	}
+
+	start := time.Now()
+	for i := 0; i < dnsMaxCallers+1; i++ {
+		c := l.caller(dnsAddr(fmt.Sprint(i)))
+		c.mutex.Lock()
+		c.lastSeen = start.Add(time.Duration(i) * time.Millisecond)
+		c.mutex.Unlock()
+	}
+	if len(l.callers) != dnsMaxCallers {
+		t.Fatal("unexpected number of callers", len(l.callers))
+	}
+	if _, ok := l.callers[dnsAddr(fmt.Sprint(0))]; ok {
+		t.Fatal("caller heard from least recently was kept")
+	}
+}
@@ -0,0 +1,162 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+)
+
+const (
+	ethType        = 0x88B5 // IEEE 802 local experimental EtherType
+	ethDialTimeout = time.Minute
+)
+
+// tcpeth hangs the raw Ethernet transport off of the TCP one, in the same way
+// as the serial transport, so that machines on the same cable or VLAN can peer
+// without any IP configuration. Both sides list each other as peers, e.g.
+// eth://eth0?peer=aa:bb:cc:dd:ee:ff, and the link runs over the session layer
+// of the UDP transport, sent in Ethernet frames of our own EtherType. Each
+// frame carries a 2 byte length first, as short frames are padded on the wire.
+//
+// Unlike UDP there is no listener, so both sides send hellos until they hear
+// from the other. Each side says hello with a session ID of its own, and a
+// hello with a different ID means that the other side has restarted, so the
+// session is closed to be set up again.
+type tcpeth struct {
+	tcp     *tcp
+	upgrade *TcpUpgrade
+}
+
+// ethOptions are the options for an Ethernet link, from its peer URI.
+type ethOptions struct {
+	iface string
+	peer  net.HardwareAddr
+}
+
+func (e *tcpeth) init(tcp *tcp) {
+	e.tcp = tcp
+	e.upgrade = &TcpUpgrade{
+		upgrade: func(c net.Conn, _ *tcpOptions) (net.Conn, error) { return c, nil },
+		name:    "eth",
+		dial:    e.dial,
+	}
+}
+
+// parseEthOptions reads the interface and the MAC address of the peer from a
+// peer URI.
+func parseEthOptions(u *url.URL) (ethOptions, error) {
+	options := ethOptions{iface: u.Host}
+	if options.iface == "" {
+		return options, fmt.Errorf("eth peer %s must give an interface, e.g. eth://eth0?peer=aa:bb:cc:dd:ee:ff", u.String())
+	}
+	peer, err := net.ParseMAC(u.Query().Get("peer"))
+	if err != nil || len(peer) != 6 {
+		return options, fmt.Errorf("eth peer %s must give the MAC address of the peer", u.String())
+	}
+	options.peer = peer
+	return options, nil
+}
+
+// ethAddr is an interface and the MAC address of a machine on it.
+type ethAddr struct {
+	iface string
+	mac   net.HardwareAddr
+}
+
+func (a *ethAddr) Network() string { return "eth" }
+func (a *ethAddr) String() string  { return a.iface + "/" + a.mac.String() }
+
+func (e *tcpeth) dial(ctx context.Context, _ string, options *tcpOptions) (net.Conn, error) {
+	sock, err := openEth(options.eth.iface)
+	if err != nil {
+		return nil, err
+	}
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		sock.Close()
+		return nil, err
+	}
+	peer := options.eth.peer
+	s := newUDPSession(e.tcp.links.core.clock, binary.BigEndian.Uint64(id[:]),
+		&ethAddr{options.eth.iface, sock.local},
+		&ethAddr{options.eth.iface, peer},
+		func(b []byte) error {
+			frame := make([]byte, 2, 2+len(b))
+			binary.BigEndian.PutUint16(frame, uint16(len(b)))
+			return sock.send(peer, append(frame, b...))
+		},
+		func() { sock.Close() },
+	)
+	go e.receive(sock, s, peer)
+	ctx, done := context.WithTimeout(ctx, ethDialTimeout)
+	defer done()
+	hello := s.header(udpHello)
+	for {
+		if err := s.send(hello); err != nil {
+			s.Close()
+			return nil, err
+		}
+		select {
+		case <-s.established:
+			return s, nil
+		case <-s.closed:
+			return nil, errors.New("eth session closed by peer")
+		case <-ctx.Done():
+			s.shutdown(false)
+			return nil, fmt.Errorf("no reply from %s on %s", peer, options.eth.iface)
+		case <-time.After(udpHelloInterval):
+			// The peer may not be up yet, which mustn't time the session out
+			s.mutex.Lock()
+			s.lastRecv = time.Now()
+			s.mutex.Unlock()
+		}
+	}
+}
+
+// receive passes the frames from the peer to the session, until the socket is
+// closed.
+func (e *tcpeth) receive(sock *ethSocket, s *udpSession, peer net.HardwareAddr) {
+	var peerID uint64
+	var seen bool
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := sock.recv(buf)
+		if err != nil {
+			s.shutdown(false)
+			return
+		}
+		if !bytes.Equal(from, peer) || n < 2 {
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(buf))
+		if length < udpHeaderSize || 2+length > n {
+			continue
+		}
+		pkt := buf[2 : 2+length]
+		id := binary.BigEndian.Uint64(pkt[1:udpHeaderSize])
+		switch {
+		case pkt[0] == udpHello || pkt[0] == udpHelloAck:
+			if seen && id != peerID {
+				s.shutdown(false) // The peer has restarted
+				return
+			}
+			peerID, seen = id, true
+			if pkt[0] == udpHello {
+				_ = s.send(s.header(udpHelloAck))
+			}
+			s.markReceived()
+		case !seen || id != peerID:
+			// Left over from an earlier session
+		default:
+			// The session expects its own ID on everything it receives
+			binary.BigEndian.PutUint64(pkt[1:udpHeaderSize], s.id)
+			s.receive(pkt)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+//go:build linux
+// +build linux
+
+package core
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ethSocket is a packet socket for our EtherType on a single interface.
+type ethSocket struct {
+	file    *os.File
+	raw     syscall.RawConn
+	ifindex int
+	local   net.HardwareAddr
+}
+
+// htons returns v in network byte order, as packet sockets expect it in the
+// protocol fields.
+func htons(v uint16) uint16 {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	return *(*uint16)(unsafe.Pointer(&b[0]))
+}
+
+func openEth(iface string) (*ethSocket, error) {
+	intf, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, int(htons(ethType)))
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(ethType), Ifindex: intf.Index}); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	file := os.NewFile(uintptr(fd), "eth:"+iface)
+	raw, err := file.SyscallConn()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &ethSocket{file: file, raw: raw, ifindex: intf.Index, local: intf.HardwareAddr}, nil
+}
+
+func (s *ethSocket) send(to net.HardwareAddr, b []byte) error {
+	sa := &unix.SockaddrLinklayer{Protocol: htons(ethType), Ifindex: s.ifindex, Halen: uint8(len(to))}
+	copy(sa.Addr[:], to)
+	var serr error
+	if err := s.raw.Write(func(fd uintptr) bool {
+		serr = unix.Sendto(int(fd), b, 0, sa)
+		return serr != unix.EAGAIN
+	}); err != nil {
+		return err
+	}
+	return serr
+}
+
+func (s *ethSocket) recv(b []byte) (int, net.HardwareAddr, error) {
+	var n int
+	var from unix.Sockaddr
+	var rerr error
+	if err := s.raw.Read(func(fd uintptr) bool {
+		n, from, rerr = unix.Recvfrom(int(fd), b, 0)
+		return rerr != unix.EAGAIN
+	}); err != nil {
+		return 0, nil, err
+	}
+	if rerr != nil {
+		return 0, nil, rerr
+	}
+	var mac net.HardwareAddr
+	if ll, ok := from.(*unix.SockaddrLinklayer); ok {
+		mac = net.HardwareAddr(ll.Addr[:ll.Halen])
+	}
+	return n, mac, nil
+}
+
+func (s *ethSocket) Close() error {
+	return s.file.Close()
+}
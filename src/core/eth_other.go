@@ -0,0 +1,33 @@
+//go:build !linux
+// +build !linux
+
+package core
+
+import (
+	"errors"
+	"net"
+)
+
+// Raw Ethernet needs BPF devices on the BSDs and macOS, which aren't supported
+// yet, so for now only Linux packet sockets are.
+var errNoEth = errors.New("ethernet links are only supported on Linux")
+
+type ethSocket struct {
+	local net.HardwareAddr
+}
+
+func openEth(iface string) (*ethSocket, error) {
+	return nil, errNoEth
+}
+
+func (s *ethSocket) send(to net.HardwareAddr, b []byte) error {
+	return errNoEth
+}
+
+func (s *ethSocket) recv(b []byte) (int, net.HardwareAddr, error) {
+	return 0, nil, errNoEth
+}
+
+func (s *ethSocket) Close() error {
+	return nil
+}
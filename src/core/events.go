@@ -0,0 +1,121 @@
+package core
+
+// This file contains the callbacks for links to peers coming up and going
+// down, so that applications that embed a node can update their UI or fail
+// over to something else without scraping the logs. The callbacks are run by
+// an actor of their own, one at a time and in the order that the links came
+// up and went down, so that a slow callback doesn't hold up the links.
+
+import (
+	"crypto/ed25519"
+	"sort"
+
+	"github.com/Arceliar/phony"
+)
+
+// PeerEvent describes a link to a peer that has come up or gone down.
+type PeerEvent struct {
+	Key   ed25519.PublicKey
+	Link  Link  // The statistics of the link at the time, as GetLinks returns them
+	Error error // Why the link went down, if it went down with an error
+}
+
+// PeerCallbacksID identifies callbacks added by AddPeerCallbacks, so that they
+// can be removed again.
+type PeerCallbacksID uint64
+
+// peerCallbacks are the functions set by SetPeerCallbacks, under the zero id,
+// and added by AddPeerCallbacks, which are run by the inbox.
+type peerCallbacks struct {
+	phony.Inbox
+	set  map[PeerCallbacksID]peerCallbackPair
+	next PeerCallbacksID
+}
+
+type peerCallbackPair struct {
+	onUp   func(PeerEvent)
+	onDown func(PeerEvent)
+}
+
+// SetPeerCallbacks sets functions to call whenever a link to a peer has come
+// up, once its metadata has been exchanged and it has been accepted, and
+// whenever one goes down. Either may be nil to stop being told about them.
+// Callbacks added by AddPeerCallbacks are left as they are.
+func (c *Core) SetPeerCallbacks(onUp, onDown func(PeerEvent)) {
+	c.links.mutex.Lock()
+	defer c.links.mutex.Unlock()
+	c.links.callbacks.put(0, onUp, onDown)
+}
+
+// AddPeerCallbacks adds functions to call whenever a link to a peer has come
+// up or gone down, as SetPeerCallbacks does, but alongside any others, so
+// that more than one part of an application can be told about them. The
+// returned id removes them again with RemovePeerCallbacks.
+func (c *Core) AddPeerCallbacks(onUp, onDown func(PeerEvent)) PeerCallbacksID {
+	c.links.mutex.Lock()
+	defer c.links.mutex.Unlock()
+	c.links.callbacks.next++
+	id := c.links.callbacks.next
+	c.links.callbacks.put(id, onUp, onDown)
+	return id
+}
+
+// RemovePeerCallbacks removes the functions added by AddPeerCallbacks with
+// the given id.
+func (c *Core) RemovePeerCallbacks(id PeerCallbacksID) {
+	if id == 0 {
+		return // Belongs to SetPeerCallbacks
+	}
+	c.links.mutex.Lock()
+	defer c.links.mutex.Unlock()
+	delete(c.links.callbacks.set, id)
+}
+
+// put sets the callbacks with the given id, or removes them if both are nil.
+// The links mutex must be held.
+func (p *peerCallbacks) put(id PeerCallbacksID, onUp, onDown func(PeerEvent)) {
+	if onUp == nil && onDown == nil {
+		delete(p.set, id)
+		return
+	}
+	if p.set == nil {
+		p.set = make(map[PeerCallbacksID]peerCallbackPair)
+	}
+	p.set[id] = peerCallbackPair{onUp, onDown}
+}
+
+// notify queues the callbacks for a link coming up or going down, if there are
+// any, in the order that they were set or added.
+func (l *links) notify(intf *link, up bool, err error) {
+	l.mutex.RLock()
+	ids := make([]PeerCallbacksID, 0, len(l.callbacks.set))
+	for id := range l.callbacks.set {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	var callbacks []func(PeerEvent)
+	for _, id := range ids {
+		pair := l.callbacks.set[id]
+		callback := pair.onUp
+		if !up {
+			callback = pair.onDown
+		}
+		if callback != nil {
+			callbacks = append(callbacks, callback)
+		}
+	}
+	l.mutex.RUnlock()
+	if len(callbacks) == 0 {
+		return
+	}
+	event := PeerEvent{
+		Key:   append(ed25519.PublicKey(nil), intf.info.key[:]...),
+		Link:  intf.stats(l.core.clock.Now(), up, err),
+		Error: err,
+	}
+	l.callbacks.Act(nil, func() {
+		for _, callback := range callbacks {
+			callback(event)
+		}
+	})
+}
@@ -0,0 +1,58 @@
+package core
+
+import (
+	"bytes"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestCore_PeerCallbacks(t *testing.T) {
+	nodeA := new(Core)
+	if err := nodeA.Start(GenerateConfig(), GetLoggerWithPrefix("A: ", false)); err != nil {
+		t.Fatal(err)
+	}
+	defer nodeA.Stop()
+	events := make(chan PeerEvent, 4)
+	nodeA.SetPeerCallbacks(
+		func(e PeerEvent) { events <- e },
+		func(e PeerEvent) { events <- e },
+	)
+	// Added callbacks run alongside those that were set, until removed
+	added, removed := make(chan PeerEvent, 4), make(chan PeerEvent, 4)
+	nodeA.AddPeerCallbacks(
+		func(e PeerEvent) { added <- e },
+		func(e PeerEvent) { added <- e },
+	)
+	id := nodeA.AddPeerCallbacks(
+		func(e PeerEvent) { removed <- e },
+		func(e PeerEvent) { removed <- e },
+	)
+	nodeA.RemovePeerCallbacks(id)
+	nodeB := new(Core)
+	if err := nodeB.Start(GenerateConfig(), GetLoggerWithPrefix("B: ", false)); err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("tcp://" + nodeA.links.tcp.getAddr().String())
+	if err := nodeB.CallPeer(u, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, up := range []bool{true, false} {
+		for _, ch := range []chan PeerEvent{events, added} {
+			select {
+			case e := <-ch:
+				if e.Link.Up != up || !bytes.Equal(e.Key, nodeB.PublicKey()) {
+					t.Fatalf("unexpected event %+v", e)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("no event for the link going up or down")
+			}
+		}
+		if up {
+			nodeB.Stop()
+		}
+	}
+	if len(removed) != 0 {
+		t.Fatal("removed callbacks were called")
+	}
+}
@@ -0,0 +1,29 @@
+package core
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestCore_FastOpen(t *testing.T) {
+	cfgA := GenerateConfig()
+	cfgA.Listen = []string{"tcp://127.0.0.1:0?fastopen=true"}
+	nodeA, nodeB := new(Core), new(Core)
+	if err := nodeA.Start(cfgA, GetLoggerWithPrefix("A: ", false)); err != nil {
+		t.Fatal(err)
+	}
+	defer nodeA.Stop()
+	if err := nodeB.Start(GenerateConfig(), GetLoggerWithPrefix("B: ", false)); err != nil {
+		t.Fatal(err)
+	}
+	defer nodeB.Stop()
+	// Links come up with or without a cookie, and where Fast Open isn't supported
+	u, _ := url.Parse("tcp://" + nodeA.links.tcp.getAddr().String() + "?fastopen=true")
+	if err := nodeB.CallPeer(u, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !WaitConnected(nodeA, nodeB) {
+		t.Fatal("nodes did not connect")
+	}
+	CheckEcho(t, nodeA, nodeB)
+}
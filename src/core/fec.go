@@ -0,0 +1,300 @@
+package core
+
+// This file contains the forward error correction of UDP links, for radio and
+// satellite links that lose so many datagrams that too few frames make it
+// through whole. Peers and listeners with the datashards and parityshards
+// options, as for KCP, send the datagrams of their sessions in groups of that
+// many, each followed by that many parity datagrams, from a Reed-Solomon code
+// over the group. Any datagrams of a group that are lost can be recovered as
+// long as at least as many datagrams of the group arrive as it has data
+// datagrams. A group that isn't full is sent after udpFECFlush, with a share
+// of the parity datagrams in proportion to its size, so that FEC adds little
+// latency. Every node that this version can receive FEC datagrams, so only
+// the sending side needs the options, but older nodes drop them.
+//
+// FEC datagrams start with the usual type and session ID, followed by a 2 byte
+// group number, the 1 byte index in the group, and, for parity datagrams, the
+// 1 byte numbers of data and parity datagrams in the group, which are 0 for
+// data datagrams. Data datagrams then carry a data datagram of the session,
+// without its type and ID, which is handled straight away. Parity datagrams
+// carry parity over the data datagrams, each with a 2 byte length before it,
+// padded to the length of the longest.
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+const (
+	udpFECHeaderSize = udpHeaderSize + 5
+	udpFECOverhead   = udpFECHeaderSize - udpHeaderSize + 2 // Each fragment loses this much room to FEC
+	udpFECMaxShards  = 255                                  // Data and parity datagrams in a group
+	udpFECGroups     = 64                                   // Groups that may be recovered at once per session
+	udpFECFlush      = 5 * time.Millisecond                 // How long a group that isn't full waits to be sent
+)
+
+// fecOptions are the options for forward error correction on a UDP link.
+type fecOptions struct {
+	dataShards   int // Datagrams in each group, or 0 to disable FEC
+	parityShards int // Parity datagrams sent after each full group
+}
+
+// parseFECOptions reads the datashards and parityshards options of a udp://
+// peer or listener URI.
+func parseFECOptions(u *url.URL) (fecOptions, error) {
+	var options fecOptions
+	for name, opt := range map[string]*int{
+		"datashards":   &options.dataShards,
+		"parityshards": &options.parityShards,
+	} {
+		if v := u.Query().Get(name); v != "" {
+			n, err := strconv.ParseUint(v, 10, 8)
+			if err != nil {
+				return options, fmt.Errorf("%s has invalid %s: %w", u.String(), name, err)
+			}
+			*opt = int(n)
+		}
+	}
+	if (options.dataShards == 0) != (options.parityShards == 0) {
+		return options, fmt.Errorf("%s must have both data and parity shards, or neither", u.String())
+	}
+	if options.dataShards+options.parityShards > udpFECMaxShards {
+		return options, fmt.Errorf("%s has more than %d shards", u.String(), udpFECMaxShards)
+	}
+	return options, nil
+}
+
+// fecEncoders holds the Reed-Solomon encoders by their numbers of data and
+// parity shards, as groups that aren't full need encoders of their own.
+type fecEncoders map[[2]int]reedsolomon.Encoder
+
+func (e fecEncoders) get(data, parity int) (reedsolomon.Encoder, error) {
+	key := [2]int{data, parity}
+	if enc, ok := e[key]; ok {
+		return enc, nil
+	}
+	enc, err := reedsolomon.New(data, parity)
+	if err != nil {
+		return nil, err
+	}
+	e[key] = enc
+	return enc, nil
+}
+
+// udpFECSender groups the data datagrams that a session sends. It is protected
+// by the wmutex of the session.
+type udpFECSender struct {
+	options  fecOptions
+	encoders fecEncoders
+	group    uint16
+	shards   [][]byte // The data datagrams of the group so far
+}
+
+// udpFECGroup is a group of datagrams that are being received.
+type udpFECGroup struct {
+	data   int      // Data datagrams in the group, once a parity datagram has arrived
+	parity int      // Parity datagrams in the group, likewise
+	size   int      // The length of the parity datagrams
+	shards [][]byte // Data datagrams without their lengths, then parity
+	have   int
+	done   bool // Every data datagram has been handled
+}
+
+// udpFECReceiver recovers lost datagrams. It is only used by receive.
+type udpFECReceiver struct {
+	encoders fecEncoders
+	groups   map[uint16]*udpFECGroup
+	order    []uint16 // The groups, oldest first
+}
+
+// enableFEC has the session send its datagrams with forward error correction.
+// It must be called before the session is used.
+func (s *udpSession) enableFEC(options fecOptions) {
+	if options.dataShards == 0 {
+		return
+	}
+	s.fec = &udpFECSender{options: options, encoders: make(fecEncoders)}
+	s.fragSize = udpFragmentSize - udpFECOverhead
+}
+
+// sendData sends a data datagram, which is pkt without its type and session
+// ID, as part of the current group if FEC is enabled. It must be called with
+// the wmutex held.
+func (s *udpSession) sendData(pkt []byte) error {
+	if s.fec == nil {
+		return s.send(pkt)
+	}
+	f := s.fec
+	body := pkt[udpHeaderSize:]
+	out := s.header(udpFEC)
+	out = append(out, byte(f.group>>8), byte(f.group), byte(len(f.shards)), 0, 0)
+	out = append(out, body...)
+	if err := s.send(out); err != nil {
+		return err
+	}
+	f.shards = append(f.shards, append([]byte(nil), body...))
+	switch {
+	case len(f.shards) >= f.options.dataShards:
+		return s.sendParity()
+	case len(f.shards) == 1:
+		group := f.group
+		time.AfterFunc(udpFECFlush, func() {
+			s.wmutex.Lock()
+			defer s.wmutex.Unlock()
+			if f.group == group && len(f.shards) > 0 {
+				s.sent(s.sendParity())
+			}
+		})
+	}
+	return nil
+}
+
+// sendParity sends the parity datagrams of the current group, and starts the
+// next one. It must be called with the wmutex held.
+func (s *udpSession) sendParity() error {
+	f := s.fec
+	data := len(f.shards)
+	parity := (data*f.options.parityShards + f.options.dataShards - 1) / f.options.dataShards
+	if data+parity > udpFECMaxShards {
+		parity = udpFECMaxShards - data
+	}
+	group := f.group
+	f.group++
+	defer func() { f.shards = f.shards[:0] }()
+	enc, err := f.encoders.get(data, parity)
+	if err != nil {
+		return err
+	}
+	size := 0
+	for _, body := range f.shards {
+		if len(body)+2 > size {
+			size = len(body) + 2
+		}
+	}
+	shards := make([][]byte, data+parity)
+	for i := range shards {
+		shards[i] = make([]byte, size)
+		if i < data {
+			binary.BigEndian.PutUint16(shards[i], uint16(len(f.shards[i])))
+			copy(shards[i][2:], f.shards[i])
+		}
+	}
+	if err := enc.Encode(shards); err != nil {
+		return err
+	}
+	for i := data; i < len(shards); i++ {
+		out := s.header(udpFEC)
+		out = append(out, byte(group>>8), byte(group), byte(i), byte(data), byte(parity))
+		out = append(out, shards[i]...)
+		if err := s.send(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// receiveFEC handles a FEC datagram, without its type and session ID. It must
+// only be called by receive.
+func (s *udpSession) receiveFEC(body []byte) {
+	if len(body) < udpFECHeaderSize-udpHeaderSize {
+		return
+	}
+	r := &s.fecRecv
+	if r.groups == nil {
+		r.encoders = make(fecEncoders)
+		r.groups = make(map[uint16]*udpFECGroup)
+	}
+	seq := binary.BigEndian.Uint16(body)
+	index, data, parity := int(body[2]), int(body[3]), int(body[4])
+	payload := body[5:]
+	g := r.groups[seq]
+	if g == nil {
+		if len(r.order) >= udpFECGroups {
+			delete(r.groups, r.order[0])
+			r.order = r.order[1:]
+		}
+		g = &udpFECGroup{shards: make([][]byte, udpFECMaxShards)}
+		r.groups[seq] = g
+		r.order = append(r.order, seq)
+	}
+	if g.done || g.shards[index] != nil {
+		return
+	}
+	if data == 0 {
+		// A data datagram, which is handled straight away
+		if g.data != 0 && index >= g.data {
+			return
+		}
+		g.shards[index] = append([]byte(nil), payload...)
+		g.have++
+		s.receiveData(payload)
+	} else {
+		if index < data || data+parity > udpFECMaxShards || (g.data != 0 && (g.data != data || g.parity != parity || g.size != len(payload))) {
+			return
+		}
+		g.data, g.parity, g.size = data, parity, len(payload)
+		g.shards[index] = append([]byte(nil), payload...)
+		g.have++
+	}
+	if g.data == 0 || g.have < g.data {
+		return
+	}
+	missing := false
+	for i := 0; i < g.data; i++ {
+		if g.shards[i] == nil {
+			missing = true
+		} else if len(g.shards[i])+2 > g.size {
+			return // Doesn't fit the parity, so the group is corrupt
+		}
+	}
+	g.done = true
+	if !missing {
+		g.shards = nil
+		return
+	}
+	s.recoverFEC(g)
+	g.shards = nil
+}
+
+// recoverFEC recovers the lost data datagrams of a group that has enough of
+// its datagrams, and handles them.
+func (s *udpSession) recoverFEC(g *udpFECGroup) {
+	enc, err := s.fecRecv.encoders.get(g.data, g.parity)
+	if err != nil {
+		return
+	}
+	shards := make([][]byte, g.data+g.parity)
+	lost := make([]bool, g.data)
+	for i := range shards {
+		switch {
+		case g.shards[i] == nil:
+			if i < g.data {
+				lost[i] = true
+			}
+		case i < g.data:
+			shards[i] = make([]byte, g.size)
+			binary.BigEndian.PutUint16(shards[i], uint16(len(g.shards[i])))
+			copy(shards[i][2:], g.shards[i])
+		default:
+			shards[i] = g.shards[i]
+		}
+	}
+	if err := enc.ReconstructData(shards); err != nil {
+		return
+	}
+	for i, l := range lost {
+		if !l {
+			continue
+		}
+		n := int(binary.BigEndian.Uint16(shards[i]))
+		if n+2 > len(shards[i]) {
+			continue
+		}
+		s.receiveData(shards[i][2 : 2+n])
+	}
+}
@@ -0,0 +1,54 @@
+package core
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestUDPSession_FEC(t *testing.T) {
+	var b *udpSession
+	sent := 0
+	a := newUDPSession(systemClock{}, 1, nil, nil, func(pkt []byte) error {
+		sent++
+		if sent%5 != 2 { // Lose a fifth of the datagrams
+			b.receive(append([]byte(nil), pkt...))
+		}
+		return nil
+	}, func() {})
+	b = newUDPSession(systemClock{}, 1, nil, nil, func([]byte) error { return nil }, func() {})
+	defer a.Close()
+	defer b.Close()
+	a.enableFEC(fecOptions{dataShards: 4, parityShards: 2})
+	var frames [][]byte
+	for i := 0; i < 50; i++ {
+		frame := make([]byte, 10+rand.Intn(3000))
+		rand.Read(frame)
+		frames = append(frames, frame)
+		if _, err := a.Write(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Recovered frames may arrive out of order, as they would over UDP anyway
+	want := make(map[string]struct{})
+	for _, frame := range frames {
+		want[string(frame)] = struct{}{}
+	}
+	buf := make([]byte, 65535)
+	for range frames {
+		done := make(chan int)
+		go func() {
+			n, _ := b.Read(buf)
+			done <- n
+		}()
+		select {
+		case n := <-done:
+			if _, ok := want[string(buf[:n])]; !ok {
+				t.Fatal("received a frame that wasn't sent")
+			}
+			delete(want, string(buf[:n]))
+		case <-time.After(time.Second):
+			t.Fatalf("%d frames were lost", len(want))
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package core
+
+// This file contains the filters on the source addresses of inbound
+// connections, which let public listeners be restricted without a firewall.
+// Each filter in ListenFilters allows or denies a prefix, e.g.
+// "allow 192.0.2.0/24" or "deny ::/0", and the first one that matches the
+// remote address of a connection decides whether it is accepted. Connections
+// that match none of them are accepted. The filters are checked as soon as a
+// connection is accepted, before the handshake, and apply to every listener.
+// Connections without an IP address, such as over named pipes, Tor or I2P,
+// are never filtered.
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+type listenFilter struct {
+	allow  bool
+	prefix *net.IPNet
+}
+
+// parseListenFilters reads the filters from the config.
+func parseListenFilters(rules []string) ([]listenFilter, error) {
+	filters := make([]listenFilter, 0, len(rules))
+	for _, rule := range rules {
+		fields := strings.Fields(rule)
+		if len(fields) != 2 || (fields[0] != "allow" && fields[0] != "deny") {
+			return nil, fmt.Errorf("listen filter %q is not \"allow\" or \"deny\" and a prefix", rule)
+		}
+		prefix := fields[1]
+		if !strings.Contains(prefix, "/") {
+			// A single address
+			if ip := net.ParseIP(prefix); ip != nil && ip.To4() != nil {
+				prefix += "/32"
+			} else {
+				prefix += "/128"
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("listen filter %q has an invalid prefix: %w", rule, err)
+		}
+		filters = append(filters, listenFilter{allow: fields[0] == "allow", prefix: ipnet})
+	}
+	return filters, nil
+}
+
+// addrIP returns the IP address of a remote address, or nil if it doesn't
+// have one.
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	host := addrHost(addr)
+	if i := strings.LastIndex(host, "%"); i >= 0 {
+		host = host[:i]
+	}
+	return net.ParseIP(host)
+}
+
+// filtered returns true if the filters deny connections from the address.
+func (t *tcp) filtered(addr net.Addr) bool {
+	ip := addrIP(addr)
+	if ip == nil {
+		return false
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, filter := range t.filters {
+		if filter.prefix.Contains(ip) {
+			return !filter.allow
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package core
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCore_ListenFilters(t *testing.T) {
+	var tc tcp
+	filters, err := parseListenFilters([]string{"allow 192.0.2.1", "deny 192.0.2.0/24", "deny ::/0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.filters = filters
+	for addr, denied := range map[string]bool{
+		"192.0.2.1:1":             false,
+		"192.0.2.2:1":             true,
+		"198.51.100.1:1":          false,
+		"[2001:db8::1]:1":         true,
+		"[::ffff:192.0.2.2]:1":    true,
+		"[fe80::1%eth0]:1":        true,
+		"unix-socket-without-ip":  false,
+		"[::ffff:198.51.100.1]:1": false,
+	} {
+		if tc.filtered(&testAddr{addr}) != denied {
+			t.Error("wrong filter result for", addr)
+		}
+	}
+	if _, err := parseListenFilters([]string{"permit 192.0.2.0/24"}); err == nil {
+		t.Fatal("bad filter was accepted")
+	}
+	// Denied connections are closed before the handshake
+	cfg := GenerateConfig()
+	cfg.ListenFilters = []string{"deny 127.0.0.0/8"}
+	node := new(Core)
+	if err := node.Start(cfg, GetLoggerWithPrefix("", false)); err != nil {
+		t.Fatal(err)
+	}
+	defer node.Stop()
+	conn, err := net.Dial("tcp", node.links.tcp.getAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatal("denied connection was not closed:", err)
+	}
+}
@@ -0,0 +1,191 @@
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// tcph2 hangs the HTTP/2 CONNECT transport off of the TCP one. A peer URI such
+// as h2://user:pass@proxy:port/target:port asks the proxy to open a tunnel to
+// the TLS listener at target:port, in the same way as socks:// does, which
+// gets through corporate proxies that only allow HTTP. The h2:// scheme speaks
+// to the proxy over TLS and h2c:// speaks to it in the clear. Each link gets a
+// connection to the proxy of its own.
+type tcph2 struct {
+	tcp             *tcp
+	forDialer       *TcpUpgrade
+	forSecureDialer *TcpUpgrade
+}
+
+// h2Options are the options for an HTTP/2 CONNECT link, from its peer URI.
+type h2Options struct {
+	proxy  string        // The HTTP/2 proxy
+	auth   *url.Userinfo // The credentials for the proxy, if any
+	target string        // The listener that the proxy tunnels the link to
+	secure bool          // Whether to speak to the proxy over TLS
+}
+
+func (h *tcph2) init(tcp *tcp) {
+	h.tcp = tcp
+	h.forDialer = &TcpUpgrade{
+		upgrade: tcp.tls.upgradeDialer,
+		name:    "h2c",
+		dial:    h.dial,
+	}
+	h.forSecureDialer = &TcpUpgrade{
+		upgrade: tcp.tls.upgradeDialer,
+		name:    "h2",
+		dial:    h.dial,
+	}
+}
+
+// parseH2Options reads the options for an HTTP/2 CONNECT link from its peer
+// URI, where the userinfo gives the credentials for the proxy and the path
+// gives the target.
+func parseH2Options(u *url.URL) (h2Options, error) {
+	options := h2Options{
+		proxy:  u.Host,
+		auth:   u.User,
+		target: strings.Trim(u.Path, "/"),
+		secure: u.Scheme == "h2",
+	}
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		return options, fmt.Errorf("%s peer %s must give the port of the proxy", u.Scheme, u.String())
+	}
+	if _, _, err := net.SplitHostPort(options.target); err != nil {
+		return options, fmt.Errorf("%s peer %s must give the host and port to tunnel to as its path", u.Scheme, u.String())
+	}
+	return options, nil
+}
+
+// dial connects to the proxy and sends a CONNECT request for the target. The
+// address passed in is the proxy and the target together, so that links to
+// different targets through the same proxy are told apart.
+func (h *tcph2) dial(ctx context.Context, saddr string, options *tcpOptions) (net.Conn, error) {
+	ctx, done := context.WithTimeout(ctx, default_timeout)
+	defer done()
+	dialer := net.Dialer{Control: h.tcp.tcpContext}
+	conn, err := dialer.DialContext(ctx, "tcp", options.h2.proxy)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	raw := conn
+	if options.h2.secure {
+		host, _, _ := net.SplitHostPort(options.h2.proxy)
+		tlsconn := tls.Client(conn, &tls.Config{
+			ServerName: host,
+			NextProtos: []string{http2.NextProtoTLS},
+		})
+		if err := tlsconn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if proto := tlsconn.ConnectionState().NegotiatedProtocol; proto != http2.NextProtoTLS {
+			conn.Close()
+			return nil, fmt.Errorf("proxy %s does not support HTTP/2", options.h2.proxy)
+		}
+		conn = tlsconn
+	}
+	transport := &http2.Transport{AllowHTTP: !options.h2.secure}
+	client, err := transport.NewClientConn(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	reader, writer := io.Pipe()
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Host: options.h2.target},
+		Host:   options.h2.target,
+		Header: make(http.Header),
+		Body:   reader,
+	}
+	if options.h2.auth != nil {
+		password, _ := options.h2.auth.Password()
+		credentials := options.h2.auth.Username() + ":" + password
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	}
+	res, err := client.RoundTrip(req.WithContext(h.tcp.links.core.ctx))
+	if err != nil {
+		writer.Close()
+		conn.Close()
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		writer.Close()
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused to tunnel to %s: %s", options.h2.proxy, options.h2.target, res.Status)
+	}
+	_ = raw.SetDeadline(time.Time{})
+	return &h2Conn{
+		raw:    raw,
+		body:   res.Body,
+		writer: writer,
+		remote: h2Addr(saddr),
+	}, nil
+}
+
+// h2Addr is the address of a node reached through an HTTP/2 proxy, giving both
+// the proxy and the target, as a tunnelled stream has no address of its own.
+type h2Addr string
+
+func (a h2Addr) Network() string { return "h2" }
+func (a h2Addr) String() string  { return string(a) }
+
+// h2Conn is a tunnel through an HTTP/2 proxy, which is the body of a CONNECT
+// request in one direction and of the response in the other. Deadlines are set
+// on the connection to the proxy, which carries nothing but this tunnel.
+type h2Conn struct {
+	raw    net.Conn
+	body   io.ReadCloser
+	writer *io.PipeWriter
+	remote h2Addr
+}
+
+func (c *h2Conn) Read(b []byte) (int, error) {
+	return c.body.Read(b)
+}
+
+func (c *h2Conn) Write(b []byte) (int, error) {
+	return c.writer.Write(b)
+}
+
+func (c *h2Conn) Close() error {
+	c.writer.Close()
+	c.body.Close()
+	return c.raw.Close()
+}
+
+func (c *h2Conn) LocalAddr() net.Addr {
+	return c.raw.LocalAddr()
+}
+
+func (c *h2Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *h2Conn) SetDeadline(t time.Time) error {
+	return c.raw.SetDeadline(t)
+}
+
+func (c *h2Conn) SetReadDeadline(t time.Time) error {
+	return c.raw.SetReadDeadline(t)
+}
+
+func (c *h2Conn) SetWriteDeadline(t time.Time) error {
+	return c.raw.SetWriteDeadline(t)
+}
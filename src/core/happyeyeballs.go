@@ -0,0 +1,170 @@
+package core
+
+// This file contains the resolution and dialling of TCP peers that are given
+// by hostname. The name is looked up again on every call, so that peers
+// behind dynamic DNS are found at their new address as soon as it changes,
+// and all of the addresses that it has are tried using Happy Eyeballs (RFC
+// 8305), so that a peer with a broken IPv6 or IPv4 address is still reached
+// quickly over the other. The IPv6 and IPv4 addresses are looked up at the
+// same time, and a resolver that is slow to answer for IPv6 only holds up
+// dialling for the Resolution Delay.
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	happyEyeballsDelay           = 250 * time.Millisecond // The Connection Attempt Delay of RFC 8305
+	happyEyeballsResolutionDelay = 50 * time.Millisecond  // The Resolution Delay of RFC 8305
+)
+
+// resolveTCPAddrs looks up all of the addresses of the host in saddr, with
+// IPv6 and IPv4 addresses interleaved, IPv6 first. Nothing is cached, so each
+// call sees the current DNS records. If the IPv4 addresses are found first,
+// the IPv6 ones are only waited for until the Resolution Delay, and are left
+// out if they aren't found by then.
+func resolveTCPAddrs(ctx context.Context, saddr string) ([]*net.TCPAddr, error) {
+	host, service, err := net.SplitHostPort(saddr)
+	if err != nil {
+		return nil, err
+	}
+	if i := strings.LastIndex(host, "%"); net.ParseIP(host) != nil || (i >= 0 && net.ParseIP(host[:i]) != nil) {
+		// A literal address, perhaps with a zone
+		addr, err := net.ResolveTCPAddr("tcp", saddr)
+		if err != nil {
+			return nil, err
+		}
+		return []*net.TCPAddr{addr}, nil
+	}
+	port, err := net.DefaultResolver.LookupPort(ctx, "tcp", service)
+	if err != nil {
+		return nil, err
+	}
+	type lookup struct {
+		ips []net.IP
+		err error
+	}
+	resolve := func(network string) chan lookup {
+		answers := make(chan lookup, 1)
+		go func() {
+			ips, err := net.DefaultResolver.LookupIP(ctx, network, host)
+			answers <- lookup{ips, err}
+		}()
+		return answers
+	}
+	answers6, answers4 := resolve("ip6"), resolve("ip4")
+	var r6, r4 lookup
+	select {
+	case r6 = <-answers6:
+		r4 = <-answers4
+	case r4 = <-answers4:
+		if r4.err != nil || len(r4.ips) == 0 {
+			r6 = <-answers6
+			break
+		}
+		select {
+		case r6 = <-answers6:
+		case <-time.After(happyEyeballsResolutionDelay):
+		}
+	}
+	if r6.err != nil && r4.err != nil {
+		return nil, r4.err
+	}
+	var v6, v4 []*net.TCPAddr
+	for _, ip := range r6.ips {
+		v6 = append(v6, &net.TCPAddr{IP: ip, Port: port})
+	}
+	for _, ip := range r4.ips {
+		v4 = append(v4, &net.TCPAddr{IP: ip, Port: port})
+	}
+	addrs := make([]*net.TCPAddr, 0, len(v6)+len(v4))
+	for i := 0; i < len(v6) || i < len(v4); i++ {
+		if i < len(v6) {
+			addrs = append(addrs, v6[i])
+		}
+		if i < len(v4) {
+			addrs = append(addrs, v4[i])
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("no addresses found for " + host)
+	}
+	return addrs, nil
+}
+
+// dialHappyEyeballs dials each address in turn, starting the next attempt if
+// the last one hasn't connected within the Connection Attempt Delay or as soon
+// as it fails, and returns the first connection that succeeds. Addresses of
+// the other family to the dialer's LocalAddr, if it has one, are skipped.
+func (t *tcp) dialHappyEyeballs(ctx context.Context, dialer *net.Dialer, dsts []*net.TCPAddr) (net.Conn, error) {
+	if local, ok := dialer.LocalAddr.(*net.TCPAddr); ok && local != nil {
+		var same []*net.TCPAddr
+		for _, dst := range dsts {
+			if (dst.IP.To4() != nil) == (local.IP.To4() != nil) {
+				same = append(same, dst)
+			}
+		}
+		if len(same) == 0 {
+			return nil, errors.New("no addresses of the same family as " + local.String())
+		}
+		dsts = same
+	}
+	if len(dsts) == 1 {
+		return t.dialTCP(ctx, dialer, dsts[0])
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, len(dsts))
+	next, pending := 0, 0
+	lost := func() {
+		// Close any connections that lose the race
+		for ; pending > 0; pending-- {
+			if r := <-results; r.conn != nil {
+				r.conn.Close()
+			}
+		}
+	}
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	var err error
+	for next < len(dsts) || pending > 0 {
+		select {
+		case <-timer.C:
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				go lost()
+				return r.conn, nil
+			}
+			err = r.err
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		case <-ctx.Done():
+			go lost()
+			return nil, ctx.Err()
+		}
+		if next < len(dsts) {
+			dst := dsts[next]
+			next++
+			pending++
+			go func() {
+				conn, err := t.dialTCP(ctx, dialer, dst)
+				results <- result{conn, err}
+			}()
+			timer.Reset(happyEyeballsDelay)
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,64 @@
+package core
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHappyEyeballs(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	c := &Core{config: GenerateConfig(), log: GetLoggerWithPrefix("", false)}
+	c.links.core = c
+	c.links.tcp.links = &c.links
+	// The first address refuses the connection, so the second is tried
+	// straight away rather than after the delay
+	dsts := []*net.TCPAddr{closed.Addr().(*net.TCPAddr), listener.Addr().(*net.TCPAddr)}
+	start := time.Now()
+	conn, err := c.links.tcp.dialHappyEyeballs(context.Background(), &net.Dialer{}, dsts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if conn.RemoteAddr().String() != listener.Addr().String() {
+		t.Fatal("connected to the wrong address:", conn.RemoteAddr())
+	}
+	if time.Since(start) >= happyEyeballsDelay {
+		t.Fatal("second address waited for the delay")
+	}
+	if _, err := c.links.tcp.dialHappyEyeballs(context.Background(), &net.Dialer{}, dsts[:1]); err == nil {
+		t.Fatal("connected to a closed port")
+	}
+	addrs, err := resolveTCPAddrs(context.Background(), "[fe80::1%lo]:80")
+	if err != nil || len(addrs) != 1 || addrs[0].Zone != "lo" {
+		t.Fatal("literal address was not kept as it is:", addrs, err)
+	}
+	addrs, err = resolveTCPAddrs(context.Background(), "localhost:80")
+	if err != nil || len(addrs) == 0 {
+		t.Fatal("failed to resolve localhost:", err)
+	}
+	for _, addr := range addrs[1:] {
+		if addr.IP.To4() == nil && addrs[0].IP.To4() != nil {
+			t.Fatal("IPv6 addresses do not come first:", addrs)
+		}
+	}
+}
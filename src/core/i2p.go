@@ -0,0 +1,301 @@
+package core
+
+// This file contains the I2P transport, which works through the SAM API of a
+// local I2P router, see https://geti2p.net/en/docs/api/samv3. Listeners create
+// a streaming session with a destination that can be kept in a key file, so
+// that its address stays the same over restarts, and then accept streams on
+// it. Each outbound link gets its own transient destination, so dialling a
+// peer doesn't reveal which node is calling, beyond its key.
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultI2PSAM = "127.0.0.1:7656"
+	i2pTimeout    = 2 * time.Minute // Tunnels can take a while to build
+)
+
+var i2pBase64 = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-~")
+
+// tcpi2p hangs the I2P transport off of the TCP one, in the same way as the
+// Tor transport, as SAM streams are just TCP connections to the router.
+type tcpi2p struct {
+	tcp     *tcp
+	upgrade *TcpUpgrade
+}
+
+func (p *tcpi2p) init(tcp *tcp) {
+	p.tcp = tcp
+	p.upgrade = &TcpUpgrade{
+		upgrade: func(c net.Conn, _ *tcpOptions) (net.Conn, error) { return c, nil },
+		name:    "i2p",
+		dial:    p.dial,
+	}
+}
+
+// i2pAddr is the .b32.i2p address of an I2P destination.
+type i2pAddr string
+
+func (a i2pAddr) Network() string { return "i2p" }
+func (a i2pAddr) String() string  { return string(a) }
+
+// b32Address returns the .b32.i2p address of a base 64 destination.
+func b32Address(dest string) (i2pAddr, error) {
+	raw, err := i2pBase64.DecodeString(dest)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(raw)
+	b32 := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(hash[:])
+	return i2pAddr(strings.ToLower(b32) + ".b32.i2p"), nil
+}
+
+// samConn is a connection to the SAM bridge, which carries commands until it
+// becomes a stream.
+type samConn struct {
+	net.Conn
+	reader *bufio.Reader
+	remote i2pAddr
+	closer func() // Closes anything else that the stream depends on
+}
+
+func dialSAM(ctx context.Context, addr string) (*samConn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &samConn{Conn: conn, reader: bufio.NewReader(conn)}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if _, err := c.command("HELLO VERSION MIN=3.1 MAX=3.3"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// command sends a command and returns the values from its reply, or an error
+// if the result wasn't OK.
+func (c *samConn) command(cmd string) (map[string]string, error) {
+	if _, err := fmt.Fprintf(c.Conn, "%s\n", cmd); err != nil {
+		return nil, err
+	}
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	values := replyValues(strings.TrimRight(line, "\r\n"))
+	if result := values["RESULT"]; result != "OK" {
+		if message := values["MESSAGE"]; message != "" {
+			return nil, fmt.Errorf("%s: %s", result, message)
+		}
+		return nil, fmt.Errorf("SAM bridge replied %q", strings.TrimSpace(line))
+	}
+	return values, nil
+}
+
+func (c *samConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *samConn) RemoteAddr() net.Addr {
+	if c.remote != "" {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *samConn) Close() error {
+	if c.closer != nil {
+		c.closer()
+	}
+	return c.Conn.Close()
+}
+
+// createSession creates a streaming session with the given private destination,
+// or a transient one if it is empty. The session lasts for as long as the
+// returned connection stays open.
+func createSession(ctx context.Context, sam, dest string) (*samConn, string, string, error) {
+	control, err := dialSAM(ctx, sam)
+	if err != nil {
+		return nil, "", "", err
+	}
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		control.Close()
+		return nil, "", "", err
+	}
+	session := "yggdrasil-" + hex.EncodeToString(id[:])
+	if dest == "" {
+		dest = "TRANSIENT SIGNATURE_TYPE=7" // Ed25519
+	}
+	values, err := control.command(fmt.Sprintf("SESSION CREATE STYLE=STREAM ID=%s DESTINATION=%s", session, dest))
+	if err != nil {
+		control.Close()
+		return nil, "", "", fmt.Errorf("failed to create I2P session: %w", err)
+	}
+	_ = control.SetDeadline(time.Time{})
+	return control, session, values["DESTINATION"], nil
+}
+
+func (p *tcpi2p) dial(ctx context.Context, saddr string, options *tcpOptions) (net.Conn, error) {
+	ctx, done := context.WithTimeout(ctx, i2pTimeout)
+	defer done()
+	control, session, _, err := createSession(ctx, options.samAddr, "")
+	if err != nil {
+		return nil, err
+	}
+	stream, err := dialSAM(ctx, options.samAddr)
+	if err != nil {
+		control.Close()
+		return nil, err
+	}
+	stream.closer = func() { control.Close() }
+	host, _, err := net.SplitHostPort(saddr)
+	if err != nil {
+		host = saddr
+	}
+	values, err := stream.command("NAMING LOOKUP NAME=" + host)
+	if err == nil {
+		_, err = stream.command(fmt.Sprintf("STREAM CONNECT ID=%s DESTINATION=%s SILENT=false", session, values["VALUE"]))
+	}
+	if err != nil {
+		stream.Close()
+		return nil, fmt.Errorf("failed to connect to %s: %w", host, err)
+	}
+	_ = stream.SetDeadline(time.Time{})
+	stream.remote = i2pAddr(host)
+	return stream, nil
+}
+
+// i2pListener accepts streams on a session, which is closed along with the
+// listener.
+type i2pListener struct {
+	sam     string
+	session string
+	control *samConn
+	local   i2pAddr
+	mutex   sync.Mutex // protects the below
+	pending *samConn   // The connection waiting for a stream, if any
+	closed  bool
+}
+
+func (l *i2pListener) Accept() (net.Conn, error) {
+	ctx, done := context.WithTimeout(context.Background(), default_timeout)
+	stream, err := dialSAM(ctx, l.sam)
+	done()
+	if err == nil {
+		_, err = stream.command(fmt.Sprintf("STREAM ACCEPT ID=%s SILENT=false", l.session))
+		if err != nil {
+			stream.Conn.Close()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	_ = stream.SetDeadline(time.Time{})
+	l.mutex.Lock()
+	if l.closed {
+		l.mutex.Unlock()
+		stream.Conn.Close()
+		return nil, net.ErrClosed
+	}
+	l.pending = stream
+	l.mutex.Unlock()
+	// The bridge sends the destination of the caller once there is one
+	line, err := stream.reader.ReadString('\n')
+	l.mutex.Lock()
+	l.pending = nil
+	l.mutex.Unlock()
+	if err != nil {
+		stream.Conn.Close()
+		return nil, err
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		stream.Conn.Close()
+		return nil, errors.New("SAM bridge did not send the destination of the caller")
+	}
+	if stream.remote, err = b32Address(fields[0]); err != nil {
+		stream.Conn.Close()
+		return nil, err
+	}
+	return stream, nil
+}
+
+func (l *i2pListener) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.closed = true
+	if l.pending != nil {
+		l.pending.Conn.Close()
+	}
+	return l.control.Close()
+}
+
+func (l *i2pListener) Addr() net.Addr {
+	return l.local
+}
+
+// listen creates a session on the SAM bridge at the host of the URI and accepts
+// streams on it. The keyfile option names a file to keep the private key of
+// the destination in, which is created if it doesn't exist yet, and otherwise
+// the destination is transient.
+func (p *tcpi2p) listen(u *url.URL) (*TcpListener, error) {
+	keyfile := u.Query().Get("keyfile")
+	var dest string
+	if keyfile != "" {
+		key, err := ioutil.ReadFile(keyfile)
+		switch {
+		case err == nil:
+			dest = strings.TrimSpace(string(key))
+		case !os.IsNotExist(err):
+			return nil, fmt.Errorf("failed to read I2P key file: %w", err)
+		}
+	}
+	ctx, done := context.WithTimeout(p.tcp.links.core.ctx, i2pTimeout)
+	defer done()
+	control, session, priv, err := createSession(ctx, u.Host, dest)
+	if err != nil {
+		return nil, err
+	}
+	if keyfile != "" && dest == "" {
+		if err := ioutil.WriteFile(keyfile, []byte(priv+"\n"), 0600); err != nil {
+			control.Close()
+			return nil, fmt.Errorf("failed to write I2P key file: %w", err)
+		}
+	}
+	values, err := control.command("NAMING LOOKUP NAME=ME")
+	var local i2pAddr
+	if err == nil {
+		local, err = b32Address(values["VALUE"])
+	}
+	if err != nil {
+		control.Close()
+		return nil, fmt.Errorf("failed to look up I2P destination: %w", err)
+	}
+	l := TcpListener{
+		Listener: &i2pListener{sam: u.Host, session: session, control: control, local: local},
+		opts:     tcpOptions{upgrade: p.upgrade},
+		stop:     make(chan struct{}),
+	}
+	return &l, nil
+}
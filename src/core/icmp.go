@@ -0,0 +1,339 @@
+package core
+
+// This file contains the experimental ICMP transport, for networks that pass
+// nothing but ping. Links run over the session layer of the UDP transport,
+// with each datagram sent in the payload of an ICMP echo, after a 4 byte
+// marker. The caller sends echo requests and the listener answers them with
+// echo replies, using the identifier and sequence number of the last request
+// from the caller, so that stateful firewalls and NAT let the replies back.
+// The kernel of the listener answers the requests too, but those replies
+// carry the request marker and so the caller ignores them.
+//
+// Sockets send at a limited rate, given in packets per second by the rate
+// option of the peer or listener URI, as a steady flood of large pings is
+// more than some networks will put up with. Raw ICMP sockets need root, or
+// CAP_NET_RAW on Linux.
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	icmpDefaultRate = 100 // Packets per second
+	icmpBurst       = 16  // Packets that may be sent at once after a quiet spell
+	icmpPeerExpiry  = time.Minute
+)
+
+var (
+	icmpRequestMarker = []byte("yggq")
+	icmpReplyMarker   = []byte("yggr")
+)
+
+// tcpicmp hangs the ICMP transport off of the TCP one, in the same way as the
+// UDP transport.
+type tcpicmp struct {
+	tcp     *tcp
+	upgrade *TcpUpgrade
+}
+
+// icmpOptions are the options for an ICMP peer or listener, from its URI.
+type icmpOptions struct {
+	rate int
+}
+
+func (i *tcpicmp) init(tcp *tcp) {
+	i.tcp = tcp
+	i.upgrade = &TcpUpgrade{
+		upgrade: func(c net.Conn, _ *tcpOptions) (net.Conn, error) { return c, nil },
+		name:    "icmp",
+		dial:    i.dial,
+	}
+}
+
+// parseICMPOptions reads the options of an ICMP peer or listener URI.
+func parseICMPOptions(u *url.URL) (icmpOptions, error) {
+	options := icmpOptions{rate: icmpDefaultRate}
+	if u.Hostname() == "" || u.Port() != "" {
+		return options, fmt.Errorf("icmp URI %s must give a host without a port, e.g. icmp://a.b.c.d", u.String())
+	}
+	if rate := u.Query().Get("rate"); rate != "" {
+		r, err := strconv.Atoi(rate)
+		if err != nil || r <= 0 {
+			return options, fmt.Errorf("icmp URI %s has an invalid rate %q", u.String(), rate)
+		}
+		options.rate = r
+	}
+	return options, nil
+}
+
+// icmpSocket is a raw ICMP socket, for one address family, which paces what
+// it sends.
+type icmpSocket struct {
+	conn     *icmp.PacketConn
+	proto    int
+	request  icmp.Type
+	reply    icmp.Type
+	interval time.Duration
+	buf      []byte     // Only used by recv
+	mutex    sync.Mutex // protects the below
+	next     time.Time  // When the next packet may be sent
+}
+
+func openICMP(ip net.IP, rate int) (*icmpSocket, error) {
+	s := &icmpSocket{
+		interval: time.Second / time.Duration(rate),
+		buf:      make([]byte, 65535),
+	}
+	var err error
+	if ip.To4() != nil {
+		s.proto, s.request, s.reply = 1, ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+		s.conn, err = icmp.ListenPacket("ip4:icmp", ip.String())
+	} else {
+		s.proto, s.request, s.reply = 58, ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+		s.conn, err = icmp.ListenPacket("ip6:ipv6-icmp", ip.String())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// wait blocks until the next packet may be sent.
+func (s *icmpSocket) wait() {
+	s.mutex.Lock()
+	now := time.Now()
+	if earliest := now.Add(-icmpBurst * s.interval); s.next.Before(earliest) {
+		s.next = earliest
+	}
+	s.next = s.next.Add(s.interval)
+	delay := s.next.Sub(now)
+	s.mutex.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// send sends a datagram in an echo of the given type.
+func (s *icmpSocket) send(typ icmp.Type, id, seq int, b []byte, to net.Addr) error {
+	marker := icmpRequestMarker
+	if typ == s.reply {
+		marker = icmpReplyMarker
+	}
+	msg := icmp.Message{
+		Type: typ,
+		Body: &icmp.Echo{
+			ID:   id,
+			Seq:  seq,
+			Data: append(append(make([]byte, 0, len(marker)+len(b)), marker...), b...),
+		},
+	}
+	pkt, err := msg.Marshal(nil)
+	if err != nil {
+		return err
+	}
+	s.wait()
+	_, err = s.conn.WriteTo(pkt, to)
+	return err
+}
+
+// recv reads the next echo of the given type that carries a datagram, and
+// copies the datagram into b. It must only be called by one goroutine.
+func (s *icmpSocket) recv(typ icmp.Type, b []byte) (*icmp.Echo, net.Addr, error) {
+	marker := icmpRequestMarker
+	if typ == s.reply {
+		marker = icmpReplyMarker
+	}
+	for {
+		n, from, err := s.conn.ReadFrom(s.buf)
+		if err != nil {
+			return nil, nil, err
+		}
+		msg, err := icmp.ParseMessage(s.proto, s.buf[:n])
+		if err != nil || msg.Type != typ {
+			continue
+		}
+		echo, ok := msg.Body.(*icmp.Echo)
+		if !ok || !bytes.HasPrefix(echo.Data, marker) {
+			continue
+		}
+		echo.Data = b[:copy(b, echo.Data[len(marker):])]
+		return echo, from, nil
+	}
+}
+
+func (i *tcpicmp) dial(ctx context.Context, saddr string, options *tcpOptions) (net.Conn, error) {
+	raddr, err := net.ResolveIPAddr("ip", saddr)
+	if err != nil {
+		return nil, err
+	}
+	local := net.IPv4zero
+	if raddr.IP.To4() == nil {
+		local = net.IPv6unspecified
+	}
+	sock, err := openICMP(local, options.icmp.rate)
+	if err != nil {
+		return nil, err
+	}
+	var id [10]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		sock.conn.Close()
+		return nil, err
+	}
+	echoID := int(binary.BigEndian.Uint16(id[8:]))
+	var seqMutex sync.Mutex
+	var seq int
+	s := newUDPSession(i.tcp.links.core.clock, binary.BigEndian.Uint64(id[:]), sock.conn.LocalAddr(), raddr,
+		func(b []byte) error {
+			seqMutex.Lock()
+			seq = (seq + 1) & 0xffff
+			n := seq
+			seqMutex.Unlock()
+			return sock.send(sock.request, echoID, n, b, raddr)
+		},
+		func() { sock.conn.Close() },
+	)
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			echo, from, err := sock.recv(sock.reply, buf)
+			if err != nil {
+				s.shutdown(false)
+				return
+			}
+			if addr, ok := from.(*net.IPAddr); ok && addr.IP.Equal(raddr.IP) && echo.ID == echoID {
+				s.receive(echo.Data)
+			}
+		}
+	}()
+	ctx, done := context.WithTimeout(ctx, default_timeout)
+	defer done()
+	hello := s.header(udpHello)
+	for {
+		if err := s.send(hello); err != nil {
+			s.Close()
+			return nil, err
+		}
+		select {
+		case <-s.established:
+			return s, nil
+		case <-s.closed:
+			return nil, errors.New("icmp session closed by listener")
+		case <-ctx.Done():
+			s.shutdown(false)
+			return nil, ctx.Err()
+		case <-time.After(udpHelloInterval):
+		}
+	}
+}
+
+func (i *tcpicmp) listen(u *url.URL) (*TcpListener, error) {
+	options, err := parseICMPOptions(u)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := net.ResolveIPAddr("ip", u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	sock, err := openICMP(addr.IP, options.rate)
+	if err != nil {
+		return nil, err
+	}
+	ul := &udpListener{
+		clock:    i.tcp.links.core.clock,
+		sock:     &icmpListenConn{icmpSocket: sock, callers: make(map[string]icmpCaller)},
+		accept:   make(chan *udpSession, udpAcceptQueue),
+		sessions: make(map[udpSessionKey]*udpSession),
+		done:     make(chan struct{}),
+	}
+	go ul.run()
+	l := TcpListener{
+		Listener: ul,
+		opts:     tcpOptions{upgrade: i.upgrade},
+		stop:     make(chan struct{}),
+	}
+	return &l, nil
+}
+
+// icmpCaller is the last echo request from a caller, which the listener
+// answers with its replies.
+type icmpCaller struct {
+	id, seq int
+	seen    time.Time
+}
+
+// icmpListenConn is the packet socket of an ICMP listener, which reads the
+// datagrams from echo requests and sends them back in echo replies.
+type icmpListenConn struct {
+	*icmpSocket
+	callerMutex sync.Mutex // protects the below
+	callers     map[string]icmpCaller
+	pruned      time.Time
+}
+
+func (c *icmpListenConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	echo, from, err := c.recv(c.request, b)
+	if err != nil {
+		return 0, nil, err
+	}
+	now := time.Now()
+	c.callerMutex.Lock()
+	c.callers[from.String()] = icmpCaller{echo.ID, echo.Seq, now}
+	if now.Sub(c.pruned) > icmpPeerExpiry {
+		for addr, caller := range c.callers {
+			if now.Sub(caller.seen) > icmpPeerExpiry {
+				delete(c.callers, addr)
+			}
+		}
+		c.pruned = now
+	}
+	c.callerMutex.Unlock()
+	return len(echo.Data), from, nil
+}
+
+func (c *icmpListenConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.callerMutex.Lock()
+	caller, ok := c.callers[addr.String()]
+	c.callerMutex.Unlock()
+	if !ok {
+		return 0, fmt.Errorf("no echo request from %s to reply to", addr)
+	}
+	if err := c.send(c.reply, caller.id, caller.seq, b, addr); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *icmpListenConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *icmpListenConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *icmpListenConn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+func (c *icmpListenConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *icmpListenConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
@@ -0,0 +1,157 @@
+package core
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// An exported identity is made up of a version byte, a 16 byte salt, a 24 byte
+// nonce, the 32 byte public key and finally the sealed private key seed. The seed is sealed with XChaCha20-Poly1305 under a key derived from the
+// password with scrypt, and the public key is used as additional data so
+// that a blob cannot be tampered with to produce a different identity.
+const (
+	identityVersion   = 1
+	identitySaltSize  = 16
+	identityPublicLen = ed25519.PublicKeySize
+	identityScryptN   = 1 << 15
+	identityScryptR   = 8
+	identityScryptP   = 1
+	probeKeyInterval  = time.Second
+)
+
+// ExportIdentity returns the private key of this node, encrypted with the
+// given password, suitable for passing to ImportIdentity on another machine.
+func (c *Core) ExportIdentity(password string) ([]byte, error) {
+	if password == "" {
+		return nil, errors.New("a password is required")
+	}
+	salt := make([]byte, identitySaltSize)
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	aead, err := identityCipher(password, salt)
+	if err != nil {
+		return nil, err
+	}
+	out := []byte{identityVersion}
+	out = append(out, salt...)
+	out = append(out, nonce...)
+	out = append(out, c.public...)
+	return aead.Seal(out, nonce, c.secret.Seed(), c.public), nil
+}
+
+// ImportIdentity decrypts an identity that was produced by ExportIdentity and
+// returns the private key, which can then be used as the PrivateKey of a new
+// node's configuration.
+func ImportIdentity(identity []byte, password string) (ed25519.PrivateKey, error) {
+	header := 1 + identitySaltSize + chacha20poly1305.NonceSizeX + identityPublicLen
+	if len(identity) < header {
+		return nil, errors.New("identity is too short")
+	}
+	if identity[0] != identityVersion {
+		return nil, errors.New("unsupported identity version")
+	}
+	salt := identity[1 : 1+identitySaltSize]
+	nonce := identity[1+identitySaltSize : 1+identitySaltSize+chacha20poly1305.NonceSizeX]
+	public := identity[header-identityPublicLen : header]
+	aead, err := identityCipher(password, salt)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := aead.Open(nil, nonce, identity[header:], public)
+	if err != nil {
+		return nil, errors.New("incorrect password or corrupt identity")
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, errors.New("identity is corrupt")
+	}
+	secret := ed25519.NewKeyFromSeed(seed)
+	if !bytes.Equal(secret.Public().(ed25519.PublicKey), public) {
+		return nil, errors.New("identity is corrupt")
+	}
+	return secret, nil
+}
+
+func identityCipher(password string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(password), salt, identityScryptN, identityScryptR, identityScryptP, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.NewX(key)
+}
+
+// IsKeyLive reports whether a node with the given key appears to be running
+// somewhere on the network. It first checks our own peers, DHT, paths and
+// sessions, and then sends a probe to the key, waiting up to the timeout for
+// a reply. It is used to avoid running two nodes with the same identity. A
+// node that has only just joined the network may not be able to reply yet, so
+// this can't prove that the key is unused.
+func (c *Core) IsKeyLive(key ed25519.PublicKey, timeout time.Duration) bool {
+	if bytes.Equal(key, c.public) {
+		return true
+	}
+	for _, p := range c.GetPeers() {
+		if bytes.Equal(key, p.Key) {
+			return true
+		}
+	}
+	for _, d := range c.GetDHT() {
+		if bytes.Equal(key, d.Key) {
+			return true
+		}
+	}
+	for _, p := range c.GetPaths() {
+		if bytes.Equal(key, p.Key) {
+			return true
+		}
+	}
+	for _, s := range c.GetSessions() {
+		if bytes.Equal(key, s.Key) {
+			return true
+		}
+	}
+	return c.probeKey(key, timeout)
+}
+
+// probeKey sends getSelf requests to the key, which ironwood has to look up,
+// and reports whether anything replied within the timeout. The first requests
+// are often dropped while ironwood is still looking for a path, so they are
+// sent again every probeKeyInterval.
+func (c *Core) probeKey(key ed25519.PublicKey, timeout time.Duration) bool {
+	var k keyArray
+	copy(k[:], key)
+	ch := make(chan struct{}, 1)
+	send := func() {
+		c.proto.sendGetSelfRequest(k, func(_ []byte) {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		})
+	}
+	send()
+	ticker := time.NewTicker(probeKeyInterval)
+	defer ticker.Stop()
+	deadline := time.After(timeout)
+	for {
+		select {
+		case <-ch:
+			return true
+		case <-ticker.C:
+			send()
+		case <-deadline:
+			return false
+		}
+	}
+}
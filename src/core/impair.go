@@ -0,0 +1,164 @@
+package core
+
+// This file contains the link impairment emulation, which works much like
+// netem but on a single link, so that peerings over slow or lossy links can
+// be reproduced during development without any external tooling.
+
+import (
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const impairQueueSize = 1024 // Frames that may be in flight on an impaired link
+
+// Impairment describes the conditions to emulate on the frames sent on a
+// link. A zero Impairment doesn't change anything.
+type Impairment struct {
+	Latency   time.Duration // Added to the delivery of every frame
+	Jitter    time.Duration // Up to this much more is added at random
+	Bandwidth uint64        // Bytes per second, or 0 for no limit
+	Loss      float64       // Probability, from 0 to 1, that a frame is lost
+}
+
+func (imp *Impairment) enabled() bool {
+	return imp.Latency > 0 || imp.Jitter > 0 || imp.Bandwidth > 0 || imp.Loss > 0
+}
+
+type impairedFrame struct {
+	due  time.Time
+	data []byte
+}
+
+// linkImpairer sits between a linkConn and the underlying connection. Frames
+// are written to the connection by a separate goroutine once they are due,
+// so that latency doesn't also reduce throughput, while writes block for as
+// long as the frame would take to send at the emulated bandwidth.
+type linkImpairer struct {
+	mutex      sync.Mutex // protects the below
+	impairment Impairment
+	rand       *rand.Rand
+	busy       time.Time // When the emulated link finishes sending
+	err        error     // The error from the last delayed write, if any
+	queue      chan impairedFrame
+	closed     <-chan struct{}
+}
+
+func newLinkImpairer(imp Impairment, conn *linkConn, closed <-chan struct{}) *linkImpairer {
+	li := &linkImpairer{
+		impairment: imp,
+		rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		queue:      make(chan impairedFrame, impairQueueSize),
+		closed:     closed,
+	}
+	go li.run(conn)
+	return li
+}
+
+func (li *linkImpairer) set(imp Impairment) {
+	li.mutex.Lock()
+	defer li.mutex.Unlock()
+	li.impairment = imp
+}
+
+func (li *linkImpairer) write(p []byte) (int, error) {
+	li.mutex.Lock()
+	if err := li.err; err != nil {
+		li.mutex.Unlock()
+		return 0, err
+	}
+	imp := li.impairment
+	now := time.Now()
+	if li.busy.Before(now) {
+		li.busy = now
+	}
+	start := li.busy
+	if imp.Bandwidth > 0 {
+		li.busy = li.busy.Add(time.Duration(len(p)) * time.Second / time.Duration(imp.Bandwidth))
+	}
+	due := li.busy.Add(imp.Latency)
+	if imp.Jitter > 0 {
+		due = due.Add(time.Duration(li.rand.Int63n(int64(imp.Jitter))))
+	}
+	lost := imp.Loss > 0 && li.rand.Float64() < imp.Loss
+	li.mutex.Unlock()
+	// Wait until the emulated link is free, to apply backpressure
+	time.Sleep(time.Until(start))
+	if !lost {
+		select {
+		case li.queue <- impairedFrame{due, append([]byte(nil), p...)}:
+		case <-li.closed:
+			return 0, io.ErrClosedPipe
+		}
+	}
+	return len(p), nil
+}
+
+// run writes the queued frames to the connection as they become due, until
+// the link is closed.
+func (li *linkImpairer) run(conn *linkConn) {
+	for {
+		select {
+		case <-li.closed:
+			return
+		case frame := <-li.queue:
+			if wait := time.Until(frame.due); wait > 0 {
+				select {
+				case <-li.closed:
+					return
+				case <-time.After(wait):
+				}
+			}
+			if _, err := conn.Conn.Write(frame.data); err != nil {
+				li.mutex.Lock()
+				li.err = err
+				li.mutex.Unlock()
+				return
+			}
+		}
+	}
+}
+
+// SetImpairment emulates the given latency, bandwidth and loss on the frames
+// sent on links with the given name, which is the Remote shown by GetPeers,
+// including any that connect later. Setting a zero Impairment removes it,
+// although frames already in flight are still delivered late.
+func (c *Core) SetImpairment(name string, imp Impairment) {
+	c.links.mutex.Lock()
+	defer c.links.mutex.Unlock()
+	if c.links.impairments == nil {
+		c.links.impairments = make(map[string]Impairment)
+	}
+	if imp.enabled() {
+		c.links.impairments[name] = imp
+	} else {
+		delete(c.links.impairments, name)
+	}
+	for _, intf := range c.links.links {
+		if intf.lname == name {
+			intf.impair(imp)
+		}
+	}
+}
+
+// GetImpairments returns the impairments that have been set, by link name.
+func (c *Core) GetImpairments() map[string]Impairment {
+	c.links.mutex.RLock()
+	defer c.links.mutex.RUnlock()
+	imps := make(map[string]Impairment, len(c.links.impairments))
+	for name, imp := range c.links.impairments {
+		imps[name] = imp
+	}
+	return imps
+}
+
+// impair applies the impairment to the link, adding an impairer if it doesn't
+// already have one. The links mutex must be held.
+func (intf *link) impair(imp Impairment) {
+	if li, _ := intf.conn.impairer.Load().(*linkImpairer); li != nil {
+		li.set(imp)
+	} else if imp.enabled() {
+		intf.conn.impairer.Store(newLinkImpairer(imp, intf.conn, intf.closed))
+	}
+}
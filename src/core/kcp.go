@@ -0,0 +1,190 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+
+	"github.com/xtaci/kcp-go/v5"
+)
+
+// tcpkcp hangs the KCP transport off of the TCP one, in the same way as the
+// UDP transport. KCP gives a reliable stream over UDP which recovers from loss
+// much faster than TCP does, at the cost of some extra bandwidth, so it suits
+// satellite and mobile links. The windows and forward error correction can be
+// tuned with the options in the URI, and the FEC options must match on both
+// sides of the link. Note that closing a KCP listener drops the links that it
+// accepted, as they share its socket.
+type tcpkcp struct {
+	tcp       *tcp
+	forDialer *TcpUpgrade
+}
+
+// kcpOptions are the tuning options for a KCP link.
+type kcpOptions struct {
+	sndWnd       int // Packets that may be in flight
+	rcvWnd       int // Packets that may be buffered for reading
+	dataShards   int // FEC data shards, or 0 to disable FEC
+	parityShards int // FEC parity shards
+	mtu          int
+}
+
+// The defaults have windows large enough to fill a link with a high
+// bandwidth-delay product, such as a satellite link, and enough FEC to ride
+// out light loss without any retransmissions.
+var defaultKCPOptions = kcpOptions{
+	sndWnd:       1024,
+	rcvWnd:       1024,
+	dataShards:   10,
+	parityShards: 3,
+	mtu:          1350,
+}
+
+// parseKCPOptions reads the options for a KCP link from the query string of
+// its URI, using the defaults for any that aren't given.
+func parseKCPOptions(u *url.URL) (kcpOptions, error) {
+	options := defaultKCPOptions
+	for name, opt := range map[string]*int{
+		"sndwnd":       &options.sndWnd,
+		"rcvwnd":       &options.rcvWnd,
+		"datashards":   &options.dataShards,
+		"parityshards": &options.parityShards,
+		"mtu":          &options.mtu,
+	} {
+		if v := u.Query().Get(name); v != "" {
+			n, err := strconv.ParseUint(v, 10, 16)
+			if err != nil {
+				return options, fmt.Errorf("%s has invalid %s: %w", u.String(), name, err)
+			}
+			*opt = int(n)
+		}
+	}
+	if options.sndWnd == 0 || options.rcvWnd == 0 {
+		return options, fmt.Errorf("%s must have non-zero windows", u.String())
+	}
+	if (options.dataShards == 0) != (options.parityShards == 0) {
+		return options, fmt.Errorf("%s must have both data and parity shards, or neither", u.String())
+	}
+	if options.mtu < 100 || options.mtu > 1500 {
+		return options, fmt.Errorf("%s has an mtu outside of 100 to 1500", u.String())
+	}
+	return options, nil
+}
+
+func (k *tcpkcp) init(tcp *tcp) {
+	k.tcp = tcp
+	k.forDialer = &TcpUpgrade{
+		upgrade: func(c net.Conn, _ *tcpOptions) (net.Conn, error) { return c, nil },
+		name:    "kcp",
+		dial:    k.dial,
+	}
+}
+
+// tune applies the options to a session. Links carry a stream of frames, so
+// stream mode lets KCP pack small frames together.
+func (o *kcpOptions) tune(s *kcp.UDPSession) {
+	s.SetStreamMode(true)
+	s.SetWriteDelay(false)
+	s.SetWindowSize(o.sndWnd, o.rcvWnd)
+	s.SetNoDelay(1, 20, 2, 0) // Fast retransmission, with congestion control
+	s.SetMtu(o.mtu)
+}
+
+// kcpSocket returns a UDP socket for KCP to use, with the firewall mark set,
+// as KCP only opens sockets itself without one. The socket is bound to addr,
+// or to any address if it is nil.
+func (k *tcpkcp) kcpSocket(addr string) (*net.UDPConn, error) {
+	laddr := &net.UDPAddr{}
+	if addr != "" {
+		var err error
+		if laddr, err = net.ResolveUDPAddr("udp", addr); err != nil {
+			return nil, err
+		}
+	}
+	return k.tcp.listenUDP(laddr)
+}
+
+// kcpMarkedListener closes the socket that it is served from, which KCP leaves
+// open as the socket wasn't opened by KCP.
+type kcpMarkedListener struct {
+	*kcp.Listener
+	sock net.PacketConn
+}
+
+func (l *kcpMarkedListener) Close() error {
+	err := l.Listener.Close()
+	l.sock.Close()
+	return err
+}
+
+// kcpMarkedSession closes its socket in the same way.
+type kcpMarkedSession struct {
+	*kcp.UDPSession
+	sock net.PacketConn
+}
+
+func (s *kcpMarkedSession) Close() error {
+	err := s.UDPSession.Close()
+	s.sock.Close()
+	return err
+}
+
+func (k *tcpkcp) listen(listenaddr string, options kcpOptions) (*TcpListener, error) {
+	var listener net.Listener
+	if k.tcp.mark != 0 {
+		sock, err := k.kcpSocket(listenaddr)
+		if err != nil {
+			return nil, err
+		}
+		kl, err := kcp.ServeConn(nil, options.dataShards, options.parityShards, sock)
+		if err != nil {
+			sock.Close()
+			return nil, err
+		}
+		listener = &kcpMarkedListener{kl, sock}
+	} else {
+		kl, err := kcp.ListenWithOptions(listenaddr, nil, options.dataShards, options.parityShards)
+		if err != nil {
+			return nil, err
+		}
+		listener = kl
+	}
+	l := TcpListener{
+		Listener: listener,
+		opts: tcpOptions{upgrade: &TcpUpgrade{
+			upgrade: func(c net.Conn, _ *tcpOptions) (net.Conn, error) {
+				if s, ok := c.(*kcp.UDPSession); ok {
+					options.tune(s)
+				}
+				return c, nil
+			},
+			name: "kcp",
+		}},
+		stop: make(chan struct{}),
+	}
+	return &l, nil
+}
+
+func (k *tcpkcp) dial(_ context.Context, saddr string, options *tcpOptions) (net.Conn, error) {
+	if k.tcp.mark != 0 {
+		sock, err := k.kcpSocket("")
+		if err != nil {
+			return nil, err
+		}
+		s, err := kcp.NewConn(saddr, nil, options.kcp.dataShards, options.kcp.parityShards, sock)
+		if err != nil {
+			sock.Close()
+			return nil, err
+		}
+		options.kcp.tune(s)
+		return &kcpMarkedSession{s, sock}, nil
+	}
+	s, err := kcp.DialWithOptions(saddr, nil, options.kcp.dataShards, options.kcp.parityShards)
+	if err != nil {
+		return nil, err
+	}
+	options.kcp.tune(s)
+	return s, nil
+}
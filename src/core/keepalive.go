@@ -0,0 +1,65 @@
+package core
+
+// This file contains the link-level keepalives, which are for links through
+// NATs and firewalls that drop idle connections without telling either end.
+// A link with the keepalive option sends a dummy frame, which ironwood
+// ignores, whenever it has sent nothing else for the interval, and is closed
+// once nothing at all has been received from the remote node for a few
+// intervals, rather than waiting for the kernel to give up on the socket.
+
+import (
+	"errors"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+const keepaliveMisses = 3 // Intervals without receiving anything before the link is closed
+
+// parseKeepalive reads the keepalive option of a peer or listener URI, which
+// is a duration such as 5s, or 0 if the option isn't set.
+func parseKeepalive(u *url.URL) (time.Duration, error) {
+	s := u.Query().Get("keepalive")
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < 100*time.Millisecond {
+		return 0, errors.New("keepalive must be at least 100ms")
+	}
+	return d, nil
+}
+
+// monitorKeepalive sends keepalives on the link, and closes it once keepalives
+// or anything else stop arriving, until the link closes.
+func (intf *link) monitorKeepalive(interval time.Duration) {
+	c := intf.conn
+	now := c.clock.Now().UnixNano()
+	atomic.StoreInt64(&c.lastRecv, now)
+	atomic.StoreInt64(&c.lastSent, now)
+	ticker := c.clock.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-intf.closed:
+			return
+		case t := <-ticker.Chan():
+			now = t.UnixNano()
+		}
+		if time.Duration(now-atomic.LoadInt64(&c.lastRecv)) >= keepaliveMisses*interval {
+			intf.links.core.log.Warnf("Closing link %s as nothing has been received for %s",
+				intf.name(), keepaliveMisses*interval)
+			intf.close()
+			return
+		}
+		if time.Duration(now-atomic.LoadInt64(&c.lastSent)) >= interval {
+			// The same dummy frame that bundles use to keep their links up
+			if _, err := c.Write(bundleKeepalive); err != nil {
+				return
+			}
+		}
+	}
+}
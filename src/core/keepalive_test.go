@@ -0,0 +1,66 @@
+package core
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestLink_KeepaliveTimeout checks that a link with the keepalive option sends keepalives while idle
+// and is closed once nothing has been received for keepaliveMisses intervals, on the core's clock.
+func TestLink_KeepaliveTimeout(t *testing.T) {
+	clk := &fakeClock{now: time.Now()}
+	c := &Core{clock: clk, log: GetLoggerWithPrefix("", false)}
+	c.links.core = c
+	local, remote := net.Pipe()
+	defer remote.Close()
+	intf, err := c.links.create(local, "pipe", "pipe", "", "pipe", false, false, linkOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	intf.closed = make(chan struct{})
+	defer close(intf.closed)
+	received := make(chan int, 16)
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, err := remote.Read(buf)
+			if err != nil {
+				close(received)
+				return
+			}
+			received <- n
+		}
+	}()
+	const interval = time.Second
+	go intf.monitorKeepalive(interval)
+	for i := 1; i < keepaliveMisses; i++ {
+		clk.WaitForWaiters(t)
+		clk.Advance(interval)
+		select {
+		case _, ok := <-received:
+			if !ok {
+				t.Fatal("link closed after", i, "intervals")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no keepalive was sent")
+		}
+		// The keepalive is read before the write returns and records it
+		for atomic.LoadInt64(&intf.conn.lastSent) != clk.Now().UnixNano() {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	clk.WaitForWaiters(t)
+	clk.Advance(interval)
+	for {
+		select {
+		case _, ok := <-received:
+			if !ok {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("link was not closed")
+		}
+	}
+}
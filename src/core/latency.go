@@ -0,0 +1,126 @@
+package core
+
+// This file contains the latency probes and the part of the link penalty that
+// comes from latency. A link with the latencypenalty option sends a probe every
+// few seconds, as a dummy frame that ironwood ignores, and the remote node
+// echoes it back if its end of the link has the option too. The round trip
+// times are smoothed in the same way as TCP smooths them, and the penalty is
+// raised by the smoothed time, so that faster links have lower penalties. Like
+// the rest of the penalty, this doesn't affect routing, see penalty.go.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	latencyProbeInterval = 5 * time.Second
+	latencyPenaltyUnit   = time.Millisecond // The round trip time that raises the penalty by 1
+	latencyPenaltyMax    = 255              // Upper bound on the penalty that latency can add
+)
+
+// The probes are dummy frames carrying a time, which is sent back unchanged in
+// the reply.
+var (
+	latencyPing = []byte{0x00, 0x0d, 0x00, 'p', 'i', 'n', 'g'}
+	latencyPong = []byte{0x00, 0x0d, 0x00, 'p', 'o', 'n', 'g'}
+)
+
+// linkProbes is the probing state of a link, which is only touched by Read
+// apart from the channel of replies to send.
+type linkProbes struct {
+	pongs   chan []byte // Replies for monitorLatency to send
+	penalty *linkPenalty
+	clock   clock     // The clock of the core
+	epoch   time.Time // What the times in probes are measured from
+}
+
+// elapsed returns the time since the epoch, which is what probes carry, so
+// that they are measured with the monotonic clock.
+func (p *linkProbes) elapsed() time.Duration {
+	return p.clock.Now().Sub(p.epoch)
+}
+
+// handle answers or measures a probe, and returns false if the frame isn't
+// one.
+func (p *linkProbes) handle(frame []byte) bool {
+	switch {
+	case len(frame) != len(latencyPing)+8:
+		return false
+	case bytes.HasPrefix(frame, latencyPing):
+		pong := append(append([]byte(nil), latencyPong...), frame[len(latencyPing):]...)
+		select {
+		case p.pongs <- pong:
+		default: // Already replying to one, so the remote node sent too many
+		}
+		return true
+	case bytes.HasPrefix(frame, latencyPong):
+		sent := time.Duration(binary.BigEndian.Uint64(frame[len(latencyPong):]))
+		if rtt := p.elapsed() - sent; rtt >= 0 {
+			p.penalty.measured(rtt)
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// measured updates the smoothed round trip time with a new sample, weighted
+// by 1/8 as in RFC 6298.
+func (m *linkPenalty) measured(rtt time.Duration) {
+	m.rtts.add(rtt)
+	srtt := time.Duration(atomic.LoadInt64(&m.latency))
+	if srtt == 0 {
+		srtt = rtt
+	} else {
+		srtt += (rtt - srtt) / 8
+	}
+	if srtt <= 0 {
+		srtt = 1
+	}
+	atomic.StoreInt64(&m.latency, int64(srtt))
+}
+
+// latencyPenalty returns how much the smoothed round trip time adds to the
+// penalty.
+func (m *linkPenalty) latencyPenalty() uint64 {
+	penalty := uint64(atomic.LoadInt64(&m.latency) / int64(latencyPenaltyUnit))
+	if penalty > latencyPenaltyMax {
+		penalty = latencyPenaltyMax
+	}
+	return penalty
+}
+
+// monitorLatency sends probes on the link, and the replies to probes from the
+// remote node, until the link closes.
+func (intf *link) monitorLatency(probes *linkProbes) {
+	ticker := intf.links.core.clock.NewTicker(latencyProbeInterval)
+	defer ticker.Stop()
+	probe := make([]byte, len(latencyPing)+8)
+	copy(probe, latencyPing)
+	ping := func() error {
+		binary.BigEndian.PutUint64(probe[len(latencyPing):], uint64(probes.elapsed()))
+		_, err := intf.conn.Write(probe)
+		return err
+	}
+	// The first probe is sent straight away, so that the link has a penalty
+	if ping() != nil {
+		return
+	}
+	for {
+		select {
+		case <-intf.closed:
+			return
+		case pong := <-probes.pongs:
+			if _, err := intf.conn.Write(pong); err != nil {
+				return
+			}
+		case <-ticker.Chan():
+			if ping() != nil {
+				return
+			}
+		}
+	}
+}
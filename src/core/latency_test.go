@@ -0,0 +1,34 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCore_LatencyProbes(t *testing.T) {
+	cfgA, cfgB := GenerateConfig(), GenerateConfig()
+	cfgA.Listen = []string{"mem://latency?latencypenalty=true"}
+	cfgB.Peers = []string{"mem://latency?latencypenalty=true"}
+	nodeA, nodeB := new(Core), new(Core)
+	if err := nodeA.Start(cfgA, GetLoggerWithPrefix("A: ", false)); err != nil {
+		t.Fatal(err)
+	}
+	defer nodeA.Stop()
+	if err := nodeB.Start(cfgB, GetLoggerWithPrefix("B: ", false)); err != nil {
+		t.Fatal(err)
+	}
+	defer nodeB.Stop()
+	// Both ends send a probe as soon as the link is up
+	measured := func(node *Core) bool {
+		peers := node.GetPeers()
+		return len(peers) == 1 && peers[0].Latency > 0
+	}
+	for i := 0; i < 50 && !(measured(nodeA) && measured(nodeB)); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if !measured(nodeA) || !measured(nodeB) {
+		t.Fatal("latency was not measured", nodeA.GetPeers(), nodeB.GetPeers())
+	}
+	// The link still carries traffic with the probes taken out of it
+	CheckEcho(t, nodeA, nodeB)
+}
@@ -1,13 +1,16 @@
 package core
 
 import (
+	"bytes"
 	"crypto/ed25519"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -17,18 +20,40 @@ import (
 	"sync/atomic"
 
 	"github.com/yggdrasil-network/yggdrasil-go/src/address"
-	"github.com/yggdrasil-network/yggdrasil-go/src/util"
-	"golang.org/x/net/proxy"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	//"github.com/Arceliar/phony" // TODO? use instead of mutexes
 )
 
+const (
+	// linkHandshakeTimeout is how long each side of the metadata exchange may
+	// take before the link is abandoned.
+	linkHandshakeTimeout = 30 * time.Second
+	// linkMinReadTimeout is the shortest ReadTimeout, as ironwood only sends
+	// keepalives after four seconds of sending nothing else.
+	linkMinReadTimeout = 6 * time.Second
+)
+
 type links struct {
-	core    *Core
-	mutex   sync.RWMutex // protects links below
-	links   map[linkInfo]*link
-	tcp     tcp // TCP interface support
-	stopped chan struct{}
-	// TODO timeout (to remove from switch), read from config.ReadTimeout
+	core        *Core
+	mutex       sync.RWMutex // protects links, draining, chaos, impairments, obfuscators, bundles, callbacks, penaltyHook and logger below
+	links       map[linkInfo]*link
+	draining    bool
+	chaos       map[string]*linkChaos    // Faults to inject, by link name, see SetChaos
+	impairments map[string]Impairment    // Conditions to emulate, by link name
+	obfuscators map[string]Obfuscator    // Pluggable transports, by name, see SetObfuscator
+	bundles     map[keyArray]*linkBundle // Bundled links, by the key of the remote node
+	tcp         tcp                      // TCP interface support
+	stopped     chan struct{}
+	timeout     time.Duration // How long a link may receive nothing before it is closed, if not 0
+	wtimeout    time.Duration // How long each write may take before the link is closed, if not 0
+	history     []Link        // The links that have closed most recently, see stats.go
+	callbacks   peerCallbacks // For links coming up and going down, see events.go
+	dials       linkDials     // Outbound calls that are in progress, see dials.go
+	penaltyHook PenaltyFunc   // From SetPenaltyHook, see penaltyhook.go
+	handshakes  linkHandshakes
+	logger      LinkLogger   // From SetLinkLogger, see linklog.go
+	passwords   passwordKeys // Keys derived from passwords, see password.go
 }
 
 // linkInfo is used as a map key
@@ -37,21 +62,49 @@ type linkInfo struct {
 	linkType string // Type of link, e.g. TCP, AWDL
 	local    string // Local name or address
 	remote   string // Remote name or address
+	name     string // The name of a link that may be bundled, as it is a duplicate otherwise
 }
 
 type link struct {
-	lname    string
-	links    *links
-	conn     *linkConn
-	options  linkOptions
-	info     linkInfo
-	incoming bool
-	force    bool
-	closed   chan struct{}
+	lname     string
+	links     *links
+	conn      *linkConn
+	raw       net.Conn // The underlying socket, before any upgrade
+	penalty   linkPenalty
+	options   linkOptions
+	info      linkInfo
+	incoming  bool
+	force     bool
+	closed    chan struct{}
+	handshake time.Duration // How long the metadata exchange took
 }
 
+// ErrLinkAlreadyExists is returned for a link that duplicates one that is
+// already up, along with a channel that is closed once the existing link
+// closes, so that the caller can wait for it or close its own connection.
+var ErrLinkAlreadyExists = errors.New("link already exists")
+
 type linkOptions struct {
 	pinnedEd25519Keys map[keyArray]struct{}
+	tlsKey            ed25519.PublicKey // The key that the TLS certificate of the remote node proved, if any
+	penalty           uint8
+	lossPenalty       bool
+	latencyPenalty    bool
+	schedule          schedule
+	obfuscation       string        // The name of the obfuscator to wrap the link with, if any
+	bundle            string        // How to spread traffic over a bundle, if the link is bundled
+	maxBpsUp          uint64        // Limit on sending in bits per second, if not 0
+	maxBpsDown        uint64        // Limit on receiving in bits per second, if not 0
+	keepalive         time.Duration // How often to send keepalives, if not 0
+	compress          []string      // The compression algorithms to offer, if any
+	padding           []int         // The sizes to pad writes up to, if any
+	password          string        // The password that the remote node must know, if any
+	cover             float64       // Dummy frames to send each second, if not 0
+	socket            socketOptions // TCP socket options, where they differ from the TCPSocket config
+	peer              *peerState    // The retry state of the configured peer that was called, if any
+	handshakeDone     func()        // Frees the slot of an inbound connection, see throttle.go
+	dialDone          func()        // Ends the outbound call once the link is up, see dials.go
+	callSpan          trace.Span    // The span of the outbound call, see tracing.go
 }
 
 func (l *links) init(c *Core) error {
@@ -60,78 +113,213 @@ func (l *links) init(c *Core) error {
 	l.links = make(map[linkInfo]*link)
 	l.mutex.Unlock()
 	l.stopped = make(chan struct{})
+	c.config.RLock()
+	if c.config.ReadTimeout > 0 {
+		l.timeout = time.Duration(c.config.ReadTimeout) * time.Millisecond
+		if l.timeout < linkMinReadTimeout {
+			l.timeout = linkMinReadTimeout
+		}
+	}
+	l.wtimeout = time.Duration(c.config.WriteTimeout) * time.Millisecond
+	c.config.RUnlock()
 
 	if err := l.tcp.init(l); err != nil {
 		c.log.Errorln("Failed to start TCP interface")
 		return err
 	}
+	if l.timeout > 0 {
+		go l.closeIdle()
+	}
 
 	return nil
 }
 
-func (l *links) call(u *url.URL, sintf string) error {
-	//u, err := url.Parse(uri)
-	//if err != nil {
-	//	return fmt.Errorf("peer %s is not correctly formatted (%s)", uri, err)
-	//}
-	tcpOpts := tcpOptions{}
+// parseLinkOptions reads the options for an outbound link from the query
+// string of the peer URI.
+func parseLinkOptions(u *url.URL) (linkOptions, error) {
+	var options linkOptions
 	if pubkeys, ok := u.Query()["key"]; ok && len(pubkeys) > 0 {
-		tcpOpts.pinnedEd25519Keys = make(map[keyArray]struct{})
+		options.pinnedEd25519Keys = make(map[keyArray]struct{})
 		for _, pubkey := range pubkeys {
 			if sigPub, err := hex.DecodeString(pubkey); err == nil {
 				var sigPubKey keyArray
 				copy(sigPubKey[:], sigPub)
-				tcpOpts.pinnedEd25519Keys[sigPubKey] = struct{}{}
+				options.pinnedEd25519Keys[sigPubKey] = struct{}{}
 			}
 		}
 	}
-	switch u.Scheme {
-	case "tcp":
-		l.tcp.call(u.Host, tcpOpts, sintf)
-	case "socks":
-		tcpOpts.socksProxyAddr = u.Host
-		if u.User != nil {
-			tcpOpts.socksProxyAuth = &proxy.Auth{}
-			tcpOpts.socksProxyAuth.User = u.User.Username()
-			tcpOpts.socksProxyAuth.Password, _ = u.User.Password()
-		}
-		tcpOpts.upgrade = l.tcp.tls.forDialer // TODO make this configurable
-		pathtokens := strings.Split(strings.Trim(u.Path, "/"), "/")
-		l.tcp.call(pathtokens[0], tcpOpts, sintf)
-	case "tls":
-		tcpOpts.upgrade = l.tcp.tls.forDialer
-		// SNI headers must contain hostnames and not IP addresses, so we must make sure
-		// that we do not populate the SNI with an IP literal. We do this by splitting
-		// the host-port combo from the query option and then seeing if it parses to an
-		// IP address successfully or not.
-		if sni := u.Query().Get("sni"); sni != "" {
-			if net.ParseIP(sni) == nil {
-				tcpOpts.tlsSNI = sni
-			}
+	if penalty := u.Query().Get("penalty"); penalty != "" {
+		m, err := strconv.ParseUint(penalty, 10, 8)
+		if err != nil {
+			return options, fmt.Errorf("peer %s has invalid penalty: %w", u.String(), err)
 		}
-		// If the SNI is not configured still because the above failed then we'll try
-		// again but this time we'll use the host part of the peering URI instead.
-		if tcpOpts.tlsSNI == "" {
-			if host, _, err := net.SplitHostPort(u.Host); err == nil && net.ParseIP(host) == nil {
-				tcpOpts.tlsSNI = host
-			}
+		options.penalty = uint8(m)
+	}
+	if lossPenalty := u.Query().Get("losspenalty"); lossPenalty != "" {
+		options.lossPenalty, _ = strconv.ParseBool(lossPenalty)
+	}
+	if latencyPenalty := u.Query().Get("latencypenalty"); latencyPenalty != "" {
+		options.latencyPenalty, _ = strconv.ParseBool(latencyPenalty)
+	}
+	options.obfuscation = u.Query().Get("obfs")
+	options.bundle = u.Query().Get("bundle")
+	if err := parseBundleMode(options.bundle); err != nil {
+		return options, fmt.Errorf("peer %s has invalid bundle: %w", u.String(), err)
+	}
+	if sched := u.Query().Get("schedule"); sched != "" {
+		var err error
+		if options.schedule, err = parseSchedule(sched); err != nil {
+			return options, fmt.Errorf("peer %s has invalid schedule: %w", u.String(), err)
+		}
+	}
+	var err error
+	if options.maxBpsUp, options.maxBpsDown, err = parseRateOptions(u); err != nil {
+		return options, fmt.Errorf("peer %s has %w", u.String(), err)
+	}
+	if options.keepalive, err = parseKeepalive(u); err != nil {
+		return options, fmt.Errorf("peer %s has invalid keepalive: %w", u.String(), err)
+	}
+	if options.compress, err = parseCompress(u); err != nil {
+		return options, fmt.Errorf("peer %s has invalid compress: %w", u.String(), err)
+	}
+	options.password = u.Query().Get("password")
+	if err := checkPassword(options.password); err != nil {
+		return options, fmt.Errorf("peer %s has invalid password: %w", u.String(), err)
+	}
+	if options.padding, err = parsePadding(u); err != nil {
+		return options, fmt.Errorf("peer %s has invalid padding: %w", u.String(), err)
+	}
+	if options.cover, err = parseCover(u); err != nil {
+		return options, fmt.Errorf("peer %s has invalid cover: %w", u.String(), err)
+	}
+	if options.socket, err = parseSocketOptions(u); err != nil {
+		return options, fmt.Errorf("peer %s has invalid socket options: %w", u.String(), err)
+	}
+	return options, nil
+}
+
+// parseVia returns the source address given by the via option of a peer, or
+// nil if the option is an interface name instead.
+func parseVia(via string) *net.TCPAddr {
+	host, zone := via, ""
+	if i := strings.LastIndex(via, "%"); i >= 0 {
+		host, zone = via[:i], via[i+1:]
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return &net.TCPAddr{IP: ip, Zone: zone}
+	}
+	return nil
+}
+
+// call calls a peer, reporting how it goes to the given retry state if it is
+// a configured peer.
+func (l *links) call(u *url.URL, sintf string, state *peerState) (err error) {
+	//u, err := url.Parse(uri)
+	//if err != nil {
+	//	return fmt.Errorf("peer %s is not correctly formatted (%s)", uri, err)
+	//}
+	l.mutex.RLock()
+	draining := l.draining
+	l.mutex.RUnlock()
+	if draining {
+		return errors.New("links are draining")
+	}
+	options, err := parseLinkOptions(u)
+	if err != nil {
+		return err
+	}
+	options.peer = state
+	tcpOpts := tcpOptions{linkOptions: options, fastOpen: parseFastOpen(u)}
+	if via := u.Query().Get("via"); via != "" {
+		if sintf != "" {
+			return fmt.Errorf("peer %s has the via option but is already called from %s", u.String(), sintf)
+		}
+		if tcpOpts.sourceAddr = parseVia(via); tcpOpts.sourceAddr == nil {
+			sintf = via
+		}
+	}
+	for key := range tcpOpts.pinnedEd25519Keys {
+		if quota := l.core.quotas.get(key); quota != nil && quota.overHard() {
+			l.core.log.Debugln("Not calling", u.String(), "as it is over its hard traffic quota")
+			return nil
+		}
+	}
+	if !tcpOpts.schedule.open(l.core.clock.Now()) {
+		l.core.log.Debugln("Not calling", u.String(), "as it is outside of its schedule")
+		return nil
+	}
+	dialDone, inProgress := l.dials.start(u, sintf, options)
+	if dialDone == nil {
+		l.core.log.Debugln("Not calling", u.String(), "as a call to", inProgress, "is already in progress")
+		state.failed(errCallInProgress)
+		return nil
+	}
+	span := startSpan(nil, "yggdrasil.call",
+		attribute.String("peer.uri", traceURI(u)),
+		attribute.String("link.interface", sintf),
+		attribute.Bool("peer.configured", state != nil),
+	)
+	defer func() {
+		if err != nil {
+			dialDone()
+			endSpan(span, err)
+		}
+	}()
+	tcpOpts.callSpan = span
+	tcpOpts.dialDone = func() {
+		dialDone()
+		span.End()
+	}
+	if isOnion(u) && u.Scheme != "socks" {
+		// Onion services can only be reached through Tor
+		tcpOpts.socksProxyAddr = defaultTorSOCKS
+		if socks := u.Query().Get("socks"); socks != "" {
+			tcpOpts.socksProxyAddr = socks
 		}
-		l.tcp.call(u.Host, tcpOpts, sintf)
-	default:
+	}
+	scheme, ok := linkSchemes[u.Scheme]
+	if !ok || scheme.dial == nil {
 		return errors.New("unknown call scheme: " + u.Scheme)
 	}
+	addr, err := scheme.dial(&l.tcp, u, &tcpOpts)
+	if err != nil {
+		return err
+	}
+	l.tcp.call(addr, tcpOpts, sintf)
 	return nil
 }
 
+// tlsSNI returns the SNI to send when calling the peer URI over TLS, if any.
+func tlsSNI(u *url.URL) string {
+	// SNI headers must contain hostnames and not IP addresses, so we must make sure
+	// that we do not populate the SNI with an IP literal. We do this by splitting
+	// the host-port combo from the query option and then seeing if it parses to an
+	// IP address successfully or not.
+	if sni := u.Query().Get("sni"); sni != "" {
+		if net.ParseIP(sni) == nil {
+			return sni
+		}
+	}
+	// If the SNI is not configured still because the above failed then we'll try
+	// again but this time we'll use the host part of the peering URI instead.
+	if host, _, err := net.SplitHostPort(u.Host); err == nil && net.ParseIP(host) == nil {
+		return host
+	}
+	return ""
+}
+
 func (l *links) create(conn net.Conn, name, linkType, local, remote string, incoming, force bool, options linkOptions) (*link, error) {
 	// Technically anything unique would work for names, but let's pick something human readable, just for debugging
 	intf := link{
 		conn: &linkConn{
-			Conn: conn,
-			up:   time.Now(),
+			Conn:     conn,
+			clock:    l.core.clock,
+			up:       l.core.clock.Now(),
+			lastRecv: l.core.clock.Now().UnixNano(),
 		},
 		lname:   name,
 		links:   l,
+		penalty: linkPenalty{base: options.penalty},
 		options: options,
 		info: linkInfo{
 			linkType: linkType,
@@ -144,44 +332,141 @@ func (l *links) create(conn net.Conn, name, linkType, local, remote string, inco
 	return &intf, nil
 }
 
+// closePeers closes the links that were called for any of the given peers.
+func (l *links) closePeers(states map[*peerState]struct{}, reason string) {
+	if len(states) == 0 {
+		return
+	}
+	var closing []*link
+	l.mutex.RLock()
+	for _, intf := range l.links {
+		if _, ok := states[intf.options.peer]; ok {
+			closing = append(closing, intf)
+		}
+	}
+	l.mutex.RUnlock()
+	for _, intf := range closing {
+		l.core.log.Infof("Closing link %s as %s", intf.name(), reason)
+		intf.close()
+	}
+}
+
+// closeIdle closes any links that have received nothing, including the
+// keepalives that ironwood sends, for longer than the ReadTimeout, until the
+// node is stopped. Each link then drops out of the links map as its handler
+// returns.
+func (l *links) closeIdle() {
+	ticker := l.core.clock.NewTicker(l.timeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.core.ctx.Done():
+			return
+		case now := <-ticker.Chan():
+			var idle []*link
+			l.mutex.RLock()
+			for _, intf := range l.links {
+				if now.Sub(time.Unix(0, atomic.LoadInt64(&intf.conn.lastRecv))) >= l.timeout {
+					idle = append(idle, intf)
+				}
+			}
+			l.mutex.RUnlock()
+			for _, intf := range idle {
+				l.core.log.Infof("Closing link %s as nothing has been received for %s", intf.name(), l.timeout)
+				intf.close()
+			}
+		}
+	}
+}
+
+// drain stops any listeners and prevents new links from being set up, but
+// leaves existing links in place. The links are then flushed and closed one
+// at a time, spread out over the given timeout, so that the rest of the
+// network has the chance to converge around each one in turn rather than all
+// of them at once.
+func (l *links) drain(timeout time.Duration) {
+	l.mutex.Lock()
+	l.draining = true
+	active := make([]*link, 0, len(l.links))
+	for _, intf := range l.links {
+		active = append(active, intf)
+	}
+	l.mutex.Unlock()
+	l.tcp.stopListeners()
+	if len(active) == 0 {
+		return
+	}
+	interval := timeout / time.Duration(len(active))
+	for _, intf := range active {
+		select {
+		case <-l.stopped:
+			return
+		case <-l.core.clock.After(interval):
+		}
+		l.core.log.Debugln("Draining link", intf.name())
+		intf.conn.flush(linkShutdownTimeout)
+	}
+}
+
+// stop shuts down the links gracefully, as described in shutdown.go, and
+// then waits for the handlers of all of them to return.
 func (l *links) stop() error {
 	close(l.stopped)
+	l.shutdown(linkShutdownTimeout)
 	if err := l.tcp.stop(); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (intf *link) handler() (chan struct{}, error) {
-	// TODO split some of this into shorter functions, so it's easier to read, and for the FIXME duplicate peer issue mentioned later
+func (intf *link) handler() (_ chan struct{}, err error) {
+	// TODO split some of this into shorter functions, so it's easier to read
 	defer intf.conn.Close()
 	meta := version_getBaseMetadata()
 	meta.key = intf.links.core.public
 	metaBytes := meta.encode()
 	// TODO timeouts on send/recv (goroutine for send/recv, channel select w/ timer)
-	var err error
-	if !util.FuncTimeout(30*time.Second, func() {
-		var n int
-		n, err = intf.conn.Write(metaBytes)
+	clk := intf.links.core.clock
+	start := clk.Now()
+	var handshaken, connected bool
+	span := startSpan(intf.options.callSpan, "yggdrasil.handshake", intf.linkAttributes()...)
+	defer func() {
+		if !handshaken {
+			intf.links.handshakes.count(false)
+		}
+		if !connected {
+			endSpan(span, err) // Either the handshake or the setup span
+			failSpan(intf.options.callSpan, err)
+			if !errors.Is(err, ErrLinkAlreadyExists) {
+				intf.options.peer.failed(err)
+			}
+		}
+	}()
+	// The closure has its own error, as it keeps running after a timeout
+	var sendErr error
+	if !funcTimeout(clk, linkHandshakeTimeout, func() {
+		n, err := intf.conn.Write(metaBytes)
 		if err == nil && n != len(metaBytes) {
 			err = errors.New("incomplete metadata send")
 		}
+		sendErr = err
 	}) {
 		return nil, errors.New("timeout on metadata send")
 	}
-	if err != nil {
+	if err = sendErr; err != nil {
 		return nil, err
 	}
-	if !util.FuncTimeout(30*time.Second, func() {
-		var n int
-		n, err = io.ReadFull(intf.conn, metaBytes)
+	var recvErr error
+	if !funcTimeout(clk, linkHandshakeTimeout, func() {
+		n, err := io.ReadFull(intf.conn, metaBytes)
 		if err == nil && n != len(metaBytes) {
 			err = errors.New("incomplete metadata recv")
 		}
+		recvErr = err
 	}) {
 		return nil, errors.New("timeout on metadata recv")
 	}
-	if err != nil {
+	if err = recvErr; err != nil {
 		return nil, err
 	}
 	meta = version_metadata{}
@@ -196,24 +481,49 @@ func (intf *link) handler() (chan struct{}, error) {
 		} else {
 			connectError = "Failed to connect"
 		}
-		intf.links.core.log.Debugf("%s: %s is incompatible version (local %s, remote %s)",
+		err = errors.New("remote node is incompatible version")
+		intf.logEvent("debug", "incompatible", err, "%s: %s is incompatible version (local %s, remote %s)",
 			connectError,
 			intf.lname,
 			fmt.Sprintf("%d.%d", base.ver, base.minorVer),
 			fmt.Sprintf("%d.%d", meta.ver, meta.minorVer),
 		)
-		return nil, errors.New("remote node is incompatible version")
+		return nil, err
+	}
+	copy(intf.info.key[:], meta.key)
+	// Check if the remote side matches the keys we expected. Over TLS, the key has
+	// to be the one that the certificate of the remote node proved that it holds,
+	// as otherwise anything in the middle could send the pinned key.
+	if tlsKey := intf.options.tlsKey; tlsKey != nil && !bytes.Equal(tlsKey, meta.key) {
+		err = fmt.Errorf("failed to connect: host sent ed25519 key that does not match its TLS certificate")
+		intf.logEvent("error", "key_mismatch", err, "Failed to connect to node: %q sent ed25519 key that does not match its TLS certificate", intf.name())
+		return nil, err
 	}
-	// Check if the remote side matches the keys we expected. This is a bit of a weak
-	// check - in future versions we really should check a signature or something like that.
 	if pinned := intf.options.pinnedEd25519Keys; pinned != nil {
 		var key keyArray
 		copy(key[:], meta.key)
 		if _, allowed := pinned[key]; !allowed {
-			intf.links.core.log.Errorf("Failed to connect to node: %q sent ed25519 key that does not match pinned keys", intf.name())
-			return nil, fmt.Errorf("failed to connect: host sent ed25519 key that does not match pinned keys")
+			err = fmt.Errorf("failed to connect: host sent ed25519 key that does not match pinned keys")
+			intf.logEvent("error", "key_mismatch", err, "Failed to connect to node: %q sent ed25519 key that does not match pinned keys", intf.name())
+			return nil, err
 		}
 	}
+	if intf.options.password != "" {
+		if err := intf.checkRemotePassword(ed25519.PublicKey(meta.key)); err != nil {
+			intf.logEvent("warn", "refused", err, "%s connection with %s refused: %s",
+				strings.ToUpper(intf.info.linkType), intf.info.remote, err)
+			return nil, err
+		}
+	}
+	intf.handshake, handshaken = clk.Now().Sub(start), true
+	intf.links.handshakes.count(true)
+	span.End()
+	span = startSpan(intf.options.callSpan, "yggdrasil.setup", append(intf.linkAttributes(),
+		attribute.String("peer.key", hex.EncodeToString(meta.key)))...)
+	if intf.options.handshakeDone != nil {
+		intf.options.handshakeDone()
+	}
+	intf.conn.frames = &linkFrames{}
 	// Check if we're authorized to connect to this key / IP
 	intf.links.core.config.RLock()
 	allowed := intf.links.core.config.AllowedPublicKeys
@@ -226,25 +536,65 @@ func (intf *link) handler() (chan struct{}, error) {
 		}
 	}
 	if intf.incoming && !intf.force && !isallowed {
-		intf.links.core.log.Warnf("%s connection from %s forbidden: AllowedEncryptionPublicKeys does not contain key %s",
+		intf.logEvent("warn", "forbidden", errors.New("key is not in AllowedPublicKeys"), "%s connection from %s forbidden: AllowedEncryptionPublicKeys does not contain key %s",
 			strings.ToUpper(intf.info.linkType), intf.info.remote, hex.EncodeToString(meta.key))
 		intf.close()
 		return nil, nil
 	}
+	// Check if the link is allowed to be up at the moment
+	if ok, err := intf.inSchedule(clk.Now()); err != nil {
+		intf.links.core.log.Errorln(err)
+		return nil, err
+	} else if !ok {
+		err = errors.New("link is outside of its schedule")
+		intf.logEvent("debug", "refused", err, "%s connection with %s refused as it is outside of its schedule",
+			strings.ToUpper(intf.info.linkType), intf.info.remote)
+		return nil, err
+	}
+	if quota := intf.links.core.quotas.get(intf.info.key); quota != nil {
+		if quota.overHard() {
+			intf.logEvent("debug", "refused", errOverQuota, "%s connection with %s refused as it is over its hard traffic quota",
+				strings.ToUpper(intf.info.linkType), intf.info.remote)
+			return nil, errOverQuota
+		}
+		intf.conn.quota = quota
+	}
+	intf.limitRates()
+	intf.askPenalty() // Before the penalty is compared with those of other links below
 	// Check if we already have a link to this node
-	copy(intf.info.key[:], meta.key)
+	if intf.options.bundle != "" {
+		intf.info.name = intf.lname
+	}
 	intf.links.mutex.Lock()
+	if intf.links.draining {
+		intf.links.mutex.Unlock()
+		return nil, errors.New("links are draining")
+	}
+	intf.links.core.config.RLock()
+	maxPeers := intf.links.core.config.MaxPeers
+	intf.links.core.config.RUnlock()
+	var evicted *link
 	if oldIntf, isIn := intf.links.links[intf.info]; isIn {
 		intf.links.mutex.Unlock()
-		// FIXME we should really return an error and let the caller block instead
-		// That lets them do things like close connections on its own, avoid printing a connection message in the first place, etc.
-		intf.links.core.log.Debugln("DEBUG: found existing interface for", intf.name())
-		return oldIntf.closed, nil
+		return oldIntf.closed, ErrLinkAlreadyExists
+	} else if evicted = intf.links._evict(intf, maxPeers); evicted == intf {
+		intf.links.mutex.Unlock()
+		err = errors.New("too many peers")
+		intf.logEvent("debug", "refused", err, "%s connection from %s refused as there are already %d peers",
+			strings.ToUpper(intf.info.linkType), intf.info.remote, maxPeers)
+		return nil, err
 	} else {
 		intf.closed = make(chan struct{})
 		intf.links.links[intf.info] = intf
+		if lc := intf.links.chaos[intf.lname]; lc != nil {
+			intf.conn.setChaos(lc)
+		}
+		if imp, ok := intf.links.impairments[intf.lname]; ok {
+			intf.impair(imp)
+		}
 		defer func() {
 			intf.links.mutex.Lock()
+			intf.links._remember(intf, err)
 			delete(intf.links.links, intf.info)
 			intf.links.mutex.Unlock()
 			close(intf.closed)
@@ -252,21 +602,73 @@ func (intf *link) handler() (chan struct{}, error) {
 		intf.links.core.log.Debugln("DEBUG: registered interface for", intf.name())
 	}
 	intf.links.mutex.Unlock()
+	if intf.options.dialDone != nil {
+		intf.options.dialDone()
+	}
+	if evicted != nil {
+		evicted.logEvent("info", "evicted", nil, "Closing link %s to make room for %s, as there are already %d peers",
+			evicted.name(), intf.name(), maxPeers)
+		evicted.close()
+	}
+	if intf.options.peer != nil {
+		if !intf.options.peer.up() {
+			intf.links.core.log.Debugln("Closing link", intf.name(), "as its peer has been removed")
+			intf.close()
+			return nil, errors.New("peer has been removed")
+		}
+		defer intf.options.peer.down()
+		intf.options.peer.attach(intf)
+		defer intf.options.peer.detach(intf)
+	}
+	intf.conn.padding = intf.options.padding
+	intf.conn.wtimeout = intf.links.wtimeout
+	if len(intf.options.compress) > 0 {
+		if err = intf.negotiateCompression(); err != nil {
+			return nil, err
+		}
+	}
+	if intf.options.lossPenalty && intf.raw != nil {
+		go intf.monitorLoss(intf.raw)
+	}
+	go intf.monitorSchedule()
+	if intf.conn.quota != nil {
+		go intf.monitorQuota(intf.conn.quota)
+	}
+	if intf.options.keepalive > 0 {
+		go intf.monitorKeepalive(intf.options.keepalive)
+	}
+	if intf.options.latencyPenalty {
+		clk := intf.links.core.clock
+		intf.conn.probes = &linkProbes{pongs: make(chan []byte, 1), penalty: &intf.penalty, clock: clk, epoch: clk.Now()}
+		go intf.monitorLatency(intf.conn.probes)
+	}
 	themAddr := address.AddrForKey(ed25519.PublicKey(intf.info.key[:]))
 	themAddrString := net.IP(themAddr[:]).String()
 	themString := fmt.Sprintf("%s@%s", themAddrString, intf.info.remote)
-	intf.links.core.log.Infof("Connected %s: %s, source %s",
+	intf.logEvent("info", "connected", nil, "Connected %s: %s, source %s",
 		strings.ToUpper(intf.info.linkType), themString, intf.info.local)
+	span.End()
+	connected = true
+	intf.links.notify(intf, true, nil)
+	if intf.options.cover > 0 && intf.options.bundle == "" {
+		// Bundled links only start once the bundle marker has been sent
+		go intf.sendCover(intf.options.cover)
+	}
 	// Run the handler
-	err = intf.links.core.HandleConn(ed25519.PublicKey(intf.info.key[:]), intf.conn)
+	if intf.options.bundle != "" {
+		err = intf.links.bundle(intf)
+	} else {
+		err = intf.links.core.HandleConn(ed25519.PublicKey(intf.info.key[:]), intf.conn)
+	}
 	// TODO don't report an error if it's just a 'use of closed network connection'
 	if err != nil {
-		intf.links.core.log.Infof("Disconnected %s: %s, source %s; error: %s",
+		intf.logEvent("info", "disconnected", err, "Disconnected %s: %s, source %s; error: %s",
 			strings.ToUpper(intf.info.linkType), themString, intf.info.local, err)
 	} else {
-		intf.links.core.log.Infof("Disconnected %s: %s, source %s",
+		intf.logEvent("info", "disconnected", nil, "Disconnected %s: %s, source %s",
 			strings.ToUpper(intf.info.linkType), themString, intf.info.local)
 	}
+	intf.links.notify(intf, false, err)
 	return nil, err
 }
 
@@ -279,22 +681,172 @@ func (intf *link) name() string {
 }
 
 type linkConn struct {
-	// tx and rx are at the beginning of the struct to ensure 64-bit alignment
-	// on 32-bit platforms, see https://pkg.go.dev/sync/atomic#pkg-note-BUG
-	rx uint64
-	tx uint64
-	up time.Time
+	// The counters and times are at the beginning of the struct to ensure
+	// 64-bit alignment on 32-bit platforms, see https://pkg.go.dev/sync/atomic#pkg-note-BUG
+	rx       uint64
+	tx       uint64
+	rxFrames uint64
+	txFrames uint64
+	lastRecv int64 // Unix time in nanoseconds of the last read
+	lastSent int64 // Unix time in nanoseconds of the last write
+	clock    clock // The clock of the core, for the times above
+	up       time.Time
+	quota    *quotaUsage    // Traffic quota for the remote node, if any
+	sendRate []*rateLimiter // Bandwidth limits on writes, if any
+	recvRate []*rateLimiter // Bandwidth limits on reads, if any
+	chaos    atomic.Value   // *linkChaos, if faults are being injected
+	impairer atomic.Value   // *linkImpairer, if the link is impaired
+	unread   []byte         // Read ahead of ironwood, and so to be read again
+	probes   *linkProbes    // Set if latency probes are answered and measured
+	codec    *linkCodec     // Set if frames are compressed
+	padding  []int          // The sizes to pad writes up to, if any
+	rbuf     []byte         // Reused for each frame that is read whole
+	wtimeout time.Duration  // The write deadline for each send, if not 0
+	wmutex   sync.Mutex     // Keepalives and ironwood may write at the same time
+	flushing sync.RWMutex   // Held for reading by each send, and for writing by flush
+	closing  bool           // Set by flush, after which nothing more is sent
+	frames   *linkFrames    // Set once the metadata has been exchanged, to count frames
 	net.Conn
 }
 
+func (c *linkConn) setChaos(lc *linkChaos) {
+	c.chaos.Store(lc)
+}
+
 func (c *linkConn) Read(p []byte) (n int, err error) {
+	if len(c.unread) > 0 {
+		// These were counted when they were first read
+		n = copy(p, c.unread)
+		c.unread = c.unread[n:]
+		return n, nil
+	}
+	if c.probes != nil || c.codec != nil {
+		// Frames are read whole, so that probes can be taken out of them and
+		// compressed frames can be expanded
+		if c.unread, err = c.readFrame(); err != nil {
+			return 0, err
+		}
+		n = copy(p, c.unread)
+		c.unread = c.unread[n:]
+		return n, nil
+	}
+	return c.read(p)
+}
+
+// readFrame reads the next frame that isn't a probe, expanding it if it is
+// compressed, and answering or measuring any probes that come before it.
+func (c *linkConn) readFrame() ([]byte, error) {
+	for {
+		frame := c.rbuf[:0]
+		if cap(frame) < 2 {
+			frame = make([]byte, 0, 1500)
+		}
+		frame = frame[:2]
+		if _, err := io.ReadFull(linkReader{c}, frame); err != nil {
+			return nil, err
+		}
+		size := 2 + int(binary.BigEndian.Uint16(frame))
+		if cap(frame) < size {
+			frame = append(frame, make([]byte, size-2)...)
+		}
+		c.rbuf = frame
+		frame = frame[:size]
+		if _, err := io.ReadFull(linkReader{c}, frame[2:]); err != nil {
+			return nil, err
+		}
+		if c.codec != nil && c.codec.compressed(frame) {
+			var err error
+			if frame, err = c.codec.expand(frame); err != nil {
+				return nil, err
+			}
+		}
+		if c.probes != nil && c.probes.handle(frame) {
+			continue
+		}
+		return frame, nil
+	}
+}
+
+// linkReader reads from the connection underneath a linkConn, with the same
+// accounting as Read.
+type linkReader struct {
+	c *linkConn
+}
+
+func (r linkReader) Read(p []byte) (int, error) {
+	return r.c.read(p)
+}
+
+// read reads from the underlying connection, counting what is read against
+// any quota and bandwidth limits.
+func (c *linkConn) read(p []byte) (n int, err error) {
 	n, err = c.Conn.Read(p)
 	atomic.AddUint64(&c.rx, uint64(n))
+	if c.frames != nil && n > 0 {
+		atomic.AddUint64(&c.rxFrames, c.frames.rx.count(p[:n]))
+	}
+	if n > 0 {
+		atomic.StoreInt64(&c.lastRecv, c.clock.Now().UnixNano())
+	}
+	if c.quota != nil {
+		c.quota.add(n)
+	}
+	for _, r := range c.recvRate {
+		r.wait(n)
+	}
 	return
 }
 
 func (c *linkConn) Write(p []byte) (n int, err error) {
-	n, err = c.Conn.Write(p)
+	frame := p
+	if c.codec != nil {
+		// Limits and counters are for what goes over the wire
+		frame = c.codec.compress(p)
+	}
+	if c.padding != nil {
+		frame = pad(frame, c.padding)
+	}
+	if c.quota != nil {
+		c.quota.wait(len(frame))
+	}
+	for _, r := range c.sendRate {
+		r.wait(len(frame))
+	}
+	c.flushing.RLock()
+	defer c.flushing.RUnlock()
+	if c.closing {
+		return 0, net.ErrClosed
+	}
+	c.wmutex.Lock()
+	if c.wtimeout > 0 {
+		// A remote node that has stopped reading fails the write, and so the link
+		_ = c.SetWriteDeadline(time.Now().Add(c.wtimeout))
+	}
+	if lc, _ := c.chaos.Load().(*linkChaos); lc != nil {
+		n, err = lc.write(frame, c.send)
+	} else {
+		n, err = c.send(frame)
+	}
+	if c.frames != nil && n > 0 {
+		atomic.AddUint64(&c.txFrames, c.frames.tx.count(frame[:n]))
+	}
+	c.wmutex.Unlock()
 	atomic.AddUint64(&c.tx, uint64(n))
+	atomic.StoreInt64(&c.lastSent, c.clock.Now().UnixNano())
+	if c.quota != nil {
+		c.quota.add(n)
+	}
+	if err == nil && n == len(frame) {
+		n = len(p)
+	}
 	return
 }
+
+// send writes to the underlying connection, through the impairer if the link
+// is impaired.
+func (c *linkConn) send(p []byte) (int, error) {
+	if li, _ := c.impairer.Load().(*linkImpairer); li != nil {
+		return li.write(p)
+	}
+	return c.Conn.Write(p)
+}
@@ -0,0 +1,97 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCore_DuplicateLink(t *testing.T) {
+	nodeA, nodeB := new(Core), new(Core)
+	if err := nodeA.Start(GenerateConfig(), GetLoggerWithPrefix("A: ", false)); err != nil {
+		t.Fatal(err)
+	}
+	defer nodeA.Stop()
+	if err := nodeB.Start(GenerateConfig(), GetLoggerWithPrefix("B: ", false)); err != nil {
+		t.Fatal(err)
+	}
+	defer nodeB.Stop()
+	addPair := func() (chan error, chan error) {
+		a, b := memPipe(memAddrOf(nodeA), memAddrOf(nodeB))
+		errA, errB := make(chan error, 1), make(chan error, 1)
+		go func() { errA <- nodeA.AddConn(a, "pipe", false) }()
+		go func() { errB <- nodeB.AddConn(b, "pipe", true) }()
+		return errA, errB
+	}
+	addPair()
+	if !WaitConnected(nodeA, nodeB) {
+		t.Fatal("nodes did not connect")
+	}
+	// Whichever side finds the duplicate first closes it, so the other side
+	// may only see the connection close
+	errA, errB := addPair()
+	var refused bool
+	for _, errs := range []chan error{errA, errB} {
+		select {
+		case err := <-errs:
+			refused = refused || errors.Is(err, ErrLinkAlreadyExists)
+		case <-time.After(5 * time.Second):
+			t.Fatal("duplicate link was not closed")
+		}
+	}
+	if !refused {
+		t.Fatal("duplicate link was not refused with ErrLinkAlreadyExists")
+	}
+	if a, b := len(nodeA.GetPeers()), len(nodeB.GetPeers()); a != 1 || b != 1 {
+		t.Fatal("unexpected number of peers", a, b)
+	}
+}
+
+// TestLink_HandshakeTimeout checks that a link is abandoned if the remote side
+// never completes the metadata exchange, without waiting for the real timeout.
+func TestLink_HandshakeTimeout(t *testing.T) {
+	clk := &fakeClock{now: time.Now()}
+	c := &Core{clock: clk, log: GetLoggerWithPrefix("", false)}
+	c.public, c.secret, _ = ed25519.GenerateKey(nil)
+	c.links.core = c
+	local, remote := net.Pipe()
+	defer remote.Close()
+	intf, err := c.links.create(local, "pipe", "pipe", "", "pipe", false, false, linkOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make(chan error, 1)
+	go func() {
+		_, err := intf.handler()
+		result <- err
+	}()
+	clk.WaitForWaiters(t)
+	select {
+	case err := <-result:
+		t.Fatal("handler returned early:", err)
+	default:
+	}
+	clk.Advance(linkHandshakeTimeout)
+	select {
+	case err := <-result:
+		if err == nil || !strings.Contains(err.Error(), "timeout on metadata send") {
+			t.Fatal("unexpected error:", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler did not time out")
+	}
+}
+
+func TestLinkConn_WriteTimeout(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	c := &linkConn{Conn: local, clock: systemClock{}, wtimeout: 50 * time.Millisecond}
+	// Nothing reads from the remote end
+	if _, err := c.Write([]byte{0x00, 0x01, 0x01}); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatal("write to a stalled remote node did not time out:", err)
+	}
+}
@@ -0,0 +1,69 @@
+package core
+
+// This file contains the structured logging of links. The link handler logs
+// each link that connects, disconnects or is refused as an event with fields,
+// rather than only as a line of text, so that log aggregation systems don't
+// have to parse the messages. Unless a link logger has been set with
+// SetLinkLogger, the events are logged as the usual messages.
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+// LinkLogEvent is a structured log event about a link.
+type LinkLogEvent struct {
+	Level     string // info, warn, error or debug
+	Event     string // e.g. connected, disconnected or refused
+	Message   string // The message that is logged if there is no link logger
+	LinkType  string
+	Local     string
+	Remote    string
+	Key       string // The key of the remote node in hex, once it is known
+	Direction string // inbound or outbound
+	Error     string // Why the link failed or closed, if it did
+}
+
+// LinkLogger receives the log events about links. It is called from the link
+// handler, so it must not block.
+type LinkLogger func(LinkLogEvent)
+
+// SetLinkLogger sets a function to log the events about links with, in place
+// of the node's logger, or nil to go back to the node's logger.
+func (c *Core) SetLinkLogger(logger LinkLogger) {
+	c.links.mutex.Lock()
+	defer c.links.mutex.Unlock()
+	c.links.logger = logger
+}
+
+// logEvent logs an event about the link, with the message formatted from the
+// format and arguments.
+func (intf *link) logEvent(level, event string, err error, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	intf.links.mutex.RLock()
+	logger := intf.links.logger
+	intf.links.mutex.RUnlock()
+	if logger == nil {
+		_ = intf.links.core.log.Output(level, message)
+		return
+	}
+	e := LinkLogEvent{
+		Level:     level,
+		Event:     event,
+		Message:   message,
+		LinkType:  intf.info.linkType,
+		Local:     intf.info.local,
+		Remote:    intf.info.remote,
+		Direction: "outbound",
+	}
+	if intf.info.key != (keyArray{}) {
+		e.Key = hex.EncodeToString(intf.info.key[:])
+	}
+	if intf.incoming {
+		e.Direction = "inbound"
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	logger(e)
+}
@@ -0,0 +1,39 @@
+package core
+
+import (
+	"encoding/hex"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestCore_LinkLogger(t *testing.T) {
+	nodeA := new(Core)
+	if err := nodeA.Start(GenerateConfig(), GetLoggerWithPrefix("A: ", false)); err != nil {
+		t.Fatal(err)
+	}
+	defer nodeA.Stop()
+	events := make(chan LinkLogEvent, 4)
+	nodeA.SetLinkLogger(func(e LinkLogEvent) { events <- e })
+	nodeB := new(Core)
+	if err := nodeB.Start(GenerateConfig(), GetLoggerWithPrefix("B: ", false)); err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("tcp://" + nodeA.links.tcp.getAddr().String())
+	if err := nodeB.CallPeer(u, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range []string{"connected", "disconnected"} {
+		select {
+		case e := <-events:
+			if e.Event != event || e.Direction != "inbound" || e.Key != hex.EncodeToString(nodeB.PublicKey()) {
+				t.Fatalf("unexpected event %+v", e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event for the link going up or down")
+		}
+		if event == "connected" {
+			nodeB.Stop()
+		}
+	}
+}
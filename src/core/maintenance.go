@@ -0,0 +1,79 @@
+package core
+
+import (
+	"sync"
+)
+
+// maintenance allows a node to be cordoned so that it stops accepting new
+// sessions from remote nodes. Transit traffic is forwarded by ironwood and is
+// not affected, nor are sessions that were already active or that we open
+// ourselves.
+type maintenance struct {
+	mutex          sync.RWMutex // protects the below
+	refuseSessions bool
+	allowed        map[keyArray]struct{}
+}
+
+func (m *maintenance) setRefuseSessions(refuse bool, active []Session) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.refuseSessions = refuse
+	m.allowed = nil
+	if !refuse {
+		return
+	}
+	m.allowed = make(map[keyArray]struct{}, len(active))
+	for _, s := range active {
+		var key keyArray
+		copy(key[:], s.Key)
+		m.allowed[key] = struct{}{}
+	}
+}
+
+// isAllowed returns true if traffic from the given key should be delivered.
+func (m *maintenance) isAllowed(key keyArray) bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if !m.refuseSessions {
+		return true
+	}
+	_, ok := m.allowed[key]
+	return ok
+}
+
+// allow marks a key as permitted, i.e. because we sent traffic to it.
+func (m *maintenance) allow(key keyArray) {
+	m.mutex.RLock()
+	refuse := m.refuseSessions
+	_, ok := m.allowed[key]
+	m.mutex.RUnlock()
+	if !refuse || ok {
+		return
+	}
+	m.mutex.Lock()
+	if m.refuseSessions {
+		m.allowed[key] = struct{}{}
+	}
+	m.mutex.Unlock()
+}
+
+// SetRefuseNewSessions puts the node into (or takes it out of) maintenance
+// mode. While refusing new sessions, traffic from remote nodes that we didn't
+// already have a session with, or haven't sent traffic to ourselves, will be
+// dropped. The node will continue to forward transit traffic as normal.
+func (c *Core) SetRefuseNewSessions(refuse bool) {
+	c.maintenance.setRefuseSessions(refuse, c.GetSessions())
+	if refuse {
+		c.log.Infoln("Refusing new sessions")
+	} else {
+		c.log.Infoln("Accepting new sessions")
+	}
+}
+
+// RefusingNewSessions returns true if the node is currently in maintenance
+// mode and is refusing new sessions.
+func (c *Core) RefusingNewSessions() bool {
+	c.maintenance.mutex.RLock()
+	defer c.maintenance.mutex.RUnlock()
+	return c.maintenance.refuseSessions
+}
@@ -0,0 +1,59 @@
+package core
+
+// This file contains the firewall mark of the sockets that carry links, for
+// nodes whose routes themselves go through Yggdrasil, such as when it is the
+// default gateway. With LinkMark set, the sockets of links over TCP, as well
+// as udp://, kcp://, dns://, wg:// and sctp:// ones, are marked with SO_MARK,
+// so that a policy routing rule can send links around the overlay rather than
+// into it, where they would loop. This is only supported on Linux.
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"syscall"
+)
+
+// initMark reads the LinkMark from the config.
+func (t *tcp) initMark() error {
+	t.links.core.config.RLock()
+	t.mark = t.links.core.config.LinkMark
+	t.links.core.config.RUnlock()
+	if t.mark != 0 && runtime.GOOS != "linux" {
+		return errors.New("LinkMark is only supported on Linux")
+	}
+	return nil
+}
+
+// markControl is the control function for sockets that aren't TCP, which
+// only sets the mark. TCP sockets are marked by tcpContext.
+func (t *tcp) markControl(network, address string, c syscall.RawConn) error {
+	if t.mark == 0 {
+		return nil
+	}
+	if err := socketMark(c, t.mark); err != nil {
+		return fmt.Errorf("failed to set SO_MARK: %w", err)
+	}
+	return nil
+}
+
+// listenUDP is net.ListenUDP, but with the mark set.
+func (t *tcp) listenUDP(addr *net.UDPAddr) (*net.UDPConn, error) {
+	lc := net.ListenConfig{Control: t.markControl}
+	sock, err := lc.ListenPacket(t.links.core.ctx, "udp", addr.String())
+	if err != nil {
+		return nil, err
+	}
+	return sock.(*net.UDPConn), nil
+}
+
+// dialUDP is net.DialUDP, but with the mark set.
+func (t *tcp) dialUDP(raddr *net.UDPAddr) (*net.UDPConn, error) {
+	dialer := net.Dialer{Control: t.markControl}
+	sock, err := dialer.Dial("udp", raddr.String())
+	if err != nil {
+		return nil, err
+	}
+	return sock.(*net.UDPConn), nil
+}
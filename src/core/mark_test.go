@@ -0,0 +1,41 @@
+package core
+
+import (
+	"net/url"
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestCore_LinkMark(t *testing.T) {
+	cfgA, cfgB := GenerateConfig(), GenerateConfig()
+	cfgA.LinkMark, cfgB.LinkMark = 0x1234, 0x1234
+	nodeA, nodeB := new(Core), new(Core)
+	err := nodeA.Start(cfgA, GetLoggerWithPrefix("A: ", false))
+	if runtime.GOOS != "linux" {
+		if err == nil {
+			nodeA.Stop()
+			t.Fatal("LinkMark was accepted on", runtime.GOOS)
+		}
+		return
+	}
+	if os.Geteuid() != 0 {
+		t.Skip("setting SO_MARK needs CAP_NET_ADMIN")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodeA.Stop()
+	if err := nodeB.Start(cfgB, GetLoggerWithPrefix("B: ", false)); err != nil {
+		t.Fatal(err)
+	}
+	defer nodeB.Stop()
+	u, _ := url.Parse("tcp://" + nodeA.links.tcp.getAddr().String())
+	if err := nodeB.CallPeer(u, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !WaitConnected(nodeA, nodeB) {
+		t.Fatal("nodes did not connect")
+	}
+	CheckEcho(t, nodeA, nodeB)
+}
@@ -0,0 +1,59 @@
+package core
+
+// This file contains the limit on the number of links, which stops a public
+// node from being exhausted by unbounded inbound connections. Once the node
+// has MaxPeers links, each new one makes room by evicting an inbound link,
+// starting with those that have the highest penalty and then those that have
+// gone the longest without carrying anything. Outbound links and link-local
+// peers are never evicted, as they were asked for or discovered locally.
+
+import (
+	"sync/atomic"
+)
+
+// evictable returns true if the link may be closed to make room for another.
+func (intf *link) evictable() bool {
+	return intf.incoming && !intf.force
+}
+
+// lastUsed returns the Unix time in nanoseconds at which the link last read or
+// wrote anything.
+func (intf *link) lastUsed() int64 {
+	recv := atomic.LoadInt64(&intf.conn.lastRecv)
+	if sent := atomic.LoadInt64(&intf.conn.lastSent); sent > recv {
+		return sent
+	}
+	return recv
+}
+
+// worseThan returns true if the link should be evicted before the other one.
+func (intf *link) worseThan(other *link) bool {
+	if m, o := intf.penalty.total(), other.penalty.total(); m != o {
+		return m > o
+	}
+	return intf.lastUsed() < other.lastUsed()
+}
+
+// _evict returns the link to close to make room for a new one, if the node
+// already has max links, or nil if there is room. The new link is itself the
+// one to be evicted if it is inbound and has a higher penalty than all of the
+// others, or if there is nothing else to evict, in which case it should be
+// refused. An outbound or link-local link is let in over the limit if there
+// are no inbound links to evict. This must be called with the mutex held.
+func (l *links) _evict(intf *link, max uint64) *link {
+	if max == 0 || uint64(len(l.links)) < max {
+		return nil
+	}
+	var worst *link
+	for _, other := range l.links {
+		if other.evictable() && (worst == nil || other.worseThan(worst)) {
+			worst = other
+		}
+	}
+	// The new link hasn't had the chance to be used yet, so only its penalty
+	// counts against it
+	if intf.evictable() && (worst == nil || intf.penalty.total() > worst.penalty.total()) {
+		return intf
+	}
+	return worst
+}
@@ -0,0 +1,44 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestCore_MaxPeers(t *testing.T) {
+	start := func(prefix string, listen, peers []string) *Core {
+		cfg := GenerateConfig()
+		cfg.Listen = listen
+		cfg.Peers = peers
+		cfg.MaxPeers = 1
+		node := new(Core)
+		if err := node.Start(cfg, GetLoggerWithPrefix(prefix, false)); err != nil {
+			t.Fatal(err)
+		}
+		return node
+	}
+	onlyPeer := func(node, other *Core) bool {
+		peers := node.GetPeers()
+		return len(peers) == 1 && bytes.Equal(peers[0].Key, other.PublicKey())
+	}
+	hub := start("H: ", []string{"mem://maxpeers-hub"}, nil)
+	defer hub.Stop()
+	nodeA := start("A: ", nil, []string{"mem://maxpeers-hub"})
+	defer nodeA.Stop()
+	for i := 0; i < 50 && !onlyPeer(hub, nodeA); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if !onlyPeer(hub, nodeA) {
+		t.Fatal("first peer did not connect")
+	}
+	// The hub is full, so the inbound link from A makes room for B
+	nodeB := start("B: ", nil, []string{"mem://maxpeers-hub"})
+	defer nodeB.Stop()
+	for i := 0; i < 50 && !onlyPeer(hub, nodeB); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if !onlyPeer(hub, nodeB) {
+		t.Fatal("first peer was not evicted for the second", len(hub.GetPeers()))
+	}
+}
@@ -0,0 +1,209 @@
+package core
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// tcpmem hangs the in-memory transport off of the TCP one. Links are carried
+// over net.Pipe between nodes in the same process, which makes tests and
+// simulations cheap, as nothing touches the network. A listener on mem://name
+// can be called from any other node in the process with a peer of mem://name.
+type tcpmem struct {
+	tcp     *tcp
+	upgrade *TcpUpgrade
+}
+
+// memListeners are the in-memory listeners of every node in the process, by
+// name.
+var memListeners = struct {
+	sync.Mutex
+	byName map[string]*memListener
+}{byName: make(map[string]*memListener)}
+
+func (m *tcpmem) init(tcp *tcp) {
+	m.tcp = tcp
+	m.upgrade = &TcpUpgrade{
+		upgrade: func(c net.Conn, _ *tcpOptions) (net.Conn, error) { return c, nil },
+		name:    "mem",
+		dial:    m.dial,
+	}
+}
+
+// memAddr is the name of an in-memory listener, or the key of the node at the
+// other end of a pipe. It isn't the address of the node, as that would look
+// like a link being routed over Yggdrasil itself.
+type memAddr string
+
+func memAddrOf(c *Core) memAddr {
+	return memAddr(hex.EncodeToString(c.public))
+}
+
+func (a memAddr) Network() string { return "mem" }
+func (a memAddr) String() string  { return string(a) }
+
+// memConn is one end of a pipe, with addresses that name the link. A pipe has
+// no buffering, so writes are queued and written by another goroutine, as
+// otherwise both ends would block in the link handshake, which starts with a
+// write on each side. Write deadlines are ignored, as a write only waits for
+// the queue.
+type memConn struct {
+	net.Conn
+	local  memAddr
+	remote memAddr
+	writes chan []byte
+	closed chan struct{}
+	once   sync.Once
+}
+
+const memWriteQueue = 64
+
+// memPipe returns the two ends of a new pipe between a and b.
+func memPipe(a, b memAddr) (*memConn, *memConn) {
+	pa, pb := net.Pipe()
+	ca := &memConn{Conn: pa, local: a, remote: b, writes: make(chan []byte, memWriteQueue), closed: make(chan struct{})}
+	cb := &memConn{Conn: pb, local: b, remote: a, writes: make(chan []byte, memWriteQueue), closed: make(chan struct{})}
+	go ca.run()
+	go cb.run()
+	return ca, cb
+}
+
+func (c *memConn) run() {
+	for {
+		select {
+		case b := <-c.writes:
+			if _, err := c.Conn.Write(b); err != nil {
+				c.Close()
+				return
+			}
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+func (c *memConn) Write(p []byte) (int, error) {
+	b := append([]byte(nil), p...)
+	select {
+	case c.writes <- b:
+		return len(p), nil
+	case <-c.closed:
+		return 0, net.ErrClosed
+	}
+}
+
+func (c *memConn) Close() error {
+	var err error
+	c.once.Do(func() {
+		close(c.closed)
+		err = c.Conn.Close()
+	})
+	return err
+}
+
+func (c *memConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *memConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *memConn) SetDeadline(t time.Time) error {
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *memConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// memListener accepts pipes from other nodes in the process.
+type memListener struct {
+	name   memAddr
+	accept chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (l *memListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.accept:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *memListener) Close() error {
+	l.once.Do(func() {
+		close(l.closed)
+		memListeners.Lock()
+		if memListeners.byName[string(l.name)] == l {
+			delete(memListeners.byName, string(l.name))
+		}
+		memListeners.Unlock()
+	})
+	return nil
+}
+
+func (l *memListener) Addr() net.Addr {
+	return l.name
+}
+
+func (m *tcpmem) listen(u *url.URL) (*TcpListener, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("mem listener %s must have a name", u.String())
+	}
+	listener := &memListener{
+		name:   memAddr(u.Host),
+		accept: make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+	memListeners.Lock()
+	if _, isIn := memListeners.byName[u.Host]; isIn {
+		memListeners.Unlock()
+		return nil, fmt.Errorf("mem listener %s is already in use", u.String())
+	}
+	memListeners.byName[u.Host] = listener
+	memListeners.Unlock()
+	l := TcpListener{
+		Listener: listener,
+		opts:     tcpOptions{upgrade: m.upgrade},
+		stop:     make(chan struct{}),
+	}
+	return &l, nil
+}
+
+func (m *tcpmem) dial(ctx context.Context, saddr string, _ *tcpOptions) (net.Conn, error) {
+	memListeners.Lock()
+	listener := memListeners.byName[saddr]
+	memListeners.Unlock()
+	if listener == nil {
+		return nil, fmt.Errorf("no mem listener named %s", saddr)
+	}
+	local, remote := memPipe(memAddrOf(m.tcp.links.core), listener.name)
+	select {
+	case listener.accept <- remote:
+		return local, nil
+	case <-listener.closed:
+	case <-ctx.Done():
+	}
+	local.Close()
+	remote.Close()
+	return nil, fmt.Errorf("mem listener %s is not accepting", saddr)
+}
+
+// link runs a link with another node over a new pipe, without either of them
+// needing a listener.
+func (m *tcpmem) link(other *tcpmem) {
+	local, remote := memPipe(memAddrOf(m.tcp.links.core), memAddrOf(other.tcp.links.core))
+	m.tcp.waitgroup.Add(1)
+	go m.tcp.handler(local, false, tcpOptions{upgrade: m.upgrade})
+	other.tcp.waitgroup.Add(1)
+	go other.tcp.handler(remote, true, tcpOptions{upgrade: other.upgrade})
+}
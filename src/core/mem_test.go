@@ -0,0 +1,50 @@
+package core
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCore_MemoryTransport checks that nodes in the same process can peer
+// over pipes, both directly and through a mem:// listener.
+func TestCore_MemoryTransport(t *testing.T) {
+	cfgA := GenerateConfig()
+	cfgA.Listen = []string{"mem://test-a"}
+	nodeA := new(Core)
+	if err := nodeA.Start(cfgA, GetLoggerWithPrefix("A: ", false)); err != nil {
+		t.Fatal(err)
+	}
+	defer nodeA.Stop()
+	var others []*Core
+	for _, prefix := range []string{"B: ", "C: "} {
+		cfg := GenerateConfig()
+		cfg.Listen = nil
+		node := new(Core)
+		if err := node.Start(cfg, GetLoggerWithPrefix(prefix, false)); err != nil {
+			t.Fatal(err)
+		}
+		defer node.Stop()
+		others = append(others, node)
+	}
+	if err := others[0].DialMemory(nodeA); err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("mem://test-a")
+	if err := others[1].CallPeer(u, ""); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50 && len(nodeA.GetPeers()) < 2; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	peers := nodeA.GetPeers()
+	if len(peers) != 2 {
+		t.Fatal("unexpected number of peers", len(peers))
+	}
+	for _, peer := range peers {
+		if !strings.HasPrefix(peer.Remote, "mem://") {
+			t.Errorf("peer %s is not over a pipe", peer.Remote)
+		}
+	}
+}
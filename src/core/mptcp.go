@@ -0,0 +1,46 @@
+package core
+
+// This file contains Multipath TCP support for TCP-based links, which is
+// turned on with MultipathTCP in the config. A Multipath TCP connection can
+// move between the addresses of a node, e.g. from Wi-Fi to LTE, so links stay
+// up without having to handshake again. Remote nodes that don't support it
+// just see plain TCP, and where the kernel doesn't support it links quietly
+// fall back to plain TCP too.
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+var errNoMPTCP = errors.New("multipath TCP is not supported")
+
+func (t *tcp) multipath() bool {
+	t.links.core.config.RLock()
+	defer t.links.core.config.RUnlock()
+	return t.links.core.config.MultipathTCP
+}
+
+// dialTCP dials a TCP link, with Multipath TCP if it's turned on.
+func (t *tcp) dialTCP(ctx context.Context, dialer *net.Dialer, dst *net.TCPAddr) (net.Conn, error) {
+	if t.multipath() {
+		conn, err := dialMPTCP(ctx, dialer, dst)
+		if !errors.Is(err, errNoMPTCP) {
+			return conn, err
+		}
+		t.links.core.log.Debugln("Dialling", dst, "with TCP:", err)
+	}
+	return dialer.DialContext(ctx, "tcp", dst.String())
+}
+
+// listenTCP listens for TCP links, with Multipath TCP if it's turned on.
+func (t *tcp) listenTCP(lc *net.ListenConfig, listenaddr string) (net.Listener, error) {
+	if t.multipath() {
+		listener, err := listenMPTCP(lc.Control, listenaddr)
+		if !errors.Is(err, errNoMPTCP) {
+			return listener, err
+		}
+		t.links.core.log.Debugln("Listening on", listenaddr, "with TCP:", err)
+	}
+	return lc.Listen(t.links.core.ctx, "tcp", listenaddr)
+}
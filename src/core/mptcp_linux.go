@@ -0,0 +1,51 @@
+//go:build linux
+// +build linux
+
+package core
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// mptcpError returns errNoMPTCP if a Multipath TCP socket couldn't be opened
+// because the kernel doesn't support it or has it turned off.
+func mptcpError(err error) error {
+	var serr *os.SyscallError
+	if errors.As(err, &serr) && serr.Syscall == "socket" {
+		switch serr.Err {
+		case unix.EPROTONOSUPPORT, unix.ENOPROTOOPT, unix.EINVAL:
+			return errNoMPTCP
+		}
+	}
+	return err
+}
+
+// dialMPTCP is like dialer.DialContext, but with Multipath TCP. The socket is
+// given to the dialer's Control function, and bound to its LocalAddr if set.
+func dialMPTCP(ctx context.Context, dialer *net.Dialer, dst *net.TCPAddr) (net.Conn, error) {
+	local, _ := dialer.LocalAddr.(*net.TCPAddr)
+	conn, err := dialInet(ctx, unix.IPPROTO_MPTCP, dst, local, func(_ int, raw syscall.RawConn) error {
+		if dialer.Control == nil {
+			return nil
+		}
+		return dialer.Control("tcp", dst.String(), raw)
+	})
+	return conn, mptcpError(err)
+}
+
+// listenMPTCP is like net.ListenConfig.Listen, but with Multipath TCP.
+func listenMPTCP(control func(string, string, syscall.RawConn) error, address string) (net.Listener, error) {
+	listener, err := listenInet(unix.IPPROTO_MPTCP, address, func(_ int, raw syscall.RawConn) error {
+		if control == nil {
+			return nil
+		}
+		return control("tcp", address, raw)
+	})
+	return listener, mptcpError(err)
+}
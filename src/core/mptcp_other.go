@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package core
+
+import (
+	"context"
+	"net"
+	"syscall"
+)
+
+func dialMPTCP(ctx context.Context, dialer *net.Dialer, dst *net.TCPAddr) (net.Conn, error) {
+	return nil, errNoMPTCP
+}
+
+func listenMPTCP(control func(string, string, syscall.RawConn) error, address string) (net.Listener, error) {
+	return nil, errNoMPTCP
+}
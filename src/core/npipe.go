@@ -0,0 +1,66 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// tcpnpipe hangs the Windows named pipe transport off of the TCP one, in the
+// same way as the UDP transport, so that nodes on the same machine or within
+// reach over SMB can peer without opening any ports. A peer URI such as
+// npipe://host/name refers to the pipe \\host\pipe\name, where the host is "."
+// for the local machine, which is the only host that listeners may use.
+type tcpnpipe struct {
+	tcp     *tcp
+	upgrade *TcpUpgrade
+}
+
+func (p *tcpnpipe) init(tcp *tcp) {
+	p.tcp = tcp
+	p.upgrade = &TcpUpgrade{
+		upgrade: func(c net.Conn, _ *tcpOptions) (net.Conn, error) { return c, nil },
+		name:    "npipe",
+		dial:    p.dial,
+	}
+}
+
+// pipePath returns the path of the named pipe that an npipe:// URI refers to.
+func pipePath(u *url.URL) (string, error) {
+	name := strings.Trim(u.Path, "/")
+	if u.Host == "" || name == "" {
+		return "", errors.New("npipe URIs must have a host and a pipe name")
+	}
+	return `\\` + u.Host + `\pipe\` + strings.ReplaceAll(name, "/", `\`), nil
+}
+
+// listen starts listening on the named pipe for the URI. The sddl option sets
+// the security descriptor of the pipe, as the default only lets the same user,
+// administrators and the system account connect.
+func (p *tcpnpipe) listen(u *url.URL) (*TcpListener, error) {
+	if u.Host != "." {
+		return nil, errors.New("npipe listeners must use the host \".\"")
+	}
+	path, err := pipePath(u)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := listenPipe(path, u.Query().Get("sddl"))
+	if err != nil {
+		return nil, err
+	}
+	l := TcpListener{
+		Listener: listener,
+		opts:     tcpOptions{upgrade: p.upgrade},
+		stop:     make(chan struct{}),
+	}
+	return &l, nil
+}
+
+func (p *tcpnpipe) dial(ctx context.Context, path string, _ *tcpOptions) (net.Conn, error) {
+	ctx, done := context.WithTimeout(ctx, default_timeout)
+	defer done()
+	return dialPipe(ctx, path)
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package core
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+var errNoPipes = errors.New("named pipes are only supported on Windows")
+
+func listenPipe(path, sddl string) (net.Listener, error) {
+	return nil, errNoPipes
+}
+
+func dialPipe(ctx context.Context, path string) (net.Conn, error) {
+	return nil, errNoPipes
+}
@@ -0,0 +1,28 @@
+//go:build windows
+// +build windows
+
+package core
+
+import (
+	"context"
+	"net"
+
+	"golang.org/x/sys/windows"
+	"golang.zx2c4.com/wireguard/ipc/winpipe"
+)
+
+func listenPipe(path, sddl string) (net.Listener, error) {
+	var config winpipe.ListenConfig
+	if sddl != "" {
+		sd, err := windows.SecurityDescriptorFromString(sddl)
+		if err != nil {
+			return nil, err
+		}
+		config.SecurityDescriptor = sd
+	}
+	return winpipe.Listen(path, &config)
+}
+
+func dialPipe(ctx context.Context, path string) (net.Conn, error) {
+	return winpipe.DialContext(ctx, path, nil)
+}
@@ -0,0 +1,67 @@
+package core
+
+// This file contains the hooks for pluggable transports, so that an
+// obfuscation layer such as obfs4 can wrap the connection of a link before
+// anything else is sent on it. The metadata exchange, and the TLS handshake of
+// tls:// links, are otherwise easy to fingerprint, which matters to users on
+// censored networks. No obfuscators are built in: applications embedding
+// Yggdrasil register them with SetObfuscator, and peers and listeners then
+// name one with the obfs option, e.g. tls://a.b.c.d:e?obfs=name.
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// Obfuscator wraps the connections of links. The wrapped connection carries
+// everything that would otherwise be sent on the connection, including the
+// TLS handshake if there is one, and it is closed when the link closes.
+type Obfuscator interface {
+	// Client wraps a connection that this node opened to a peer.
+	Client(conn net.Conn) (net.Conn, error)
+	// Server wraps a connection that a peer opened to this node.
+	Server(conn net.Conn) (net.Conn, error)
+}
+
+// SetObfuscator registers an obfuscator under the given name, for peers and
+// listeners with that name as their obfs option, replacing any obfuscator
+// already registered under it. Setting a nil Obfuscator removes it. Links that
+// are already up aren't affected, but links that name an obfuscator which
+// isn't registered fail to come up.
+func (c *Core) SetObfuscator(name string, o Obfuscator) {
+	c.links.mutex.Lock()
+	defer c.links.mutex.Unlock()
+	if c.links.obfuscators == nil {
+		c.links.obfuscators = make(map[string]Obfuscator)
+	}
+	if o != nil {
+		c.links.obfuscators[name] = o
+	} else {
+		delete(c.links.obfuscators, name)
+	}
+}
+
+// obfuscate wraps a connection with the named obfuscator, which must finish
+// any handshake of its own within the link handshake timeout.
+func (l *links) obfuscate(conn net.Conn, name string, incoming bool) (net.Conn, error) {
+	l.mutex.RLock()
+	o := l.obfuscators[name]
+	l.mutex.RUnlock()
+	if o == nil {
+		return nil, fmt.Errorf("no obfuscator named %q", name)
+	}
+	_ = conn.SetDeadline(time.Now().Add(linkHandshakeTimeout))
+	var wrapped net.Conn
+	var err error
+	if incoming {
+		wrapped, err = o.Server(conn)
+	} else {
+		wrapped, err = o.Client(conn)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("obfuscator %q failed: %w", name, err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return wrapped, nil
+}
@@ -0,0 +1,65 @@
+package core
+
+import (
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// xorObfuscator is a trivial Obfuscator, which is enough to make a link that
+// doesn't use it fail the metadata exchange.
+type xorObfuscator struct{}
+
+type xorConn struct{ net.Conn }
+
+func (c xorConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	for i := range p[:n] {
+		p[i] ^= 0x5a
+	}
+	return n, err
+}
+
+func (c xorConn) Write(p []byte) (int, error) {
+	b := make([]byte, len(p))
+	for i := range p {
+		b[i] = p[i] ^ 0x5a
+	}
+	return c.Conn.Write(b)
+}
+
+func (xorObfuscator) Client(conn net.Conn) (net.Conn, error) { return xorConn{conn}, nil }
+
+func (xorObfuscator) Server(conn net.Conn) (net.Conn, error) { return xorConn{conn}, nil }
+
+// TestCore_Obfuscator checks that links are wrapped with the obfuscator that
+// they name, so that only peers using the same one can link.
+func TestCore_Obfuscator(t *testing.T) {
+	cfgA := GenerateConfig()
+	cfgA.Listen = []string{"mem://obfs-a?obfs=xor"}
+	nodeA := new(Core)
+	nodeA.SetObfuscator("xor", xorObfuscator{})
+	if err := nodeA.Start(cfgA, GetLoggerWithPrefix("A: ", false)); err != nil {
+		t.Fatal(err)
+	}
+	defer nodeA.Stop()
+	for _, peer := range []string{"mem://obfs-a?obfs=xor", "mem://obfs-a"} {
+		cfg := GenerateConfig()
+		cfg.Listen = nil
+		node := new(Core)
+		node.SetObfuscator("xor", xorObfuscator{})
+		if err := node.Start(cfg, GetLoggerWithPrefix("B: ", false)); err != nil {
+			t.Fatal(err)
+		}
+		defer node.Stop()
+		u, _ := url.Parse(peer)
+		if err := node.CallPeer(u, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(time.Second)
+	if peers := nodeA.GetPeers(); len(peers) != 1 {
+		t.Fatal("unexpected number of peers", len(peers))
+	}
+}
@@ -0,0 +1,326 @@
+package core
+
+// This file contains the Tor transport. Listeners publish an onion service
+// through the control port of a local Tor, which forwards connections to a
+// TCP listener on the loopback interface, and the onion service goes away
+// again when the listener stops. Outbound links to onion services are dialled
+// through the SOCKS port of a local Tor.
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/proxy"
+)
+
+const (
+	defaultTorSOCKS   = "127.0.0.1:9050"
+	defaultOnionPort  = 9002 // The virtual port of the onion service
+	torSafeCookieKey  = "Tor safe cookie authentication controller-to-server hash"
+	torServerHashKey  = "Tor safe cookie authentication server-to-controller hash"
+	onionKeyDerivePre = "yggdrasil onion service key"
+)
+
+// tcponion hangs the Tor transport off of the TCP one. Links over Tor are just
+// TCP, but carrying an upgrade gives the links and listeners their names, and
+// its dial goes through the SOCKS port of Tor.
+type tcponion struct {
+	tcp     *tcp
+	upgrade *TcpUpgrade
+}
+
+func (o *tcponion) init(tcp *tcp) {
+	o.tcp = tcp
+	o.upgrade = &TcpUpgrade{
+		upgrade: func(c net.Conn, _ *tcpOptions) (net.Conn, error) { return c, nil },
+		name:    "onion",
+		dial:    o.dial,
+	}
+}
+
+// isOnion returns true if the host of the URI is an onion service.
+func isOnion(u *url.URL) bool {
+	return strings.HasSuffix(strings.ToLower(u.Hostname()), ".onion")
+}
+
+// onionAddr is the address of an onion service, which isn't an IP address and
+// so can't be a net.TCPAddr.
+type onionAddr string
+
+func (a onionAddr) Network() string { return "onion" }
+func (a onionAddr) String() string  { return string(a) }
+
+// onionConn reports the onion service as its remote address, rather than the
+// SOCKS port that it is really connected to.
+type onionConn struct {
+	net.Conn
+	remote onionAddr
+}
+
+func (c *onionConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (o *tcponion) dial(ctx context.Context, saddr string, options *tcpOptions) (net.Conn, error) {
+	dialer, err := proxy.SOCKS5("tcp", options.socksProxyAddr, options.socksProxyAuth, proxy.Direct)
+	if err != nil {
+		return nil, err
+	}
+	ctx, done := context.WithTimeout(ctx, default_timeout*5) // Onion circuits take a while to build
+	defer done()
+	conn, err := dialer.(proxy.ContextDialer).DialContext(ctx, "tcp", saddr)
+	if err != nil {
+		return nil, err
+	}
+	return &onionConn{Conn: conn, remote: onionAddr(saddr)}, nil
+}
+
+// onionListener removes the onion service, by closing the control connection
+// that it belongs to, when the listener is closed.
+type onionListener struct {
+	net.Listener
+	control *torControl
+}
+
+func (l *onionListener) Close() error {
+	l.control.conn.Close()
+	return l.Listener.Close()
+}
+
+// listen publishes an onion service through the Tor control port at the host
+// of the URI. The port option sets the virtual port of the onion service, and
+// the password option, or the password in the URI, is used to authenticate if
+// cookie authentication isn't available. The onion service key is derived from
+// the node key, so that the onion address stays the same over restarts, but in
+// a way that doesn't let anyone link the two.
+func (o *tcponion) listen(u *url.URL) (*TcpListener, error) {
+	port := defaultOnionPort
+	if p := u.Query().Get("port"); p != "" {
+		n, err := strconv.ParseUint(p, 10, 16)
+		if err != nil || n == 0 {
+			return nil, fmt.Errorf("onion listener %s has invalid port %q", u.String(), p)
+		}
+		port = int(n)
+	}
+	password := u.Query().Get("password")
+	if u.User != nil {
+		if p, ok := u.User.Password(); ok {
+			password = p
+		}
+	}
+	control, err := dialTorControl(u.Host, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the Tor control port: %w", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		control.conn.Close()
+		return nil, err
+	}
+	seed := sha512.Sum512(append([]byte(onionKeyDerivePre), o.tcp.links.core.secret.Seed()...))
+	key := sha512.Sum512(seed[:32])
+	key[0] &= 248
+	key[31] &= 127
+	key[31] |= 64
+	reply, err := control.command(fmt.Sprintf("ADD_ONION ED25519-V3:%s Flags=DiscardPK Port=%d,%s",
+		base64.StdEncoding.EncodeToString(key[:]), port, listener.Addr().String()))
+	if err != nil {
+		listener.Close()
+		control.conn.Close()
+		return nil, fmt.Errorf("failed to publish onion service: %w", err)
+	}
+	var service string
+	for _, line := range reply {
+		if strings.HasPrefix(line, "ServiceID=") {
+			service = strings.TrimPrefix(line, "ServiceID=")
+		}
+	}
+	o.tcp.links.core.log.Infof("Published onion service at onion://%s.onion:%d", service, port)
+	l := TcpListener{
+		Listener: &onionListener{listener, control},
+		opts:     tcpOptions{upgrade: o.upgrade},
+		stop:     make(chan struct{}),
+	}
+	return &l, nil
+}
+
+// torControl is a connection to the Tor control port, see control-spec.txt.
+type torControl struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialTorControl(addr, password string) (*torControl, error) {
+	conn, err := net.DialTimeout("tcp", addr, default_timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &torControl{conn: conn, reader: bufio.NewReader(conn)}
+	if err := c.authenticate(password); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// command sends a command and returns the lines of a successful reply, without
+// the status codes.
+func (c *torControl) command(cmd string) ([]string, error) {
+	if _, err := fmt.Fprintf(c.conn, "%s\r\n", cmd); err != nil {
+		return nil, err
+	}
+	var lines []string
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if len(line) < 4 {
+			return nil, fmt.Errorf("malformed reply %q", line)
+		}
+		status, sep, text := line[:3], line[3], line[4:]
+		if status != "250" {
+			return nil, fmt.Errorf("%s %s", status, text)
+		}
+		lines = append(lines, text)
+		switch sep {
+		case ' ':
+			return lines, nil
+		case '+':
+			// The data follows, up to a line with a single dot
+			for {
+				data, err := c.reader.ReadString('\n')
+				if err != nil {
+					return nil, err
+				}
+				if data = strings.TrimRight(data, "\r\n"); data == "." {
+					break
+				}
+				lines = append(lines, data)
+			}
+		}
+	}
+}
+
+// replyValues returns the key=value pairs in a reply line from Tor or from an
+// I2P SAM bridge, skipping any words without values and unquoting any quoted
+// values.
+func replyValues(line string) map[string]string {
+	values := make(map[string]string)
+	for line = strings.TrimSpace(line); line != ""; line = strings.TrimSpace(line) {
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			break
+		}
+		if sp := strings.IndexByte(line, ' '); sp >= 0 && sp < eq {
+			line = line[sp:] // A word without a value
+			continue
+		}
+		key, rest := line[:eq], line[eq+1:]
+		if strings.HasPrefix(rest, `"`) {
+			end := 1
+			for end < len(rest) && rest[end] != '"' {
+				if rest[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(rest) {
+				break
+			}
+			if value, err := strconv.Unquote(rest[:end+1]); err == nil {
+				values[key] = value
+			}
+			line = rest[end+1:]
+			continue
+		}
+		end := strings.IndexByte(rest, ' ')
+		if end < 0 {
+			end = len(rest)
+		}
+		values[key] = rest[:end]
+		line = rest[end:]
+	}
+	return values
+}
+
+func (c *torControl) authenticate(password string) error {
+	reply, err := c.command("PROTOCOLINFO 1")
+	if err != nil {
+		return err
+	}
+	methods, cookiefile := map[string]bool{}, ""
+	for _, line := range reply {
+		if strings.HasPrefix(line, "AUTH ") {
+			values := replyValues(line)
+			for _, m := range strings.Split(values["METHODS"], ",") {
+				methods[m] = true
+			}
+			cookiefile = values["COOKIEFILE"]
+		}
+	}
+	switch {
+	case methods["NULL"]:
+		_, err = c.command("AUTHENTICATE")
+	case password != "" && methods["HASHEDPASSWORD"]:
+		_, err = c.command("AUTHENTICATE " + strconv.Quote(password))
+	case methods["SAFECOOKIE"] && cookiefile != "":
+		err = c.safeCookie(cookiefile)
+	case methods["COOKIE"] && cookiefile != "":
+		var cookie []byte
+		if cookie, err = ioutil.ReadFile(cookiefile); err == nil {
+			_, err = c.command("AUTHENTICATE " + hex.EncodeToString(cookie))
+		}
+	default:
+		err = errors.New("no supported authentication method, a password may be needed")
+	}
+	return err
+}
+
+func (c *torControl) safeCookie(cookiefile string) error {
+	cookie, err := ioutil.ReadFile(cookiefile)
+	if err != nil {
+		return err
+	}
+	clientNonce := make([]byte, 32)
+	if _, err := rand.Read(clientNonce); err != nil {
+		return err
+	}
+	reply, err := c.command("AUTHCHALLENGE SAFECOOKIE " + hex.EncodeToString(clientNonce))
+	if err != nil {
+		return err
+	}
+	values := replyValues(reply[0])
+	serverHash, err := hex.DecodeString(values["SERVERHASH"])
+	if err != nil {
+		return err
+	}
+	serverNonce, err := hex.DecodeString(values["SERVERNONCE"])
+	if err != nil {
+		return err
+	}
+	message := append(append(append([]byte(nil), cookie...), clientNonce...), serverNonce...)
+	mac := hmac.New(sha256.New, []byte(torServerHashKey))
+	mac.Write(message)
+	if !hmac.Equal(mac.Sum(nil), serverHash) {
+		return errors.New("tor did not prove that it knows the cookie")
+	}
+	mac = hmac.New(sha256.New, []byte(torSafeCookieKey))
+	mac.Write(message)
+	_, err = c.command("AUTHENTICATE " + hex.EncodeToString(mac.Sum(nil)))
+	return err
+}
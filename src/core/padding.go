@@ -0,0 +1,143 @@
+package core
+
+// This file contains the padding and cover traffic, for users who need some
+// resistance to traffic analysis of particular peerings. A link with the
+// padding option pads everything that it sends up to one of a few fixed sizes,
+// by following each frame with a dummy frame of filler, so that the sizes of
+// writes say little about what is in them. A link with the cover option also
+// sends dummy frames of random sizes at random times, at the given average
+// rate, so that the timing of writes says less about when there is traffic.
+// Ironwood ignores dummy frames, so the remote node doesn't need either option.
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	paddingFrameMin = 3         // The smallest dummy frame, which is only its length and type
+	paddingFrameMax = 2 + 65535 // The largest frame of any kind
+)
+
+// paddingDefault holds the sizes that padding=true pads up to.
+var paddingDefault = []int{256, 1024, 4096, 16384}
+
+// parsePadding reads the padding option of a peer or listener URI, which is
+// either true, for the default sizes, or a list of the sizes in bytes to pad
+// writes up to, e.g. 512,1500,16384.
+func parsePadding(u *url.URL) ([]int, error) {
+	s := u.Query().Get("padding")
+	if s == "" {
+		return nil, nil
+	}
+	if on, err := strconv.ParseBool(s); err == nil {
+		if !on {
+			return nil, nil
+		}
+		return paddingDefault, nil
+	}
+	var sizes []int
+	for _, f := range strings.Split(s, ",") {
+		size, err := strconv.Atoi(f)
+		if err != nil {
+			return nil, err
+		}
+		if size < paddingFrameMin || size > paddingFrameMax {
+			return nil, errors.New("padding sizes must be from 3 to 65537")
+		}
+		sizes = append(sizes, size)
+	}
+	sort.Ints(sizes)
+	return sizes, nil
+}
+
+// parseCover reads the cover option of a peer or listener URI, which is the
+// average number of dummy frames to send each second.
+func parseCover(u *url.URL) (float64, error) {
+	s := u.Query().Get("cover")
+	if s == "" {
+		return 0, nil
+	}
+	rate, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if rate < 0 || rate > 1000 {
+		return 0, errors.New("cover must be from 0 to 1000 frames per second")
+	}
+	return rate, nil
+}
+
+// paddedSize returns the size to pad a write of n bytes up to, which is the
+// smallest of the sizes that leaves either no room or room for a dummy frame.
+// Writes larger than all of the sizes are padded to a multiple of the largest.
+func paddedSize(n int, sizes []int) int {
+	for _, size := range sizes {
+		if size == n || size >= n+paddingFrameMin {
+			return size
+		}
+	}
+	largest := sizes[len(sizes)-1]
+	size := (n + largest - 1) / largest * largest
+	for size != n && size < n+paddingFrameMin {
+		size += largest
+	}
+	return size
+}
+
+// appendFiller appends n bytes of dummy frames, where n is at least
+// paddingFrameMin.
+func appendFiller(bs []byte, n int) []byte {
+	for n > 0 {
+		size := n
+		if size > paddingFrameMax {
+			size = paddingFrameMax
+			if n-size < paddingFrameMin {
+				size = n - paddingFrameMin
+			}
+		}
+		start := len(bs)
+		bs = append(bs, make([]byte, size)...)
+		binary.BigEndian.PutUint16(bs[start:], uint16(size-2))
+		n -= size
+	}
+	return bs
+}
+
+// pad returns the frame followed by enough filler to reach one of the sizes.
+func pad(frame []byte, sizes []int) []byte {
+	size := paddedSize(len(frame), sizes)
+	if size == len(frame) {
+		return frame
+	}
+	padded := make([]byte, len(frame), size)
+	copy(padded, frame)
+	return appendFiller(padded, size-len(frame))
+}
+
+// sendCover sends dummy frames, of the padding sizes if any, with random gaps
+// that average out to the given rate, until the link closes.
+func (intf *link) sendCover(rate float64) {
+	sizes := intf.options.padding
+	if len(sizes) == 0 {
+		sizes = paddingDefault
+	}
+	for {
+		gap := time.Duration(rand.ExpFloat64() / rate * float64(time.Second))
+		select {
+		case <-intf.closed:
+			return
+		case <-intf.links.core.clock.After(gap):
+		}
+		size := sizes[rand.Intn(len(sizes))]
+		if _, err := intf.conn.Write(appendFiller(nil, size)); err != nil {
+			return
+		}
+	}
+}
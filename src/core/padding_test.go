@@ -0,0 +1,58 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestCore_Padding(t *testing.T) {
+	cfgA, cfgB := GenerateConfig(), GenerateConfig()
+	cfgA.Listen = []string{"mem://padding"}
+	cfgB.Peers = []string{"mem://padding?padding=512,2048,16384&cover=50"}
+	nodeA, nodeB := new(Core), new(Core)
+	if err := nodeA.Start(cfgA, GetLoggerWithPrefix("A: ", false)); err != nil {
+		t.Fatal(err)
+	}
+	defer nodeA.Stop()
+	if err := nodeB.Start(cfgB, GetLoggerWithPrefix("B: ", false)); err != nil {
+		t.Fatal(err)
+	}
+	defer nodeB.Stop()
+	if !WaitConnected(nodeA, nodeB) {
+		t.Fatal("nodes did not connect")
+	}
+	// A doesn't pad, and ignores the filler and cover that B sends
+	CheckEcho(t, nodeA, nodeB)
+	time.Sleep(100 * time.Millisecond)
+	// Everything after the metadata is padded
+	if tx := nodeB.GetPeers()[0].TXBytes - uint64(version_getMetaLength()); tx%512 != 0 {
+		t.Fatal("sent bytes are not padded", tx)
+	}
+}
+
+func TestPadding(t *testing.T) {
+	sizes := []int{256, 1024}
+	for _, n := range []int{3, 100, 254, 256, 1022, 1024, 1500, 2047, 2048, 70000} {
+		size := paddedSize(n, sizes)
+		if size < n || (size != n && size-n < paddingFrameMin) || (size > 1024 && size%1024 != 0) {
+			t.Fatal("bad padded size for", n, size)
+		}
+	}
+	frame := []byte{0x00, 0x05, bundlePathTraffic, 1, 2, 3, 4}
+	padded := pad(frame, sizes)
+	if len(padded) != 256 || !bytes.Equal(padded[:len(frame)], frame) {
+		t.Fatal("frame was not padded", len(padded))
+	}
+	// The filler is whole dummy frames
+	r := bytes.NewReader(padded[len(frame):])
+	for r.Len() > 0 {
+		filler, err := readBundleFrame(r)
+		if err != nil || filler[2] != 0x00 {
+			t.Fatal("filler is not dummy frames", err)
+		}
+	}
+	if f := appendFiller(nil, 2*paddingFrameMax+1); len(f) != 2*paddingFrameMax+1 {
+		t.Fatal("bad filler length", len(f))
+	}
+}
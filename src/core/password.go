@@ -0,0 +1,194 @@
+package core
+
+// This file contains the passwords of peerings. A peer or listener with the
+// password option only completes links with nodes that know the password,
+// whatever their keys are. Once the metadata has been exchanged, each side
+// sends a random nonce and whether it is listening, and then signs a hash of
+// both keys and the nonce of the other side, keyed with the password. This
+// proves both that the remote node knows the password and that it holds the
+// key that it sent, without the password itself ever being sent. The
+// messages are dummy frames, so a node that has no password for the link
+// ignores them, and the link fails on this side as soon as something else
+// arrives instead.
+//
+// The calling side sends its proof first, and the listening side only sends
+// its own once it has checked that one, so a node that calls a listener
+// learns nothing that it could guess the password from offline. A node that
+// is called, or anyone watching a link that isn't encrypted, still sees a
+// proof, so the key that proofs are made with is derived from the password
+// with scrypt, salted with the key of the listening side, to make guessing
+// expensive. Passwords should still be long random keys rather than words.
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	passwordMaxLength = 64
+	passwordNonceSize = 32
+	passwordKeysMax   = 64 // Derived keys to remember before starting again
+)
+
+// The prefixes of the frames that carry the nonce and the signature.
+var (
+	passwordNonce = []byte{0x00, 0x00, 0x00, 'p', 's', 'k', ':'}
+	passwordProof = []byte{0x00, 0x00, 0x00, 'p', 's', 'k', '!'}
+)
+
+// checkPassword returns an error if a password can't be used.
+func checkPassword(password string) error {
+	if len(password) > passwordMaxLength {
+		return fmt.Errorf("password must be at most %d bytes", passwordMaxLength)
+	}
+	return nil
+}
+
+// passwordFrame returns a dummy frame with the given prefix and body.
+func passwordFrame(prefix, body []byte) []byte {
+	frame := append(append([]byte(nil), prefix...), body...)
+	binary.BigEndian.PutUint16(frame, uint16(len(frame)-2))
+	return frame
+}
+
+// passwordKeyID is used as a map key.
+type passwordKeyID struct {
+	password string
+	listener keyArray
+}
+
+// passwordKeys remembers the keys derived from passwords, so that scrypt
+// only runs once for each password and listening node.
+type passwordKeys struct {
+	mutex sync.Mutex
+	keys  map[passwordKeyID][]byte
+}
+
+// get returns the key derived from the password for links to or from the
+// listening node with the given key.
+func (k *passwordKeys) get(password string, listener ed25519.PublicKey) ([]byte, error) {
+	id := passwordKeyID{password: password}
+	copy(id.listener[:], listener)
+	k.mutex.Lock()
+	key, isIn := k.keys[id]
+	k.mutex.Unlock()
+	if isIn {
+		return key, nil
+	}
+	key, err := scrypt.Key([]byte(password), listener, identityScryptN, identityScryptR, identityScryptP, blake2b.Size256)
+	if err != nil {
+		return nil, err
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if k.keys == nil || len(k.keys) >= passwordKeysMax {
+		k.keys = make(map[passwordKeyID][]byte)
+	}
+	k.keys[id] = key
+	return key, nil
+}
+
+// passwordHash returns the hash that the node with the first key signs, to
+// prove to the node with the second key, which sent the nonce, that it knows
+// the password that the key was derived from.
+func passwordHash(key []byte, signer, verifier ed25519.PublicKey, nonce []byte) []byte {
+	h, _ := blake2b.New256(key) // Only fails if the key is too long
+	_, _ = h.Write(signer)
+	_, _ = h.Write(verifier)
+	_, _ = h.Write(nonce)
+	return h.Sum(nil)
+}
+
+// readPasswordFrame reads a frame that must have the given prefix and body
+// length.
+func (intf *link) readPasswordFrame(prefix []byte, size int) ([]byte, error) {
+	frame, err := readBundleFrame(intf.conn)
+	if err != nil {
+		return nil, err
+	}
+	if len(frame) != len(prefix)+size || !bytes.Equal(frame[2:len(prefix)], prefix[2:]) {
+		return nil, errors.New("remote node did not send a password")
+	}
+	return frame[len(prefix):], nil
+}
+
+// checkRemotePassword proves to the remote node that this node knows the
+// password of the link, and checks that the remote node knows it too.
+func (intf *link) checkRemotePassword(remote ed25519.PublicKey) error {
+	clk := intf.links.core.clock
+	local := intf.links.core.public
+	ours := make([]byte, passwordNonceSize+1)
+	if _, err := rand.Read(ours[:passwordNonceSize]); err != nil {
+		return err
+	}
+	if intf.incoming {
+		ours[passwordNonceSize] = 1
+	}
+	// The closures have their own errors, as they keep running after a timeout
+	var theirs []byte
+	var nonceErr error
+	if !funcTimeout(clk, linkHandshakeTimeout, func() {
+		if _, err := intf.conn.Write(passwordFrame(passwordNonce, ours)); err != nil {
+			nonceErr = err
+			return
+		}
+		theirs, nonceErr = intf.readPasswordFrame(passwordNonce, passwordNonceSize+1)
+	}) {
+		return errors.New("timeout on password exchange")
+	}
+	if nonceErr != nil {
+		return nonceErr
+	}
+	// The listening side is the one that was called, or the one with the lower
+	// key if both sides called, as over serial lines. It goes second.
+	listening := intf.incoming
+	switch remoteIncoming := theirs[passwordNonceSize] != 0; {
+	case intf.incoming && remoteIncoming:
+		return errors.New("remote node is also listening")
+	case !intf.incoming && !remoteIncoming:
+		listening = bytes.Compare(local, remote) < 0
+	}
+	listener := remote
+	if listening {
+		listener = local
+	}
+	key, err := intf.links.passwords.get(intf.options.password, listener)
+	if err != nil {
+		return err
+	}
+	send := func() error {
+		hash := passwordHash(key, local, remote, theirs[:passwordNonceSize])
+		_, err := intf.conn.Write(passwordFrame(passwordProof, ed25519.Sign(intf.links.core.secret, hash)))
+		return err
+	}
+	var proofErr error
+	if !funcTimeout(clk, linkHandshakeTimeout, func() {
+		if !listening {
+			if proofErr = send(); proofErr != nil {
+				return
+			}
+		}
+		var sig []byte
+		if sig, proofErr = intf.readPasswordFrame(passwordProof, ed25519.SignatureSize); proofErr != nil {
+			return
+		}
+		if !ed25519.Verify(remote, passwordHash(key, remote, local, ours[:passwordNonceSize]), sig) {
+			proofErr = errors.New("remote node sent the wrong password")
+			return
+		}
+		if listening {
+			proofErr = send()
+		}
+	}) {
+		return errors.New("timeout on password exchange")
+	}
+	return proofErr
+}
@@ -0,0 +1,99 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCore_Password(t *testing.T) {
+	for name, tc := range map[string]struct {
+		listen, peer string
+		connects     bool
+	}{
+		"same":    {"mem://password-same?password=a", "mem://password-same?password=a", true},
+		"wrong":   {"mem://password-wrong?password=a", "mem://password-wrong?password=b", false},
+		"listen":  {"mem://password-listen?password=a", "mem://password-listen", false},
+		"peer":    {"mem://password-peer", "mem://password-peer?password=a", false},
+		"neither": {"mem://password-neither", "mem://password-neither", true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfgA, cfgB := GenerateConfig(), GenerateConfig()
+			cfgA.Listen = []string{tc.listen}
+			cfgB.Peers = []string{tc.peer}
+			nodeA, nodeB := new(Core), new(Core)
+			if err := nodeA.Start(cfgA, GetLoggerWithPrefix("A: ", false)); err != nil {
+				t.Fatal(err)
+			}
+			defer nodeA.Stop()
+			if err := nodeB.Start(cfgB, GetLoggerWithPrefix("B: ", false)); err != nil {
+				t.Fatal(err)
+			}
+			defer nodeB.Stop()
+			if tc.connects {
+				if !WaitConnected(nodeA, nodeB) {
+					t.Fatal("nodes did not connect")
+				}
+				CheckEcho(t, nodeA, nodeB)
+				return
+			}
+			time.Sleep(time.Second)
+			if len(nodeA.GetPeers()) != 0 || len(nodeB.GetPeers()) != 0 {
+				t.Fatal("nodes connected without the same password")
+			}
+		})
+	}
+}
+
+// TestLink_PasswordListenerGoesSecond checks that a listener doesn't send its
+// proof of the password to a caller that sent a wrong one.
+func TestLink_PasswordListenerGoesSecond(t *testing.T) {
+	for name, password := range map[string]string{"right": "a", "wrong": "b"} {
+		t.Run(name, func(t *testing.T) {
+			pub, priv, _ := ed25519.GenerateKey(nil)
+			c := &Core{clock: systemClock{}, log: GetLoggerWithPrefix("", false), public: pub, secret: priv}
+			c.links.core = c
+			local, remote := net.Pipe()
+			defer remote.Close()
+			intf, err := c.links.create(local, "pipe", "pipe", "", "pipe", true, false, linkOptions{password: "a"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			callerPub, callerPriv, _ := ed25519.GenerateKey(nil)
+			result := make(chan error, 1)
+			go func() {
+				result <- intf.checkRemotePassword(callerPub)
+				local.Close()
+			}()
+			nonce := make([]byte, passwordNonceSize+1)
+			go remote.Write(passwordFrame(passwordNonce, nonce)) // Both sides send first
+			frame, err := readBundleFrame(remote)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if frame[len(frame)-1] != 1 {
+				t.Fatal("listener did not say that it is listening")
+			}
+			theirs := frame[len(passwordNonce) : len(frame)-1]
+			key, err := c.links.passwords.get(password, pub)
+			if err != nil {
+				t.Fatal(err)
+			}
+			proof := ed25519.Sign(callerPriv, passwordHash(key, callerPub, pub, theirs))
+			if _, err := remote.Write(passwordFrame(passwordProof, proof)); err != nil {
+				t.Fatal(err)
+			}
+			frame, err = readBundleFrame(remote)
+			if err = <-result; (err == nil) != (password == "a") {
+				t.Fatal("unexpected result of password exchange:", err)
+			}
+			if password != "a" && frame != nil {
+				t.Fatal("listener sent its proof after a wrong one")
+			}
+			if password == "a" && !ed25519.Verify(pub, passwordHash(key, pub, callerPub, nonce[:passwordNonceSize]), frame[len(passwordProof):]) {
+				t.Fatal("listener sent a wrong proof")
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package core
+
+// This file contains the per-link penalty and the part of it that comes from
+// loss, while the part that comes from latency is in latency.go. The penalty
+// doesn't change which links traffic is routed over, as ironwood has no notion
+// of link costs. It is reported by getPeers and getLinks, so that operators
+// can spot links that are flaky, and MaxPeers evicts the incoming links with
+// the highest penalties first.
+
+import (
+	"math"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	lossSampleInterval = 10 * time.Second
+	lossThreshold      = 0.01  // Smoothed fraction of segments retransmitted before we penalise the link
+	lossClearThreshold = 0.002 // Smoothed fraction that the loss must fall below before the penalty decays
+	lossSmoothing      = 4     // Each sample moves the smoothed loss by 1/lossSmoothing of the difference
+	lossPenaltyStep    = 16    // How much the penalty is raised (or decayed) per sample
+	lossPenaltyMax     = 128   // Upper bound on the penalty that loss can add
+)
+
+// linkPenalty tracks the configured penalty of a link along with what has been
+// added to it automatically as a result of observed loss, latency or quota.
+type linkPenalty struct {
+	// fromLoss is at the beginning of the struct to ensure 64-bit alignment
+	// on 32-bit platforms, see https://pkg.go.dev/sync/atomic#pkg-note-BUG
+	fromLoss uint64
+	loss     uint64 // The bits of the smoothed loss as a float64
+	quota    uint64 // Set while over the soft traffic quota
+	latency  int64  // Smoothed round trip time in nanoseconds, if measured
+	base     uint8
+	rtts     linkRTTs // The most recent round trip times, see stats.go
+}
+
+// total returns the configured penalty plus any loss, quota or latency
+// penalty.
+func (m *linkPenalty) total() uint64 {
+	return uint64(m.base) + atomic.LoadUint64(&m.fromLoss) + atomic.LoadUint64(&m.quota) + m.latencyPenalty()
+}
+
+// smoothedLoss returns the smoothed fraction of segments retransmitted, or 0
+// if the link doesn't measure it.
+func (m *linkPenalty) smoothedLoss() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&m.loss))
+}
+
+// update adjusts the penalty based on the fraction of segments that had to be
+// retransmitted since the last sample. The samples are smoothed, and while the
+// smoothed loss is above lossThreshold each sample raises the penalty by a
+// single step, so that a brief burst of loss doesn't immediately cause a large
+// jump in the penalty. The penalty only decays again once the smoothed loss has
+// fallen below lossClearThreshold, and is held in between, so that a link
+// with loss hovering around the threshold doesn't flap between penalties.
+func (m *linkPenalty) update(loss float64) {
+	smoothed := m.smoothedLoss()
+	smoothed += (loss - smoothed) / lossSmoothing
+	atomic.StoreUint64(&m.loss, math.Float64bits(smoothed))
+	penalty := atomic.LoadUint64(&m.fromLoss)
+	switch {
+	case smoothed > lossThreshold && penalty < lossPenaltyMax:
+		penalty += lossPenaltyStep
+	case smoothed < lossClearThreshold && penalty >= lossPenaltyStep:
+		penalty -= lossPenaltyStep
+	case smoothed < lossClearThreshold:
+		penalty = 0
+	}
+	atomic.StoreUint64(&m.fromLoss, penalty)
+}
+
+// monitorLoss periodically samples the retransmission counters of the raw
+// socket underneath the link until the link closes. This only does anything
+// on platforms where tcpRetransmits is able to return statistics.
+func (intf *link) monitorLoss(raw net.Conn) {
+	lastRetrans, _, ok := tcpRetransmits(raw)
+	if !ok {
+		return
+	}
+	lastTX := atomic.LoadUint64(&intf.conn.tx)
+	ticker := intf.links.core.clock.NewTicker(lossSampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-intf.closed:
+			return
+		case <-ticker.Chan():
+		}
+		retrans, mss, ok := tcpRetransmits(raw)
+		if !ok || mss == 0 {
+			continue
+		}
+		tx := atomic.LoadUint64(&intf.conn.tx)
+		segments := (tx - lastTX) / uint64(mss)
+		if segments == 0 {
+			segments = 1
+		}
+		intf.penalty.update(float64(retrans-lastRetrans) / float64(segments))
+		lastRetrans, lastTX = retrans, tx
+	}
+}
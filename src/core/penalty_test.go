@@ -0,0 +1,32 @@
+package core
+
+import (
+	"testing"
+)
+
+func TestLinkPenalty_Loss(t *testing.T) {
+	var m linkPenalty
+	for i := 0; i < 4; i++ {
+		m.update(0.05)
+	}
+	raised := m.total()
+	if raised == 0 {
+		t.Fatal("lossy samples did not raise the penalty")
+	}
+	// Loss between the thresholds holds the penalty where it is
+	for i := 0; i < 20; i++ {
+		m.update(0.005)
+		if e := m.total(); e < raised {
+			t.Fatal("penalty decayed before the loss cleared", e, raised)
+		}
+	}
+	for i := 0; i < 50; i++ {
+		m.update(0)
+	}
+	if e := m.total(); e != 0 {
+		t.Fatal("penalty did not decay once the loss cleared", e)
+	}
+	if l := m.smoothedLoss(); l >= lossClearThreshold {
+		t.Fatal("smoothed loss did not fall", l)
+	}
+}
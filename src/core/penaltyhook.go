@@ -0,0 +1,148 @@
+package core
+
+// This file contains the penalty hook, which lets operators set the penalty of
+// each link by policy, such as keeping links on eth1 ahead of others when
+// MaxPeers evicts links, without recompiling. Penalties don't affect which
+// links traffic is routed over, see penalty.go.
+// As a link comes up, once the key of the remote node is known, the hook is
+// asked for the penalty of the link, and whatever it answers replaces the one
+// from the penalty option of the URI. Applications that embed a node can set a
+// Go function with SetPenaltyHook. Otherwise, the PenaltyHook config is the
+// path of a program that is run with the details of the link in its
+// environment, and prints the penalty, or nothing to leave it as it is.
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const penaltyHookTimeout = 5 * time.Second // How long the PenaltyHook program may take to answer
+
+// PenaltyRequest describes a link that the penalty hook is asked about.
+type PenaltyRequest struct {
+	Type      string // The type of the link, e.g. tcp or tls
+	Name      string // The name of the link, as in GetLinks
+	Local     string // The local address of the link
+	Remote    string // The remote address of the link
+	Interface string // The local network interface of the link, if it could be found
+	Key       ed25519.PublicKey
+	Incoming  bool
+	Penalty   uint8 // The penalty that the link would otherwise have
+}
+
+// PenaltyFunc returns the penalty for a link, or false to leave the penalty as
+// it is. It must return quickly, as the link waits for it.
+type PenaltyFunc func(PenaltyRequest) (uint8, bool)
+
+// SetPenaltyHook sets a function to ask for the penalty of each link as it comes
+// up. This takes the place of the PenaltyHook config, and nil goes back to it.
+func (c *Core) SetPenaltyHook(hook PenaltyFunc) {
+	c.links.mutex.Lock()
+	defer c.links.mutex.Unlock()
+	c.links.penaltyHook = hook
+}
+
+// askPenalty sets the penalty of the link from the penalty hook, if there is one.
+func (intf *link) askPenalty() {
+	intf.links.mutex.RLock()
+	hook := intf.links.penaltyHook
+	intf.links.mutex.RUnlock()
+	intf.links.core.config.RLock()
+	program := intf.links.core.config.PenaltyHook
+	intf.links.core.config.RUnlock()
+	if hook == nil && program == "" {
+		return
+	}
+	req := PenaltyRequest{
+		Type:      intf.info.linkType,
+		Name:      intf.name(),
+		Local:     intf.info.local,
+		Remote:    intf.info.remote,
+		Interface: localInterface(intf.info.local),
+		Key:       append(ed25519.PublicKey(nil), intf.info.key[:]...),
+		Incoming:  intf.incoming,
+		Penalty:   intf.penalty.base,
+	}
+	var penalty uint8
+	var ok bool
+	if hook != nil {
+		penalty, ok = hook(req)
+	} else {
+		var err error
+		if penalty, ok, err = runPenaltyHook(program, req); err != nil {
+			intf.links.core.log.Warnf("PenaltyHook failed for link %s: %s", intf.name(), err)
+			return
+		}
+	}
+	if ok && penalty != intf.penalty.base {
+		intf.links.core.log.Debugf("Penalty of link %s set to %d by the penalty hook", intf.name(), penalty)
+		intf.penalty.base = penalty
+	}
+}
+
+// runPenaltyHook runs the PenaltyHook program and reads the penalty that it
+// prints, if any.
+func runPenaltyHook(program string, req PenaltyRequest) (uint8, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), penaltyHookTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, program)
+	cmd.Env = append(os.Environ(),
+		"YGGDRASIL_LINK_TYPE="+req.Type,
+		"YGGDRASIL_LINK_NAME="+req.Name,
+		"YGGDRASIL_LINK_LOCAL="+req.Local,
+		"YGGDRASIL_LINK_REMOTE="+req.Remote,
+		"YGGDRASIL_LINK_INTERFACE="+req.Interface,
+		"YGGDRASIL_LINK_KEY="+hex.EncodeToString(req.Key),
+		"YGGDRASIL_LINK_INCOMING="+strconv.FormatBool(req.Incoming),
+		"YGGDRASIL_LINK_PENALTY="+strconv.Itoa(int(req.Penalty)),
+	)
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, false, err
+	}
+	s := strings.TrimSpace(string(out))
+	if s == "" {
+		return 0, false, nil
+	}
+	penalty, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid penalty %q", s)
+	}
+	return uint8(penalty), true, nil
+}
+
+// localInterface returns the name of the network interface with the given
+// local address, or an empty string if there isn't one.
+func localInterface(local string) string {
+	if i := strings.LastIndex(local, "%"); i >= 0 {
+		return local[i+1:] // Link-local addresses name their interface
+	}
+	ip := net.ParseIP(local)
+	if ip == nil {
+		return ""
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+				return iface.Name
+			}
+		}
+	}
+	return ""
+}
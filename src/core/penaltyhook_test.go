@@ -0,0 +1,60 @@
+package core
+
+import (
+	"bytes"
+	"net/url"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestCore_PenaltyHook(t *testing.T) {
+	nodeA := new(Core)
+	if err := nodeA.Start(GenerateConfig(), GetLoggerWithPrefix("A: ", false)); err != nil {
+		t.Fatal(err)
+	}
+	defer nodeA.Stop()
+	asked := make(chan PenaltyRequest, 1)
+	nodeA.SetPenaltyHook(func(req PenaltyRequest) (uint8, bool) {
+		asked <- req
+		return 42, true
+	})
+	cfgB := GenerateConfig()
+	if runtime.GOOS != "windows" {
+		// The program answers for outbound links only
+		cfgB.PenaltyHook = t.TempDir() + "/penalty.sh"
+		script := "#!/bin/sh\n[ \"$YGGDRASIL_LINK_INCOMING\" = false ] && echo 7\n"
+		if err := os.WriteFile(cfgB.PenaltyHook, []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	nodeB := new(Core)
+	if err := nodeB.Start(cfgB, GetLoggerWithPrefix("B: ", false)); err != nil {
+		t.Fatal(err)
+	}
+	defer nodeB.Stop()
+	u, _ := url.Parse("tcp://" + nodeA.links.tcp.getAddr().String())
+	if err := nodeB.CallPeer(u, ""); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case req := <-asked:
+		if !req.Incoming || !bytes.Equal(req.Key, nodeB.PublicKey()) || req.Interface == "" {
+			t.Fatalf("unexpected request %+v", req)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("penalty hook was not asked")
+	}
+	if !WaitConnected(nodeA, nodeB) {
+		t.Fatal("nodes did not connect")
+	}
+	for node, penalty := range map[*Core]uint64{nodeA: 42, nodeB: 7} {
+		if node == nodeB && cfgB.PenaltyHook == "" {
+			continue
+		}
+		if peers := node.GetPeers(); len(peers) != 1 || peers[0].Penalty != penalty {
+			t.Errorf("wrong penalty %+v", peers)
+		}
+	}
+}
@@ -37,6 +37,7 @@ type protoHandler struct {
 
 	core     *Core
 	nodeinfo nodeinfo
+	services services
 
 	selfRequests  map[keyArray]*reqInfo
 	peersRequests map[keyArray]*reqInfo
@@ -46,6 +47,7 @@ type protoHandler struct {
 func (p *protoHandler) init(core *Core) {
 	p.core = core
 	p.nodeinfo.init(p)
+	p.services.init(p)
 
 	p.selfRequests = make(map[keyArray]*reqInfo)
 	p.peersRequests = make(map[keyArray]*reqInfo)
@@ -64,6 +66,10 @@ func (p *protoHandler) handleProto(from phony.Actor, key keyArray, bs []byte) {
 		p.nodeinfo.handleReq(p, key)
 	case typeProtoNodeInfoResponse:
 		p.nodeinfo.handleRes(p, key, bs[1:])
+	case typeProtoServiceRequest:
+		p.services.handleReq(p, key, bs[1:])
+	case typeProtoServiceResponse:
+		p.services.handleRes(p, key, bs[1:])
 	case typeProtoDebug:
 		p.handleDebug(from, key, bs[1:])
 	}
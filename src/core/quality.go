@@ -0,0 +1,208 @@
+package core
+
+// This file contains the scoring of configured peers, so that a node with many
+// peers stops wasting calls on the ones that serve it badly. Each peer is
+// scored from 0 to 100 on how much of the time that it was meant to be
+// connected it actually was, which leaves out any time on standby, how often
+// its links have dropped soon after coming up, the round trip time of its
+// links, if they measure it, and how much traffic they carried compared with
+// the links to the other peers. A peer that scores below peerDemoteScore for
+// peerDemoteAfter is demoted for peerDemotion, which means that it is only
+// called while none of the peers that aren't demoted are connected, and it
+// then starts again with a clean record. Preferred peers are never demoted.
+// The scores, and what went into them, are in getPeerRetries.
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const (
+	peerFlapTime    = time.Minute           // Links that drop sooner than this after coming up are flaps
+	peerFlapWindow  = time.Hour             // How long flaps count against a peer
+	peerFlapLimit   = 5                     // Flaps within the window that lose all of the points for flapping
+	peerLatencyGood = 50 * time.Millisecond // Round trip times up to this get all of the points for latency
+	peerLatencyBad  = time.Second           // Round trip times from this get none of them
+	peerDemoteScore = 50                    // Peers that score below this for too long are demoted
+	peerDemoteAfter = 15 * time.Minute
+	peerDemotion    = time.Hour // How long peers stay demoted
+)
+
+// The points that each part of the score is worth, out of 100.
+const (
+	peerPointsUptime     = 40
+	peerPointsFlaps      = 25
+	peerPointsLatency    = 25
+	peerPointsThroughput = 10
+)
+
+// peerQuality is the record of a configured peer that it is scored on. It is
+// protected by the mutex of the peerState.
+type peerQuality struct {
+	wanted   time.Duration       // Time that the peer was meant to be connected
+	lastSeen time.Time           // When the peer was last meant to be connected
+	uptime   time.Duration       // Time connected over the links that have closed
+	bytes    uint64              // Traffic over the links that have closed
+	latency  time.Duration       // The last round trip time that was measured, if any
+	flaps    []time.Time         // When links dropped soon after coming up
+	links    map[*link]time.Time // The links that are up, by when they came up
+	score    int                 // As of the last review
+	lowSince time.Time           // When the score fell below peerDemoteScore, if it is
+	demoted  time.Time           // When the demotion of the peer ends, if it is demoted
+}
+
+// peerMeasures are what a peer is scored on, as of a point in time.
+type peerMeasures struct {
+	availability float64       // The fraction of the time that the peer has been connected
+	flaps        int           // Within the last peerFlapWindow
+	latency      time.Duration // 0 if not measured
+	throughput   float64       // Bytes per second while connected
+}
+
+// attach records that a link to the peer has come up.
+func (s *peerState) attach(intf *link) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.clock.Now()
+	if s.quality.links == nil {
+		s.quality.links = make(map[*link]time.Time)
+	}
+	s.quality.links[intf] = now
+}
+
+// want records that the peer is meant to be connected, which the supervisor
+// does each time that it calls the peers that are due.
+func (s *peerState) want(now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	q := &s.quality
+	if gap := now.Sub(q.lastSeen); !q.lastSeen.IsZero() && gap > 0 && gap <= 2*peerRetryInterval {
+		q.wanted += gap
+	}
+	q.lastSeen = now
+}
+
+// detach records that a link to the peer has closed.
+func (s *peerState) detach(intf *link) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.clock.Now()
+	up, ok := s.quality.links[intf]
+	if !ok {
+		return
+	}
+	delete(s.quality.links, intf)
+	s.quality.uptime += now.Sub(up)
+	s.quality.bytes += atomic.LoadUint64(&intf.conn.rx) + atomic.LoadUint64(&intf.conn.tx)
+	if latency := time.Duration(atomic.LoadInt64(&intf.penalty.latency)); latency > 0 {
+		s.quality.latency = latency
+	}
+	if now.Sub(up) < peerFlapTime {
+		s.quality.flaps = append(s.quality.flaps, now)
+	}
+}
+
+// measure returns what the peer is scored on. It must be called with the
+// mutex held.
+func (s *peerState) measure(now time.Time) peerMeasures {
+	q := &s.quality
+	for len(q.flaps) > 0 && now.Sub(q.flaps[0]) >= peerFlapWindow {
+		q.flaps = q.flaps[1:]
+	}
+	m := peerMeasures{flaps: len(q.flaps), latency: q.latency}
+	uptime, bytes := q.uptime, q.bytes
+	for intf, up := range q.links {
+		uptime += now.Sub(up)
+		bytes += atomic.LoadUint64(&intf.conn.rx) + atomic.LoadUint64(&intf.conn.tx)
+		if latency := time.Duration(atomic.LoadInt64(&intf.penalty.latency)); latency > 0 {
+			m.latency = latency
+		}
+	}
+	if q.wanted == 0 {
+		m.availability = 1 // Not called yet, so there's nothing against it
+	} else if m.availability = float64(uptime) / float64(q.wanted); m.availability > 1 {
+		m.availability = 1
+	}
+	if uptime > 0 {
+		m.throughput = float64(bytes) / uptime.Seconds()
+	}
+	return m
+}
+
+// peerScore returns the score of a peer from its measures, where best is the
+// highest throughput of any of the configured peers. Latency and throughput
+// get half of their points when there is nothing to compare.
+func peerScore(m peerMeasures, best float64) int {
+	score := peerPointsUptime * m.availability
+	flaps := m.flaps
+	if flaps > peerFlapLimit {
+		flaps = peerFlapLimit
+	}
+	score += peerPointsFlaps * float64(peerFlapLimit-flaps) / peerFlapLimit
+	switch {
+	case m.latency == 0:
+		score += peerPointsLatency / 2
+	case m.latency <= peerLatencyGood:
+		score += peerPointsLatency
+	case m.latency < peerLatencyBad:
+		score += peerPointsLatency * float64(peerLatencyBad-m.latency) / float64(peerLatencyBad-peerLatencyGood)
+	}
+	if best > 0 {
+		score += peerPointsThroughput * m.throughput / best
+	} else {
+		score += peerPointsThroughput / 2
+	}
+	return int(score + 0.5)
+}
+
+// _reviewPeers scores the configured peers, demotes those that have scored
+// badly for too long, and gives those that have served their demotion a clean
+// record. It returns the demoted peers that shouldn't be called, which is none
+// of them unless a peer that isn't demoted is connected. This must be called
+// by the core actor.
+func (c *Core) _reviewPeers(peers []*peerState, now time.Time) map[*peerState]struct{} {
+	measures := make([]peerMeasures, len(peers))
+	var best float64
+	for i, s := range peers {
+		s.mutex.Lock()
+		measures[i] = s.measure(now)
+		s.mutex.Unlock()
+		if measures[i].throughput > best {
+			best = measures[i].throughput
+		}
+	}
+	demoted := make(map[*peerState]struct{})
+	fallback := true
+	for i, s := range peers {
+		score := peerScore(measures[i], best)
+		s.mutex.Lock()
+		q := &s.quality
+		q.score = score
+		if !q.demoted.IsZero() && !now.Before(q.demoted) {
+			c.log.Infof("Peer %s is no longer demoted", s.target.uri)
+			*q = peerQuality{links: q.links, score: score}
+		}
+		switch {
+		case !q.demoted.IsZero():
+		case s.preferred || q.wanted == 0 || score >= peerDemoteScore:
+			q.lowSince = time.Time{}
+		case q.lowSince.IsZero():
+			q.lowSince = now
+		case now.Sub(q.lowSince) >= peerDemoteAfter:
+			q.demoted = now.Add(peerDemotion)
+			c.log.Warnf("Demoting peer %s for %s, as it has scored below %d for %s",
+				s.target.uri, peerDemotion, peerDemoteScore, peerDemoteAfter)
+		}
+		if !q.demoted.IsZero() {
+			demoted[s] = struct{}{}
+		} else if s.links > 0 {
+			fallback = false
+		}
+		s.mutex.Unlock()
+	}
+	if fallback {
+		// Calling the demoted peers is better than calling none at all
+		return nil
+	}
+	return demoted
+}
@@ -0,0 +1,51 @@
+package core
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/gologme/log"
+)
+
+func TestPeerQuality(t *testing.T) {
+	clk := &fakeClock{now: time.Now()}
+	c := &Core{log: log.New(io.Discard, "", 0)}
+	bad, good := &peerState{clock: clk}, &peerState{clock: clk}
+	intf := &link{conn: &linkConn{clock: systemClock{}}}
+	good.up()
+	good.attach(intf)
+	peers := []*peerState{bad, good}
+	review := func() map[*peerState]struct{} {
+		for _, s := range peers {
+			s.want(clk.Now())
+		}
+		return c._reviewPeers(peers, clk.Now())
+	}
+	// The bad peer never connects, so it is demoted once it has scored
+	// badly for long enough
+	for i := 0; i <= int(peerDemoteAfter/peerRetryInterval); i++ {
+		if len(review()) != 0 {
+			t.Fatalf("peer was demoted after %s", time.Duration(i)*peerRetryInterval)
+		}
+		clk.Advance(peerRetryInterval)
+	}
+	demoted := review()
+	if _, ok := demoted[bad]; !ok || len(demoted) != 1 {
+		t.Fatal("bad peer was not demoted")
+	}
+	if bad.quality.score >= peerDemoteScore || good.quality.score < 80 {
+		t.Fatalf("scores of %d and %d", bad.quality.score, good.quality.score)
+	}
+	// With no other peer connected, the demoted peer is called anyway
+	good.detach(intf)
+	good.down()
+	if len(review()) != 0 {
+		t.Fatal("demoted peer was not called with no other peers connected")
+	}
+	clk.Advance(peerDemotion)
+	review()
+	if !bad.quality.demoted.IsZero() {
+		t.Fatal("peer was still demoted after its demotion")
+	}
+}
@@ -0,0 +1,277 @@
+package core
+
+// This file contains the per-peer traffic quotas, which are intended for
+// peerings over metered links. Usage is counted per remote key rather than per
+// link, so that reconnecting doesn't reset it. Over the hard quota, links are
+// closed. Over the soft quota, sending is paced to the SoftRate, which is the
+// only thing that slows traffic down, since the raised penalty doesn't change
+// routing (see penalty.go).
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/config"
+)
+
+const (
+	quotaCheckInterval = 10 * time.Second
+	quotaSaveInterval  = time.Minute
+	quotaPenalty       = 255 // Added to the penalty of links over the soft quota, which shows in getPeers
+)
+
+type quotas struct {
+	core  *Core
+	mutex sync.Mutex // protects start in each quotaUsage
+	usage map[keyArray]*quotaUsage
+	file  string
+}
+
+type quotaUsage struct {
+	// bytes is at the beginning of the struct to ensure 64-bit alignment
+	// on 32-bit platforms, see https://pkg.go.dev/sync/atomic#pkg-note-BUG
+	bytes    uint64
+	start    time.Time // Start of the current period
+	config   config.PeerQuotaConfig
+	clock    clock      // The clock of the core, for throttling
+	throttle sync.Mutex // protects next
+	next     time.Time  // When the next write may be sent, while throttled
+}
+
+type savedQuota struct {
+	Start time.Time `json:"start"`
+	Bytes uint64    `json:"bytes"`
+}
+
+// periodStart returns the start of the quota period that contains t.
+func periodStart(period string, t time.Time) (time.Time, error) {
+	y, m, d := t.Date()
+	switch period {
+	case "daily":
+		return time.Date(y, m, d, 0, 0, 0, 0, t.Location()), nil
+	case "weekly":
+		offset := (int(t.Weekday()) + 6) % 7 // Weeks start on Monday
+		return time.Date(y, m, d-offset, 0, 0, 0, 0, t.Location()), nil
+	case "monthly":
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location()), nil
+	default:
+		return time.Time{}, fmt.Errorf("unknown quota period %q", period)
+	}
+}
+
+func (q *quotas) init(c *Core, peers map[string]config.PeerQuotaConfig, file string) error {
+	q.core = c
+	q.file = file
+	q.usage = make(map[keyArray]*quotaUsage, len(peers))
+	now := c.clock.Now()
+	for k, conf := range peers {
+		bs, err := hex.DecodeString(k)
+		if err != nil || len(bs) != len(keyArray{}) {
+			return fmt.Errorf("invalid public key %q in PeerQuotas", k)
+		}
+		start, err := periodStart(conf.Period, now)
+		if err != nil {
+			return err
+		}
+		var key keyArray
+		copy(key[:], bs)
+		q.usage[key] = &quotaUsage{start: start, config: conf, clock: c.clock}
+	}
+	if err := q.load(); err != nil {
+		c.log.Warnln("Failed to load traffic quota usage:", err)
+	}
+	if len(q.usage) > 0 {
+		go q.run()
+	}
+	return nil
+}
+
+// load restores the usage saved by a previous run, as long as it is still
+// from the current period.
+func (q *quotas) load() error {
+	if q.file == "" {
+		return nil
+	}
+	bs, err := ioutil.ReadFile(q.file)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var saved map[string]savedQuota
+	if err := json.Unmarshal(bs, &saved); err != nil {
+		return err
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for k, s := range saved {
+		var key keyArray
+		if bs, err := hex.DecodeString(k); err == nil {
+			copy(key[:], bs)
+		}
+		if u := q.usage[key]; u != nil && u.start.Equal(s.Start) {
+			atomic.StoreUint64(&u.bytes, s.Bytes)
+		}
+	}
+	return nil
+}
+
+func (q *quotas) save() error {
+	if q.file == "" {
+		return nil
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	saved := make(map[string]savedQuota, len(q.usage))
+	for key, u := range q.usage {
+		saved[hex.EncodeToString(key[:])] = savedQuota{
+			Start: u.start,
+			Bytes: atomic.LoadUint64(&u.bytes),
+		}
+	}
+	bs, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := q.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, bs, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.file)
+}
+
+// run resets usage when a new period starts and saves it periodically, until
+// the core is stopped.
+func (q *quotas) run() {
+	ticker := q.core.clock.NewTicker(quotaSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.core.ctx.Done():
+			return
+		case now := <-ticker.Chan():
+			q.mutex.Lock()
+			for _, u := range q.usage {
+				if start, _ := periodStart(u.config.Period, now); start.After(u.start) {
+					u.start = start
+					atomic.StoreUint64(&u.bytes, 0)
+				}
+			}
+			q.mutex.Unlock()
+			if err := q.save(); err != nil {
+				q.core.log.Warnln("Failed to save traffic quota usage:", err)
+			}
+		}
+	}
+}
+
+// stop saves the usage one last time, as the core is stopping.
+func (q *quotas) stop() {
+	if len(q.usage) == 0 {
+		return
+	}
+	if err := q.save(); err != nil {
+		q.core.log.Warnln("Failed to save traffic quota usage:", err)
+	}
+}
+
+// get returns the usage for the given key, or nil if it has no quota.
+func (q *quotas) get(key keyArray) *quotaUsage {
+	return q.usage[key]
+}
+
+func (u *quotaUsage) add(n int) {
+	atomic.AddUint64(&u.bytes, uint64(n))
+}
+
+func (u *quotaUsage) overSoft() bool {
+	return u.config.Soft > 0 && atomic.LoadUint64(&u.bytes) >= u.config.Soft
+}
+
+func (u *quotaUsage) overHard() bool {
+	return u.config.Hard > 0 && atomic.LoadUint64(&u.bytes) >= u.config.Hard
+}
+
+// wait blocks for long enough to keep sending at or below the SoftRate, once
+// over the soft quota.
+func (u *quotaUsage) wait(n int) {
+	if u.config.SoftRate == 0 || !u.overSoft() {
+		return
+	}
+	u.throttle.Lock()
+	now := u.clock.Now()
+	if u.next.Before(now) {
+		u.next = now
+	}
+	u.next = u.next.Add(time.Duration(n) * time.Second / time.Duration(u.config.SoftRate))
+	delay := u.next.Sub(now)
+	u.throttle.Unlock()
+	<-u.clock.After(delay)
+}
+
+var errOverQuota = errors.New("link is over its hard traffic quota")
+
+// monitorQuota raises the penalty of the link while it is over the soft quota
+// and closes the link once it is over the hard quota.
+func (intf *link) monitorQuota(u *quotaUsage) {
+	ticker := intf.links.core.clock.NewTicker(quotaCheckInterval)
+	defer ticker.Stop()
+	soft := false
+	for {
+		select {
+		case <-intf.closed:
+			return
+		case <-ticker.Chan():
+		}
+		if u.overHard() {
+			intf.links.core.log.Warnf("Closing link %s as it is over its hard traffic quota", intf.name())
+			intf.close()
+			return
+		}
+		if u.overSoft() != soft {
+			soft = !soft
+			if soft {
+				intf.links.core.log.Warnf("Link %s is over its soft traffic quota", intf.name())
+				atomic.StoreUint64(&intf.penalty.quota, quotaPenalty)
+			} else {
+				atomic.StoreUint64(&intf.penalty.quota, 0)
+			}
+		}
+	}
+}
+
+// Quota describes the traffic quota usage for a node.
+type Quota struct {
+	Key    ed25519.PublicKey
+	Period string
+	Start  time.Time
+	Bytes  uint64
+	Soft   uint64
+	Hard   uint64
+}
+
+// GetQuotas returns the current usage of each configured traffic quota.
+func (c *Core) GetQuotas() []Quota {
+	var quotas []Quota
+	c.quotas.mutex.Lock()
+	defer c.quotas.mutex.Unlock()
+	for key, u := range c.quotas.usage {
+		quotas = append(quotas, Quota{
+			Key:    append([]byte(nil), key[:]...),
+			Period: u.config.Period,
+			Start:  u.start,
+			Bytes:  atomic.LoadUint64(&u.bytes),
+			Soft:   u.config.Soft,
+			Hard:   u.config.Hard,
+		})
+	}
+	return quotas
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/config"
+)
+
+// TestQuotas_SaveLoad checks that traffic quota usage is saved and restored
+// within a period, and forgotten once a new period starts.
+func TestQuotas_SaveLoad(t *testing.T) {
+	clk := &fakeClock{now: time.Date(2026, time.March, 10, 23, 0, 0, 0, time.UTC)}
+	file := t.TempDir() + "/quota.json"
+	pub, _, _ := ed25519.GenerateKey(nil)
+	var key keyArray
+	copy(key[:], pub)
+	peers := map[string]config.PeerQuotaConfig{
+		hex.EncodeToString(pub): {Period: "daily", Hard: 1000},
+	}
+	start := func() *Core {
+		c := &Core{clock: clk, log: GetLoggerWithPrefix("", false)}
+		c.ctx, c.ctxCancel = context.WithCancel(context.Background())
+		if err := c.quotas.init(c, peers, file); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	bytes := func(c *Core) uint64 {
+		return c.GetQuotas()[0].Bytes
+	}
+	// Usage is saved periodically, and restored by the next run
+	first := start()
+	first.quotas.get(key).add(600)
+	clk.WaitForWaiters(t)
+	clk.Advance(quotaSaveInterval)
+	var second *Core
+	for i := 0; i < 50; i++ {
+		second = start()
+		if bytes(second) == 600 {
+			break
+		}
+		second.ctxCancel()
+		time.Sleep(100 * time.Millisecond)
+	}
+	first.ctxCancel()
+	if bytes(second) != 600 {
+		t.Fatal("usage was not restored:", bytes(second))
+	}
+	if err := second.quotas.save(); err != nil {
+		t.Fatal(err)
+	}
+	second.ctxCancel()
+	// Usage from the day before is forgotten
+	clk.Advance(time.Hour)
+	if third := start(); bytes(third) != 0 {
+		t.Fatal("usage from the last period was restored:", bytes(third))
+	} else {
+		third.ctxCancel()
+	}
+}
+
+// TestCore_QuotaRefused checks that a node that is over its hard traffic quota,
+// as restored from the QuotaFile, isn't peered with, whether or not its key is
+// pinned.
+func TestCore_QuotaRefused(t *testing.T) {
+	cfgA, cfgB := GenerateConfig(), GenerateConfig()
+	cfgA.Listen = []string{"mem://quota-refused"}
+	nodeA := new(Core)
+	if err := nodeA.Start(cfgA, GetLoggerWithPrefix("A: ", false)); err != nil {
+		t.Fatal(err)
+	}
+	defer nodeA.Stop()
+	key := hex.EncodeToString(nodeA.public)
+	start, _ := periodStart("monthly", time.Now())
+	bs, _ := json.Marshal(map[string]savedQuota{key: {Start: start, Bytes: 1000}})
+	cfgB.QuotaFile = t.TempDir() + "/quota.json"
+	if err := os.WriteFile(cfgB.QuotaFile, bs, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfgB.PeerQuotas = map[string]config.PeerQuotaConfig{key: {Period: "monthly", Hard: 1000}}
+	cfgB.Peers = []string{"mem://quota-refused?key=" + key, "mem://quota-refused"}
+	nodeB := new(Core)
+	if err := nodeB.Start(cfgB, GetLoggerWithPrefix("B: ", false)); err != nil {
+		t.Fatal(err)
+	}
+	defer nodeB.Stop()
+	if quotas := nodeB.GetQuotas(); len(quotas) != 1 || quotas[0].Bytes != 1000 {
+		t.Fatal("usage was not restored:", quotas)
+	}
+	time.Sleep(time.Second)
+	if len(nodeA.GetPeers()) != 0 || len(nodeB.GetPeers()) != 0 {
+		t.Fatal("nodes connected over the hard quota")
+	}
+}
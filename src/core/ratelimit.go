@@ -0,0 +1,151 @@
+package core
+
+// This file contains the per-peer bandwidth limits, which are intended for
+// nodes on metered or slow uplinks. Limits from the PeerRateLimits section of
+// the config are shared by all links with the remote node, while limits from
+// the maxbps options of a peer or listener URI apply to each of its links.
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/config"
+)
+
+const (
+	rateBurst    = 250 * time.Millisecond // Sending or receiving at the limit for this long can be done all at once
+	rateBurstMin = 16384                  // The smallest burst in bytes, which is enough for a typical frame
+)
+
+type rateLimits struct {
+	limits map[keyArray]linkRates
+}
+
+// linkRates holds the limits for each direction of a link, either of which is
+// nil if that direction is unlimited.
+type linkRates struct {
+	send *rateLimiter
+	recv *rateLimiter
+}
+
+// rateLimiter is a token bucket, which is allowed to go into debt so that a
+// frame larger than the bucket still gets through, after a longer wait.
+type rateLimiter struct {
+	mutex  sync.Mutex // protects tokens and last
+	rate   float64    // Bytes per second
+	burst  float64    // Bytes
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a limiter for the given rate in bits per second, or
+// nil if the rate is 0, which means unlimited.
+func newRateLimiter(bps uint64) *rateLimiter {
+	if bps == 0 {
+		return nil
+	}
+	rate := float64(bps) / 8
+	burst := rate * rateBurst.Seconds()
+	if burst < rateBurstMin {
+		burst = rateBurstMin
+	}
+	return &rateLimiter{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// delay takes n bytes from the bucket, and returns how long to wait before
+// they may be sent or received.
+func (r *rateLimiter) delay(n int, now time.Time) time.Duration {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if now.After(r.last) {
+		r.tokens += now.Sub(r.last).Seconds() * r.rate
+		r.last = now
+	}
+	if r.tokens > r.burst {
+		r.tokens = r.burst
+	}
+	r.tokens -= float64(n)
+	if r.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-r.tokens / r.rate * float64(time.Second))
+}
+
+// wait blocks for long enough to keep within the limit.
+func (r *rateLimiter) wait(n int) {
+	if d := r.delay(n, time.Now()); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+func (l *rateLimits) init(peers map[string]config.PeerRateLimitConfig) error {
+	l.limits = make(map[keyArray]linkRates, len(peers))
+	for k, conf := range peers {
+		bs, err := hex.DecodeString(k)
+		if err != nil || len(bs) != len(keyArray{}) {
+			return fmt.Errorf("invalid public key %q in PeerRateLimits", k)
+		}
+		var key keyArray
+		copy(key[:], bs)
+		l.limits[key] = linkRates{
+			send: newRateLimiter(conf.Up),
+			recv: newRateLimiter(conf.Down),
+		}
+	}
+	return nil
+}
+
+// get returns the limits shared by all links with the given key.
+func (l *rateLimits) get(key keyArray) linkRates {
+	return l.limits[key]
+}
+
+// parseRateOptions reads the maxbps, maxbpsup and maxbpsdown options from a
+// peer or listener URI, in bits per second. The maxbps option limits both
+// directions, unless they are given separately.
+func parseRateOptions(u *url.URL) (up, down uint64, err error) {
+	parse := func(name string, v *uint64) error {
+		s := u.Query().Get(name)
+		if s == "" {
+			return nil
+		}
+		bps, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+		*v = bps
+		return nil
+	}
+	var both uint64
+	if err = parse("maxbps", &both); err != nil {
+		return
+	}
+	up, down = both, both
+	if err = parse("maxbpsup", &up); err != nil {
+		return
+	}
+	err = parse("maxbpsdown", &down)
+	return
+}
+
+// limitRates sets the bandwidth limits of the link, from both its options and
+// the config for the remote key, once the key is known.
+func (intf *link) limitRates() {
+	if r := newRateLimiter(intf.options.maxBpsUp); r != nil {
+		intf.conn.sendRate = append(intf.conn.sendRate, r)
+	}
+	if r := newRateLimiter(intf.options.maxBpsDown); r != nil {
+		intf.conn.recvRate = append(intf.conn.recvRate, r)
+	}
+	shared := intf.links.core.rates.get(intf.info.key)
+	if shared.send != nil {
+		intf.conn.sendRate = append(intf.conn.sendRate, shared.send)
+	}
+	if shared.recv != nil {
+		intf.conn.recvRate = append(intf.conn.recvRate, shared.recv)
+	}
+}
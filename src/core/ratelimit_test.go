@@ -0,0 +1,36 @@
+package core
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// TestCore_Reconfigure checks that a config which can't be applied is rolled
+// back, leaving the node with its previous listeners and peers.
+func TestRateLimiter(t *testing.T) {
+	u, _ := url.Parse("tcp://127.0.0.1:1?maxbps=8000000&maxbpsdown=800000")
+	up, down, err := parseRateOptions(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if up != 8000000 || down != 800000 {
+		t.Fatalf("got limits of %d up and %d down", up, down)
+	}
+	// 1MB/s, with a burst of 250KB
+	r := newRateLimiter(up)
+	now := r.last
+	if d := r.delay(250000, now); d != 0 {
+		t.Fatalf("burst was delayed by %s", d)
+	}
+	if d := r.delay(100000, now); d != 100*time.Millisecond {
+		t.Fatalf("send over the burst was delayed by %s", d)
+	}
+	// The bucket refills at the rate, but only up to the burst
+	if d := r.delay(250000, now.Add(time.Second)); d != 0 {
+		t.Fatalf("send after refilling was delayed by %s", d)
+	}
+	if d := r.delay(1000, now.Add(time.Second)); d != time.Millisecond {
+		t.Fatalf("send over the refilled burst was delayed by %s", d)
+	}
+}
@@ -0,0 +1,168 @@
+package core
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/config"
+)
+
+// The config options that Reconfigure can apply to a running node. Anything
+// else needs the node to be restarted.
+var reconfigurable = map[string]struct{}{
+	"Peers":             {},
+	"InterfacePeers":    {},
+	"Listen":            {},
+	"ListenFilters":     {},
+	"MaxHandshakes":     {},
+	"ListenRate":        {},
+	"PenaltyHook":       {},
+	"AllowedPublicKeys": {},
+	"PeerSchedules":     {},
+	"MaxPeers":          {},
+	"ActivePeers":       {},
+	"PeerRotation":      {},
+}
+
+// checkPeerURI returns an error if a peer URI from the config can't be called.
+func checkPeerURI(peer string) error {
+	u, err := url.Parse(peer)
+	if err != nil {
+		return fmt.Errorf("peer %q is not correctly formatted: %w", peer, err)
+	}
+	scheme, ok := linkSchemes[u.Scheme]
+	if !ok || scheme.dial == nil {
+		return fmt.Errorf("peer %q has unknown scheme %q", peer, u.Scheme)
+	}
+	if _, err := scheme.dial(&tcp{}, u, &tcpOptions{}); err != nil {
+		return fmt.Errorf("peer %q is not correctly formatted: %w", peer, err)
+	}
+	if u.Host == "" && u.Scheme != "serial" {
+		// A serial peer has a device rather than an address
+		return fmt.Errorf("peer %q has no address", peer)
+	}
+	if _, err = parseLinkOptions(u); err != nil {
+		return err
+	}
+	if _, err = peerBackoff(u); err != nil {
+		return err
+	}
+	_, err = peerTier(u)
+	return err
+}
+
+// Reconfigure applies the peers, listeners, listen filters and limits, allowed
+// keys, schedules and peer limits from the new config to the running node. If
+// any of them can't be applied, such as a peer URI that is malformed or a
+// listener that fails to bind, then none of them are: any listeners that were
+// already started are stopped again and the node carries on with its previous
+// config. Otherwise, any new peers are called straight away, but links to
+// peers that have been removed stay up until they next drop. The names of any
+// other options that differ between the configs are returned, as they only
+// take effect after a restart.
+func (c *Core) Reconfigure(nc *config.NodeConfig) ([]string, error) {
+	for _, peer := range nc.Peers {
+		if err := checkPeerURI(peer); err != nil {
+			return nil, err
+		}
+	}
+	for _, peers := range nc.InterfacePeers {
+		for _, peer := range peers {
+			if err := checkPeerURI(peer); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for key, sched := range nc.PeerSchedules {
+		if _, err := parseSchedule(sched); err != nil {
+			return nil, fmt.Errorf("invalid schedule for %s: %w", key, err)
+		}
+	}
+	filters, err := parseListenFilters(nc.ListenFilters)
+	if err != nil {
+		return nil, err
+	}
+	listen := make(map[string]*url.URL, len(nc.Listen))
+	for _, addr := range nc.Listen {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return nil, fmt.Errorf("listener %q is not correctly formatted: %w", addr, err)
+		}
+		if scheme, ok := linkSchemes[u.Scheme]; !ok || scheme.listen == nil {
+			return nil, fmt.Errorf("listener %q has unknown scheme %q", addr, u.Scheme)
+		}
+		listen[addr] = u
+	}
+
+	c.config.RLock()
+	var restart []string
+	current := map[string]struct{}{}
+	for _, addr := range c.config.Listen {
+		current[addr] = struct{}{}
+	}
+	oldv, newv := reflect.ValueOf(c.config).Elem(), reflect.ValueOf(nc).Elem()
+	for i := 0; i < oldv.NumField(); i++ {
+		field := oldv.Type().Field(i)
+		if _, ok := reconfigurable[field.Name]; ok || field.Anonymous {
+			continue
+		}
+		if !reflect.DeepEqual(oldv.Field(i).Interface(), newv.Field(i).Interface()) {
+			restart = append(restart, field.Name)
+		}
+	}
+	c.config.RUnlock()
+
+	// Start the new listeners first, as they are the most likely to fail, and
+	// stop them all again if any of them do
+	var started []*TcpListener
+	for addr, u := range listen {
+		if _, ok := current[addr]; ok {
+			continue
+		}
+		listener, err := c.links.tcp.listenURL(u, "")
+		if err != nil {
+			for _, l := range started {
+				l.Stop()
+			}
+			return nil, fmt.Errorf("failed to start listener %q: %w", addr, err)
+		}
+		started = append(started, listener)
+	}
+	for addr := range current {
+		if _, ok := listen[addr]; ok {
+			continue
+		}
+		if u, err := url.Parse(addr); err == nil {
+			c.links.tcp.mutex.Lock()
+			if l := c.links.tcp.listeners[listenerKey(u, "")]; l != nil {
+				l.Stop()
+			}
+			c.links.tcp.mutex.Unlock()
+		}
+	}
+
+	c.config.Lock()
+	c.config.Peers = nc.Peers
+	c.config.InterfacePeers = nc.InterfacePeers
+	c.config.Listen = nc.Listen
+	c.config.ListenFilters = nc.ListenFilters
+	c.config.MaxHandshakes = nc.MaxHandshakes
+	c.config.ListenRate = nc.ListenRate
+	c.config.PenaltyHook = nc.PenaltyHook
+	c.config.AllowedPublicKeys = nc.AllowedPublicKeys
+	c.config.PeerSchedules = nc.PeerSchedules
+	c.config.MaxPeers = nc.MaxPeers
+	c.config.ActivePeers = nc.ActivePeers
+	c.config.PeerRotation = nc.PeerRotation
+	c.config.Unlock()
+	c.links.tcp.mutex.Lock()
+	c.links.tcp.filters = filters
+	c.links.tcp.mutex.Unlock()
+	c.Act(nil, func() {
+		c.config.RLock()
+		defer c.config.RUnlock()
+		c._callConfiguredPeers(false)
+	})
+	return restart, nil
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/config"
+)
+
+func TestCore_Reconfigure(t *testing.T) {
+	cfg := GenerateConfig()
+	node := new(Core)
+	if err := node.Start(cfg, GetLoggerWithPrefix("", false)); err != nil {
+		t.Fatal(err)
+	}
+	defer node.Stop()
+	busy := "tcp://" + node.links.tcp.getAddr().String()
+	for _, bad := range []func(nc *config.NodeConfig){
+		func(nc *config.NodeConfig) { nc.Peers = []string{"foo://bar"} },
+		func(nc *config.NodeConfig) { nc.Listen = []string{cfg.Listen[0], "tcp://127.0.0.1:0", busy} },
+	} {
+		nc := GenerateConfig()
+		nc.PrivateKey, nc.PublicKey = cfg.PrivateKey, cfg.PublicKey
+		bad(nc)
+		if _, err := node.Reconfigure(nc); err == nil {
+			t.Fatal("bad config was applied")
+		}
+		time.Sleep(100 * time.Millisecond) // Listeners are stopped asynchronously
+		node.links.tcp.mutex.Lock()
+		listeners := len(node.links.tcp.listeners)
+		node.links.tcp.mutex.Unlock()
+		if listeners != 1 || len(node.config.Peers) != 0 || len(node.config.Listen) != 1 {
+			t.Fatal("config was not rolled back")
+		}
+	}
+	nc := GenerateConfig()
+	nc.PrivateKey, nc.PublicKey = cfg.PrivateKey, cfg.PublicKey
+	nc.IfMTU = 1500
+	restart, err := node.Reconfigure(nc)
+	if err != nil || len(restart) != 1 || restart[0] != "IfMTU" {
+		t.Fatal("unexpected result from good config:", restart, err)
+	}
+}
+
+// TestCore_ReconfigureListeners checks that removing a listener only stops
+// that one, and not another transport on the same address.
+func TestCore_ReconfigureListeners(t *testing.T) {
+	cfg := GenerateConfig()
+	node := new(Core)
+	if err := node.Start(cfg, GetLoggerWithPrefix("", false)); err != nil {
+		t.Fatal(err)
+	}
+	defer node.Stop()
+	tcpAddr := cfg.Listen[0]
+	udpAddr := "udp://" + node.links.tcp.getAddr().String()
+	reconfigure := func(listen ...string) {
+		nc := GenerateConfig()
+		nc.PrivateKey, nc.PublicKey = cfg.PrivateKey, cfg.PublicKey
+		nc.Listen = listen
+		if _, err := node.Reconfigure(nc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reconfigure(tcpAddr, udpAddr)
+	reconfigure(tcpAddr)
+	time.Sleep(100 * time.Millisecond) // Listeners are stopped asynchronously
+	node.links.tcp.mutex.Lock()
+	defer node.links.tcp.mutex.Unlock()
+	u, _ := url.Parse(tcpAddr)
+	if _, isIn := node.links.tcp.listeners[listenerKey(u, "")]; !isIn || len(node.links.tcp.listeners) != 1 {
+		t.Fatal("the wrong listener was stopped")
+	}
+}
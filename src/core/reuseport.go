@@ -0,0 +1,118 @@
+package core
+
+// This file contains listeners with more than one accept socket, for busy
+// public nodes. A tcp://, tls:// or ws:// listener with the acceptors option,
+// e.g. tls://[::]:e?acceptors=4, opens that many sockets on the same address
+// with SO_REUSEPORT, so that the kernel spreads new connections over them
+// rather than queueing all of them on one socket, and accepts on each of them
+// at once. This is only supported on Linux and macOS.
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+const tcpMaxAcceptors = 64
+
+// parseAcceptors reads the acceptors option of a listener URI, which is 1 if
+// the option isn't set.
+func parseAcceptors(u *url.URL) (int, error) {
+	s := u.Query().Get("acceptors")
+	if s == "" {
+		return 1, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if n < 1 || n > tcpMaxAcceptors {
+		return 0, fmt.Errorf("acceptors must be from 1 to %d", tcpMaxAcceptors)
+	}
+	return n, nil
+}
+
+// reusePortControl returns the control function for a listener that also
+// sets SO_REUSEPORT on the socket, which fails if that isn't supported.
+func reusePortControl(control func(string, string, syscall.RawConn) error) func(string, string, syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		if err := tcpReusePort(c); err != nil {
+			return fmt.Errorf("failed to set SO_REUSEPORT: %w", err)
+		}
+		return control(network, address, c)
+	}
+}
+
+// acceptResult is a connection, or the error, from one accept socket.
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+// multiListener accepts connections from several sockets on the same address.
+// Its address is that of the first one, which the others were bound to so
+// that they have the same port.
+type multiListener struct {
+	net.Listener
+	sockets []net.Listener
+	results chan acceptResult
+	closed  chan struct{}
+	once    sync.Once
+}
+
+func newMultiListener(sockets []net.Listener) *multiListener {
+	m := &multiListener{
+		Listener: sockets[0],
+		sockets:  sockets,
+		results:  make(chan acceptResult),
+		closed:   make(chan struct{}),
+	}
+	for _, socket := range sockets {
+		go m.accept(socket)
+	}
+	return m
+}
+
+// accept accepts connections from one socket until the listener is closed.
+func (m *multiListener) accept(socket net.Listener) {
+	for {
+		conn, err := socket.Accept()
+		select {
+		case m.results <- acceptResult{conn, err}:
+		case <-m.closed:
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+	}
+}
+
+func (m *multiListener) Accept() (net.Conn, error) {
+	select {
+	case r := <-m.results:
+		return r.conn, r.err
+	case <-m.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (m *multiListener) Close() error {
+	var err error
+	m.once.Do(func() {
+		close(m.closed)
+		for _, socket := range m.sockets {
+			if e := socket.Close(); err == nil {
+				err = e
+			}
+		}
+	})
+	return err
+}
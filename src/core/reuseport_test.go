@@ -0,0 +1,39 @@
+package core
+
+import (
+	"net/url"
+	"runtime"
+	"testing"
+)
+
+func TestCore_Acceptors(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("SO_REUSEPORT is not supported on", runtime.GOOS)
+	}
+	cfgA := GenerateConfig()
+	cfgA.Listen = []string{"tcp://127.0.0.1:0?acceptors=4"}
+	nodeA, nodeB := new(Core), new(Core)
+	if err := nodeA.Start(cfgA, GetLoggerWithPrefix("A: ", false)); err != nil {
+		t.Fatal(err)
+	}
+	defer nodeA.Stop()
+	if err := nodeB.Start(GenerateConfig(), GetLoggerWithPrefix("B: ", false)); err != nil {
+		t.Fatal(err)
+	}
+	defer nodeB.Stop()
+	u, _ := url.Parse("tcp://" + nodeA.links.tcp.getAddr().String())
+	if err := nodeB.CallPeer(u, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !WaitConnected(nodeA, nodeB) {
+		t.Fatal("nodes did not connect")
+	}
+	nodeA.links.tcp.mutex.Lock()
+	for _, l := range nodeA.links.tcp.listeners {
+		if m, ok := l.Listener.(*multiListener); !ok || len(m.sockets) != 4 {
+			t.Error("listener does not have 4 sockets")
+		}
+	}
+	nodeA.links.tcp.mutex.Unlock()
+	CheckEcho(t, nodeA, nodeB)
+}
@@ -0,0 +1,122 @@
+package core
+
+// This file contains the rotation of configured peers, for nodes that list
+// many public peers but only need a few of them. With ActivePeers set, only
+// that many of the peers are called at a time, chosen at random. A peer whose
+// call fails, or whose link drops, is swapped for another one once it is due
+// to be called again, and with PeerRotation set the peer that has been active
+// the longest is also swapped out every so often, so that the load of a large
+// network is spread over all of the public peers rather than landing on every
+// one of them at once. Preferred peers are always called, and take up slots
+// of ActivePeers without ever being swapped out.
+
+import (
+	"math/rand"
+	"time"
+)
+
+// isActive returns true if the peer has been chosen to be called.
+func (s *peerState) isActive() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.active
+}
+
+// setActive chooses the peer to be called, or stops it from being called.
+func (s *peerState) setActive(active bool, now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if active && !s.active {
+		s.activeSince = now
+	}
+	s.active = active
+}
+
+// since returns when the peer was chosen to be called.
+func (s *peerState) since() time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.activeSince
+}
+
+// _selectPeers returns the peers that may be called, which is all of them
+// unless ActivePeers is set and there are more of them than that. This must
+// be called by the core actor with the config held.
+func (c *Core) _selectPeers(peers []*peerState, now time.Time) []*peerState {
+	max := int(c.config.ActivePeers)
+	if max == 0 || len(peers) <= max {
+		for _, s := range peers {
+			s.setActive(true, now)
+		}
+		return peers
+	}
+	var preferred, active, standby, dropped []*peerState
+	for _, s := range peers {
+		switch {
+		case s.preferred:
+			s.setActive(true, now)
+			preferred = append(preferred, s)
+		case !s.isActive():
+			standby = append(standby, s)
+		case s.due(now):
+			// Its call failed or its link dropped, so try another peer
+			s.setActive(false, now)
+			dropped = append(dropped, s)
+		default:
+			active = append(active, s)
+		}
+	}
+	var candidates []*peerState
+	for _, s := range standby {
+		if s.due(now) {
+			candidates = append(candidates, s)
+		}
+	}
+	if max -= len(preferred); max < 0 {
+		max = 0
+	}
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	closing := make(map[*peerState]struct{})
+	deactivate := func(i int) {
+		s := active[i]
+		s.setActive(false, now)
+		if s.connected() {
+			closing[s] = struct{}{}
+		}
+		active = append(active[:i], active[i+1:]...)
+	}
+	// Prefer to keep the peers that are connected if ActivePeers was lowered
+	for i := len(active) - 1; i >= 0 && len(active) > max; i-- {
+		if !active[i].connected() {
+			deactivate(i)
+		}
+	}
+	for len(active) > max {
+		deactivate(len(active) - 1)
+	}
+	interval := time.Duration(c.config.PeerRotation) * time.Second
+	if interval > 0 && len(active) == max && len(candidates) > 0 {
+		oldest := -1
+		for i, s := range active {
+			if s.connected() && now.Sub(s.since()) >= interval && (oldest < 0 || s.since().Before(active[oldest].since())) {
+				oldest = i
+			}
+		}
+		if oldest >= 0 {
+			deactivate(oldest)
+		}
+	}
+	// Peers that were just dropped are only tried again if nothing else is due
+	candidates = append(candidates, dropped...)
+	for _, s := range candidates {
+		if len(active) >= max {
+			break
+		}
+		s.setActive(true, now)
+		active = append(active, s)
+	}
+	c.links.closePeers(closing, "peers are being rotated")
+	return append(preferred, active...)
+}
@@ -0,0 +1,111 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/config"
+)
+
+func TestCore_ActivePeers(t *testing.T) {
+	start := func(prefix string, listen, peers []string) *Core {
+		cfg := GenerateConfig()
+		cfg.Listen = listen
+		cfg.Peers = peers
+		cfg.ActivePeers = 1
+		node := new(Core)
+		if err := node.Start(cfg, GetLoggerWithPrefix(prefix, false)); err != nil {
+			t.Fatal(err)
+		}
+		return node
+	}
+	var others []*Core
+	var peers []string
+	for i := 0; i < 3; i++ {
+		uri := fmt.Sprintf("mem://active-peers-%d", i)
+		other := start(fmt.Sprintf("%d: ", i), []string{uri}, nil)
+		others = append(others, other)
+		peers = append(peers, uri)
+	}
+	node := start("N: ", nil, peers)
+	defer node.Stop()
+	var first *Core
+	defer func() {
+		for _, other := range others {
+			if other != first {
+				other.Stop()
+			}
+		}
+	}()
+	connected := func() *Core {
+		ps := node.GetPeers()
+		if len(ps) != 1 {
+			return nil
+		}
+		for _, other := range others {
+			if bytes.Equal(ps[0].Key, other.PublicKey()) {
+				return other
+			}
+		}
+		return nil
+	}
+	for i := 0; i < 50 && connected() == nil; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if first = connected(); first == nil {
+		t.Fatal("no peer was called")
+	}
+	time.Sleep(time.Second) // Long enough for the others to be called, if they were going to be
+	if len(node.GetPeers()) != 1 {
+		t.Fatal("more peers were called than ActivePeers")
+	}
+	standby := 0
+	for _, retry := range node.GetPeerRetries() {
+		if retry.Standby {
+			standby++
+		}
+	}
+	if standby != 2 {
+		t.Fatal("expected 2 peers on standby, got", standby)
+	}
+	// Once the link to the peer drops, another one is called in its place
+	first.Stop()
+	for i := 0; i < 150 && (connected() == nil || connected() == first); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if c := connected(); c == nil || c == first {
+		t.Fatal("peer was not replaced after its link dropped")
+	}
+}
+
+func TestSelectPeersPreferred(t *testing.T) {
+	clk := &fakeClock{now: time.Now()}
+	c := &Core{config: &config.NodeConfig{ActivePeers: 1, PeerRotation: 1}}
+	var peers []*peerState
+	for i := 0; i < 3; i++ {
+		peers = append(peers, &peerState{clock: clk})
+	}
+	// The preferred peer comes last, so that it would be the one trimmed if
+	// it weren't exempt
+	preferred := peers[2]
+	preferred.preferred = true
+	intf := &link{conn: &linkConn{clock: systemClock{}}}
+	for i := 0; i < 10; i++ {
+		active := c._selectPeers(peers, clk.Now())
+		if len(active) != 1 || active[0] != preferred {
+			t.Fatalf("round %d: the preferred peer wasn't the only one active", i)
+		}
+		if i == 0 {
+			preferred.up()
+			preferred.attach(intf)
+		}
+		clk.Advance(time.Second)
+	}
+	for _, s := range peers[:2] {
+		if s.isActive() {
+			t.Fatal("a peer was active beyond ActivePeers")
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package core
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const scheduleCheckInterval = time.Minute
+
+var scheduleDays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// A schedule is a list of windows, in local time, during which a peering may
+// be up. A window is written as "22:00-06:00", optionally prefixed with the
+// days that it starts on, such as "Mon-Fri/22:00-06:00" or "Sat/08:00-20:00",
+// and several windows are separated by commas. A window that ends before it
+// starts runs past midnight into the next day. An empty schedule is always
+// open.
+type schedule []scheduleWindow
+
+type scheduleWindow struct {
+	days       [7]bool
+	start, end int // Minutes since midnight
+}
+
+func parseSchedule(s string) (schedule, error) {
+	var sched schedule
+	for _, w := range strings.Split(s, ",") {
+		w = strings.TrimSpace(w)
+		if w == "" {
+			continue
+		}
+		var window scheduleWindow
+		if i := strings.Index(w, "/"); i >= 0 {
+			first, last := w[:i], w[:i]
+			if j := strings.Index(first, "-"); j >= 0 {
+				first, last = first[:j], first[j+1:]
+			}
+			from, ok1 := scheduleDays[strings.ToLower(first)]
+			to, ok2 := scheduleDays[strings.ToLower(last)]
+			if !ok1 || !ok2 {
+				return nil, fmt.Errorf("invalid days in schedule window %q", w)
+			}
+			for d := from; ; d = (d + 1) % 7 {
+				window.days[d] = true
+				if d == to {
+					break
+				}
+			}
+			w = w[i+1:]
+		} else {
+			window.days = [7]bool{true, true, true, true, true, true, true}
+		}
+		times := strings.Split(w, "-")
+		if len(times) != 2 {
+			return nil, fmt.Errorf("invalid schedule window %q", w)
+		}
+		var err error
+		if window.start, err = parseScheduleTime(times[0]); err != nil {
+			return nil, err
+		}
+		if window.end, err = parseScheduleTime(times[1]); err != nil {
+			return nil, err
+		}
+		sched = append(sched, window)
+	}
+	return sched, nil
+}
+
+func parseScheduleTime(s string) (int, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid schedule time %q", s)
+	}
+	h, err1 := strconv.Atoi(parts[0])
+	m, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("invalid schedule time %q", s)
+	}
+	return h*60 + m, nil
+}
+
+// open returns true if the time falls within any of the windows.
+func (s schedule) open(t time.Time) bool {
+	if len(s) == 0 {
+		return true
+	}
+	now := t.Hour()*60 + t.Minute()
+	today := t.Weekday()
+	yesterday := (today + 6) % 7
+	for _, w := range s {
+		if w.start < w.end {
+			if w.days[today] && now >= w.start && now < w.end {
+				return true
+			}
+		} else if (w.days[today] && now >= w.start) || (w.days[yesterday] && now < w.end) {
+			return true
+		}
+	}
+	return false
+}
+
+// inSchedule returns true if the link is within both the schedule from the
+// PeerSchedules section of the config for the remote key, if any, and the
+// schedule given in the peer URI, if it is an outbound link.
+func (intf *link) inSchedule(t time.Time) (bool, error) {
+	intf.links.core.config.RLock()
+	s := intf.links.core.config.PeerSchedules[hex.EncodeToString(intf.info.key[:])]
+	intf.links.core.config.RUnlock()
+	sched, err := parseSchedule(s)
+	if err != nil {
+		return false, fmt.Errorf("invalid schedule for %s: %w", hex.EncodeToString(intf.info.key[:]), err)
+	}
+	return sched.open(t) && intf.options.schedule.open(t), nil
+}
+
+// monitorSchedule closes the link once it is outside of its schedule.
+func (intf *link) monitorSchedule() {
+	clk := intf.links.core.clock
+	for {
+		select {
+		case <-intf.closed:
+			return
+		case now := <-clk.After(scheduleCheckInterval):
+			if ok, _ := intf.inSchedule(now); !ok {
+				intf.links.core.log.Infof("Closing link %s as it is outside of its schedule", intf.name())
+				intf.close()
+				return
+			}
+		}
+	}
+}
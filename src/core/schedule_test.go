@@ -0,0 +1,33 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+// TestSchedule checks that schedule windows, including those which run past
+// midnight or are limited to certain days, are open at the right times.
+func TestSchedule(t *testing.T) {
+	sched, err := parseSchedule("Mon-Fri/22:00-06:00,Sat-Sun/00:00-24:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for when, open := range map[string]bool{
+		"2022-03-07 21:59": false, // Monday
+		"2022-03-07 22:00": true,
+		"2022-03-08 05:59": true,
+		"2022-03-08 06:00": false,
+		"2022-03-12 12:00": true,  // Saturday
+		"2022-03-14 01:00": false, // Monday morning, after Sunday
+	} {
+		tm, _ := time.ParseInLocation("2006-01-02 15:04", when, time.Local)
+		if sched.open(tm) != open {
+			t.Errorf("schedule open at %s should be %v", when, open)
+		}
+	}
+	for _, bad := range []string{"22:00", "Mon/25:00-26:00", "Funday/10:00-11:00"} {
+		if _, err := parseSchedule(bad); err == nil {
+			t.Errorf("invalid schedule %q was accepted", bad)
+		}
+	}
+}
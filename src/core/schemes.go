@@ -0,0 +1,284 @@
+package core
+
+// This file contains the transports that links can be carried over, by the
+// scheme of their URIs. Peers can be called over any transport that has a dial
+// function, and listeners started for any that has a listen function.
+// checkPeerURI, Reconfigure, links.call and tcp.listenURL all look schemes up
+// here, so that they agree on which ones there are.
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/proxy"
+)
+
+type linkScheme struct {
+	// dial checks a peer URI and sets up the options to call it with, and
+	// returns the address to call
+	dial func(t *tcp, u *url.URL, o *tcpOptions) (string, error)
+	// listen starts a listener for a URI, on the address of the URI with the
+	// interface from the zone, if any
+	listen func(t *tcp, u *url.URL, hostport string, sockets tcpListenOptions) (*TcpListener, error)
+}
+
+var linkSchemes = map[string]linkScheme{
+	"tcp": {
+		dial: func(_ *tcp, u *url.URL, _ *tcpOptions) (string, error) {
+			return u.Host, nil
+		},
+		listen: func(t *tcp, _ *url.URL, hostport string, sockets tcpListenOptions) (*TcpListener, error) {
+			return t.listen(hostport, nil, sockets)
+		},
+	},
+	"socks": {
+		dial: func(t *tcp, u *url.URL, o *tcpOptions) (string, error) {
+			o.socksProxyAddr = u.Host
+			if u.User != nil {
+				o.socksProxyAuth = &proxy.Auth{}
+				o.socksProxyAuth.User = u.User.Username()
+				o.socksProxyAuth.Password, _ = u.User.Password()
+			}
+			o.upgrade = t.tls.forDialer // TODO make this configurable
+			pathtokens := strings.Split(strings.Trim(u.Path, "/"), "/")
+			return pathtokens[0], nil
+		},
+	},
+	"tls": {
+		dial: func(t *tcp, u *url.URL, o *tcpOptions) (string, error) {
+			o.upgrade = t.tls.forDialer
+			o.tlsSNI = tlsSNI(u)
+			o.tlsALPN = tlsALPN(u)
+			return u.Host, nil
+		},
+		listen: func(t *tcp, u *url.URL, hostport string, sockets tcpListenOptions) (*TcpListener, error) {
+			return t.listen(hostport, t.tls.listenerFor(u), sockets)
+		},
+	},
+	"ws":  {dial: dialWS, listen: listenWS},
+	"wss": {dial: dialWS, listen: listenWS},
+	"udp": {
+		dial: func(t *tcp, u *url.URL, o *tcpOptions) (string, error) {
+			var err error
+			if o.fec, err = parseFECOptions(u); err != nil {
+				return "", err
+			}
+			o.upgrade = t.udp.upgrade
+			return u.Host, nil
+		},
+		listen: func(t *tcp, u *url.URL, hostport string, _ tcpListenOptions) (*TcpListener, error) {
+			fec, err := parseFECOptions(u)
+			if err != nil {
+				return nil, err
+			}
+			return t.udp.listen(hostport, fec)
+		},
+	},
+	"onion": {
+		dial: func(t *tcp, u *url.URL, o *tcpOptions) (string, error) {
+			if !isOnion(u) {
+				return "", errors.New("onion peers must be onion services: " + u.String())
+			}
+			o.upgrade = t.onion.upgrade
+			return u.Host, nil
+		},
+		listen: func(t *tcp, u *url.URL, _ string, _ tcpListenOptions) (*TcpListener, error) {
+			return t.onion.listen(u)
+		},
+	},
+	"i2p": {
+		dial: func(t *tcp, u *url.URL, o *tcpOptions) (string, error) {
+			if !strings.HasSuffix(strings.ToLower(u.Hostname()), ".i2p") {
+				return "", errors.New("i2p peers must be I2P destinations: " + u.String())
+			}
+			o.upgrade = t.i2p.upgrade
+			o.samAddr = defaultI2PSAM
+			if sam := u.Query().Get("sam"); sam != "" {
+				o.samAddr = sam
+			}
+			return u.Host, nil
+		},
+		listen: func(t *tcp, u *url.URL, _ string, _ tcpListenOptions) (*TcpListener, error) {
+			return t.i2p.listen(u)
+		},
+	},
+	"h2":  {dial: dialH2},
+	"h2c": {dial: dialH2},
+	"serial": {
+		dial: func(t *tcp, u *url.URL, o *tcpOptions) (string, error) {
+			var err error
+			if o.serial, err = parseSerialOptions(u); err != nil {
+				return "", err
+			}
+			o.upgrade = t.serial.upgrade
+			return o.serial.device, nil
+		},
+	},
+	"bt": {
+		dial: func(t *tcp, u *url.URL, o *tcpOptions) (string, error) {
+			addr, err := parseBTAddr(u)
+			if err != nil {
+				return "", err
+			}
+			o.upgrade = t.bt.upgrade
+			return addr.String(), nil
+		},
+		listen: func(t *tcp, u *url.URL, _ string, _ tcpListenOptions) (*TcpListener, error) {
+			return t.bt.listen(u)
+		},
+	},
+	"eth": {
+		dial: func(t *tcp, u *url.URL, o *tcpOptions) (string, error) {
+			var err error
+			if o.eth, err = parseEthOptions(u); err != nil {
+				return "", err
+			}
+			o.upgrade = t.eth.upgrade
+			return u.Host + "/" + o.eth.peer.String(), nil
+		},
+	},
+	"awdl": {
+		dial: func(t *tcp, u *url.URL, o *tcpOptions) (string, error) {
+			saddr, err := awdlHost(u)
+			if err != nil {
+				return "", err
+			}
+			o.upgrade = t.awdl.upgrade
+			return saddr, nil
+		},
+		listen: func(t *tcp, u *url.URL, _ string, _ tcpListenOptions) (*TcpListener, error) {
+			return t.awdl.listen(u)
+		},
+	},
+	"mem": {
+		dial: func(t *tcp, u *url.URL, o *tcpOptions) (string, error) {
+			o.upgrade = t.mem.upgrade
+			return u.Host, nil
+		},
+		listen: func(t *tcp, u *url.URL, _ string, _ tcpListenOptions) (*TcpListener, error) {
+			return t.mem.listen(u)
+		},
+	},
+	"ssh": {
+		dial: func(t *tcp, u *url.URL, o *tcpOptions) (string, error) {
+			var err error
+			if o.ssh, err = parseSSHOptions(u); err != nil {
+				return "", err
+			}
+			o.upgrade = t.ssh.upgrade
+			return u.Host + "/" + o.ssh.target, nil
+		},
+	},
+	"wg": {
+		dial: func(t *tcp, u *url.URL, o *tcpOptions) (string, error) {
+			var err error
+			if o.wg, err = parseWGOptions(u); err != nil {
+				return "", err
+			}
+			o.upgrade = t.wg.upgrade
+			return u.Host + "/" + o.wg.target.String(), nil
+		},
+	},
+	"icmp": {
+		dial: func(t *tcp, u *url.URL, o *tcpOptions) (string, error) {
+			var err error
+			if o.icmp, err = parseICMPOptions(u); err != nil {
+				return "", err
+			}
+			o.upgrade = t.icmp.upgrade
+			return u.Hostname(), nil
+		},
+		listen: func(t *tcp, u *url.URL, _ string, _ tcpListenOptions) (*TcpListener, error) {
+			return t.icmp.listen(u)
+		},
+	},
+	"dns": {
+		dial: func(t *tcp, u *url.URL, o *tcpOptions) (string, error) {
+			var err error
+			if o.dns, err = parseDNSOptions(u); err != nil {
+				return "", err
+			}
+			o.upgrade = t.dns.upgrade
+			return o.dns.addr + "/" + o.dns.zone, nil
+		},
+		listen: func(t *tcp, u *url.URL, _ string, _ tcpListenOptions) (*TcpListener, error) {
+			return t.dns.listen(u)
+		},
+	},
+	"sctp": {
+		dial: func(t *tcp, u *url.URL, o *tcpOptions) (string, error) {
+			o.upgrade = t.sctp.upgrade
+			return u.Host, nil
+		},
+		listen: func(t *tcp, _ *url.URL, hostport string, _ tcpListenOptions) (*TcpListener, error) {
+			return t.sctp.listen(hostport)
+		},
+	},
+	"srv": {
+		dial: func(t *tcp, u *url.URL, o *tcpOptions) (string, error) {
+			o.upgrade = t.srv.upgrade
+			return u.Hostname(), nil
+		},
+	},
+	"kcp": {
+		dial: func(t *tcp, u *url.URL, o *tcpOptions) (string, error) {
+			var err error
+			if o.kcp, err = parseKCPOptions(u); err != nil {
+				return "", err
+			}
+			o.upgrade = t.kcp.forDialer
+			return u.Host, nil
+		},
+		listen: func(t *tcp, u *url.URL, hostport string, _ tcpListenOptions) (*TcpListener, error) {
+			options, err := parseKCPOptions(u)
+			if err != nil {
+				return nil, err
+			}
+			return t.kcp.listen(hostport, options)
+		},
+	},
+	"npipe": {
+		dial: func(t *tcp, u *url.URL, o *tcpOptions) (string, error) {
+			path, err := pipePath(u)
+			if err != nil {
+				return "", err
+			}
+			o.upgrade = t.npipe.upgrade
+			return path, nil
+		},
+		listen: func(t *tcp, u *url.URL, _ string, _ tcpListenOptions) (*TcpListener, error) {
+			return t.npipe.listen(u)
+		},
+	},
+}
+
+func dialWS(t *tcp, u *url.URL, o *tcpOptions) (string, error) {
+	o.upgrade = t.ws.forDialer
+	if u.Scheme == "wss" {
+		o.upgrade = t.ws.forSecureDialer
+		o.tlsSNI = tlsSNI(u)
+	}
+	o.wsURL = wsURL(u)
+	return u.Host, nil
+}
+
+func listenWS(t *tcp, u *url.URL, hostport string, sockets tcpListenOptions) (*TcpListener, error) {
+	upgrade, err := t.ws.listenerFor(u)
+	if err != nil {
+		return nil, err
+	}
+	return t.listen(hostport, upgrade, sockets)
+}
+
+func dialH2(t *tcp, u *url.URL, o *tcpOptions) (string, error) {
+	var err error
+	if o.h2, err = parseH2Options(u); err != nil {
+		return "", err
+	}
+	o.upgrade = t.h2.forDialer
+	if o.h2.secure {
+		o.upgrade = t.h2.forSecureDialer
+	}
+	return u.Host + "/" + o.h2.target, nil
+}
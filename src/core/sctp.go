@@ -0,0 +1,74 @@
+package core
+
+// This file contains the SCTP transport. SCTP carries messages on several
+// streams in one association, and only messages on the same stream are held
+// up behind a lost packet, so an SCTP link keeps protocol traffic, and each
+// flow of overlay traffic, from stalling behind the others as they would on
+// a TCP link. Each frame from ironwood is sent as one message, with protocol
+// frames on stream 0 and traffic spread over the other streams by flow, in
+// the same way as a bundle with the flow option spreads it over its links.
+
+import (
+	"context"
+	"hash/fnv"
+	"net"
+)
+
+const sctpStreams = 16 // Streams that are asked for in each direction
+
+// tcpsctp hangs the SCTP transport off of the TCP one, so that SCTP links are
+// called, listened for and handled by the same code as every other type.
+type tcpsctp struct {
+	tcp     *tcp
+	upgrade *TcpUpgrade
+}
+
+func (s *tcpsctp) init(tcp *tcp) {
+	s.tcp = tcp
+	s.upgrade = &TcpUpgrade{
+		upgrade: func(c net.Conn, _ *tcpOptions) (net.Conn, error) { return c, nil },
+		name:    "sctp",
+		dial:    s.dial,
+	}
+}
+
+func (s *tcpsctp) dial(ctx context.Context, saddr string, _ *tcpOptions) (net.Conn, error) {
+	dst, err := net.ResolveTCPAddr("tcp", saddr)
+	if err != nil {
+		return nil, err
+	}
+	ctx, done := context.WithTimeout(ctx, default_timeout)
+	defer done()
+	return dialSCTP(ctx, dst, s.tcp.mark)
+}
+
+func (s *tcpsctp) listen(listenaddr string) (*TcpListener, error) {
+	listener, err := listenSCTP(listenaddr, s.tcp.mark)
+	if err != nil {
+		return nil, err
+	}
+	l := TcpListener{
+		Listener: listener,
+		opts:     tcpOptions{upgrade: s.upgrade},
+		stop:     make(chan struct{}),
+	}
+	return &l, nil
+}
+
+// sctpStream returns the stream to send a frame on, out of the given number.
+func sctpStream(frame []byte, streams uint16) uint16 {
+	flow := bundleFlow(frame)
+	if flow == nil || streams < 2 {
+		return 0
+	}
+	h := fnv.New32a()
+	_, _ = h.Write(flow)
+	return 1 + uint16(h.Sum32()%uint32(streams-1))
+}
+
+// sctpAddr is the address of one end of an SCTP association.
+type sctpAddr struct {
+	*net.TCPAddr
+}
+
+func (a sctpAddr) Network() string { return "sctp" }
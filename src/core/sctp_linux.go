@@ -0,0 +1,227 @@
+//go:build linux
+// +build linux
+
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// From linux/sctp.h, which x/sys/unix doesn't have
+const (
+	sctpSndRcv      = 1 // The cmsg type of struct sctp_sndrcvinfo
+	sctpInitMsg     = 2 // The socket option taking struct sctp_initmsg
+	sctpNoDelay     = 3
+	sctpSndRcvSize  = 32 // sizeof(struct sctp_sndrcvinfo)
+	sctpMaxAttempts = 4
+)
+
+// sctpInitMsgOpt is struct sctp_initmsg.
+type sctpInitMsgOpt struct {
+	numOStreams  uint16
+	maxInStreams uint16
+	maxAttempts  uint16
+	maxInitTimeo uint16
+}
+
+// sctpSetup asks for the streams that links use, and turns off the delay
+// that would otherwise bundle small messages together.
+func sctpSetup(fd int, _ syscall.RawConn) error {
+	init := sctpInitMsgOpt{
+		numOStreams:  sctpStreams,
+		maxInStreams: sctpStreams,
+		maxAttempts:  sctpMaxAttempts,
+	}
+	opt := (*[unsafe.Sizeof(init)]byte)(unsafe.Pointer(&init))[:]
+	if err := unix.SetsockoptString(fd, unix.IPPROTO_SCTP, sctpInitMsg, string(opt)); err != nil {
+		return err
+	}
+	return unix.SetsockoptInt(fd, unix.IPPROTO_SCTP, sctpNoDelay, 1)
+}
+
+// sctpSetupMarked returns the setup for a socket that also sets the mark, if
+// not 0.
+func sctpSetupMarked(mark uint32) func(int, syscall.RawConn) error {
+	return func(fd int, raw syscall.RawConn) error {
+		if mark != 0 {
+			if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_MARK, int(mark)); err != nil {
+				return fmt.Errorf("failed to set SO_MARK: %w", err)
+			}
+		}
+		return sctpSetup(fd, raw)
+	}
+}
+
+func dialSCTP(ctx context.Context, dst *net.TCPAddr, mark uint32) (net.Conn, error) {
+	conn, err := dialInet(ctx, unix.IPPROTO_SCTP, dst, nil, sctpSetupMarked(mark))
+	if err != nil {
+		return nil, err
+	}
+	return newSCTPConn(conn)
+}
+
+func listenSCTP(address string, mark uint32) (net.Listener, error) {
+	listener, err := listenInet(unix.IPPROTO_SCTP, address, sctpSetupMarked(mark))
+	if err != nil {
+		return nil, err
+	}
+	return &sctpListener{listener}, nil
+}
+
+// sctpListener accepts SCTP associations, which the net package takes for TCP
+// connections.
+type sctpListener struct {
+	net.Listener
+}
+
+func (l *sctpListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return newSCTPConn(conn)
+}
+
+func (l *sctpListener) Addr() net.Addr {
+	if addr, ok := l.Listener.Addr().(*net.TCPAddr); ok {
+		return sctpAddr{addr}
+	}
+	return l.Listener.Addr()
+}
+
+// sctpConn is a net.Conn over an SCTP association, on which each write is sent
+// as a single message, on the stream for the frame that it holds.
+type sctpConn struct {
+	conn    *net.TCPConn
+	raw     syscall.RawConn
+	buf     []byte     // Only used by Read
+	readBuf []byte     // Only used by Read
+	wmutex  sync.Mutex // Only one frame may be written at once
+	streams uint16     // Protected by wmutex
+}
+
+func newSCTPConn(c net.Conn) (*sctpConn, error) {
+	conn, ok := c.(*net.TCPConn)
+	if !ok {
+		c.Close()
+		return nil, errors.New("sctp socket was not a stream socket")
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &sctpConn{
+		conn:    conn,
+		raw:     raw,
+		buf:     make([]byte, 65536),
+		streams: sctpStreams,
+	}, nil
+}
+
+// Read returns the data of one message at a time, which may take several
+// reads from the socket if the message is large.
+func (c *sctpConn) Read(p []byte) (int, error) {
+	if len(c.readBuf) == 0 {
+		var msg []byte
+		for {
+			var n, flags int
+			var rerr error
+			if err := c.raw.Read(func(fd uintptr) bool {
+				n, _, flags, _, rerr = unix.Recvmsg(int(fd), c.buf, nil, 0)
+				return rerr != unix.EAGAIN
+			}); err != nil {
+				return 0, err
+			}
+			if rerr != nil {
+				return 0, &net.OpError{Op: "read", Net: "sctp", Addr: c.RemoteAddr(), Err: rerr}
+			}
+			if n == 0 {
+				return 0, io.EOF
+			}
+			if flags&unix.MSG_EOR != 0 && msg == nil {
+				msg = c.buf[:n] // The whole message came at once
+				break
+			}
+			msg = append(msg, c.buf[:n]...)
+			if flags&unix.MSG_EOR != 0 {
+				break
+			}
+		}
+		c.readBuf = msg
+	}
+	n := copy(p, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+	return n, nil
+}
+
+// Write sends a frame as a message. If the remote end accepted fewer streams
+// than were asked for, the frame goes on a stream that it did accept.
+func (c *sctpConn) Write(p []byte) (int, error) {
+	c.wmutex.Lock()
+	defer c.wmutex.Unlock()
+	for {
+		stream := sctpStream(p, c.streams)
+		err := c.send(p, stream)
+		if err == unix.EINVAL && stream != 0 {
+			c.streams = stream
+			continue
+		}
+		if err != nil {
+			return 0, &net.OpError{Op: "write", Net: "sctp", Addr: c.RemoteAddr(), Err: err}
+		}
+		return len(p), nil
+	}
+}
+
+func (c *sctpConn) send(p []byte, stream uint16) error {
+	oob := make([]byte, unix.CmsgSpace(sctpSndRcvSize))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	h.Level = unix.IPPROTO_SCTP
+	h.Type = sctpSndRcv
+	h.SetLen(unix.CmsgLen(sctpSndRcvSize))
+	// The stream is the first field of struct sctp_sndrcvinfo
+	*(*uint16)(unsafe.Pointer(&oob[unix.CmsgLen(0)])) = stream
+	var serr error
+	if err := c.raw.Write(func(fd uintptr) bool {
+		_, serr = unix.SendmsgN(int(fd), p, oob, nil, 0)
+		return serr != unix.EAGAIN
+	}); err != nil {
+		return err
+	}
+	return serr
+}
+
+func (c *sctpConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *sctpConn) LocalAddr() net.Addr {
+	return sctpAddr{c.conn.LocalAddr().(*net.TCPAddr)}
+}
+
+func (c *sctpConn) RemoteAddr() net.Addr {
+	return sctpAddr{c.conn.RemoteAddr().(*net.TCPAddr)}
+}
+
+func (c *sctpConn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+func (c *sctpConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *sctpConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package core
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// SCTP needs a kernel module or a userspace stack on other platforms, which
+// aren't supported yet, so for now only the Linux kernel implementation is.
+var errNoSCTP = errors.New("sctp links are only supported on Linux")
+
+func dialSCTP(ctx context.Context, dst *net.TCPAddr, mark uint32) (net.Conn, error) {
+	return nil, errNoSCTP
+}
+
+func listenSCTP(address string, mark uint32) (net.Listener, error) {
+	return nil, errNoSCTP
+}
@@ -0,0 +1,299 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/url"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSerialBaud       = 115200
+	serialKeepaliveInterval = time.Second
+	serialTimeout           = 10 * time.Second
+	serialMaxFrame          = 1 << 17 // Larger than any frame from ironwood
+	serialFrameQueue        = 64
+)
+
+// The framing is SLIP, see RFC 1055, with a CRC-32 after the payload of each
+// frame. An empty payload is a keepalive.
+const (
+	slipEnd    = 0xC0
+	slipEsc    = 0xDB
+	slipEscEnd = 0xDC
+	slipEscEsc = 0xDD
+)
+
+var errSerialCRC = errors.New("serial frame failed its checksum")
+
+// tcpserial hangs the serial transport off of the TCP one, in the same way as
+// the UDP transport. A serial line has no error checking of its own, so each
+// write is sent as a single frame with a checksum, and corrupted frames are
+// dropped, as the network would drop a corrupted packet. There is no listener,
+// as a serial line is point-to-point: both ends of it list it in their peers.
+type tcpserial struct {
+	tcp     *tcp
+	upgrade *TcpUpgrade
+}
+
+// serialOptions are the options for a serial link, from its peer URI.
+type serialOptions struct {
+	device string
+	baud   int
+}
+
+func (s *tcpserial) init(tcp *tcp) {
+	s.tcp = tcp
+	s.upgrade = &TcpUpgrade{
+		upgrade: func(c net.Conn, _ *tcpOptions) (net.Conn, error) { return c, nil },
+		name:    "serial",
+		dial:    s.dial,
+	}
+}
+
+// parseSerialOptions reads the device and baud rate from a peer URI such as
+// serial:///dev/ttyUSB0?baud=115200, or serial:///COM3 on Windows.
+func parseSerialOptions(u *url.URL) (serialOptions, error) {
+	options := serialOptions{
+		device: u.Path,
+		baud:   defaultSerialBaud,
+	}
+	if runtime.GOOS == "windows" {
+		options.device = strings.TrimPrefix(options.device, "/")
+	}
+	if u.Host != "" || options.device == "" || options.device == "/" {
+		return options, fmt.Errorf("serial peer %s must give the path of a device, e.g. serial:///dev/ttyUSB0", u.String())
+	}
+	if b := u.Query().Get("baud"); b != "" {
+		n, err := strconv.ParseUint(b, 10, 32)
+		if err != nil || n == 0 {
+			return options, fmt.Errorf("serial peer %s has invalid baud %q", u.String(), b)
+		}
+		options.baud = int(n)
+	}
+	return options, nil
+}
+
+func (s *tcpserial) dial(_ context.Context, _ string, options *tcpOptions) (net.Conn, error) {
+	port, err := openSerial(options.serial.device, options.serial.baud)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", options.serial.device, err)
+	}
+	c := newSerialConn(port, serialAddr(options.serial.device))
+	go c.run()
+	go c.keepalive()
+	return c, nil
+}
+
+// appendSerialFrame appends the payload and its checksum to b as a frame.
+func appendSerialFrame(b, payload []byte) []byte {
+	var crc [4]byte
+	binary.BigEndian.PutUint32(crc[:], crc32.ChecksumIEEE(payload))
+	b = append(b, slipEnd)
+	for _, p := range [][]byte{payload, crc[:]} {
+		for _, c := range p {
+			switch c {
+			case slipEnd:
+				b = append(b, slipEsc, slipEscEnd)
+			case slipEsc:
+				b = append(b, slipEsc, slipEscEsc)
+			default:
+				b = append(b, c)
+			}
+		}
+	}
+	return append(b, slipEnd)
+}
+
+// readSerialFrame reads the next frame and returns its payload. It returns
+// errSerialCRC if the frame was corrupted, after which the next frame can
+// still be read, as the framing resynchronises at each end byte.
+func readSerialFrame(r *bufio.Reader) ([]byte, error) {
+	var frame []byte
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		switch c {
+		case slipEnd:
+			if len(frame) == 0 {
+				continue // Between frames
+			}
+			if len(frame) < 4 || len(frame) > serialMaxFrame {
+				return nil, errSerialCRC
+			}
+			payload, crc := frame[:len(frame)-4], frame[len(frame)-4:]
+			if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(crc) {
+				return nil, errSerialCRC
+			}
+			return payload, nil
+		case slipEsc:
+			if c, err = r.ReadByte(); err != nil {
+				return nil, err
+			}
+			switch c {
+			case slipEscEnd:
+				c = slipEnd
+			case slipEscEsc:
+				c = slipEsc
+			}
+		}
+		if len(frame) <= serialMaxFrame {
+			frame = append(frame, c)
+		}
+	}
+}
+
+// serialAddr is the device of a serial link.
+type serialAddr string
+
+func (a serialAddr) Network() string { return "serial" }
+func (a serialAddr) String() string  { return string(a) }
+
+// serialConn is a net.Conn over a serial line. As with UDP sessions, deadlines
+// are ignored, and the link is instead closed once keepalives stop arriving,
+// such as when the cable is unplugged.
+type serialConn struct {
+	port      io.ReadWriteCloser
+	addr      serialAddr
+	frames    chan []byte
+	readBuf   []byte     // Only used by Read
+	wmutex    sync.Mutex // Only one frame may be written at once
+	mutex     sync.Mutex // protects the below
+	lastSent  time.Time
+	lastRecv  time.Time
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newSerialConn(port io.ReadWriteCloser, addr serialAddr) *serialConn {
+	now := time.Now()
+	return &serialConn{
+		port:     port,
+		addr:     addr,
+		frames:   make(chan []byte, serialFrameQueue),
+		lastSent: now,
+		lastRecv: now,
+		closed:   make(chan struct{}),
+	}
+}
+
+func (c *serialConn) run() {
+	defer c.Close()
+	reader := bufio.NewReader(c.port)
+	for {
+		payload, err := readSerialFrame(reader)
+		switch {
+		case err == errSerialCRC:
+			continue
+		case err != nil:
+			return
+		}
+		c.mutex.Lock()
+		c.lastRecv = time.Now()
+		c.mutex.Unlock()
+		if len(payload) == 0 {
+			continue // A keepalive
+		}
+		select {
+		case c.frames <- payload:
+		default:
+			// The reader has fallen behind, so drop the frame as the network would
+		}
+	}
+}
+
+func (c *serialConn) keepalive() {
+	ticker := time.NewTicker(serialKeepaliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-ticker.C:
+		}
+		c.mutex.Lock()
+		now := time.Now()
+		timedOut := now.Sub(c.lastRecv) > serialTimeout
+		idle := now.Sub(c.lastSent) >= serialKeepaliveInterval
+		c.mutex.Unlock()
+		switch {
+		case timedOut:
+			c.Close()
+			return
+		case idle:
+			_, _ = c.Write(nil)
+		}
+	}
+}
+
+func (c *serialConn) Read(p []byte) (int, error) {
+	if len(c.readBuf) == 0 {
+		select {
+		case c.readBuf = <-c.frames:
+		case <-c.closed:
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+	return n, nil
+}
+
+func (c *serialConn) Write(p []byte) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	frame := appendSerialFrame(make([]byte, 0, len(p)+16), p)
+	c.wmutex.Lock()
+	defer c.wmutex.Unlock()
+	if _, err := c.port.Write(frame); err != nil {
+		return 0, err
+	}
+	c.mutex.Lock()
+	c.lastSent = time.Now()
+	c.mutex.Unlock()
+	return len(p), nil
+}
+
+func (c *serialConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		err = c.port.Close()
+	})
+	return err
+}
+
+func (c *serialConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+func (c *serialConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func (c *serialConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *serialConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *serialConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !openbsd && !windows
+// +build !linux,!darwin,!freebsd,!openbsd,!windows
+
+package core
+
+import (
+	"errors"
+	"io"
+)
+
+func openSerial(device string, baud int) (io.ReadWriteCloser, error) {
+	return nil, errors.New("serial links are not supported on this platform")
+}
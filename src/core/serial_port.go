@@ -0,0 +1,20 @@
+//go:build linux || darwin || freebsd || openbsd || windows
+// +build linux darwin freebsd openbsd windows
+
+package core
+
+import (
+	"io"
+
+	"go.bug.st/serial"
+)
+
+// openSerial opens the device as 8N1 at the given baud rate.
+func openSerial(device string, baud int) (io.ReadWriteCloser, error) {
+	return serial.Open(device, &serial.Mode{
+		BaudRate: baud,
+		DataBits: 8,
+		Parity:   serial.NoParity,
+		StopBits: serial.OneStopBit,
+	})
+}
@@ -0,0 +1,44 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+// TestSerialFraming checks that frames which contain the framing bytes survive
+// a serial line, and that a corrupted frame is dropped without losing the
+// frames after it.
+func TestSerialFraming(t *testing.T) {
+	frames := [][]byte{
+		{slipEnd, 1, slipEsc, slipEscEnd, slipEscEsc},
+		{},
+		bytes.Repeat([]byte{slipEsc, slipEnd}, 100),
+		[]byte("corrupted"),
+		[]byte("after"),
+	}
+	var line []byte
+	for i, frame := range frames {
+		start := len(line)
+		line = appendSerialFrame(line, frame)
+		if i == 3 {
+			line[start+2] ^= 0x01
+		}
+	}
+	reader := bufio.NewReader(bytes.NewReader(line))
+	for i, frame := range frames {
+		payload, err := readSerialFrame(reader)
+		if i == 3 {
+			if err != errSerialCRC {
+				t.Fatalf("corrupted frame was not dropped: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if !bytes.Equal(payload, frame) {
+			t.Fatalf("frame %d came out as %v", i, payload)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package core
+
+// This file contains a simple service registry, which maps human-readable
+// service names onto the key and port of the node providing them. Lookups are
+// flooded to our direct peers, which answer if they provide the service and
+// forward the lookup on to their own peers, up to a limited number of hops.
+// Each lookup carries a random id, which nodes remember for a while so that
+// a lookup isn't handled twice. Answers go back hop by hop along the path that
+// the lookup took, rather than straight to the origin named in the lookup, so
+// that a node can't have others send answers to a node that didn't ask. The
+// origin isn't authenticated, so each node only handles a limited number of
+// lookups from each of the nodes that sent them to it, and remembers a limited
+// number of lookups in all, so that lookups can't be used to flood the
+// network. Nodes that forward answers could change them, so an answer is only
+// a hint as to which key to connect to.
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"time"
+
+	iwt "github.com/Arceliar/ironwood/types"
+	"github.com/Arceliar/phony"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/address"
+)
+
+const (
+	serviceLookupHops    = 4
+	serviceNameMaxLength = 64
+	serviceSeenTimeout   = 30 * time.Second
+	serviceSenderLimit   = 16   // Lookups from each sender that are handled per serviceSeenTimeout
+	serviceSendersMax    = 256  // Senders that lookups are handled from per serviceSeenTimeout
+	serviceSeenMax       = 4096 // Lookups that are remembered at once
+	serviceLookupIDSize  = 8
+)
+
+type serviceLookupID [serviceLookupIDSize]byte
+
+// ServiceEntry represents a node that is providing a named service.
+type ServiceEntry struct {
+	Key  ed25519.PublicKey
+	Port uint16
+}
+
+type services struct {
+	phony.Inbox
+	proto      *protoHandler
+	registered map[string]uint16
+	seen       map[serviceLookupID]serviceSeen // To stop lookups looping
+	senders    map[keyArray]int                // Lookups handled from each sender since the last cleanup
+	lookups    map[serviceLookupID]chan ServiceEntry
+}
+
+// serviceSeen is a lookup that has been handled, and the node that it came
+// from, to send answers back to.
+type serviceSeen struct {
+	when time.Time
+	from keyArray // Our own key for our own lookups
+	name string
+}
+
+func (s *services) init(proto *protoHandler) {
+	s.Act(nil, func() {
+		s.proto = proto
+		s.registered = make(map[string]uint16)
+		s.seen = make(map[serviceLookupID]serviceSeen)
+		s.senders = make(map[keyArray]int)
+		s.lookups = make(map[serviceLookupID]chan ServiceEntry)
+		s._cleanup()
+	})
+}
+
+// _cleanup forgets old lookups and resets the limit for each sender, every
+// serviceSeenTimeout until the node stops.
+func (s *services) _cleanup() {
+	core := s.proto.core
+	for id, seen := range s.seen {
+		if core.clock.Now().Sub(seen.when) > serviceSeenTimeout {
+			delete(s.seen, id)
+		}
+	}
+	s.senders = make(map[keyArray]int)
+	core.clock.AfterFunc(serviceSeenTimeout, func() {
+		select {
+		case <-core.ctx.Done():
+		default:
+			s.Act(nil, s._cleanup)
+		}
+	})
+}
+
+func checkServiceName(name string) error {
+	switch {
+	case name == "":
+		return errors.New("service name is empty")
+	case len(name) > serviceNameMaxLength:
+		return errors.New("service name is too long")
+	}
+	return nil
+}
+
+func (s *services) _sendLookup(to, origin keyArray, id serviceLookupID, hops uint8, name string) {
+	bs := []byte{typeSessionProto, typeProtoServiceRequest, hops}
+	bs = append(bs, origin[:]...)
+	bs = append(bs, id[:]...)
+	bs = append(bs, name...)
+	_, _ = s.proto.core.PacketConn.WriteTo(bs, iwt.Addr(to[:]))
+}
+
+// Sends a lookup to each of our direct peers, except for those listed.
+func (s *services) _floodLookup(origin keyArray, id serviceLookupID, hops uint8, name string, except ...keyArray) {
+peers:
+	for _, p := range s.proto.core.GetPeers() {
+		var key keyArray
+		copy(key[:], p.Key)
+		for _, e := range except {
+			if key == e {
+				continue peers
+			}
+		}
+		s._sendLookup(key, origin, id, hops, name)
+	}
+}
+
+// handleReq answers a lookup from the node with the given key, if we provide
+// the service, and forwards it to our peers while it has hops left.
+func (s *services) handleReq(from phony.Actor, key keyArray, bs []byte) {
+	s.Act(from, func() {
+		var origin keyArray
+		var id serviceLookupID
+		if len(bs) <= 1+len(origin)+len(id) {
+			return
+		}
+		hops := bs[0]
+		if hops > serviceLookupHops {
+			hops = serviceLookupHops
+		}
+		copy(origin[:], bs[1:])
+		copy(id[:], bs[1+len(origin):])
+		name := string(bs[1+len(origin)+len(id):])
+		if checkServiceName(name) != nil {
+			return
+		}
+		if _, seen := s.seen[id]; seen {
+			return
+		}
+		// The sender is the only part of the lookup that can't be made up
+		if n, ok := s.senders[key]; n >= serviceSenderLimit || (!ok && len(s.senders) >= serviceSendersMax) {
+			return
+		}
+		if len(s.seen) >= serviceSeenMax {
+			return
+		}
+		s.senders[key]++
+		s.seen[id] = serviceSeen{when: s.proto.core.clock.Now(), from: key, name: name}
+		if port, ok := s.registered[name]; ok {
+			var self keyArray
+			copy(self[:], s.proto.core.public)
+			s._sendResponse(key, port, self, origin, id, name)
+		}
+		if hops > 0 {
+			s._floodLookup(origin, id, hops-1, name, key, origin)
+		}
+	})
+}
+
+// _sendResponse sends an answer towards the origin of a lookup, by way of the
+// node that the lookup came from.
+func (s *services) _sendResponse(to keyArray, port uint16, provider, origin keyArray, id serviceLookupID, name string) {
+	res := []byte{typeSessionProto, typeProtoServiceResponse, 0, 0}
+	binary.BigEndian.PutUint16(res[2:], port)
+	res = append(res, provider[:]...)
+	res = append(res, origin[:]...)
+	res = append(res, id[:]...)
+	res = append(res, name...)
+	_, _ = s.proto.core.PacketConn.WriteTo(res, iwt.Addr(to[:]))
+}
+
+// handleRes passes an answer to our own lookup on to its callers, or sends it
+// on towards the origin along the path that the lookup took, if we forwarded
+// the lookup and it came from the peer that we sent it to.
+func (s *services) handleRes(from phony.Actor, key keyArray, bs []byte) {
+	s.Act(from, func() {
+		var provider, origin keyArray
+		var id serviceLookupID
+		if len(bs) <= 2+len(provider)+len(origin)+len(id) {
+			return
+		}
+		port := binary.BigEndian.Uint16(bs)
+		copy(provider[:], bs[2:])
+		copy(origin[:], bs[2+len(provider):])
+		copy(id[:], bs[2+len(provider)+len(origin):])
+		name := string(bs[2+len(provider)+len(origin)+len(id):])
+		seen, ok := s.seen[id]
+		if !ok || seen.name != name {
+			return
+		}
+		if !bytes.Equal(origin[:], s.proto.core.public) {
+			if key != seen.from {
+				s._sendResponse(seen.from, port, provider, origin, id, name)
+			}
+			return
+		}
+		ch, ok := s.lookups[id]
+		if !ok {
+			return
+		}
+		entry := ServiceEntry{
+			Key:  append(ed25519.PublicKey(nil), provider[:]...),
+			Port: port,
+		}
+		select {
+		case ch <- entry:
+		default:
+		}
+	})
+}
+
+// RegisterService advertises a service with the given name, which can then be
+// found by other nodes using LookupService. Registering a name that is already
+// registered replaces the port.
+func (c *Core) RegisterService(name string, port uint16) error {
+	if err := checkServiceName(name); err != nil {
+		return err
+	}
+	phony.Block(&c.proto.services, func() {
+		c.proto.services.registered[name] = port
+	})
+	return nil
+}
+
+// UnregisterService stops advertising a service with the given name.
+func (c *Core) UnregisterService(name string) {
+	phony.Block(&c.proto.services, func() {
+		delete(c.proto.services.registered, name)
+	})
+}
+
+// GetServices returns the services that are registered on this node.
+func (c *Core) GetServices() map[string]uint16 {
+	registered := make(map[string]uint16)
+	phony.Block(&c.proto.services, func() {
+		for name, port := range c.proto.services.registered {
+			registered[name] = port
+		}
+	})
+	return registered
+}
+
+// LookupService searches the nearby network for nodes providing a service
+// with the given name, returning all of the answers received before the
+// timeout expires.
+func (c *Core) LookupService(name string, timeout time.Duration) ([]ServiceEntry, error) {
+	if err := checkServiceName(name); err != nil {
+		return nil, err
+	}
+	var id serviceLookupID
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	s := &c.proto.services
+	ch := make(chan ServiceEntry, 16)
+	s.Act(nil, func() {
+		s.lookups[id] = ch
+		var self keyArray
+		copy(self[:], c.public)
+		s.seen[id] = serviceSeen{when: c.clock.Now(), from: self, name: name}
+		s._floodLookup(self, id, serviceLookupHops, name)
+	})
+	defer s.Act(nil, func() {
+		delete(s.lookups, id)
+	})
+	var entries []ServiceEntry
+	found := make(map[string]struct{})
+	expired := c.clock.After(timeout)
+	for {
+		select {
+		case <-expired:
+			return entries, nil
+		case entry := <-ch:
+			if _, ok := found[string(entry.Key)]; ok {
+				continue
+			}
+			found[string(entry.Key)] = struct{}{}
+			entries = append(entries, entry)
+		}
+	}
+}
+
+// Admin socket stuff
+
+type LookupServiceRequest struct {
+	Name string `json:"name"`
+}
+
+type LookupServiceResponse map[string]ServiceEntryInfo
+
+type ServiceEntryInfo struct {
+	Key  string `json:"key"`
+	Port uint16 `json:"port"`
+}
+
+func (s *services) lookupAdminHandler(in json.RawMessage) (interface{}, error) {
+	var req LookupServiceRequest
+	if err := json.Unmarshal(in, &req); err != nil {
+		return nil, err
+	}
+	entries, err := s.proto.core.LookupService(req.Name, 6*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	res := LookupServiceResponse{}
+	for _, entry := range entries {
+		ip := net.IP(address.AddrForKey(entry.Key)[:])
+		res[ip.String()] = ServiceEntryInfo{
+			Key:  hex.EncodeToString(entry.Key),
+			Port: entry.Port,
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"bytes"
+	"math/rand"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Arceliar/phony"
+)
+
+// TestCore_LookupService checks that a service registered on one node can be found by name from
+// another two hops away, with the answer coming back through the node in between, that it can be
+// looked up again straight away, and that each node only handles a limited number of lookups from
+// each node that sends them, whatever origin they claim.
+func TestCore_LookupService(t *testing.T) {
+	skipIfSessionRace(t)
+	nodeA, nodeB := CreateAndConnectTwo(t, false)
+	defer nodeA.Stop()
+	defer nodeB.Stop()
+	nodeC := new(Core)
+	if err := nodeC.Start(GenerateConfig(), GetLoggerWithPrefix("C: ", false)); err != nil {
+		t.Fatal(err)
+	}
+	defer nodeC.Stop()
+	u, err := url.Parse("tcp://" + nodeB.links.tcp.getAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nodeC.CallPeer(u, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !WaitConnected(nodeB, nodeC) {
+		t.Fatal("nodes did not connect")
+	}
+
+	// Protocol traffic is only handled while something is reading from the node
+	for _, n := range []*Core{nodeA, nodeB, nodeC} {
+		go func(n *Core) {
+			buf := make([]byte, 65535)
+			for {
+				if _, _, err := n.ReadFrom(buf); err != nil {
+					return
+				}
+			}
+		}(n)
+	}
+
+	if err := nodeA.RegisterService("echo", 7); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		entries, err := nodeC.LookupService("echo", 2*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || !bytes.Equal(entries[0].Key, nodeA.PublicKey()) || entries[0].Port != 7 {
+			t.Fatal("unexpected lookup result", i, entries)
+		}
+	}
+
+	var peer keyArray
+	copy(peer[:], nodeA.PublicKey())
+	s := &nodeB.proto.services
+	for i := 0; i < 2*serviceSenderLimit; i++ {
+		var origin keyArray
+		var id serviceLookupID
+		rand.Read(origin[:])
+		rand.Read(id[:])
+		req := append([]byte{255}, origin[:]...)
+		req = append(req, id[:]...)
+		req = append(req, "flood"...)
+		s.handleReq(nil, peer, req)
+	}
+	phony.Block(s, func() {
+		if n := s.senders[peer]; n != serviceSenderLimit {
+			t.Fatal("unexpected number of lookups handled", n)
+		}
+	})
+}
@@ -0,0 +1,76 @@
+package core
+
+// This file contains the graceful shutdown of links. When the node stops, each
+// link stops taking new frames, waits for the writes that are under way to
+// reach the connection, and then closes its sending side if the connection
+// can be half-closed. That tells the remote node that the link is going down
+// as soon as it has read everything before it, so the remote node closes its
+// end of the link straight away instead of losing frames or waiting for a
+// timeout. Links that are still up when the deadline passes are closed anyway.
+
+import (
+	"sync"
+	"time"
+)
+
+const linkShutdownTimeout = 2 * time.Second // How long links get to flush and close when stopping
+
+// shutdown stops any listeners and prevents new links from being set up, then
+// flushes every link and waits for the remote nodes to close them, up to the
+// timeout. Any links left after that are closed.
+func (l *links) shutdown(timeout time.Duration) {
+	l.mutex.Lock()
+	l.draining = true
+	active := make([]*link, 0, len(l.links))
+	for _, intf := range l.links {
+		active = append(active, intf)
+	}
+	l.mutex.Unlock()
+	l.tcp.stopListeners()
+	clk := l.core.clock
+	deadline := clk.Now().Add(timeout)
+	var wg sync.WaitGroup
+	for _, intf := range active {
+		wg.Add(1)
+		go func(intf *link) {
+			defer wg.Done()
+			intf.conn.flush(deadline.Sub(clk.Now()))
+			select {
+			case <-intf.closed:
+			case <-clk.After(deadline.Sub(clk.Now())):
+				l.core.log.Debugln("Closing link", intf.name(), "as it did not shut down in time")
+			}
+			intf.close()
+		}(intf)
+	}
+	wg.Wait()
+}
+
+// flush refuses any more writes, and waits for those that are under way to
+// finish, for up to the timeout. The sending side of the connection is then
+// closed, or the whole connection if it can't be half-closed.
+func (c *linkConn) flush(timeout time.Duration) {
+	// So that sends to a stalled remote node give up. Sockets only know the
+	// system clock, so this deadline can't come from c.clock.
+	_ = c.SetWriteDeadline(time.Now().Add(timeout))
+	locked := make(chan struct{})
+	go func() {
+		c.flushing.Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-c.clock.After(timeout):
+		// The remote node isn't reading, so give up on what it hasn't read
+		c.Close()
+		<-locked
+	}
+	c.closing = true
+	c.flushing.Unlock()
+	if hc, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		if hc.CloseWrite() == nil {
+			return
+		}
+	}
+	c.Close()
+}
@@ -0,0 +1,59 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCore_Drain(t *testing.T) {
+	nodeA, nodeB := CreateAndConnectTwo(t, false)
+	defer nodeA.Stop()
+
+	nodeB.Drain(100 * time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+
+	if l := len(nodeA.GetPeers()); l != 0 {
+		t.Fatal("unexpected number of peers after drain", l)
+	}
+}
+
+func TestLinkConn_Flush(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	c := &linkConn{Conn: local, clock: systemClock{}}
+	frame := []byte{0x00, 0x01, 0x01}
+	wrote := make(chan error, 1)
+	go func() {
+		_, err := c.Write(frame)
+		wrote <- err
+	}()
+	time.Sleep(10 * time.Millisecond) // Let the write start
+	flushed := make(chan struct{})
+	go func() {
+		c.flush(5 * time.Second)
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+		t.Fatal("flush returned before the write was read")
+	case <-time.After(50 * time.Millisecond):
+	}
+	buf := make([]byte, len(frame))
+	if _, err := io.ReadFull(remote, buf); err != nil || !bytes.Equal(buf, frame) {
+		t.Fatal("write was not flushed:", buf, err)
+	}
+	if err := <-wrote; err != nil {
+		t.Fatal("write failed:", err)
+	}
+	<-flushed
+	if _, err := c.Write(frame); !errors.Is(err, net.ErrClosed) {
+		t.Fatal("write after flush did not fail:", err)
+	}
+	if _, err := remote.Read(buf); err != io.EOF {
+		t.Fatal("remote node was not told that the link closed:", err)
+	}
+}
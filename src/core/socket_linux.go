@@ -0,0 +1,150 @@
+//go:build linux
+// +build linux
+
+package core
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// inetSockaddr converts a TCP address into a socket address, along with the
+// address family to open the socket with.
+func inetSockaddr(addr *net.TCPAddr) (int, unix.Sockaddr, error) {
+	if ip4 := addr.IP.To4(); ip4 != nil {
+		sa := &unix.SockaddrInet4{Port: addr.Port}
+		copy(sa.Addr[:], ip4)
+		return unix.AF_INET, sa, nil
+	}
+	sa := &unix.SockaddrInet6{Port: addr.Port}
+	if addr.IP != nil {
+		copy(sa.Addr[:], addr.IP.To16())
+	}
+	if addr.Zone != "" {
+		if ifi, err := net.InterfaceByName(addr.Zone); err == nil {
+			sa.ZoneId = uint32(ifi.Index)
+		} else if index, err := strconv.ParseUint(addr.Zone, 10, 32); err == nil {
+			sa.ZoneId = uint32(index)
+		} else {
+			return 0, nil, errors.New("unknown zone " + addr.Zone)
+		}
+	}
+	return unix.AF_INET6, sa, nil
+}
+
+// inetSocket opens a stream socket of the given IP protocol, such as Multipath
+// TCP or SCTP, which the net package can't open itself. The socket is closed
+// if setup fails.
+func inetSocket(family, proto int, name string, setup func(fd int, raw syscall.RawConn) error) (*os.File, int, error) {
+	fd, err := unix.Socket(family, unix.SOCK_STREAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, proto)
+	if err != nil {
+		return nil, -1, os.NewSyscallError("socket", err)
+	}
+	file := os.NewFile(uintptr(fd), name)
+	if setup != nil {
+		raw, err := file.SyscallConn()
+		if err == nil {
+			err = setup(fd, raw)
+		}
+		if err != nil {
+			file.Close()
+			return nil, -1, err
+		}
+	}
+	return file, fd, nil
+}
+
+// dialInet connects a stream socket of the given IP protocol to dst, from
+// local if it isn't nil, calling setup on the socket before it connects.
+func dialInet(ctx context.Context, proto int, dst, local *net.TCPAddr, setup func(fd int, raw syscall.RawConn) error) (net.Conn, error) {
+	family, sa, err := inetSockaddr(dst)
+	if err != nil {
+		return nil, err
+	}
+	file, fd, err := inetSocket(family, proto, dst.String(), setup)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close() // net.FileConn takes a copy of the socket
+	if local != nil {
+		_, lsa, err := inetSockaddr(local)
+		if err != nil {
+			return nil, err
+		}
+		if err := unix.Bind(fd, lsa); err != nil {
+			return nil, os.NewSyscallError("bind", err)
+		}
+	}
+	if err = unix.Connect(fd, sa); err != nil && err != unix.EINPROGRESS {
+		return nil, os.NewSyscallError("connect", err)
+	}
+	if err == unix.EINPROGRESS {
+		// Wait for the socket to become writable, which it does once the
+		// connection either succeeds or fails
+		if deadline, ok := ctx.Deadline(); ok {
+			_ = file.SetWriteDeadline(deadline)
+		}
+		raw, err := file.SyscallConn()
+		if err != nil {
+			return nil, err
+		}
+		var serr error
+		if err := raw.Write(func(fd uintptr) bool {
+			fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLOUT}}
+			if n, err := unix.Poll(fds, 0); err == nil && n == 0 {
+				return false
+			}
+			var errno int
+			errno, serr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_ERROR)
+			if serr == nil && errno != 0 {
+				serr = unix.Errno(errno)
+			}
+			return true
+		}); err != nil {
+			return nil, err
+		}
+		if serr != nil {
+			return nil, os.NewSyscallError("connect", serr)
+		}
+		_ = file.SetWriteDeadline(time.Time{})
+	}
+	return net.FileConn(file)
+}
+
+// listenInet is like dialInet, but listens on the given address instead.
+func listenInet(proto int, address string, setup func(fd int, raw syscall.RawConn) error) (net.Listener, error) {
+	addr, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	family, sa, err := inetSockaddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	file, fd, err := inetSocket(family, proto, address, setup)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close() // net.FileListener takes a copy of the socket
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+		return nil, os.NewSyscallError("setsockopt", err)
+	}
+	if family == unix.AF_INET6 && (addr.IP == nil || addr.IP.IsUnspecified()) {
+		// Listen on IPv4 too, as net.Listen does
+		_ = unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_V6ONLY, 0)
+	}
+	if err := unix.Bind(fd, sa); err != nil {
+		return nil, os.NewSyscallError("bind", err)
+	}
+	if err := unix.Listen(fd, unix.SOMAXCONN); err != nil {
+		return nil, os.NewSyscallError("listen", err)
+	}
+	return net.FileListener(file)
+}
@@ -0,0 +1,124 @@
+package core
+
+// This file contains the TCP socket options of links, as the defaults of the
+// operating system suit neither gaming, which wants every write sent straight
+// away, nor bulk transfers over long fat pipes, which want large buffers. The
+// TCPSocket config sets the options for every link over TCP, and peers and
+// listeners can override each of them with the nodelay, tcpkeepalive,
+// tcpkeepcount, sndbuf and rcvbuf options in their URI.
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// socketOptions holds the socket options from a peer or listener URI. Those
+// that are unset take their values from the TCPSocket config.
+type socketOptions struct {
+	noDelay   *bool
+	keepAlive time.Duration // The interval between TCP keepalives, if not 0
+	keepCount int           // Unanswered TCP keepalives before the connection is dropped, if not 0
+	sndbuf    int           // The size of the send buffer in bytes, if not 0
+	rcvbuf    int           // The size of the receive buffer in bytes, if not 0
+}
+
+// parseSocketOptions reads the socket options of a peer or listener URI.
+func parseSocketOptions(u *url.URL) (socketOptions, error) {
+	var o socketOptions
+	q := u.Query()
+	if s := q.Get("nodelay"); s != "" {
+		noDelay, err := strconv.ParseBool(s)
+		if err != nil {
+			return o, err
+		}
+		o.noDelay = &noDelay
+	}
+	if s := q.Get("tcpkeepalive"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return o, err
+		}
+		if d < time.Second {
+			return o, errors.New("tcpkeepalive must be at least 1s")
+		}
+		o.keepAlive = d
+	}
+	for _, opt := range []struct {
+		name  string
+		value *int
+	}{
+		{"tcpkeepcount", &o.keepCount},
+		{"sndbuf", &o.sndbuf},
+		{"rcvbuf", &o.rcvbuf},
+	} {
+		s := q.Get(opt.name)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return o, err
+		}
+		if n <= 0 {
+			return o, errors.New(opt.name + " must be positive")
+		}
+		*opt.value = n
+	}
+	return o, nil
+}
+
+// setSocketOptions applies the socket options of a link, and those from the
+// config that the link doesn't override, to its TCP connection. Failures only
+// make the link perform worse, so they are logged rather than returned.
+func (t *tcp) setSocketOptions(sock *net.TCPConn, o socketOptions) {
+	t.links.core.config.RLock()
+	cfg := t.links.core.config.TCPSocket
+	t.links.core.config.RUnlock()
+	noDelay := cfg.NoDelay
+	if o.noDelay != nil {
+		noDelay = *o.noDelay
+	}
+	keepAlive := time.Duration(cfg.KeepAliveInterval) * time.Second
+	if o.keepAlive > 0 {
+		keepAlive = o.keepAlive
+	}
+	keepCount, sndbuf, rcvbuf := int(cfg.KeepAliveCount), int(cfg.SendBuffer), int(cfg.ReceiveBuffer)
+	if o.keepCount > 0 {
+		keepCount = o.keepCount
+	}
+	if o.sndbuf > 0 {
+		sndbuf = o.sndbuf
+	}
+	if o.rcvbuf > 0 {
+		rcvbuf = o.rcvbuf
+	}
+	log := t.links.core.log
+	if err := sock.SetNoDelay(noDelay); err != nil {
+		log.Debugln("Failed to set TCP_NODELAY:", err)
+	}
+	if keepAlive > 0 {
+		if err := sock.SetKeepAlive(true); err != nil {
+			log.Debugln("Failed to set SO_KEEPALIVE:", err)
+		} else if err := sock.SetKeepAlivePeriod(keepAlive); err != nil {
+			log.Debugln("Failed to set the TCP keepalive interval:", err)
+		}
+	}
+	if keepCount > 0 {
+		if err := tcpSetKeepAliveCount(sock, keepCount); err != nil {
+			log.Debugln("Failed to set TCP_KEEPCNT:", err)
+		}
+	}
+	if sndbuf > 0 {
+		if err := sock.SetWriteBuffer(sndbuf); err != nil {
+			log.Debugln("Failed to set SO_SNDBUF:", err)
+		}
+	}
+	if rcvbuf > 0 {
+		if err := sock.SetReadBuffer(rcvbuf); err != nil {
+			log.Debugln("Failed to set SO_RCVBUF:", err)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package core
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSocketOptions(t *testing.T) {
+	u, _ := url.Parse("tcp://127.0.0.1:9001?nodelay=false&tcpkeepalive=30s&tcpkeepcount=4&sndbuf=65536")
+	o, err := parseSocketOptions(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.noDelay == nil || *o.noDelay || o.keepAlive != 30*time.Second || o.keepCount != 4 || o.sndbuf != 65536 || o.rcvbuf != 0 {
+		t.Fatalf("bad socket options: %+v", o)
+	}
+	for _, bad := range []string{"nodelay=maybe", "tcpkeepalive=10ms", "sndbuf=0", "rcvbuf=x"} {
+		u, _ := url.Parse("tcp://127.0.0.1:9001?" + bad)
+		if _, err := parseSocketOptions(u); err == nil {
+			t.Fatal("accepted", bad)
+		}
+	}
+}
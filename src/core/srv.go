@@ -0,0 +1,70 @@
+package core
+
+// This file contains peers that are found through DNS SRV records, so that
+// the operator of a domain can move, add or rebalance the nodes behind it
+// without everyone who peers with it having to change their config. A peer of
+// srv://example.com looks up _overlay._tcp.example.com on each call, and dials
+// the targets over TCP in the order of their priority, choosing between those
+// of the same priority at random by their weight, as in RFC 2782.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	srvService = "overlay"
+	srvProto   = "tcp"
+)
+
+// tcpsrv hangs the SRV peers off of the TCP transport, as they are just TCP
+// links to whichever target is reached first.
+type tcpsrv struct {
+	tcp     *tcp
+	upgrade *TcpUpgrade
+}
+
+func (s *tcpsrv) init(tcp *tcp) {
+	s.tcp = tcp
+	s.upgrade = &TcpUpgrade{
+		upgrade: func(c net.Conn, _ *tcpOptions) (net.Conn, error) { return c, nil },
+		name:    "srv",
+		dial:    s.dial,
+	}
+}
+
+// dial looks up the SRV records of the domain and tries each target in turn
+// until one of them connects.
+func (s *tcpsrv) dial(ctx context.Context, domain string, _ *tcpOptions) (net.Conn, error) {
+	ctx, done := context.WithTimeout(ctx, default_timeout)
+	defer done()
+	// The records are sorted by priority and shuffled by weight by the resolver
+	_, records, err := net.DefaultResolver.LookupSRV(ctx, srvService, srvProto, domain)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 1 && records[0].Target == "." {
+		return nil, fmt.Errorf("%s has no %s service", domain, srvService)
+	}
+	err = errors.New("no SRV records found for " + domain)
+	for _, record := range records {
+		target := strings.TrimSuffix(record.Target, ".")
+		saddr := net.JoinHostPort(target, fmt.Sprint(record.Port))
+		var dsts []*net.TCPAddr
+		if dsts, err = resolveTCPAddrs(ctx, saddr); err != nil {
+			s.tcp.links.core.log.Debugln("Failed to resolve SRV target", saddr, err)
+			continue
+		}
+		dialer := net.Dialer{Control: s.tcp.tcpContext}
+		conn, derr := s.tcp.dialHappyEyeballs(ctx, &dialer, dsts)
+		if derr == nil {
+			return conn, nil
+		}
+		err = derr
+		s.tcp.links.core.log.Debugln("Failed to dial SRV target", saddr, err)
+	}
+	return nil, err
+}
@@ -0,0 +1,241 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	sshKeepaliveInterval = 5 * time.Second
+	sshKeepaliveTimeout  = 10 * time.Second
+)
+
+// tcpssh hangs the SSH transport off of the TCP one, in the same way as the
+// Tor transport. A peer URI such as ssh://user@host:port/target:port connects
+// to the SSH server at host:port, which then forwards the link to the TCP
+// listener at target:port, as "ssh -W" would. This lets nodes peer through a
+// bastion host without a separate tunnel.
+type tcpssh struct {
+	tcp     *tcp
+	upgrade *TcpUpgrade
+}
+
+// sshOptions are the options for an SSH link, from its peer URI.
+type sshOptions struct {
+	server     string // The SSH server, which is usually a bastion host
+	user       string
+	target     string // The listener that the SSH server forwards the link to
+	keyFile    string // A private key to use alongside those from the agent
+	knownHosts string // The known_hosts file to check the host key against
+}
+
+func (s *tcpssh) init(tcp *tcp) {
+	s.tcp = tcp
+	s.upgrade = &TcpUpgrade{
+		upgrade: func(c net.Conn, _ *tcpOptions) (net.Conn, error) { return c, nil },
+		name:    "ssh",
+		dial:    s.dial,
+	}
+}
+
+// parseSSHOptions reads the options for an SSH link from its peer URI. The
+// user defaults to the local one, and the host key is checked against the
+// knownhosts option or else ~/.ssh/known_hosts. The keyfile option gives a
+// private key to offer, which isn't needed if the key is in the SSH agent.
+func parseSSHOptions(u *url.URL) (sshOptions, error) {
+	options := sshOptions{
+		server:     u.Host,
+		target:     strings.Trim(u.Path, "/"),
+		keyFile:    u.Query().Get("keyfile"),
+		knownHosts: u.Query().Get("knownhosts"),
+	}
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		return options, fmt.Errorf("ssh peer %s must give the port of the SSH server", u.String())
+	}
+	if _, _, err := net.SplitHostPort(options.target); err != nil {
+		return options, fmt.Errorf("ssh peer %s must give the host and port to forward to as its path", u.String())
+	}
+	if u.User != nil {
+		options.user = u.User.Username()
+	} else {
+		options.user = os.Getenv("USER")
+	}
+	if options.knownHosts == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return options, fmt.Errorf("ssh peer %s needs the knownhosts option: %w", u.String(), err)
+		}
+		options.knownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	return options, nil
+}
+
+func (s *tcpssh) config(options *sshOptions) (*ssh.ClientConfig, func(), error) {
+	hostKeys, err := knownhosts.New(options.knownHosts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read known hosts: %w", err)
+	}
+	var signers []ssh.Signer
+	cleanup := func() {}
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			cleanup = func() { conn.Close() }
+			if agentSigners, err := agent.NewClient(conn).Signers(); err == nil {
+				signers = append(signers, agentSigners...)
+			}
+		}
+	}
+	if options.keyFile != "" {
+		key, err := ioutil.ReadFile(options.keyFile)
+		if err != nil {
+			cleanup()
+			return nil, nil, fmt.Errorf("failed to read key file: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			cleanup()
+			return nil, nil, fmt.Errorf("failed to parse key file: %w", err)
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) == 0 {
+		cleanup()
+		return nil, nil, errors.New("no keys in the SSH agent or key file")
+	}
+	return &ssh.ClientConfig{
+		User:            options.user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signers...)},
+		HostKeyCallback: hostKeys,
+		Timeout:         default_timeout,
+	}, cleanup, nil
+}
+
+// dial connects to the SSH server and asks it to forward a channel to the
+// target. The address passed in is the server and the target together, so that
+// links to different targets through the same server are told apart.
+func (s *tcpssh) dial(ctx context.Context, saddr string, options *tcpOptions) (net.Conn, error) {
+	config, cleanup, err := s.config(&options.ssh)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	ctx, done := context.WithTimeout(ctx, default_timeout)
+	defer done()
+	dialer := net.Dialer{Control: s.tcp.tcpContext}
+	conn, err := dialer.DialContext(ctx, "tcp", options.ssh.server)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	sc, chans, reqs, err := ssh.NewClientConn(conn, options.ssh.server, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	client := ssh.NewClient(sc, chans, reqs)
+	channel, err := client.Dial("tcp", options.ssh.target)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("ssh server failed to forward to %s: %w", options.ssh.target, err)
+	}
+	c := &sshConn{
+		Conn:   channel,
+		client: client,
+		local:  conn.LocalAddr(),
+		remote: sshAddr(saddr),
+		closed: make(chan struct{}),
+	}
+	go c.keepalive()
+	return c, nil
+}
+
+// sshAddr is the address of a node reached through an SSH server, giving both
+// the server and the target, as a forwarded channel has no address of its own.
+type sshAddr string
+
+func (a sshAddr) Network() string { return "ssh" }
+func (a sshAddr) String() string  { return string(a) }
+
+// sshConn is a link forwarded by an SSH server. SSH channels don't support
+// deadlines, so they are ignored, and the link is instead closed if the SSH
+// server stops answering keepalives.
+type sshConn struct {
+	net.Conn
+	client *ssh.Client
+	local  net.Addr // The local address of the connection to the SSH server
+	remote sshAddr
+	closed chan struct{}
+}
+
+func (c *sshConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *sshConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *sshConn) keepalive() {
+	ticker := time.NewTicker(sshKeepaliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-ticker.C:
+		}
+		reply := make(chan error, 1)
+		go func() {
+			_, _, err := c.client.SendRequest("keepalive@openssh.com", true, nil)
+			reply <- err
+		}()
+		select {
+		case <-c.closed:
+			return
+		case err := <-reply:
+			if err == nil {
+				continue
+			}
+		case <-time.After(sshKeepaliveTimeout):
+		}
+		c.Close()
+		return
+	}
+}
+
+func (c *sshConn) Close() error {
+	select {
+	case <-c.closed:
+	default:
+		close(c.closed)
+	}
+	c.Conn.Close()
+	return c.client.Close()
+}
+
+func (c *sshConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *sshConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *sshConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
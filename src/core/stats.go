@@ -0,0 +1,188 @@
+package core
+
+// This file contains the statistics of links, for applications that embed a
+// node and want more than the logs say about its links. Each link counts the
+// frames that it sends and receives, on top of the bytes, and records how long
+// its handshake took and its most recent round trip times, if it measures
+// them. GetLinks returns these for every link that is up, and for the last
+// linkHistorySize links that have closed, along with why they closed, while
+// GetHandshakes counts the handshakes that have completed and failed.
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	linkRTTSamples  = 16 // Round trip times kept for each link
+	linkHistorySize = 32 // Links that have closed that are kept for GetLinks
+)
+
+// Link describes a link that is up, or one of the last few that have closed.
+type Link struct {
+	Type       string // e.g. TCP, TLS
+	Name       string
+	Local      string
+	Remote     string
+	Key        ed25519.PublicKey
+	Incoming   bool
+	Forced     bool // Whether the link skipped the AllowedPublicKeys check, as link-local links do
+	Up         bool
+	Penalty    uint64 // The penalty of the link, see penalty.go
+	RXBytes    uint64
+	TXBytes    uint64
+	RXPackets  uint64          // Frames, including those that ironwood ignores
+	TXPackets  uint64          // Likewise
+	Handshake  time.Duration   // How long the metadata exchange took
+	Latency    time.Duration   // Smoothed round trip time, if the link measures it
+	RTTSamples []time.Duration // The most recent round trip times, oldest first
+	Uptime     time.Duration   // How long the link has been up, or was up for
+	LastError  string          // Why the link closed, if it closed with an error
+}
+
+// frameCounter counts the frames in a stream of them, from their lengths.
+type frameCounter struct {
+	skip   int // Bytes left in the current frame
+	header [2]byte
+	have   int // Bytes of the length of the next frame so far
+}
+
+func (f *frameCounter) count(p []byte) (frames uint64) {
+	for len(p) > 0 {
+		if f.skip > 0 {
+			n := f.skip
+			if n > len(p) {
+				n = len(p)
+			}
+			f.skip -= n
+			p = p[n:]
+			continue
+		}
+		f.header[f.have] = p[0]
+		f.have++
+		p = p[1:]
+		if f.have == len(f.header) {
+			f.skip = int(binary.BigEndian.Uint16(f.header[:]))
+			f.have = 0
+			frames++
+		}
+	}
+	return frames
+}
+
+// linkFrames counts the frames of a link once the metadata has been exchanged,
+// as the metadata isn't framed. The counter for receiving is only used by read,
+// and the one for sending is protected by the wmutex of the linkConn.
+type linkFrames struct {
+	rx frameCounter
+	tx frameCounter
+}
+
+// linkRTTs keeps the most recent round trip times of a link.
+type linkRTTs struct {
+	mutex   sync.Mutex // protects the below
+	samples []time.Duration
+}
+
+func (r *linkRTTs) add(rtt time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.samples) >= linkRTTSamples {
+		r.samples = append(r.samples[:0], r.samples[1:]...)
+	}
+	r.samples = append(r.samples, rtt)
+}
+
+func (r *linkRTTs) get() []time.Duration {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]time.Duration(nil), r.samples...)
+}
+
+// stats returns the statistics of a link, which closed with err if it isn't
+// up.
+func (intf *link) stats(now time.Time, up bool, err error) Link {
+	l := Link{
+		Type:       strings.ToUpper(intf.info.linkType),
+		Name:       intf.lname,
+		Local:      intf.info.local,
+		Remote:     intf.info.remote,
+		Key:        append(ed25519.PublicKey(nil), intf.info.key[:]...),
+		Incoming:   intf.incoming,
+		Forced:     intf.force,
+		Up:         up,
+		Penalty:    intf.penalty.total(),
+		RXBytes:    atomic.LoadUint64(&intf.conn.rx),
+		TXBytes:    atomic.LoadUint64(&intf.conn.tx),
+		RXPackets:  atomic.LoadUint64(&intf.conn.rxFrames),
+		TXPackets:  atomic.LoadUint64(&intf.conn.txFrames),
+		Handshake:  intf.handshake,
+		Latency:    time.Duration(atomic.LoadInt64(&intf.penalty.latency)),
+		RTTSamples: intf.penalty.rtts.get(),
+		Uptime:     now.Sub(intf.conn.up),
+	}
+	if err != nil {
+		l.LastError = err.Error()
+	}
+	return l
+}
+
+// remember keeps the statistics of a link that is closing, for GetLinks. It
+// must be called with the mutex held.
+func (l *links) _remember(intf *link, err error) {
+	if len(l.history) >= linkHistorySize {
+		l.history = append(l.history[:0], l.history[1:]...)
+	}
+	l.history = append(l.history, intf.stats(l.core.clock.Now(), false, err))
+}
+
+// Handshakes counts the handshakes of links since the node started.
+type Handshakes struct {
+	Completed uint64
+	Failed    uint64 // Including those that timed out or sent a key that wasn't pinned
+}
+
+// linkHandshakes holds the counts for GetHandshakes.
+type linkHandshakes struct {
+	mutex  sync.Mutex // protects counts
+	counts Handshakes
+}
+
+// count records a handshake that has completed or failed.
+func (h *linkHandshakes) count(completed bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if completed {
+		h.counts.Completed++
+	} else {
+		h.counts.Failed++
+	}
+}
+
+// GetHandshakes returns the number of handshakes of links that have completed
+// and failed.
+func (c *Core) GetHandshakes() Handshakes {
+	c.links.handshakes.mutex.Lock()
+	defer c.links.handshakes.mutex.Unlock()
+	return c.links.handshakes.counts
+}
+
+// GetLinks returns the statistics of every link that is up, and then of the
+// links that have closed most recently, newest first.
+func (c *Core) GetLinks() []Link {
+	now := c.clock.Now()
+	c.links.mutex.Lock()
+	defer c.links.mutex.Unlock()
+	links := make([]Link, 0, len(c.links.links)+len(c.links.history))
+	for _, intf := range c.links.links {
+		links = append(links, intf.stats(now, true, nil))
+	}
+	for i := len(c.links.history) - 1; i >= 0; i-- {
+		links = append(links, c.links.history[i])
+	}
+	return links
+}
@@ -0,0 +1,38 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestCore_GetLinks(t *testing.T) {
+	nodeA, nodeB := CreateAndConnectTwo(t, false)
+	defer nodeA.Stop()
+	CheckEcho(t, nodeA, nodeB)
+	links := nodeA.GetLinks()
+	if len(links) != 1 || !links[0].Up || !bytes.Equal(links[0].Key, nodeB.PublicKey()) {
+		t.Fatalf("unexpected links %+v", links)
+	}
+	if l := links[0]; l.RXPackets == 0 || l.TXPackets == 0 || l.RXBytes == 0 || l.Handshake <= 0 {
+		t.Fatalf("link has no statistics: %+v", l)
+	}
+	// Once the link closes, it is kept with why it closed
+	nodeB.Stop()
+	for i := 0; i < 50 && (len(nodeA.GetLinks()) == 0 || nodeA.GetLinks()[0].Up); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if links = nodeA.GetLinks(); len(links) != 1 || links[0].Up {
+		t.Fatalf("closed link was not kept: %+v", links)
+	}
+	// Frames are counted from their lengths, however they are split up
+	var f frameCounter
+	stream := append(append([]byte{0, 3, 1, 2, 3}, 0, 0), 0, 1, 9)
+	var frames uint64
+	for _, b := range stream {
+		frames += f.count([]byte{b})
+	}
+	if frames != 3 || f.count(stream) != 3 {
+		t.Fatal("miscounted frames")
+	}
+}
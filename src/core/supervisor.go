@@ -0,0 +1,282 @@
+package core
+
+// This file contains the peer supervisor, which decides when each peer from
+// the Peers and InterfacePeers sections of the config is called. A peer that
+// keeps failing is retried with exponential backoff, with jitter so that many
+// nodes that lost the same peer at once don't all call it again together,
+// and a peer that is connected isn't called at all until its link drops.
+
+import (
+	"fmt"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	peerRetryInterval = time.Second      // How often the supervisor looks for peers that are due to be called
+	peerBackoffMin    = 5 * time.Second  // The delay after the first failure, or after a link drops
+	peerBackoffMax    = 10 * time.Minute // The longest delay, unless the backoff option is set
+)
+
+type peerSupervisor struct {
+	mutex sync.Mutex // protects peers
+	peers map[peerTarget]*peerState
+}
+
+// peerTarget is a configured peer, which is called from the interface, if
+// one is given.
+type peerTarget struct {
+	uri  string
+	intf string
+}
+
+// peerState is the retry state of a configured peer. It is given to the links
+// that are called for the peer, which report back when they go up and down.
+type peerState struct {
+	target      peerTarget
+	tier        int  // From the tier option, where lower tiers are preferred
+	preferred   bool // From the preferred option, which keeps the peer from being demoted
+	clock       clock
+	mutex       sync.Mutex  // protects everything below
+	failures    int         // Calls since the peer was last connected
+	next        time.Time   // When the peer may next be called
+	links       int         // Links to the peer that are up
+	err         error       // Why the last call failed, if it did
+	removed     bool        // Whether the peer has been removed with RemovePeer
+	active      bool        // Whether the peer has been chosen to be called, see rotation.go
+	activeSince time.Time   // When the peer was last chosen
+	quality     peerQuality // What the peer is scored on, see quality.go
+}
+
+// due returns true if the peer isn't connected and has waited out its backoff.
+func (s *peerState) due(now time.Time) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.links == 0 && !now.Before(s.next)
+}
+
+// connected returns true if any links to the peer are up.
+func (s *peerState) connected() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.links > 0
+}
+
+// calling schedules the next call, in case this one fails. The delay doubles
+// with each failure up to max, and is then jittered by up to half.
+func (s *peerState) calling(now time.Time, max time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delay := peerBackoffMin
+	for i := 0; i < s.failures && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	delay -= time.Duration(rand.Int63n(int64(delay/2) + 1))
+	s.next = now.Add(delay)
+	s.failures++
+}
+
+// failed records why a call failed. It is safe to call on a nil state, for a
+// link that isn't supervised.
+func (s *peerState) failed(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mutex.Lock()
+	s.err = err
+	s.mutex.Unlock()
+}
+
+// up marks the peer as connected, which resets its backoff. It returns false
+// if the peer has been removed since it was called, in which case the link
+// should be closed.
+func (s *peerState) up() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.removed {
+		return false
+	}
+	s.links++
+	s.failures = 0
+	s.err = nil
+	return true
+}
+
+// down is called as a link to the peer closes, so that it is called again
+// after the shortest delay.
+func (s *peerState) down() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.links--
+	s.next = s.clock.Now().Add(peerBackoffMin)
+}
+
+// get returns the state of a configured peer, creating it if needed.
+func (p *peerSupervisor) get(target peerTarget, clk clock) *peerState {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.peers == nil {
+		p.peers = make(map[peerTarget]*peerState)
+	}
+	s := p.peers[target]
+	if s == nil {
+		s = &peerState{target: target, clock: clk}
+		if u, err := url.Parse(target.uri); err == nil {
+			s.tier, _ = peerTier(u)
+			s.preferred, _ = strconv.ParseBool(u.Query().Get("preferred"))
+		}
+		p.peers[target] = s
+	}
+	return s
+}
+
+// activeTier returns the lowest tier that has a peer connected, if any.
+func (p *peerSupervisor) activeTier() (int, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	tier, ok := 0, false
+	for _, s := range p.peers {
+		if s.connected() && (!ok || s.tier < tier) {
+			tier, ok = s.tier, true
+		}
+	}
+	return tier, ok
+}
+
+// aboveTier returns the states of the connected peers in higher tiers than the
+// given one.
+func (p *peerSupervisor) aboveTier(tier int) map[*peerState]struct{} {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	states := make(map[*peerState]struct{})
+	for _, s := range p.peers {
+		if s.tier > tier && s.connected() {
+			states[s] = struct{}{}
+		}
+	}
+	return states
+}
+
+// prune forgets the state of peers that are no longer configured.
+func (p *peerSupervisor) prune(configured map[peerTarget]struct{}) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for target := range p.peers {
+		if _, ok := configured[target]; !ok {
+			delete(p.peers, target)
+		}
+	}
+}
+
+// remove forgets the state of a peer that has been removed, from all of the
+// interfaces that it is called from, and returns the states that it had.
+func (p *peerSupervisor) remove(u *url.URL) map[*peerState]struct{} {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	states := make(map[*peerState]struct{})
+	for target, s := range p.peers {
+		if !samePeer(target.uri, u) {
+			continue
+		}
+		s.mutex.Lock()
+		s.removed = true
+		s.mutex.Unlock()
+		states[s] = struct{}{}
+		delete(p.peers, target)
+	}
+	return states
+}
+
+// peerBackoff returns the longest delay between calls to a peer, which is
+// shorter for preferred peers and can be set with the backoff option.
+func peerBackoff(u *url.URL) (time.Duration, error) {
+	if s := u.Query().Get("backoff"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("peer %s has invalid backoff: %w", u.String(), err)
+		}
+		if d < peerBackoffMin {
+			d = peerBackoffMin
+		}
+		return d, nil
+	}
+	if preferred, _ := strconv.ParseBool(u.Query().Get("preferred")); preferred {
+		return preferredPeerInterval, nil
+	}
+	return peerBackoffMax, nil
+}
+
+// peerTier returns the tier of a peer, from its tier option, or 0 if it has
+// none.
+func peerTier(u *url.URL) (int, error) {
+	s := u.Query().Get("tier")
+	if s == "" {
+		return 0, nil
+	}
+	tier, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("peer %s has invalid tier: %w", u.String(), err)
+	}
+	return int(tier), nil
+}
+
+// PeerRetry describes the retry state of a configured peer.
+type PeerRetry struct {
+	URI       string
+	Interface string
+	Tier      int
+	Connected bool
+	Standby   bool      // Not being called, as ActivePeers other peers are
+	Failures  int       // Calls since the peer was last connected
+	Next      time.Time // When the peer will next be called, if not connected
+	LastError string    // Why the last call failed, if it did
+	// The score of the peer from 0 to 100, and what went into it, see quality.go
+	Score        int
+	Availability float64       // The fraction of the time that the peer has been connected
+	Flaps        int           // Links that dropped soon after coming up, in the last hour
+	Latency      time.Duration // The round trip time of the last link, if measured
+	Throughput   uint64        // Bytes per second while connected
+	Demoted      time.Time     // Until when the peer is demoted, if it is
+}
+
+// GetPeerRetries returns the retry state of each configured peer.
+func (c *Core) GetPeerRetries() []PeerRetry {
+	now := c.clock.Now()
+	c.peers.mutex.Lock()
+	defer c.peers.mutex.Unlock()
+	retries := make([]PeerRetry, 0, len(c.peers.peers))
+	for target, s := range c.peers.peers {
+		s.mutex.Lock()
+		m := s.measure(now)
+		retry := PeerRetry{
+			URI:       target.uri,
+			Interface: target.intf,
+			Tier:      s.tier,
+			Connected: s.links > 0,
+			Standby:   !s.active,
+			Failures:  s.failures,
+
+			Score:        s.quality.score,
+			Availability: m.availability,
+			Flaps:        m.flaps,
+			Latency:      m.latency,
+			Throughput:   uint64(m.throughput),
+			Demoted:      s.quality.demoted,
+		}
+		if !retry.Connected {
+			retry.Next = s.next
+		}
+		if s.err != nil {
+			retry.LastError = s.err.Error()
+		}
+		s.mutex.Unlock()
+		retries = append(retries, retry)
+	}
+	return retries
+}
@@ -0,0 +1,129 @@
+package core
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCore_PeerTiers(t *testing.T) {
+	start := func(prefix string, listen, peers []string) *Core {
+		cfg := GenerateConfig()
+		cfg.Listen = listen
+		cfg.Peers = peers
+		node := new(Core)
+		if err := node.Start(cfg, GetLoggerWithPrefix(prefix, false)); err != nil {
+			t.Fatal(err)
+		}
+		return node
+	}
+	connectedTo := func(node, other *Core) bool {
+		for _, peer := range node.GetPeers() {
+			if bytes.Equal(peer.Key, other.PublicKey()) {
+				return true
+			}
+		}
+		return false
+	}
+	backup := start("C: ", []string{"mem://tier-backup"}, nil)
+	defer backup.Stop()
+	node := start("B: ", nil, []string{"mem://tier-primary", "mem://tier-backup?tier=1"})
+	defer node.Stop()
+	// The primary isn't there, so the backup is used
+	for i := 0; i < 50 && !connectedTo(node, backup); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if !connectedTo(node, backup) {
+		t.Fatal("backup peer was not called")
+	}
+	primary := start("A: ", []string{"mem://tier-primary"}, nil)
+	defer primary.Stop()
+	// Once the primary is connected, the backup is disconnected
+	for i := 0; i < 50 && (!connectedTo(node, primary) || connectedTo(node, backup)); i++ {
+		node.RetryPeers() // Rather than waiting out the backoff
+		time.Sleep(100 * time.Millisecond)
+	}
+	if !connectedTo(node, primary) || connectedTo(node, backup) {
+		t.Fatal("backup peer was not replaced by the primary")
+	}
+}
+
+// TestCore_DialFailures checks that calls which fail before a connection is made, such as through a
+// SOCKS proxy that isn't running or to a link-local address without an interface, are recorded, as
+// are calls that are refused in the handshake, such as to a node without the pinned key.
+func TestCore_DialFailures(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := l.Addr().String()
+	l.Close() // Nothing is listening there any more
+	remote := new(Core)
+	if err := remote.Start(GenerateConfig(), GetLoggerWithPrefix("R: ", false)); err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Stop()
+	wrongKey := hex.EncodeToString(make([]byte, ed25519.PublicKeySize))
+	cfg := GenerateConfig()
+	cfg.Listen = nil
+	cfg.Peers = []string{
+		"socks://" + proxy + "/127.0.0.1:1",
+		"tcp://[fe80::1]:1",
+		"tcp://" + remote.links.tcp.getAddr().String() + "?key=" + wrongKey,
+	}
+	node := new(Core)
+	if err := node.Start(cfg, GetLoggerWithPrefix("", false)); err != nil {
+		t.Fatal(err)
+	}
+	defer node.Stop()
+	failed := func() int {
+		n := 0
+		for _, retry := range node.GetPeerRetries() {
+			if retry.LastError != "" {
+				n++
+			}
+		}
+		return n
+	}
+	for i := 0; i < 50 && failed() < len(cfg.Peers); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if n := failed(); n != len(cfg.Peers) {
+		t.Fatal("expected every call to have failed, got", n, node.GetPeerRetries())
+	}
+}
+
+func TestPeerBackoff(t *testing.T) {
+	clk := &fakeClock{now: time.Now()}
+	s := &peerState{clock: clk}
+	now := clk.Now()
+	last := time.Duration(0)
+	for i := 0; i < 10; i++ {
+		if !s.due(now) {
+			t.Fatalf("peer wasn't due after %d failures", i)
+		}
+		s.calling(now, time.Minute)
+		delay := s.next.Sub(now)
+		if delay < last/2 || delay > time.Minute {
+			t.Fatalf("backoff of %s after %d failures", delay, i+1)
+		}
+		if s.due(now.Add(delay - time.Millisecond)) {
+			t.Fatal("peer was due before its backoff")
+		}
+		last = delay
+		now = s.next
+	}
+	// Connecting resets the backoff, and the peer isn't due while connected
+	s.up()
+	if s.due(now.Add(time.Hour)) {
+		t.Fatal("peer was due while connected")
+	}
+	s.down()
+	s.calling(clk.Now(), time.Minute)
+	if delay := s.next.Sub(clk.Now()); delay > peerBackoffMin {
+		t.Fatalf("backoff of %s after reconnecting", delay)
+	}
+}
@@ -16,14 +16,18 @@ package core
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/net/proxy"
 
 	"github.com/yggdrasil-network/yggdrasil-go/src/address"
@@ -38,9 +42,30 @@ type tcp struct {
 	waitgroup sync.WaitGroup
 	mutex     sync.Mutex // Protecting the below
 	listeners map[string]*TcpListener
+	filters   []listenFilter // From ListenFilters, for the source addresses of inbound connections
+	throttle  throttle       // Limits on inbound connections, see throttle.go
 	calls     map[string]struct{}
 	conns     map[linkInfo](chan struct{})
 	tls       tcptls
+	ws        tcpws
+	udp       tcpudp
+	npipe     tcpnpipe
+	kcp       tcpkcp
+	onion     tcponion
+	i2p       tcpi2p
+	ssh       tcpssh
+	h2        tcph2
+	serial    tcpserial
+	bt        tcpbt
+	mem       tcpmem
+	eth       tcpeth
+	awdl      tcpawdl
+	wg        tcpwg
+	icmp      tcpicmp
+	dns       tcpdns
+	sctp      tcpsctp
+	srv       tcpsrv
+	mark      uint32 // The firewall mark for link sockets, from LinkMark, if not 0
 }
 
 // TcpListener is a stoppable TCP listener interface. These are typically
@@ -50,12 +75,16 @@ type tcp struct {
 type TcpListener struct {
 	Listener net.Listener
 	opts     tcpOptions
+	options  linkOptions // Set after the listener has started, so protected by tcp.mutex
 	stop     chan struct{}
 }
 
 type TcpUpgrade struct {
 	upgrade func(c net.Conn, o *tcpOptions) (net.Conn, error)
 	name    string
+	// Transports that don't run over TCP at all set dial, which is then used
+	// to make outbound connections instead of dialling over TCP
+	dial func(ctx context.Context, saddr string, o *tcpOptions) (net.Conn, error)
 }
 
 type tcpOptions struct {
@@ -65,6 +94,28 @@ type tcpOptions struct {
 	socksProxyAuth *proxy.Auth
 	socksPeerAddr  string
 	tlsSNI         string
+	tlsALPN        []string
+	wsURL          string
+	kcp            kcpOptions
+	fec            fecOptions // Forward error correction, for UDP
+	samAddr        string
+	ssh            sshOptions
+	h2             h2Options
+	serial         serialOptions
+	eth            ethOptions
+	wg             wgOptions
+	icmp           icmpOptions
+	dns            dnsOptions
+	sourceAddr     *net.TCPAddr // The local address to dial from, from the via option
+	fastOpen       bool         // Dial with TCP Fast Open, from the fastopen option
+}
+
+// dialFailed records why a call failed, for the peer if it is a configured
+// one and for the trace.
+func (o *tcpOptions) dialFailed(span trace.Span, err error) {
+	o.peer.failed(err)
+	endSpan(span, err)
+	failSpan(o.callSpan, err)
 }
 
 func (l *TcpListener) Stop() {
@@ -73,10 +124,10 @@ func (l *TcpListener) Stop() {
 }
 
 // Wrapper function to set additional options for specific connection types.
-func (t *tcp) setExtraOptions(c net.Conn) {
+func (t *tcp) setExtraOptions(c net.Conn, o socketOptions) {
 	switch sock := c.(type) {
 	case *net.TCPConn:
-		_ = sock.SetNoDelay(true)
+		t.setSocketOptions(sock, o)
 	// TODO something for socks5
 	default:
 	}
@@ -90,7 +141,9 @@ func (t *tcp) getAddr() *net.TCPAddr {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 	for _, l := range t.listeners {
-		return l.Listener.Addr().(*net.TCPAddr)
+		if addr, ok := l.Listener.Addr().(*net.TCPAddr); ok {
+			return addr
+		}
 	}
 	return nil
 }
@@ -98,7 +151,28 @@ func (t *tcp) getAddr() *net.TCPAddr {
 // Initializes the struct.
 func (t *tcp) init(l *links) error {
 	t.links = l
+	if err := t.initMark(); err != nil {
+		return err
+	}
 	t.tls.init(t)
+	t.ws.init(t)
+	t.udp.init(t)
+	t.npipe.init(t)
+	t.kcp.init(t)
+	t.onion.init(t)
+	t.i2p.init(t)
+	t.ssh.init(t)
+	t.h2.init(t)
+	t.serial.init(t)
+	t.bt.init(t)
+	t.mem.init(t)
+	t.eth.init(t)
+	t.awdl.init(t)
+	t.wg.init(t)
+	t.icmp.init(t)
+	t.dns.init(t)
+	t.sctp.init(t)
+	t.srv.init(t)
 	t.mutex.Lock()
 	t.calls = make(map[string]struct{})
 	t.conns = make(map[linkInfo](chan struct{}))
@@ -107,6 +181,13 @@ func (t *tcp) init(l *links) error {
 
 	t.links.core.config.RLock()
 	defer t.links.core.config.RUnlock()
+	filters, err := parseListenFilters(t.links.core.config.ListenFilters)
+	if err != nil {
+		return err
+	}
+	t.mutex.Lock()
+	t.filters = filters
+	t.mutex.Unlock()
 	for _, listenaddr := range t.links.core.config.Listen {
 		u, err := url.Parse(listenaddr)
 		if err != nil {
@@ -121,18 +202,63 @@ func (t *tcp) init(l *links) error {
 }
 
 func (t *tcp) stop() error {
+	t.stopListeners()
+	t.waitgroup.Wait()
+	return nil
+}
+
+// Stops all of the listeners without waiting for existing connections to close.
+func (t *tcp) stopListeners() {
 	t.mutex.Lock()
 	for _, listener := range t.listeners {
 		listener.Stop()
 	}
 	t.mutex.Unlock()
-	t.waitgroup.Wait()
-	return nil
 }
 
 func (t *tcp) listenURL(u *url.URL, sintf string) (*TcpListener, error) {
+	t.links.mutex.RLock()
+	draining := t.links.draining
+	t.links.mutex.RUnlock()
+	if draining {
+		return nil, errors.New("links are draining")
+	}
+	if err := parseBundleMode(u.Query().Get("bundle")); err != nil {
+		return nil, fmt.Errorf("listener %s has invalid bundle: %w", u.String(), err)
+	}
+	maxBpsUp, maxBpsDown, err := parseRateOptions(u)
+	if err != nil {
+		return nil, fmt.Errorf("listener %s has %w", u.String(), err)
+	}
+	keepalive, err := parseKeepalive(u)
+	if err != nil {
+		return nil, fmt.Errorf("listener %s has invalid keepalive: %w", u.String(), err)
+	}
+	compress, err := parseCompress(u)
+	if err != nil {
+		return nil, fmt.Errorf("listener %s has invalid compress: %w", u.String(), err)
+	}
+	password := u.Query().Get("password")
+	if err := checkPassword(password); err != nil {
+		return nil, fmt.Errorf("listener %s has invalid password: %w", u.String(), err)
+	}
+	padding, err := parsePadding(u)
+	if err != nil {
+		return nil, fmt.Errorf("listener %s has invalid padding: %w", u.String(), err)
+	}
+	cover, err := parseCover(u)
+	if err != nil {
+		return nil, fmt.Errorf("listener %s has invalid cover: %w", u.String(), err)
+	}
+	socket, err := parseSocketOptions(u)
+	if err != nil {
+		return nil, fmt.Errorf("listener %s has invalid socket options: %w", u.String(), err)
+	}
+	sockets := tcpListenOptions{fastOpen: parseFastOpen(u)}
+	if sockets.acceptors, err = parseAcceptors(u); err != nil {
+		return nil, fmt.Errorf("listener %s has invalid acceptors: %w", u.String(), err)
+	}
 	var listener *TcpListener
-	var err error
 	hostport := u.Host // Used for tcp and tls
 	if len(sintf) != 0 {
 		host, port, err := net.SplitHostPort(hostport)
@@ -140,33 +266,93 @@ func (t *tcp) listenURL(u *url.URL, sintf string) (*TcpListener, error) {
 			hostport = fmt.Sprintf("[%s%%%s]:%s", host, sintf, port)
 		}
 	}
-	switch u.Scheme {
-	case "tcp":
-		listener, err = t.listen(hostport, nil)
-	case "tls":
-		listener, err = t.listen(hostport, t.tls.forListener)
-	default:
+	if scheme, ok := linkSchemes[u.Scheme]; ok && scheme.listen != nil {
+		listener, err = scheme.listen(t, u, hostport, sockets)
+	} else {
 		t.links.core.log.Errorln("Failed to add listener: listener", u.String(), "is not correctly formatted, ignoring")
 	}
+	if listener != nil {
+		key := listenerKey(u, sintf)
+		t.mutex.Lock()
+		if _, isIn := t.listeners[key]; isIn {
+			t.mutex.Unlock()
+			listener.Listener.Close()
+			return nil, fmt.Errorf("listener %s is already running", u.String())
+		}
+		// Tracked straight away, so that it can be found once this returns
+		t.listeners[key] = listener
+		listener.options.obfuscation = u.Query().Get("obfs")
+		listener.options.bundle = u.Query().Get("bundle")
+		listener.options.maxBpsUp = maxBpsUp
+		listener.options.maxBpsDown = maxBpsDown
+		listener.options.keepalive = keepalive
+		listener.options.compress = compress
+		listener.options.padding = padding
+		listener.options.password = password
+		listener.options.cover = cover
+		listener.options.socket = socket
+		listener.options.lossPenalty, _ = strconv.ParseBool(u.Query().Get("losspenalty"))
+		listener.options.latencyPenalty, _ = strconv.ParseBool(u.Query().Get("latencypenalty"))
+		t.mutex.Unlock()
+		t.waitgroup.Add(1)
+		go t.listener(listener, key)
+	}
 	return listener, err
 }
 
-func (t *tcp) listen(listenaddr string, upgrade *TcpUpgrade) (*TcpListener, error) {
+// listenerKey returns what the listener for the URI is tracked under, which
+// is the same for any URI that would listen in the same place with the same
+// transport, and different otherwise.
+func listenerKey(u *url.URL, sintf string) string {
+	key := u.Scheme + "://" + strings.ToLower(u.Host) + u.Path
+	if sintf != "" {
+		key += "%" + sintf
+	}
+	return key
+}
+
+// tcpListenOptions holds the options for the sockets of a tcp://, tls:// or
+// ws:// listener, rather than for its links.
+type tcpListenOptions struct {
+	fastOpen  bool // Accept TCP Fast Open, from the fastopen option
+	acceptors int  // The sockets to accept on, from the acceptors option
+}
+
+func (t *tcp) listen(listenaddr string, upgrade *TcpUpgrade, o tcpListenOptions) (*TcpListener, error) {
 	var err error
 
-	ctx := t.links.core.ctx
 	lc := net.ListenConfig{
 		Control: t.tcpContext,
 	}
-	listener, err := lc.Listen(ctx, "tcp", listenaddr)
+	if o.fastOpen {
+		lc.Control = t.fastOpenControl(lc.Control, true)
+	}
+	if o.acceptors > 1 {
+		lc.Control = reusePortControl(lc.Control)
+	}
+	listener, err := t.listenTCP(&lc, listenaddr)
+	if err == nil && o.acceptors > 1 {
+		// The other sockets are bound to the same port as the first, in case
+		// it was picked by the kernel
+		sockets := []net.Listener{listener}
+		for len(sockets) < o.acceptors {
+			var socket net.Listener
+			if socket, err = t.listenTCP(&lc, listener.Addr().String()); err != nil {
+				for _, socket := range sockets {
+					socket.Close()
+				}
+				return nil, err
+			}
+			sockets = append(sockets, socket)
+		}
+		listener = newMultiListener(sockets)
+	}
 	if err == nil {
 		l := TcpListener{
 			Listener: listener,
 			opts:     tcpOptions{upgrade: upgrade},
 			stop:     make(chan struct{}),
 		}
-		t.waitgroup.Add(1)
-		go t.listener(&l, listenaddr)
 		return &l, nil
 	}
 
@@ -174,30 +360,21 @@ func (t *tcp) listen(listenaddr string, upgrade *TcpUpgrade) (*TcpListener, erro
 }
 
 // Runs the listener, which spawns off goroutines for incoming connections.
-func (t *tcp) listener(l *TcpListener, listenaddr string) {
+// The listener must already be tracked under the key, by listenURL.
+func (t *tcp) listener(l *TcpListener, key string) {
 	defer t.waitgroup.Done()
-	if l == nil {
-		return
-	}
-	// Track the listener so that we can find it again in future
-	t.mutex.Lock()
-	if _, isIn := t.listeners[listenaddr]; isIn {
-		t.mutex.Unlock()
-		l.Listener.Close()
-		return
-	}
 	callproto := "TCP"
 	if l.opts.upgrade != nil {
 		callproto = strings.ToUpper(l.opts.upgrade.name)
 	}
-	t.listeners[listenaddr] = l
-	t.mutex.Unlock()
 	// And here we go!
 	defer func() {
 		t.links.core.log.Infoln("Stopping", callproto, "listener on:", l.Listener.Addr().String())
 		l.Listener.Close()
 		t.mutex.Lock()
-		delete(t.listeners, listenaddr)
+		if t.listeners[key] == l {
+			delete(t.listeners, key)
+		}
 		t.mutex.Unlock()
 	}()
 	t.links.core.log.Infoln("Listening for", callproto, "on:", l.Listener.Addr().String())
@@ -218,12 +395,36 @@ func (t *tcp) listener(l *TcpListener, listenaddr string) {
 			time.Sleep(time.Second) // So we don't busy loop
 			continue
 		}
+		if t.filtered(sock.RemoteAddr()) {
+			t.links.core.log.Debugln("Refusing", callproto, "connection from", sock.RemoteAddr(), "as ListenFilters deny it")
+			sock.Close()
+			continue
+		}
+		handshakeDone, err := t.admit(sock)
+		if err != nil {
+			t.links.core.log.Debugln("Refusing", callproto, "connection from", sock.RemoteAddr(), "as there are", err)
+			sock.Close()
+			continue
+		}
 		t.waitgroup.Add(1)
 		options := l.opts
+		t.mutex.Lock()
+		options.linkOptions = l.options
+		t.mutex.Unlock()
+		options.handshakeDone = handshakeDone
 		go t.handler(sock, true, options)
 	}
 }
 
+// addrHost returns the host part of the address, or all of it if it doesn't
+// have a port, such as for a named pipe or an I2P destination.
+func addrHost(addr net.Addr) string {
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return host
+	}
+	return addr.String()
+}
+
 // Checks if we already are calling this address
 func (t *tcp) startCalling(saddr string) bool {
 	t.mutex.Lock()
@@ -247,53 +448,100 @@ func (t *tcp) call(saddr string, options tcpOptions, sintf string) {
 		}
 		if sintf != "" {
 			callname = fmt.Sprintf("%s/%s/%s", callproto, saddr, sintf)
+		} else if options.sourceAddr != nil {
+			callname = fmt.Sprintf("%s/%s/%s", callproto, saddr, options.sourceAddr)
 		}
 		if !t.startCalling(callname) {
+			if options.dialDone != nil {
+				options.dialDone()
+			}
 			return
 		}
+		dialSpan := startSpan(options.callSpan, "yggdrasil.dial", attribute.String("net.peer.name", saddr))
+		defer dialSpan.End() // In case the call gives up without an error
 		defer func() {
-			// Block new calls for a little while, to mitigate livelock scenarios
-			rand.Seed(time.Now().UnixNano())
-			delay := default_timeout + time.Duration(rand.Intn(10000))*time.Millisecond
-			time.Sleep(delay)
+			// Block new calls for a little while, to mitigate livelock scenarios,
+			// unless the peer supervisor is already backing off the peer
+			if options.peer == nil {
+				rand.Seed(time.Now().UnixNano())
+				delay := default_timeout + time.Duration(rand.Intn(10000))*time.Millisecond
+				<-t.links.core.clock.After(delay)
+			}
 			t.mutex.Lock()
 			delete(t.calls, callname)
 			t.mutex.Unlock()
 		}()
+		if options.dialDone != nil {
+			defer options.dialDone() // In case the call fails before the link is up
+		}
 		var conn net.Conn
 		var err error
-		if options.socksProxyAddr != "" {
-			if sintf != "" {
+		if options.upgrade != nil && options.upgrade.dial != nil {
+			if sintf != "" || options.sourceAddr != nil {
+				t.links.core.log.Debugln("Not calling", callproto, saddr, "as it can't be bound to a source interface or address")
+				options.dialFailed(dialSpan, errors.New("can't be bound to a source interface or address"))
+				return
+			}
+			conn, err = options.upgrade.dial(t.links.core.ctx, saddr, &options)
+			if err != nil {
+				t.links.core.log.Debugf("Failed to dial %s: %s", callproto, err)
+				options.dialFailed(dialSpan, err)
+				return
+			}
+			dialSpan.End()
+			t.waitgroup.Add(1)
+			if ch := t.handler(conn, false, options); ch != nil {
+				<-ch
+			}
+		} else if options.socksProxyAddr != "" {
+			if sintf != "" || options.sourceAddr != nil {
+				t.links.core.log.Debugln("Not calling", saddr, "as SOCKS can't be bound to a source interface or address")
+				options.dialFailed(dialSpan, errors.New("SOCKS can't be bound to a source interface or address"))
 				return
 			}
 			dialerdst, er := net.ResolveTCPAddr("tcp", options.socksProxyAddr)
 			if er != nil {
+				t.links.core.log.Debugf("Failed to resolve SOCKS proxy %s: %s", options.socksProxyAddr, er)
+				options.dialFailed(dialSpan, er)
 				return
 			}
 			var dialer proxy.Dialer
-			dialer, err = proxy.SOCKS5("tcp", dialerdst.String(), options.socksProxyAuth, proxy.Direct)
+			dialer, err = proxy.SOCKS5("tcp", dialerdst.String(), options.socksProxyAuth, &net.Dialer{Control: t.tcpContext})
 			if err != nil {
+				t.links.core.log.Debugf("Failed to set up SOCKS proxy %s: %s", dialerdst, err)
+				options.dialFailed(dialSpan, err)
 				return
 			}
 			ctx, done := context.WithTimeout(t.links.core.ctx, default_timeout)
 			conn, err = dialer.(proxy.ContextDialer).DialContext(ctx, "tcp", saddr)
 			done()
 			if err != nil {
+				t.links.core.log.Debugf("Failed to dial %s through SOCKS proxy %s: %s", saddr, dialerdst, err)
+				options.dialFailed(dialSpan, err)
 				return
 			}
+			dialSpan.End()
 			t.waitgroup.Add(1)
 			options.socksPeerAddr = saddr
 			if ch := t.handler(conn, false, options); ch != nil {
 				<-ch
 			}
 		} else {
-			dst, err := net.ResolveTCPAddr("tcp", saddr)
+			ctx, done := context.WithTimeout(t.links.core.ctx, default_timeout)
+			defer done()
+			dsts, err := resolveTCPAddrs(ctx, saddr)
 			if err != nil {
+				t.links.core.log.Debugf("Failed to resolve %s: %s", saddr, err)
+				options.dialFailed(dialSpan, err)
 				return
 			}
+			dst := dsts[0]
 			if dst.IP.IsLinkLocalUnicast() {
 				dst.Zone = sintf
 				if dst.Zone == "" {
+					err = fmt.Errorf("link-local address %s needs an interface", dst.IP)
+					t.links.core.log.Debugf("Not calling %s: %s", saddr, err)
+					options.dialFailed(dialSpan, err)
 					return
 				}
 			}
@@ -304,9 +552,11 @@ func (t *tcp) call(saddr string, options tcpOptions, sintf string) {
 				dialer.Control = t.getControl(sintf)
 				ief, err := net.InterfaceByName(sintf)
 				if err != nil {
+					options.dialFailed(dialSpan, err)
 					return
 				}
 				if ief.Flags&net.FlagUp == 0 {
+					options.dialFailed(dialSpan, fmt.Errorf("interface %s is down", sintf))
 					return
 				}
 				addrs, err := ief.Addrs()
@@ -340,17 +590,30 @@ func (t *tcp) call(saddr string, options tcpOptions, sintf string) {
 						}
 					}
 					if dialer.LocalAddr == nil {
+						options.dialFailed(dialSpan, fmt.Errorf("interface %s has no address to dial %s from", sintf, dst.IP))
 						return
 					}
 				}
 			}
-			ctx, done := context.WithTimeout(t.links.core.ctx, default_timeout)
-			conn, err = dialer.DialContext(ctx, "tcp", dst.String())
+			if options.sourceAddr != nil {
+				dialer.LocalAddr = options.sourceAddr
+			}
+			if options.fastOpen {
+				dialer.Control = t.fastOpenControl(dialer.Control, false)
+			}
+			if sintf != "" {
+				// The source address was picked for this destination
+				conn, err = t.dialTCP(ctx, &dialer, dst)
+			} else {
+				conn, err = t.dialHappyEyeballs(ctx, &dialer, dsts)
+			}
 			done()
 			if err != nil {
 				t.links.core.log.Debugf("Failed to dial %s: %s", callproto, err)
+				options.dialFailed(dialSpan, err)
 				return
 			}
+			dialSpan.End()
 			t.waitgroup.Add(1)
 			if ch := t.handler(conn, false, options); ch != nil {
 				<-ch
@@ -362,18 +625,31 @@ func (t *tcp) call(saddr string, options tcpOptions, sintf string) {
 func (t *tcp) handler(sock net.Conn, incoming bool, options tcpOptions) chan struct{} {
 	defer t.waitgroup.Done() // Happens after sock.close
 	defer sock.Close()
-	t.setExtraOptions(sock)
+	if options.handshakeDone != nil {
+		defer options.handshakeDone() // In case the link fails before its handshake is over
+	}
+	t.setExtraOptions(sock, options.socket)
+	raw := sock
+	if options.obfuscation != "" {
+		var err error
+		if sock, err = t.links.obfuscate(sock, options.obfuscation, incoming); err != nil {
+			t.links.core.log.Errorln("TCP handler obfuscation failed:", err)
+			options.peer.failed(err)
+			return nil
+		}
+	}
 	var upgraded bool
 	if options.upgrade != nil {
 		var err error
 		if sock, err = options.upgrade.upgrade(sock, &options); err != nil {
 			t.links.core.log.Errorln("TCP handler upgrade failed:", err)
+			options.peer.failed(err)
 			return nil
 		}
 		upgraded = true
 	}
 	var name, proto, local, remote string
-	if options.socksProxyAddr != "" {
+	if options.socksPeerAddr != "" {
 		name = "socks://" + sock.RemoteAddr().String() + "/" + options.socksPeerAddr
 		proto = "socks"
 		local, _, _ = net.SplitHostPort(sock.LocalAddr().String())
@@ -386,8 +662,7 @@ func (t *tcp) handler(sock net.Conn, incoming bool, options tcpOptions) chan str
 			proto = "tcp"
 			name = proto + "://" + sock.RemoteAddr().String()
 		}
-		local, _, _ = net.SplitHostPort(sock.LocalAddr().String())
-		remote, _, _ = net.SplitHostPort(sock.RemoteAddr().String())
+		local, remote = addrHost(sock.LocalAddr()), addrHost(sock.RemoteAddr())
 	}
 	localIP := net.ParseIP(local)
 	if localIP = localIP.To16(); localIP != nil {
@@ -402,6 +677,7 @@ func (t *tcp) handler(sock net.Conn, incoming bool, options tcpOptions) chan str
 			//  Maybe dial/listen at the application level
 			//  Then pass a net.Conn to the core library (after these kinds of checks are done)
 			t.links.core.log.Debugln("Dropping ygg-tunneled connection", local, remote)
+			options.peer.failed(errors.New("connection would be tunneled over yggdrasil"))
 			return nil
 		}
 	}
@@ -411,8 +687,14 @@ func (t *tcp) handler(sock net.Conn, incoming bool, options tcpOptions) chan str
 		t.links.core.log.Println(err)
 		panic(err)
 	}
+	link.raw = raw
 	t.links.core.log.Debugln("DEBUG: starting handler for", name)
 	ch, err := link.handler()
+	if errors.Is(err, ErrLinkAlreadyExists) {
+		// The caller waits for the existing link to close before calling again
+		t.links.core.log.Debugln("Closing", name, "as it duplicates a link that is already up")
+		return ch
+	}
 	t.links.core.log.Debugln("DEBUG: stopped handler for", name, err)
-	return ch
+	return nil
 }
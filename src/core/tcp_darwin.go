@@ -4,6 +4,8 @@
 package core
 
 import (
+	"errors"
+	"net"
 	"syscall"
 
 	"golang.org/x/sys/unix"
@@ -31,3 +33,43 @@ func (t *tcp) tcpContext(network, address string, c syscall.RawConn) error {
 func (t *tcp) getControl(sintf string) func(string, string, syscall.RawConn) error {
 	return t.tcpContext
 }
+
+func tcpRetransmits(c net.Conn) (retrans uint32, mss uint32, ok bool) {
+	return 0, 0, false
+}
+
+// tcpSetKeepAliveCount sets how many TCP keepalives may go unanswered before
+// the connection is dropped.
+func tcpSetKeepAliveCount(c *net.TCPConn, n int) error {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockerr error
+	if err := raw.Control(func(fd uintptr) {
+		sockerr = unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_KEEPCNT, n)
+	}); err != nil {
+		return err
+	}
+	return sockerr
+}
+
+func tcpFastOpen(c syscall.RawConn, listener bool) error {
+	return errors.New("not supported on this platform")
+}
+
+// tcpReusePort sets SO_REUSEPORT on a socket, so that several listeners can
+// share its address.
+func tcpReusePort(c syscall.RawConn) error {
+	var sockerr error
+	if err := c.Control(func(fd uintptr) {
+		sockerr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	}); err != nil {
+		return err
+	}
+	return sockerr
+}
+
+func socketMark(c syscall.RawConn, mark uint32) error {
+	return errors.New("not supported on this platform")
+}
@@ -4,6 +4,7 @@
 package core
 
 import (
+	"net"
 	"syscall"
 
 	"golang.org/x/sys/unix"
@@ -12,6 +13,10 @@ import (
 // WARNING: This context is used both by net.Dialer and net.Listen in tcp.go
 
 func (t *tcp) tcpContext(network, address string, c syscall.RawConn) error {
+	if err := t.markControl(network, address, c); err != nil {
+		// Unlike the congestion control, an unmarked socket may loop
+		return err
+	}
 	var control error
 	var bbr error
 
@@ -44,3 +49,79 @@ func (t *tcp) getControl(sintf string) func(string, string, syscall.RawConn) err
 		return t.tcpContext(network, address, c)
 	}
 }
+
+// tcpRetransmits returns the total number of segments that have been
+// retransmitted on the given TCP connection, along with its current send MSS.
+func tcpRetransmits(c net.Conn) (retrans uint32, mss uint32, ok bool) {
+	tc, isTCP := c.(*net.TCPConn)
+	if !isTCP {
+		return 0, 0, false
+	}
+	raw, err := tc.SyscallConn()
+	if err != nil {
+		return 0, 0, false
+	}
+	var info *unix.TCPInfo
+	var sockerr error
+	if err := raw.Control(func(fd uintptr) {
+		info, sockerr = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+	}); err != nil || sockerr != nil {
+		return 0, 0, false
+	}
+	return info.Total_retrans, info.Snd_mss, true
+}
+
+// tcpSetKeepAliveCount sets how many TCP keepalives may go unanswered before
+// the connection is dropped.
+func tcpSetKeepAliveCount(c *net.TCPConn, n int) error {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockerr error
+	if err := raw.Control(func(fd uintptr) {
+		sockerr = unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_KEEPCNT, n)
+	}); err != nil {
+		return err
+	}
+	return sockerr
+}
+
+// tcpFastOpen enables TCP Fast Open on a socket, so that a listener accepts
+// data in SYNs, or so that a connection sends its first write in the SYN.
+func tcpFastOpen(c syscall.RawConn, listener bool) error {
+	var sockerr error
+	if err := c.Control(func(fd uintptr) {
+		if listener {
+			sockerr = unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_FASTOPEN, tcpFastOpenQueue)
+		} else {
+			sockerr = unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_FASTOPEN_CONNECT, 1)
+		}
+	}); err != nil {
+		return err
+	}
+	return sockerr
+}
+
+// tcpReusePort sets SO_REUSEPORT on a socket, so that several listeners can
+// share its address.
+func tcpReusePort(c syscall.RawConn) error {
+	var sockerr error
+	if err := c.Control(func(fd uintptr) {
+		sockerr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	}); err != nil {
+		return err
+	}
+	return sockerr
+}
+
+// socketMark sets SO_MARK on a socket, which needs CAP_NET_ADMIN.
+func socketMark(c syscall.RawConn, mark uint32) error {
+	var sockerr error
+	if err := c.Control(func(fd uintptr) {
+		sockerr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_MARK, int(mark))
+	}); err != nil {
+		return err
+	}
+	return sockerr
+}
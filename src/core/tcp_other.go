@@ -4,6 +4,8 @@
 package core
 
 import (
+	"errors"
+	"net"
 	"syscall"
 )
 
@@ -16,3 +18,23 @@ func (t *tcp) tcpContext(network, address string, c syscall.RawConn) error {
 func (t *tcp) getControl(sintf string) func(string, string, syscall.RawConn) error {
 	return t.tcpContext
 }
+
+func tcpRetransmits(c net.Conn) (retrans uint32, mss uint32, ok bool) {
+	return 0, 0, false
+}
+
+func tcpSetKeepAliveCount(c *net.TCPConn, n int) error {
+	return errors.New("not supported on this platform")
+}
+
+func tcpFastOpen(c syscall.RawConn, listener bool) error {
+	return errors.New("not supported on this platform")
+}
+
+func tcpReusePort(c syscall.RawConn) error {
+	return errors.New("not supported on this platform")
+}
+
+func socketMark(c syscall.RawConn, mark uint32) error {
+	return errors.New("not supported on this platform")
+}
@@ -0,0 +1,41 @@
+package core
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+)
+
+// TestCore_ListenerKeys checks that listeners with different transports can
+// share an address, and that a listener can't be started twice.
+func TestCore_ListenerKeys(t *testing.T) {
+	nodeA, nodeB := new(Core), new(Core)
+	if err := nodeA.Start(GenerateConfig(), GetLoggerWithPrefix("A: ", false)); err != nil {
+		t.Fatal(err)
+	}
+	defer nodeA.Stop()
+	addr := nodeA.links.tcp.getAddr()
+	u, _ := url.Parse(fmt.Sprintf("udp://%s", addr))
+	if _, err := nodeA.Listen(u, ""); err != nil {
+		t.Fatal(err)
+	}
+	u, _ = url.Parse("tcp://127.0.0.1:0")
+	if _, err := nodeA.Listen(u, ""); err == nil {
+		t.Fatal("the same listener was started twice")
+	}
+	cfgB := GenerateConfig()
+	cfgB.Listen = nil
+	cfgB.Peers = []string{fmt.Sprintf("udp://%s", addr)}
+	if err := nodeB.Start(cfgB, GetLoggerWithPrefix("B: ", false)); err != nil {
+		t.Fatal(err)
+	}
+	defer nodeB.Stop()
+	if !WaitConnected(nodeA, nodeB) {
+		t.Fatal("nodes did not connect over UDP")
+	}
+	nodeA.links.tcp.mutex.Lock()
+	defer nodeA.links.tcp.mutex.Unlock()
+	if len(nodeA.links.tcp.listeners) != 2 {
+		t.Fatal("unexpected number of listeners", len(nodeA.links.tcp.listeners))
+	}
+}
@@ -0,0 +1,125 @@
+package core
+
+// This file contains the throttling of inbound connections, so that a flood of
+// connections to a public listener can't use up goroutines and memory before
+// AllowedPublicKeys is even checked. Each address may only open ListenRate new
+// connections each second, with bursts of twice that, where IPv6 addresses
+// count as their /64, as a single host usually has all of one. At most
+// MaxHandshakes connections across all of the listeners may be handshaking at
+// once, and each of them has inboundHandshakeTimeout to finish its handshake.
+// Connections over the limits are closed as soon as they are accepted.
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	throttleBurst           = 2                        // Seconds of ListenRate that an address may use at once
+	throttleMaxSources      = 4096                     // Addresses whose rates are tracked at once
+	inboundHandshakeTimeout = 2 * linkHandshakeTimeout // How long an inbound connection has to finish its handshake, if MaxHandshakes is set
+)
+
+type throttle struct {
+	mutex      sync.Mutex // protects the below
+	handshakes int        // Inbound connections that are handshaking
+	sources    map[string]*throttleSource
+}
+
+// throttleSource is a token bucket for the connections from an address.
+type throttleSource struct {
+	tokens float64
+	last   time.Time
+}
+
+// throttleKey returns what the rate of connections from an IP address is
+// counted against.
+func throttleKey(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String()
+	}
+	return ip.Mask(net.CIDRMask(64, 128)).String()
+}
+
+// refill adds the tokens for the time since the bucket was last used.
+func (s *throttleSource) refill(now time.Time, rate float64) {
+	s.tokens += now.Sub(s.last).Seconds() * rate
+	if s.tokens > rate*throttleBurst {
+		s.tokens = rate * throttleBurst
+	}
+	s.last = now
+}
+
+// _allow takes a token from the bucket of the address, if it has one. It must
+// be called with the mutex held.
+func (th *throttle) _allow(ip net.IP, now time.Time, rate float64) bool {
+	if th.sources == nil {
+		th.sources = make(map[string]*throttleSource)
+	}
+	key := throttleKey(ip)
+	s := th.sources[key]
+	if s == nil {
+		if len(th.sources) >= throttleMaxSources {
+			// Forget the addresses that are back to a full bucket
+			for k, other := range th.sources {
+				if other.refill(now, rate); other.tokens >= rate*throttleBurst {
+					delete(th.sources, k)
+				}
+			}
+		}
+		if len(th.sources) >= throttleMaxSources {
+			return true // Too many to track, so leave it to MaxHandshakes
+		}
+		s = &throttleSource{tokens: rate * throttleBurst, last: now}
+		th.sources[key] = s
+	}
+	s.refill(now, rate)
+	if s.tokens < 1 {
+		return false
+	}
+	s.tokens--
+	return true
+}
+
+// admit checks an inbound connection against the limits, and returns the
+// function to call once its handshake is over, which may be called more than
+// once. The connection is closed if its handshake takes too long.
+func (t *tcp) admit(sock net.Conn) (func(), error) {
+	t.links.core.config.RLock()
+	max, rate := t.links.core.config.MaxHandshakes, t.links.core.config.ListenRate
+	t.links.core.config.RUnlock()
+	th := &t.throttle
+	now := t.links.core.clock.Now()
+	th.mutex.Lock()
+	defer th.mutex.Unlock()
+	if ip := addrIP(sock.RemoteAddr()); rate > 0 && ip != nil && !th._allow(ip, now, float64(rate)) {
+		return nil, errors.New("too many connections from the address")
+	}
+	if max == 0 {
+		return func() {}, nil
+	}
+	if uint64(th.handshakes) >= max {
+		return nil, errors.New("too many handshakes in progress")
+	}
+	th.handshakes++
+	var once sync.Once
+	done := func() {
+		th.mutex.Lock()
+		th.handshakes--
+		th.mutex.Unlock()
+	}
+	timer := t.links.core.clock.AfterFunc(inboundHandshakeTimeout, func() {
+		once.Do(func() {
+			done()
+			sock.Close()
+		})
+	})
+	return func() {
+		once.Do(func() {
+			timer.Stop()
+			done()
+		})
+	}, nil
+}
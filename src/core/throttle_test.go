@@ -0,0 +1,72 @@
+package core
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	clk := &fakeClock{now: time.Now()}
+	cfg := GenerateConfig()
+	cfg.MaxHandshakes, cfg.ListenRate = 2, 1
+	tc := &tcp{links: &links{core: &Core{config: cfg, clock: clk}}}
+	conn := func(addr string) *throttleConn {
+		return &throttleConn{addr: &testAddr{addr}, closed: make(chan struct{})}
+	}
+	// Each address, or IPv6 /64, gets a burst and then ListenRate
+	first, err := tc.admit(conn("[2001:db8::1]:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first()
+	first() // Only releases the slot once
+	if tc.throttle.handshakes != 0 {
+		t.Fatal("wrong number of handshakes:", tc.throttle.handshakes)
+	}
+	second := conn("[2001:db8::2]:1")
+	if _, err := tc.admit(second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tc.admit(conn("[2001:db8::3]:1")); err == nil {
+		t.Fatal("connection over the rate was admitted")
+	}
+	// The other handshake is still in progress, so only one more fits
+	slow := conn("192.0.2.1:1")
+	if _, err := tc.admit(slow); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tc.admit(conn("192.0.2.2:1")); err == nil {
+		t.Fatal("connection over MaxHandshakes was admitted")
+	}
+	// Handshakes that take too long are closed, which frees their slots
+	clk.Advance(inboundHandshakeTimeout)
+	for _, c := range []*throttleConn{second, slow} {
+		select {
+		case <-c.closed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("slow handshake was not closed")
+		}
+	}
+	if _, err := tc.admit(conn("[2001:db8::3]:1")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// throttleConn is a net.Conn with the given remote address.
+type throttleConn struct {
+	net.Conn
+	addr   net.Addr
+	closed chan struct{}
+}
+
+func (c *throttleConn) RemoteAddr() net.Addr { return c.addr }
+
+func (c *throttleConn) Close() error { close(c.closed); return nil }
+
+// testAddr is a net.Addr with the given string.
+type testAddr struct{ s string }
+
+func (a *testAddr) Network() string { return "test" }
+
+func (a *testAddr) String() string { return a.s }
@@ -13,9 +13,15 @@ import (
 	"log"
 	"math/big"
 	"net"
+	"net/url"
+	"strings"
 	"time"
 )
 
+// The number of sessions that dialers keep, so that reconnecting to a peer
+// can resume the last session with it instead of doing a full handshake.
+const tlsSessionCacheSize = 64
+
 type tcptls struct {
 	tcp         *tcp
 	config      *tls.Config
@@ -64,6 +70,14 @@ func (t *tcptls) init(tcp *tcp) {
 	cpool := x509.NewCertPool()
 	cpool.AppendCertsFromPEM(derbytes)
 
+	// Each link clones the config, so the listeners need a ticket key that is
+	// set up front, or else every clone would make its own and no ticket could
+	// ever be used to resume a session.
+	var ticketKey [32]byte
+	if _, err := rand.Read(ticketKey[:]); err != nil {
+		panic("failed to generate session ticket key")
+	}
+
 	t.config = &tls.Config{
 		RootCAs: cpool,
 		Certificates: []tls.Certificate{
@@ -74,7 +88,34 @@ func (t *tcptls) init(tcp *tcp) {
 		},
 		InsecureSkipVerify: true,
 		MinVersion:         tls.VersionTLS13,
+		ClientSessionCache: tls.NewLRUClientSessionCache(tlsSessionCacheSize),
+	}
+	t.config.SetSessionTicketKeys([][32]byte{ticketKey})
+}
+
+// listenerFor returns the upgrade for a tls:// listener URI, which offers the
+// ALPN protocols from its alpn option if it has one.
+func (t *tcptls) listenerFor(u *url.URL) *TcpUpgrade {
+	alpn := tlsALPN(u)
+	if alpn == nil {
+		return t.forListener
 	}
+	return &TcpUpgrade{
+		upgrade: func(c net.Conn, options *tcpOptions) (net.Conn, error) {
+			options.tlsALPN = alpn
+			return t.upgradeListener(c, options)
+		},
+		name: "tls",
+	}
+}
+
+// tlsALPN returns the ALPN protocols from the alpn option of a tls:// URI,
+// e.g. alpn=h2,http/1.1, so that links look more like ordinary HTTPS.
+func tlsALPN(u *url.URL) []string {
+	if alpn := u.Query().Get("alpn"); alpn != "" {
+		return strings.Split(alpn, ",")
+	}
+	return nil
 }
 
 func (t *tcptls) configForOptions(options *tcpOptions) *tls.Config {
@@ -87,25 +128,38 @@ func (t *tcptls) configForOptions(options *tcpOptions) *tls.Config {
 		if err != nil {
 			return errors.New("tls failed to parse cert")
 		}
-		if cert.PublicKeyAlgorithm != x509.Ed25519 {
-			return errors.New("tls wrong cert algorithm")
-		}
-		pk := cert.PublicKey.(ed25519.PublicKey)
-		var key keyArray
-		copy(key[:], pk)
-		// If options does not have a pinned key, then pin one now
-		if options.pinnedEd25519Keys == nil {
-			options.pinnedEd25519Keys = make(map[keyArray]struct{})
-			options.pinnedEd25519Keys[key] = struct{}{}
-		}
-		if _, isIn := options.pinnedEd25519Keys[key]; !isIn {
-			return errors.New("tls key does not match pinned key")
-		}
-		return nil
+		return options.pinTLSKey(cert)
 	}
+	config.NextProtos = options.tlsALPN
 	return config
 }
 
+// pinTLSKey checks the key of a peer certificate against the pinned keys,
+// pinning it if there are none yet. The certificate must be signed by its own
+// key, which the handshake proves that the remote node holds, so the key is
+// kept for the handler to check the key in the metadata against.
+func (options *tcpOptions) pinTLSKey(cert *x509.Certificate) error {
+	if cert.PublicKeyAlgorithm != x509.Ed25519 {
+		return errors.New("tls wrong cert algorithm")
+	}
+	if err := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+		return errors.New("tls cert is not signed by its own key")
+	}
+	pk := cert.PublicKey.(ed25519.PublicKey)
+	var key keyArray
+	copy(key[:], pk)
+	// If options does not have a pinned key, then pin one now
+	if options.pinnedEd25519Keys == nil {
+		options.pinnedEd25519Keys = make(map[keyArray]struct{})
+		options.pinnedEd25519Keys[key] = struct{}{}
+	}
+	if _, isIn := options.pinnedEd25519Keys[key]; !isIn {
+		return errors.New("tls key does not match pinned key")
+	}
+	options.tlsKey = pk
+	return nil
+}
+
 func (t *tcptls) upgradeListener(c net.Conn, options *tcpOptions) (net.Conn, error) {
 	config := t.configForOptions(options)
 	conn := tls.Server(c, config)
@@ -118,6 +172,16 @@ func (t *tcptls) upgradeListener(c net.Conn, options *tcpOptions) (net.Conn, err
 func (t *tcptls) upgradeDialer(c net.Conn, options *tcpOptions) (net.Conn, error) {
 	config := t.configForOptions(options)
 	config.ServerName = options.tlsSNI
+	// Resumed sessions skip VerifyPeerCertificate, so check their key here
+	config.VerifyConnection = func(cs tls.ConnectionState) error {
+		if !cs.DidResume {
+			return nil
+		}
+		if len(cs.PeerCertificates) != 1 {
+			return errors.New("tls not exactly 1 cert")
+		}
+		return options.pinTLSKey(cs.PeerCertificates[0])
+	}
 	conn := tls.Client(c, config)
 	if err := conn.Handshake(); err != nil {
 		return c, err
@@ -0,0 +1,108 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// TestTLS_Resumption checks that a second TLS link to the same listener
+// resumes the session of the first, and that both agree on ALPN.
+func TestTLS_Resumption(t *testing.T) {
+	nodeA, nodeB := CreateAndConnectTwo(t, false)
+	defer nodeA.Stop()
+	defer nodeB.Stop()
+	u, _ := url.Parse("tls://[::1]:0?alpn=h2")
+	listener := nodeA.links.tcp.tls.listenerFor(u)
+	for i := 0; i < 2; i++ {
+		client, server := net.Pipe()
+		errs := make(chan error, 1)
+		go func() {
+			conn, err := listener.upgrade(server, &tcpOptions{})
+			if err == nil {
+				// The session ticket comes after the handshake
+				_, err = conn.Write([]byte{0})
+			}
+			errs <- err
+		}()
+		options := &tcpOptions{tlsSNI: "resumption.test", tlsALPN: tlsALPN(u)}
+		conn, err := nodeB.links.tcp.tls.upgradeDialer(client, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Read(make([]byte, 1)); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+		state := conn.(*tls.Conn).ConnectionState()
+		if state.NegotiatedProtocol != "h2" {
+			t.Fatal("unexpected ALPN protocol", state.NegotiatedProtocol)
+		}
+		if state.DidResume != (i == 1) {
+			t.Fatal("unexpected resumption on link", i+1, state.DidResume)
+		}
+		var key keyArray
+		copy(key[:], nodeA.public)
+		if _, isIn := options.pinnedEd25519Keys[key]; !isIn {
+			t.Fatal("key of the listener was not pinned")
+		}
+		server.Close()
+		conn.Close()
+	}
+}
+
+// TestTLS_KeyBinding checks that a TLS certificate must be signed by its own
+// key, and that the key that the remote node sends must be the one in its
+// certificate, even if both keys are pinned.
+func TestTLS_KeyBinding(t *testing.T) {
+	pub, _, _ := ed25519.GenerateKey(nil)
+	_, other, _ := ed25519.GenerateKey(nil)
+	template := &x509.Certificate{SerialNumber: big.NewInt(1)}
+	der, err := x509.CreateCertificate(rand.New(rand.NewSource(1)), template, template, pub, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&tcpOptions{}).pinTLSKey(cert); err == nil {
+		t.Fatal("accepted a certificate that wasn't signed by its own key")
+	}
+	start := func(prefix string, listen []string) *Core {
+		cfg := GenerateConfig()
+		cfg.Listen = listen
+		node := new(Core)
+		if err := node.Start(cfg, GetLoggerWithPrefix(prefix, false)); err != nil {
+			t.Fatal(err)
+		}
+		return node
+	}
+	nodeA := start("A: ", []string{"tls://127.0.0.1:0"})
+	defer nodeA.Stop()
+	nodeB := start("B: ", nil)
+	defer nodeB.Stop()
+	nodeC := start("C: ", nil)
+	defer nodeC.Stop()
+	// A presents the certificate of C, but sends its own key
+	nodeA.links.tcp.tls.config.Certificates = nodeC.links.tcp.tls.config.Certificates
+	u, _ := url.Parse(fmt.Sprintf("tls://%s?key=%s&key=%s", nodeA.links.tcp.getAddr(),
+		hex.EncodeToString(nodeA.public), hex.EncodeToString(nodeC.public)))
+	if err := nodeB.CallPeer(u, ""); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	if peers := nodeB.GetPeers(); len(peers) != 0 {
+		t.Fatal("link was up with a key that doesn't match the certificate")
+	}
+}
@@ -0,0 +1,73 @@
+package core
+
+// This file contains the OpenTelemetry tracing of links being set up, so that
+// operators can see where the time went when a peering takes a long time to
+// come up. Each outbound call has a span that lasts until its link is up or
+// the call fails, with the dial, the handshake and the rest of the setup of
+// the link as its children. Inbound links have the handshake and setup spans
+// only. The spans go to the global tracer provider, which does nothing unless
+// the application that embeds the node, or the TraceEndpoint config of the
+// yggdrasil binary, sets one up.
+
+import (
+	"context"
+	"net/url"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/yggdrasil-network/yggdrasil-go/src/core"
+
+// startSpan starts a span, as a child of the parent if it isn't nil. The
+// tracer is looked up each time, as the global tracer provider may change.
+func startSpan(parent trace.Span, name string, attrs ...attribute.KeyValue) trace.Span {
+	ctx := context.Background()
+	if parent != nil {
+		ctx = trace.ContextWithSpan(ctx, parent)
+	}
+	_, span := otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+	return span
+}
+
+// failSpan records an error on a span, if there is one, without ending it.
+func failSpan(span trace.Span, err error) {
+	if span == nil || err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// endSpan ends a span, recording the error if there is one. Ending a span
+// that has already ended does nothing.
+func endSpan(span trace.Span, err error) {
+	failSpan(span, err)
+	span.End()
+}
+
+// traceURI returns the peer URI without any passwords in it, for spans.
+func traceURI(u *url.URL) string {
+	r := *u
+	if r.User != nil {
+		r.User = url.User(r.User.Username())
+	}
+	if q := r.Query(); q.Get("password") != "" {
+		q.Set("password", "xxxxx")
+		r.RawQuery = q.Encode()
+	}
+	return r.String()
+}
+
+// linkAttributes returns the attributes of the spans of a link.
+func (intf *link) linkAttributes() []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("link.name", intf.lname),
+		attribute.String("link.type", intf.info.linkType),
+		attribute.String("link.local", intf.info.local),
+		attribute.String("link.remote", intf.info.remote),
+		attribute.Bool("link.incoming", intf.incoming),
+	}
+}
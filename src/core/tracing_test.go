@@ -0,0 +1,37 @@
+package core
+
+import (
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestCore_Tracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+	nodeA, nodeB := CreateAndConnectTwo(t, false)
+	defer nodeA.Stop()
+	defer nodeB.Stop()
+	// The outbound call has the dial, handshake and setup as its children
+	parents := make(map[string]string)
+	ids := make(map[string]string)
+	for _, span := range recorder.Ended() {
+		if span.Status().Code == codes.Error {
+			t.Errorf("span %s failed: %s", span.Name(), span.Status().Description)
+		}
+		ids[span.SpanContext().SpanID().String()] = span.Name()
+		if span.Parent().IsValid() {
+			parents[span.Name()] = span.Parent().SpanID().String()
+		}
+	}
+	for _, name := range []string{"yggdrasil.dial", "yggdrasil.handshake", "yggdrasil.setup"} {
+		if ids[parents[name]] != "yggdrasil.call" {
+			t.Errorf("span %s is not a child of the call", name)
+		}
+	}
+}
@@ -12,5 +12,7 @@ const (
 	typeProtoDummy = iota
 	typeProtoNodeInfoRequest
 	typeProtoNodeInfoResponse
+	typeProtoServiceRequest
+	typeProtoServiceResponse
 	typeProtoDebug = 255
 )
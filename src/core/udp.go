@@ -0,0 +1,586 @@
+package core
+
+// This file contains the UDP transport. Each frame from ironwood is split into
+// datagrams which are small enough to never need IP fragmentation, and frames
+// that lose a datagram are dropped rather than resent, so that tunnelled TCP
+// connections don't stall behind retransmissions of the outer link. A minimal
+// session layer on top of UDP tells links apart and detects when they drop:
+//
+//  - Every datagram starts with a 1 byte type and the 8 byte session ID, which
+//    is chosen at random by the caller.
+//  - The caller sends hellos until the listener acknowledges one, after which
+//    either side may send data. Data datagrams carry a 2 byte frame sequence
+//    number, the 1 byte index of the fragment and the 1 byte fragment count.
+//  - Either side sends a keepalive if it has sent nothing else for a while,
+//    and the session is closed if nothing at all is received for longer than
+//    udpSessionTimeout. Closing a session sends a close, as a courtesy.
+//  - With forward error correction, data datagrams are sent inside FEC
+//    datagrams, see fec.go.
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	udpDatagramSize      = 1232 // The IPv6 minimum MTU, less the IPv6 and UDP headers
+	udpHeaderSize        = 9    // Type and session ID
+	udpDataHeaderSize    = udpHeaderSize + 4
+	udpFragmentSize      = udpDatagramSize - udpDataHeaderSize
+	udpMaxFragments      = 255
+	udpMaxPartial        = 8   // Frames that may be reassembled at once per session
+	udpFrameQueue        = 256 // Frames that may wait to be read per session
+	udpAcceptQueue       = 16  // Sessions that may wait to be accepted per listener
+	udpHelloInterval     = time.Second
+	udpKeepaliveInterval = time.Second
+	udpSessionTimeout    = 10 * time.Second
+)
+
+const (
+	udpHello = iota
+	udpHelloAck
+	udpData
+	udpKeepalive
+	udpClose
+	udpFEC
+)
+
+// tcpudp hangs the UDP transport off of the TCP one, so that UDP links are
+// called, listened for and handled by the same code as every other type. UDP
+// sessions don't need upgrading, but carrying an upgrade gives the links and
+// listeners their names, and its dial sets up the sessions.
+type tcpudp struct {
+	tcp     *tcp
+	upgrade *TcpUpgrade
+}
+
+func (u *tcpudp) init(tcp *tcp) {
+	u.tcp = tcp
+	u.upgrade = &TcpUpgrade{
+		upgrade: func(c net.Conn, _ *tcpOptions) (net.Conn, error) { return c, nil },
+		name:    "udp",
+		dial:    u.dial,
+	}
+}
+
+func (u *tcpudp) listen(listenaddr string, fec fecOptions) (*TcpListener, error) {
+	addr, err := net.ResolveUDPAddr("udp", listenaddr)
+	if err != nil {
+		return nil, err
+	}
+	sock, err := u.tcp.listenUDP(addr)
+	if err != nil {
+		return nil, err
+	}
+	ul := &udpListener{
+		clock:    u.tcp.links.core.clock,
+		sock:     sock,
+		accept:   make(chan *udpSession, udpAcceptQueue),
+		sessions: make(map[udpSessionKey]*udpSession),
+		done:     make(chan struct{}),
+		fec:      fec,
+	}
+	go ul.run()
+	l := TcpListener{
+		Listener: ul,
+		opts:     tcpOptions{upgrade: u.upgrade},
+		stop:     make(chan struct{}),
+	}
+	return &l, nil
+}
+
+// dial sets up a session with the UDP listener at the given address.
+func (u *tcpudp) dial(ctx context.Context, saddr string, options *tcpOptions) (net.Conn, error) {
+	raddr, err := net.ResolveUDPAddr("udp", saddr)
+	if err != nil {
+		return nil, err
+	}
+	sock, err := u.tcp.dialUDP(raddr)
+	if err != nil {
+		return nil, err
+	}
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		sock.Close()
+		return nil, err
+	}
+	clk := u.tcp.links.core.clock
+	s := newUDPSession(clk, binary.BigEndian.Uint64(id[:]), sock.LocalAddr(), raddr,
+		func(b []byte) error {
+			_, err := sock.Write(b)
+			return err
+		},
+		func() { sock.Close() },
+	)
+	s.enableFEC(options.fec)
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, err := sock.Read(buf)
+			if err != nil {
+				s.shutdown(false)
+				return
+			}
+			s.receive(buf[:n])
+		}
+	}()
+	ctx, done := context.WithTimeout(ctx, default_timeout)
+	defer done()
+	hello := s.header(udpHello)
+	for {
+		if err := s.send(hello); err != nil {
+			s.Close()
+			return nil, err
+		}
+		select {
+		case <-s.established:
+			return s, nil
+		case <-s.closed:
+			return nil, errors.New("udp session closed by listener")
+		case <-ctx.Done():
+			s.shutdown(false)
+			return nil, ctx.Err()
+		case <-clk.After(udpHelloInterval):
+		}
+	}
+}
+
+type udpSessionKey struct {
+	addr string
+	id   uint64
+}
+
+// udpListener accepts sessions on a single UDP socket, which stays open after
+// the listener is closed until all of its sessions have closed too, so that
+// stopping a listener leaves existing links in place as it does for TCP. Any
+// other packet socket that carries the same datagrams can be used instead.
+type udpListener struct {
+	clock    clock
+	sock     net.PacketConn
+	accept   chan *udpSession
+	mutex    sync.Mutex // protects the below
+	sessions map[udpSessionKey]*udpSession
+	closed   bool
+	done     chan struct{}
+	fec      fecOptions // For the sessions that are accepted
+}
+
+func (l *udpListener) Accept() (net.Conn, error) {
+	select {
+	case s := <-l.accept:
+		return s, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *udpListener) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	close(l.done)
+	if len(l.sessions) == 0 {
+		return l.sock.Close()
+	}
+	return nil
+}
+
+func (l *udpListener) Addr() net.Addr {
+	return l.sock.LocalAddr()
+}
+
+func (l *udpListener) run() {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := l.sock.ReadFrom(buf)
+		if err != nil {
+			l.mutex.Lock()
+			sessions := make([]*udpSession, 0, len(l.sessions))
+			for _, s := range l.sessions {
+				sessions = append(sessions, s)
+			}
+			l.mutex.Unlock()
+			for _, s := range sessions {
+				s.shutdown(false)
+			}
+			return
+		}
+		if n < udpHeaderSize {
+			continue
+		}
+		pkt := buf[:n]
+		key := udpSessionKey{addr.String(), binary.BigEndian.Uint64(pkt[1:udpHeaderSize])}
+		l.mutex.Lock()
+		s := l.sessions[key]
+		if s == nil && pkt[0] == udpHello && !l.closed && len(l.accept) < udpAcceptQueue {
+			// Only this goroutine sends to the queue, so this won't block
+			s = l.newSession(key, addr)
+			l.sessions[key] = s
+			l.accept <- s
+		}
+		l.mutex.Unlock()
+		switch {
+		case s == nil && pkt[0] != udpClose:
+			// Tell the caller straight away if the session is gone, e.g. because
+			// this node has restarted, so that it can set up a new one
+			_, _ = l.sock.WriteTo(append([]byte{udpClose}, pkt[1:udpHeaderSize]...), addr)
+		case s == nil:
+		case pkt[0] == udpHello:
+			s.markReceived()
+			_ = s.send(s.header(udpHelloAck))
+		default:
+			s.receive(pkt)
+		}
+	}
+}
+
+func (l *udpListener) newSession(key udpSessionKey, addr net.Addr) *udpSession {
+	s := newUDPSession(l.clock, key.id, l.sock.LocalAddr(), addr,
+		func(b []byte) error {
+			_, err := l.sock.WriteTo(b, addr)
+			return err
+		},
+		func() {
+			l.mutex.Lock()
+			defer l.mutex.Unlock()
+			delete(l.sessions, key)
+			if l.closed && len(l.sessions) == 0 {
+				l.sock.Close()
+			}
+		},
+	)
+	s.enableFEC(l.fec)
+	return s
+}
+
+type udpPartial struct {
+	frags [][]byte
+	have  int
+}
+
+// udpSession is a net.Conn over a UDP session, on which each write is sent as
+// a single frame. Reads and writes give up at their deadlines as they would on
+// any other net.Conn, but the session itself is only closed once keepalives
+// stop arriving, so that a few lost datagrams on an otherwise idle link don't
+// time it out.
+type udpSession struct {
+	clock       clock
+	id          uint64
+	local       net.Addr
+	remote      net.Addr
+	send        func([]byte) error
+	onClose     func()
+	frames      chan []byte
+	fragSize    int                    // The most bytes of a frame in each datagram
+	readBuf     []byte                 // Only used by Read
+	partials    map[uint16]*udpPartial // Only used by receive
+	order       []uint16               // The partials, oldest first
+	fecRecv     udpFECReceiver         // Only used by receive
+	wmutex      sync.Mutex             // Only one frame may be written at once
+	seq         uint16                 // Protected by wmutex
+	fec         *udpFECSender          // Set if FEC is enabled, and protected by wmutex
+	mutex       sync.Mutex             // protects the below
+	lastSent    time.Time
+	lastRecv    time.Time
+	established chan struct{}
+	estOnce     sync.Once
+	closed      chan struct{}
+	closeOnce   sync.Once
+	rdeadline   udpDeadline
+	wdeadline   udpDeadline
+}
+
+func newUDPSession(clk clock, id uint64, local, remote net.Addr, send func([]byte) error, onClose func()) *udpSession {
+	now := clk.Now()
+	s := &udpSession{
+		clock:       clk,
+		id:          id,
+		local:       local,
+		remote:      remote,
+		send:        send,
+		onClose:     onClose,
+		fragSize:    udpFragmentSize,
+		frames:      make(chan []byte, udpFrameQueue),
+		partials:    make(map[uint16]*udpPartial),
+		lastSent:    now,
+		lastRecv:    now,
+		established: make(chan struct{}),
+		closed:      make(chan struct{}),
+		rdeadline:   udpDeadline{clock: clk, cancel: make(chan struct{})},
+		wdeadline:   udpDeadline{clock: clk, cancel: make(chan struct{})},
+	}
+	go s.keepalive()
+	return s
+}
+
+func (s *udpSession) header(typ byte) []byte {
+	b := make([]byte, udpHeaderSize, udpDatagramSize)
+	b[0] = typ
+	binary.BigEndian.PutUint64(b[1:], s.id)
+	return b
+}
+
+func (s *udpSession) markReceived() {
+	s.mutex.Lock()
+	s.lastRecv = s.clock.Now()
+	s.mutex.Unlock()
+	s.estOnce.Do(func() { close(s.established) })
+}
+
+// receive handles a datagram for the session. It must only be called by the
+// goroutine reading from the socket.
+func (s *udpSession) receive(pkt []byte) {
+	if len(pkt) < udpHeaderSize || binary.BigEndian.Uint64(pkt[1:udpHeaderSize]) != s.id {
+		return
+	}
+	s.markReceived()
+	switch pkt[0] {
+	case udpData:
+		s.receiveData(pkt[udpHeaderSize:])
+	case udpFEC:
+		s.receiveFEC(pkt[udpHeaderSize:])
+	case udpClose:
+		s.shutdown(false)
+	}
+}
+
+// receiveData handles a data datagram, without its type and session ID. It
+// must only be called by receive.
+func (s *udpSession) receiveData(body []byte) {
+	if len(body) < udpDataHeaderSize-udpHeaderSize {
+		return
+	}
+	seq := binary.BigEndian.Uint16(body)
+	index, count := int(body[2]), int(body[3])
+	frag := body[4:]
+	if index >= count {
+		return
+	}
+	if count == 1 {
+		s.deliver(append([]byte(nil), frag...))
+		return
+	}
+	partial := s.partials[seq]
+	if partial == nil {
+		if len(s.order) >= udpMaxPartial {
+			// Give up on the oldest frame, as a fragment has probably been lost
+			delete(s.partials, s.order[0])
+			s.order = s.order[1:]
+		}
+		partial = &udpPartial{frags: make([][]byte, count)}
+		s.partials[seq] = partial
+		s.order = append(s.order, seq)
+	}
+	if len(partial.frags) != count || partial.frags[index] != nil {
+		return
+	}
+	partial.frags[index] = append([]byte(nil), frag...)
+	partial.have++
+	if partial.have < count {
+		return
+	}
+	delete(s.partials, seq)
+	for i, o := range s.order {
+		if o == seq {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	var frame []byte
+	for _, f := range partial.frags {
+		frame = append(frame, f...)
+	}
+	s.deliver(frame)
+}
+
+func (s *udpSession) deliver(frame []byte) {
+	select {
+	case s.frames <- frame:
+	default:
+		// The reader has fallen behind, so drop the frame as the network would
+	}
+}
+
+func (s *udpSession) keepalive() {
+	ticker := s.clock.NewTicker(udpKeepaliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.Chan():
+		}
+		s.mutex.Lock()
+		now := s.clock.Now()
+		timedOut := now.Sub(s.lastRecv) > udpSessionTimeout
+		idle := now.Sub(s.lastSent) >= udpKeepaliveInterval
+		s.mutex.Unlock()
+		switch {
+		case timedOut:
+			s.shutdown(false)
+			return
+		case idle:
+			s.wmutex.Lock()
+			s.sent(s.send(s.header(udpKeepalive)))
+			s.wmutex.Unlock()
+		}
+	}
+}
+
+func (s *udpSession) sent(err error) {
+	if err == nil {
+		s.mutex.Lock()
+		s.lastSent = s.clock.Now()
+		s.mutex.Unlock()
+	}
+}
+
+func (s *udpSession) Read(p []byte) (int, error) {
+	if len(s.readBuf) == 0 {
+		select {
+		case s.readBuf = <-s.frames:
+		case <-s.closed:
+			return 0, io.EOF
+		case <-s.rdeadline.wait():
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+	n := copy(p, s.readBuf)
+	s.readBuf = s.readBuf[n:]
+	return n, nil
+}
+
+func (s *udpSession) Write(p []byte) (int, error) {
+	select {
+	case <-s.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	count := (len(p) + s.fragSize - 1) / s.fragSize
+	if count > udpMaxFragments {
+		return 0, errors.New("frame is too large for a udp session")
+	}
+	s.wmutex.Lock()
+	defer s.wmutex.Unlock()
+	s.seq++
+	for index := 0; index < count; index++ {
+		select {
+		case <-s.wdeadline.wait():
+			return 0, os.ErrDeadlineExceeded
+		default:
+		}
+		frag := p[index*s.fragSize:]
+		if len(frag) > s.fragSize {
+			frag = frag[:s.fragSize]
+		}
+		pkt := s.header(udpData)
+		pkt = append(pkt, byte(s.seq>>8), byte(s.seq), byte(index), byte(count))
+		pkt = append(pkt, frag...)
+		if err := s.sendData(pkt); err != nil {
+			return 0, err
+		}
+	}
+	s.sent(nil)
+	return len(p), nil
+}
+
+func (s *udpSession) Close() error {
+	s.shutdown(true)
+	return nil
+}
+
+// shutdown closes the session, telling the remote side if notify is set.
+func (s *udpSession) shutdown(notify bool) {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		if notify {
+			s.wmutex.Lock()
+			_ = s.send(s.header(udpClose))
+			s.wmutex.Unlock()
+		}
+		s.onClose()
+	})
+}
+
+func (s *udpSession) LocalAddr() net.Addr {
+	return s.local
+}
+
+func (s *udpSession) RemoteAddr() net.Addr {
+	return s.remote
+}
+
+func (s *udpSession) SetDeadline(t time.Time) error {
+	s.rdeadline.set(t)
+	s.wdeadline.set(t)
+	return nil
+}
+
+func (s *udpSession) SetReadDeadline(t time.Time) error {
+	s.rdeadline.set(t)
+	return nil
+}
+
+func (s *udpSession) SetWriteDeadline(t time.Time) error {
+	s.wdeadline.set(t)
+	return nil
+}
+
+// udpDeadline is the read or write deadline of a session, as a channel that is
+// closed once the deadline has passed, so that it can wake a blocked read.
+type udpDeadline struct {
+	clock  clock
+	mutex  sync.Mutex // protects the below
+	timer  timer
+	cancel chan struct{}
+}
+
+// set changes the deadline, or clears it if t is zero.
+func (d *udpDeadline) set(t time.Time) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel // The timer has fired, so wait for it to close the channel
+	}
+	d.timer = nil
+	expired := false
+	select {
+	case <-d.cancel:
+		expired = true
+	default:
+	}
+	if t.IsZero() {
+		if expired {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+	if wait := t.Sub(d.clock.Now()); wait > 0 {
+		if expired {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = d.clock.AfterFunc(wait, func() { close(cancel) })
+		return
+	}
+	if !expired {
+		close(d.cancel)
+	}
+}
+
+// wait returns a channel that is closed once the deadline has passed.
+func (d *udpDeadline) wait() chan struct{} {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.cancel
+}
@@ -0,0 +1,50 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestUDPSession_Deadlines(t *testing.T) {
+	clk := &fakeClock{now: time.Now()}
+	s := newUDPSession(clk, 1, nil, nil, func([]byte) error { return nil }, func() {})
+	defer s.Close()
+	buf := make([]byte, 16)
+
+	// A read that is waiting gives up once its deadline passes
+	_ = s.SetReadDeadline(clk.Now().Add(time.Second))
+	read := make(chan error, 1)
+	go func() {
+		_, err := s.Read(buf)
+		read <- err
+	}()
+	clk.Advance(time.Second)
+	select {
+	case err := <-read:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatal("read returned", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("read didn't give up at its deadline")
+	}
+
+	// Once the deadline is cleared, reads wait for frames again
+	_ = s.SetReadDeadline(time.Time{})
+	s.deliver([]byte("frame"))
+	if n, err := s.Read(buf); err != nil || string(buf[:n]) != "frame" {
+		t.Fatal("read after the deadline was cleared failed:", err)
+	}
+
+	// Writes fail once their deadline has passed, and work again after it
+	// has been moved
+	_ = s.SetWriteDeadline(clk.Now())
+	if _, err := s.Write([]byte("frame")); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatal("write after the deadline returned", err)
+	}
+	_ = s.SetWriteDeadline(clk.Now().Add(time.Second))
+	if _, err := s.Write([]byte("frame")); err != nil {
+		t.Fatal("write before the deadline failed:", err)
+	}
+}
@@ -0,0 +1,375 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+const (
+	wgMTU         = 1420 // The default of wg-quick, which fits a UDP datagram of the session layer
+	wgPacketQueue = 256  // Packets that may wait to be encrypted per link
+)
+
+// tcpwg hangs the WireGuard transport off of the TCP one, in the same way as
+// the SSH transport. A peer URI such as wg://host:port/target:port sets up a
+// userspace WireGuard tunnel with the endpoint at host:port, and then runs a
+// UDP session through the tunnel with the UDP listener at target:port, which
+// is an address inside the tunnel. The far end doesn't need to know about any
+// of this: it can be an ordinary WireGuard interface, with a udp:// listener
+// on its address in the tunnel, so that existing endpoints and keys can be
+// reused to carry links.
+//
+// Each link has a WireGuard device of its own, which isn't attached to the
+// operating system, so the IP and UDP headers inside the tunnel are written
+// and read here.
+type tcpwg struct {
+	tcp     *tcp
+	upgrade *TcpUpgrade
+}
+
+// wgOptions are the options for a WireGuard link, from its peer URI.
+type wgOptions struct {
+	endpoint  string       // The WireGuard endpoint to send the tunnel to
+	target    *net.UDPAddr // The UDP listener inside the tunnel
+	address   net.IP       // This node's address inside the tunnel
+	keyFile   string       // The private key, as written by "wg genkey"
+	publicKey []byte       // The public key of the endpoint
+	pskFile   string       // An optional preshared key, as written by "wg genpsk"
+}
+
+func (w *tcpwg) init(tcp *tcp) {
+	w.tcp = tcp
+	w.upgrade = &TcpUpgrade{
+		upgrade: func(c net.Conn, _ *tcpOptions) (net.Conn, error) { return c, nil },
+		name:    "wg",
+		dial:    w.dial,
+	}
+}
+
+// parseWGKey reads a key in the base64 of the wg tool. A "+" in a query
+// string is read as a space, so spaces are turned back into "+".
+func parseWGKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(strings.TrimSpace(s), " ", "+"))
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("not a WireGuard key")
+	}
+	return key, nil
+}
+
+// parseWGOptions reads the options for a WireGuard link from its peer URI. The
+// address option gives the address of this node inside the tunnel, which the
+// endpoint must allow for its key, the keyfile option gives the private key of
+// this node and the publickey option gives the key of the endpoint. The
+// pskfile option gives a preshared key, if the endpoint uses one.
+func parseWGOptions(u *url.URL) (wgOptions, error) {
+	query := u.Query()
+	options := wgOptions{
+		endpoint: u.Host,
+		address:  net.ParseIP(query.Get("address")),
+		keyFile:  query.Get("keyfile"),
+		pskFile:  query.Get("pskfile"),
+	}
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		return options, fmt.Errorf("wg peer %s must give the port of the WireGuard endpoint", u.String())
+	}
+	target, err := net.ResolveUDPAddr("udp", strings.Trim(u.Path, "/"))
+	if err != nil || target.IP == nil || target.Port == 0 {
+		return options, fmt.Errorf("wg peer %s must give the address and port of the UDP listener in the tunnel as its path", u.String())
+	}
+	options.target = target
+	if options.address == nil || (options.address.To4() == nil) != (target.IP.To4() == nil) {
+		return options, fmt.Errorf("wg peer %s must give an address in the tunnel of the same family as the listener", u.String())
+	}
+	if options.keyFile == "" {
+		return options, fmt.Errorf("wg peer %s must give the keyfile option", u.String())
+	}
+	if options.publicKey, err = parseWGKey(query.Get("publickey")); err != nil {
+		return options, fmt.Errorf("wg peer %s must give the publickey option: %w", u.String(), err)
+	}
+	return options, nil
+}
+
+// readWGKey reads a key from a file.
+func readWGKey(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parseWGKey(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// config returns the configuration of the WireGuard device for a link, in the
+// format of the cross-platform userspace API.
+func (o *wgOptions) config() (string, error) {
+	private, err := readWGKey(o.keyFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read key file: %w", err)
+	}
+	endpoint, err := net.ResolveUDPAddr("udp", o.endpoint)
+	if err != nil {
+		return "", err
+	}
+	bits := 128
+	if o.target.IP.To4() != nil {
+		bits = 32
+	}
+	config := fmt.Sprintf("private_key=%s\nreplace_peers=true\npublic_key=%s\nendpoint=%s\nallowed_ip=%s/%d\n",
+		hex.EncodeToString(private), hex.EncodeToString(o.publicKey), endpoint, o.target.IP, bits)
+	if o.pskFile != "" {
+		psk, err := readWGKey(o.pskFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read preshared key file: %w", err)
+		}
+		config += fmt.Sprintf("preshared_key=%s\n", hex.EncodeToString(psk))
+	}
+	return config, nil
+}
+
+// wgAddr is the address of a node reached through a WireGuard endpoint, giving
+// both the endpoint and the listener inside the tunnel.
+type wgAddr string
+
+func (a wgAddr) Network() string { return "wg" }
+func (a wgAddr) String() string  { return string(a) }
+
+// dial sets up a WireGuard device with the endpoint, and then a UDP session
+// with the listener through it. The address passed in is the endpoint and the
+// listener together, so that links to different listeners through the same
+// endpoint are told apart.
+func (w *tcpwg) dial(ctx context.Context, saddr string, options *tcpOptions) (net.Conn, error) {
+	config, err := options.wg.config()
+	if err != nil {
+		return nil, err
+	}
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	local := &net.UDPAddr{
+		IP:   options.wg.address,
+		Port: 49152 + int(binary.BigEndian.Uint16(id[:])%16384),
+	}
+	target := options.wg.target
+	dev := newWGTun()
+	logger := &device.Logger{
+		Verbosef: device.DiscardLogf,
+		Errorf: func(format string, args ...interface{}) {
+			w.tcp.links.core.log.Debugf("WireGuard link to "+saddr+": "+format, args...)
+		},
+	}
+	if w.tcp.mark != 0 {
+		config = fmt.Sprintf("fwmark=%d\n", w.tcp.mark) + config
+	}
+	wg := device.NewDevice(dev, conn.NewDefaultBind(), logger)
+	if err := wg.IpcSet(config); err != nil {
+		wg.Close()
+		return nil, fmt.Errorf("failed to configure WireGuard: %w", err)
+	}
+	s := newUDPSession(w.tcp.links.core.clock, binary.BigEndian.Uint64(id[:]), local, wgAddr(saddr),
+		func(b []byte) error {
+			return dev.send(appendWGPacket(nil, local, target, b))
+		},
+		// The device waits for its receiver, which may be what closed the session
+		func() { go wg.Close() },
+	)
+	dev.receive = func(pkt []byte) {
+		if payload := readWGPacket(pkt, target, local); payload != nil {
+			s.receive(payload)
+		}
+	}
+	if err := wg.Up(); err != nil {
+		s.shutdown(false)
+		return nil, err
+	}
+	ctx, done := context.WithTimeout(ctx, default_timeout)
+	defer done()
+	hello := s.header(udpHello)
+	for {
+		if err := s.send(hello); err != nil {
+			s.Close()
+			return nil, err
+		}
+		select {
+		case <-s.established:
+			return s, nil
+		case <-s.closed:
+			return nil, errors.New("wg session closed by listener")
+		case <-ctx.Done():
+			s.shutdown(false)
+			return nil, fmt.Errorf("no reply from %s through %s", target, options.wg.endpoint)
+		case <-time.After(udpHelloInterval):
+		}
+	}
+}
+
+// wgTun is the tun.Device of a WireGuard link, which passes packets to and
+// from the UDP session rather than the operating system.
+type wgTun struct {
+	packets chan []byte      // Packets for the device to send
+	events  chan tun.Event   // Never sent to, as the device is brought up directly
+	receive func(pkt []byte) // Called by the device for each packet that it receives
+	closed  chan struct{}
+	once    sync.Once
+}
+
+func newWGTun() *wgTun {
+	return &wgTun{
+		packets: make(chan []byte, wgPacketQueue),
+		events:  make(chan tun.Event),
+		closed:  make(chan struct{}),
+	}
+}
+
+// send queues a packet for the device, dropping it if the queue is full, as
+// the network would.
+func (t *wgTun) send(pkt []byte) error {
+	select {
+	case <-t.closed:
+		return net.ErrClosed
+	case t.packets <- pkt:
+	default:
+	}
+	return nil
+}
+
+func (t *wgTun) Read(buf []byte, offset int) (int, error) {
+	select {
+	case pkt := <-t.packets:
+		return copy(buf[offset:], pkt), nil
+	case <-t.closed:
+		return 0, os.ErrClosed
+	}
+}
+
+func (t *wgTun) Write(buf []byte, offset int) (int, error) {
+	t.receive(buf[offset:])
+	return len(buf), nil
+}
+
+func (t *wgTun) Close() error {
+	t.once.Do(func() {
+		close(t.closed)
+		close(t.events)
+	})
+	return nil
+}
+
+func (t *wgTun) File() *os.File         { return nil }
+func (t *wgTun) Flush() error           { return nil }
+func (t *wgTun) MTU() (int, error)      { return wgMTU, nil }
+func (t *wgTun) Name() (string, error)  { return "wg", nil }
+func (t *wgTun) Events() chan tun.Event { return t.events }
+
+// appendWGPacket appends an IP packet carrying a UDP datagram to b. Both
+// addresses must be of the same family.
+func appendWGPacket(b []byte, src, dst *net.UDPAddr, payload []byte) []byte {
+	udpLen := 8 + len(payload)
+	var pseudo []byte
+	if src4, dst4 := src.IP.To4(), dst.IP.To4(); src4 != nil && dst4 != nil {
+		ip := make([]byte, 20)
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+udpLen))
+		binary.BigEndian.PutUint16(ip[6:], 0x4000) // Don't fragment
+		ip[8] = 64
+		ip[9] = 17
+		copy(ip[12:], src4)
+		copy(ip[16:], dst4)
+		binary.BigEndian.PutUint16(ip[10:], ^wgChecksum(0, ip))
+		b = append(b, ip...)
+		pseudo = append(append(append([]byte(nil), src4...), dst4...), 0, 17, byte(udpLen>>8), byte(udpLen))
+	} else {
+		ip := make([]byte, 40)
+		ip[0] = 0x60
+		binary.BigEndian.PutUint16(ip[4:], uint16(udpLen))
+		ip[6] = 17
+		ip[7] = 64
+		copy(ip[8:], src.IP.To16())
+		copy(ip[24:], dst.IP.To16())
+		b = append(b, ip...)
+		pseudo = append(append([]byte(nil), ip[8:40]...), 0, 0, byte(udpLen>>8), byte(udpLen), 0, 0, 0, 17)
+	}
+	udp := make([]byte, 8, udpLen)
+	binary.BigEndian.PutUint16(udp[0:], uint16(src.Port))
+	binary.BigEndian.PutUint16(udp[2:], uint16(dst.Port))
+	binary.BigEndian.PutUint16(udp[4:], uint16(udpLen))
+	udp = append(udp, payload...)
+	sum := ^wgChecksum(wgChecksum(0, pseudo), udp)
+	if sum == 0 {
+		sum = 0xffff
+	}
+	binary.BigEndian.PutUint16(udp[6:], sum)
+	return append(b, udp...)
+}
+
+// readWGPacket returns the payload of an IP packet if it is a UDP datagram from
+// src to dst, or else nil. Checksums aren't checked, as WireGuard has already
+// authenticated the packet.
+func readWGPacket(pkt []byte, src, dst *net.UDPAddr) []byte {
+	var udp []byte
+	switch {
+	case len(pkt) >= 20 && pkt[0]>>4 == 4:
+		ihl := int(pkt[0]&0x0f) * 4
+		total := int(binary.BigEndian.Uint16(pkt[2:]))
+		if pkt[9] != 17 || ihl < 20 || total > len(pkt) || total < ihl ||
+			binary.BigEndian.Uint16(pkt[6:])&0x3fff != 0 || // Fragmented
+			!net.IP(pkt[12:16]).Equal(src.IP) || !net.IP(pkt[16:20]).Equal(dst.IP) {
+			return nil
+		}
+		udp = pkt[ihl:total]
+	case len(pkt) >= 40 && pkt[0]>>4 == 6:
+		length := int(binary.BigEndian.Uint16(pkt[4:]))
+		if pkt[6] != 17 || 40+length > len(pkt) ||
+			!net.IP(pkt[8:24]).Equal(src.IP) || !net.IP(pkt[24:40]).Equal(dst.IP) {
+			return nil
+		}
+		udp = pkt[40 : 40+length]
+	default:
+		return nil
+	}
+	if len(udp) < 8 ||
+		int(binary.BigEndian.Uint16(udp[0:])) != src.Port ||
+		int(binary.BigEndian.Uint16(udp[2:])) != dst.Port {
+		return nil
+	}
+	length := int(binary.BigEndian.Uint16(udp[4:]))
+	if length < 8 || length > len(udp) {
+		return nil
+	}
+	return udp[8:length]
+}
+
+// wgChecksum adds b to a ones' complement sum.
+func wgChecksum(sum uint16, b []byte) uint16 {
+	s := uint32(sum)
+	for len(b) >= 2 {
+		s += uint32(binary.BigEndian.Uint16(b))
+		b = b[2:]
+	}
+	if len(b) == 1 {
+		s += uint32(b[0]) << 8
+	}
+	for s > 0xffff {
+		s = (s >> 16) + (s & 0xffff)
+	}
+	return uint16(s)
+}
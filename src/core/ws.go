@@ -0,0 +1,283 @@
+package core
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsSubprotocol is offered by dialers and required by listeners, so that a
+// listener doesn't mistake some other WebSocket client for a peer.
+const wsSubprotocol = "yggdrasil"
+
+// tcpws upgrades TCP connections to WebSockets, so that nodes can peer through
+// HTTP proxies and middleboxes which don't pass anything else. Each frame from
+// ironwood is sent as a single binary message.
+type tcpws struct {
+	tcp             *tcp
+	forDialer       *TcpUpgrade
+	forSecureDialer *TcpUpgrade
+	upgrader        websocket.Upgrader
+}
+
+func (w *tcpws) init(tcp *tcp) {
+	w.tcp = tcp
+	w.forDialer = &TcpUpgrade{
+		upgrade: w.upgradeDialer,
+		name:    "ws",
+	}
+	w.forSecureDialer = &TcpUpgrade{
+		upgrade: w.upgradeDialer,
+		name:    "wss",
+	}
+	w.upgrader = websocket.Upgrader{
+		Subprotocols: []string{wsSubprotocol},
+		CheckOrigin:  func(*http.Request) bool { return true },
+	}
+}
+
+func (w *tcpws) upgradeDialer(c net.Conn, options *tcpOptions) (net.Conn, error) {
+	u, err := url.Parse(options.wsURL)
+	if err != nil {
+		return c, err
+	}
+	dialer := websocket.Dialer{
+		NetDial:          func(_, _ string) (net.Conn, error) { return c, nil },
+		HandshakeTimeout: default_timeout,
+		Subprotocols:     []string{wsSubprotocol},
+	}
+	if u.Scheme == "wss" {
+		dialer.TLSClientConfig = w.tlsConfigForDialer(u, options)
+	}
+	conn, res, err := dialer.Dial(options.wsURL, nil)
+	if err != nil {
+		return c, err
+	}
+	res.Body.Close()
+	if conn.Subprotocol() != wsSubprotocol {
+		conn.Close()
+		return c, fmt.Errorf("websocket server did not accept the %q subprotocol", wsSubprotocol)
+	}
+	return &wsConn{Conn: conn}, nil
+}
+
+// tlsConfigForDialer returns the TLS config for a wss:// peer. The server must
+// either have a certificate that is valid for its hostname, as it would when
+// behind a web server such as nginx or Caddy, or present the self-signed
+// certificate of a node whose key is pinned in the peer URI.
+func (w *tcpws) tlsConfigForDialer(u *url.URL, options *tcpOptions) *tls.Config {
+	name := options.tlsSNI
+	if name == "" {
+		name = u.Hostname()
+	}
+	return &tls.Config{
+		ServerName:         name,
+		InsecureSkipVerify: true, // Verified below instead
+		MinVersion:         tls.VersionTLS12,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			certs := make([]*x509.Certificate, 0, len(rawCerts))
+			for _, raw := range rawCerts {
+				cert, err := x509.ParseCertificate(raw)
+				if err != nil {
+					return errors.New("tls failed to parse cert")
+				}
+				certs = append(certs, cert)
+			}
+			if len(certs) == 0 {
+				return errors.New("tls no certs")
+			}
+			if pk, ok := certs[0].PublicKey.(ed25519.PublicKey); ok && len(certs) == 1 {
+				var key keyArray
+				copy(key[:], pk)
+				if _, isIn := options.pinnedEd25519Keys[key]; isIn {
+					return nil
+				}
+			}
+			intermediates := x509.NewCertPool()
+			for _, cert := range certs[1:] {
+				intermediates.AddCert(cert)
+			}
+			_, err := certs[0].Verify(x509.VerifyOptions{
+				DNSName:       name,
+				Intermediates: intermediates,
+			})
+			return err
+		},
+	}
+}
+
+// listenerFor returns the upgrade for a ws:// or wss:// listener URI. A wss://
+// listener uses the certificate and key from the PEM files given by its
+// certfile and keyfile options, or else the self-signed certificate of the
+// node, which browsers will not accept. If the URI has a path, then clients
+// must request that path or something beneath it.
+func (w *tcpws) listenerFor(u *url.URL) (*TcpUpgrade, error) {
+	var config *tls.Config
+	certfile, keyfile := u.Query().Get("certfile"), u.Query().Get("keyfile")
+	switch {
+	case u.Scheme != "wss":
+		if certfile != "" || keyfile != "" {
+			return nil, errors.New("certfile and keyfile need a wss:// listener")
+		}
+	case certfile != "" && keyfile != "":
+		cert, err := tls.LoadX509KeyPair(certfile, keyfile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load certificate: %w", err)
+		}
+		config = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+	case certfile != "" || keyfile != "":
+		return nil, errors.New("certfile and keyfile must be given together")
+	default:
+		config = &tls.Config{
+			Certificates: w.tcp.tls.config.Certificates,
+			MinVersion:   tls.VersionTLS13,
+		}
+	}
+	prefix := strings.TrimSuffix(u.Path, "/")
+	return &TcpUpgrade{
+		upgrade: func(c net.Conn, options *tcpOptions) (net.Conn, error) {
+			if config != nil {
+				conn := tls.Server(c, config)
+				if err := conn.Handshake(); err != nil {
+					return c, err
+				}
+				c = conn
+			}
+			return w.upgradeListener(c, prefix)
+		},
+		name: u.Scheme,
+	}, nil
+}
+
+func (w *tcpws) upgradeListener(c net.Conn, prefix string) (net.Conn, error) {
+	if err := c.SetDeadline(time.Now().Add(default_timeout)); err != nil {
+		return c, err
+	}
+	br := bufio.NewReader(c)
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return c, err
+	}
+	res := &wsResponse{conn: c, br: br, header: http.Header{}}
+	if path := req.URL.Path; prefix != "" && path != prefix && !strings.HasPrefix(path, prefix+"/") {
+		http.NotFound(res, req)
+		return c, fmt.Errorf("websocket client requested %q, which is outside of %q", path, prefix)
+	}
+	offered := false
+	for _, protocol := range websocket.Subprotocols(req) {
+		offered = offered || protocol == wsSubprotocol
+	}
+	if !offered {
+		http.Error(res, "Not a Yggdrasil peer", http.StatusBadRequest)
+		return c, fmt.Errorf("websocket client did not offer the %q subprotocol", wsSubprotocol)
+	}
+	conn, err := w.upgrader.Upgrade(res, req, nil)
+	if err != nil {
+		return c, err
+	}
+	if err := c.SetDeadline(time.Time{}); err != nil {
+		return c, err
+	}
+	return &wsConn{Conn: conn}, nil
+}
+
+// wsURL returns the URL to request from a WebSocket listener for the given
+// peer URI, which is the same but without the options in the query string.
+func wsURL(u *url.URL) string {
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}).String()
+}
+
+// wsConn adapts a WebSocket to a net.Conn.
+type wsConn struct {
+	*websocket.Conn
+	reader io.Reader
+	wmutex sync.Mutex // Only one message may be written at once
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	for {
+		if c.reader == nil {
+			mt, r, err := c.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			if mt != websocket.BinaryMessage {
+				continue
+			}
+			c.reader = r
+		}
+		n, err := c.reader.Read(p)
+		if err == io.EOF {
+			c.reader = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	c.wmutex.Lock()
+	defer c.wmutex.Unlock()
+	if err := c.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *wsConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+// wsResponse is enough of an http.ResponseWriter for the upgrader to take over
+// a connection that we have already accepted, or to refuse it.
+type wsResponse struct {
+	conn        net.Conn
+	br          *bufio.Reader
+	header      http.Header
+	wroteHeader bool
+}
+
+func (r *wsResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *wsResponse) WriteHeader(status int) {
+	if r.wroteHeader {
+		return
+	}
+	r.wroteHeader = true
+	fmt.Fprintf(r.conn, "HTTP/1.1 %d %s\r\n", status, http.StatusText(status))
+	r.header.Set("Connection", "close")
+	_ = r.header.Write(r.conn)
+	fmt.Fprint(r.conn, "\r\n")
+}
+
+func (r *wsResponse) Write(p []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.conn.Write(p)
+}
+
+func (r *wsResponse) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return r.conn, bufio.NewReadWriter(r.br, bufio.NewWriter(r.conn)), nil
+}
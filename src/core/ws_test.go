@@ -0,0 +1,104 @@
+package core
+
+import (
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCore_WebSocketTransport checks that nodes peer over ws:// and wss:// on
+// loopback, only within the path of the listener, and that wss:// peers send
+// the SNI that they are given, here to a proxy that terminates TLS in front of
+// a ws:// listener, as nginx would.
+func TestCore_WebSocketTransport(t *testing.T) {
+	// The listeners are on different nodes, as both are on 127.0.0.1:0
+	listen := func(uri string) (*Core, net.Addr) {
+		cfg := GenerateConfig()
+		cfg.Listen = []string{uri}
+		node := new(Core)
+		if err := node.Start(cfg, GetLoggerWithPrefix("A: ", false)); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(node.Stop)
+		return node, node.links.tcp.getAddr()
+	}
+	nodeWS, wsAddr := listen("ws://127.0.0.1:0/ygg")
+	nodeWSS, wssAddr := listen("wss://127.0.0.1:0/ygg/")
+	snis := make(chan string, 16)
+	proxy, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: nodeWS.links.tcp.tls.config.Certificates,
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			snis <- hello.ServerName
+			return nil, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+	go func() {
+		for {
+			conn, err := proxy.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				backend, err := net.Dial("tcp", wsAddr.String())
+				if err != nil {
+					return
+				}
+				defer backend.Close()
+				go func() { _, _ = io.Copy(backend, conn) }()
+				_, _ = io.Copy(conn, backend)
+			}()
+		}
+	}()
+	wsKey, wssKey := hex.EncodeToString(nodeWS.public), hex.EncodeToString(nodeWSS.public)
+	for name, tc := range map[string]struct {
+		peer     string
+		listener *Core
+		connects bool
+	}{
+		"ws":          {fmt.Sprintf("ws://%s/ygg", wsAddr), nodeWS, true},
+		"ws beneath":  {fmt.Sprintf("ws://%s/ygg/node", wsAddr), nodeWS, true},
+		"ws outside":  {fmt.Sprintf("ws://%s/yggdrasil", wsAddr), nodeWS, false},
+		"wss":         {fmt.Sprintf("wss://%s/ygg?key=%s", wssAddr, wssKey), nodeWSS, true},
+		"wss outside": {fmt.Sprintf("wss://%s/?key=%s", wssAddr, wssKey), nodeWSS, false},
+		"wss proxied": {fmt.Sprintf("wss://%s/ygg?key=%s&sni=ygg.example", proxy.Addr(), wsKey), nodeWS, true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfgB := GenerateConfig()
+			cfgB.Listen = nil
+			cfgB.Peers = []string{tc.peer}
+			nodeB := new(Core)
+			if err := nodeB.Start(cfgB, GetLoggerWithPrefix("B: ", false)); err != nil {
+				t.Fatal(err)
+			}
+			defer nodeB.Stop()
+			if !tc.connects {
+				time.Sleep(time.Second)
+				if len(nodeB.GetPeers()) != 0 {
+					t.Fatal("node connected outside of the path of the listener")
+				}
+				return
+			}
+			if !WaitConnected(tc.listener, nodeB) {
+				t.Fatal("nodes did not connect")
+			}
+			if remote := nodeB.GetPeers()[0].Remote; !strings.HasPrefix(tc.peer, remote) {
+				t.Errorf("peer %s is not over %s", remote, tc.peer)
+			}
+			if strings.Contains(tc.peer, "sni=") {
+				if sni := <-snis; sni != "ygg.example" {
+					t.Errorf("proxy was sent SNI %q", sni)
+				}
+			}
+		})
+	}
+}
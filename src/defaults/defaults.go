@@ -31,13 +31,21 @@ func GenerateConfig() *config.NodeConfig {
 	cfg := new(config.NodeConfig)
 	cfg.NewKeys()
 	cfg.Listen = []string{}
+	cfg.ListenFilters = []string{}
+	cfg.MaxHandshakes = 64
+	cfg.ListenRate = 10
 	cfg.AdminListen = GetDefaults().DefaultAdminListen
 	cfg.Peers = []string{}
 	cfg.InterfacePeers = map[string][]string{}
 	cfg.AllowedPublicKeys = []string{}
+	cfg.PeerSchedules = map[string]string{}
+	cfg.PeerQuotas = map[string]config.PeerQuotaConfig{}
+	cfg.PeerRateLimits = map[string]config.PeerRateLimitConfig{}
+	cfg.TCPSocket.NoDelay = true
 	cfg.MulticastInterfaces = GetDefaults().DefaultMulticastInterfaces
 	cfg.IfName = GetDefaults().DefaultIfName
 	cfg.IfMTU = GetDefaults().DefaultIfMTU
+	cfg.IfIPv4Map = map[string]string{}
 	cfg.NodeInfoPrivacy = false
 
 	return cfg
@@ -0,0 +1,34 @@
+package dhcpv6pd
+
+import (
+	"encoding/json"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/admin"
+)
+
+type GetDHCPv6PDLeasesRequest struct{}
+type GetDHCPv6PDLeasesResponse struct {
+	Leases []Lease `json:"leases"`
+}
+
+func (s *Server) getLeasesHandler(req *GetDHCPv6PDLeasesRequest, res *GetDHCPv6PDLeasesResponse) error {
+	res.Leases = s.Leases()
+	if res.Leases == nil {
+		res.Leases = []Lease{}
+	}
+	return nil
+}
+
+func (s *Server) SetupAdminHandlers(a *admin.AdminSocket) {
+	_ = a.AddHandler("getDHCPv6PDLeases", []string{}, func(in json.RawMessage) (interface{}, error) {
+		req := &GetDHCPv6PDLeasesRequest{}
+		res := &GetDHCPv6PDLeasesResponse{}
+		if err := json.Unmarshal(in, &req); err != nil {
+			return nil, err
+		}
+		if err := s.getLeasesHandler(req, res); err != nil {
+			return nil, err
+		}
+		return res, nil
+	})
+}
@@ -0,0 +1,316 @@
+package dhcpv6pd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/Arceliar/phony"
+	"github.com/gologme/log"
+	"golang.org/x/net/ipv6"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/config"
+	"github.com/yggdrasil-network/yggdrasil-go/src/core"
+)
+
+const (
+	defaultPrefixLength = 72
+	preferredLifetime   = time.Hour
+	validLifetime       = 2 * time.Hour
+	advertiseLifetime   = 2 * time.Minute // How long an offer is held
+	sweepInterval       = time.Minute
+)
+
+var serverGroup = net.ParseIP("ff02::1:2") // All_DHCP_Relay_Agents_and_Servers
+
+// Server is a DHCPv6 prefix delegation server, which runs on a LAN interface
+// and delegates prefixes out of the node's subnet to downstream routers, so
+// that they and their own networks can reach and be reached from the mesh.
+// Routes to each delegated prefix are installed via the requesting router.
+type Server struct {
+	phony.Inbox
+	core   *core.Core
+	config *config.NodeConfig
+	log    *log.Logger
+	iface  *net.Interface
+	sock   *ipv6.PacketConn
+	duid   []byte
+	leases leaseTable
+	timer  *time.Timer
+	isOpen bool
+}
+
+// Init prepares the DHCPv6 prefix delegation server for use.
+func (s *Server) Init(core *core.Core, nc *config.NodeConfig, log *log.Logger, options interface{}) error {
+	s.core = core
+	s.config = nc
+	s.log = log
+	// A DUID-UUID derived from the node's key, so that it is stable
+	sum := sha256.Sum256(core.PublicKey())
+	s.duid = append([]byte{0, 4}, sum[:16]...)
+	return nil
+}
+
+// Start starts the server, if an interface has been configured.
+func (s *Server) Start() error {
+	var err error
+	phony.Block(s, func() {
+		err = s._start()
+	})
+	return err
+}
+
+func (s *Server) _start() error {
+	if s.isOpen {
+		return errors.New("DHCPv6 prefix delegation server is already started")
+	}
+	s.config.RLock()
+	pdconf := s.config.DHCPv6PD
+	s.config.RUnlock()
+	if pdconf.Interface == "" {
+		return nil
+	}
+	length := pdconf.PrefixLength
+	if length == 0 {
+		length = defaultPrefixLength
+	}
+	if length <= 64 || length > 128 {
+		return fmt.Errorf("DHCPv6PD PrefixLength %d is not between 65 and 128", length)
+	}
+	iface, err := net.InterfaceByName(pdconf.Interface)
+	if err != nil {
+		return err
+	}
+	subnet := s.core.Subnet()
+	s.leases.init(&subnet, length, pdconf.LeaseFile)
+	if err := s.leases.load(); err != nil {
+		s.log.Warnln("Failed to load DHCPv6 prefix delegation leases:", err)
+	}
+	conn, err := net.ListenPacket("udp6", "[::]:547")
+	if err != nil {
+		return err
+	}
+	sock := ipv6.NewPacketConn(conn)
+	if err := sock.JoinGroup(iface, &net.UDPAddr{IP: serverGroup}); err != nil {
+		conn.Close()
+		return err
+	}
+	if err := sock.SetControlMessage(ipv6.FlagInterface, true); err != nil { // nolint:staticcheck
+		// Windows can't set this flag, in which case we answer on every interface
+	}
+	s.iface = iface
+	s.sock = sock
+	s.isOpen = true
+	for _, l := range s.leases.leases {
+		if l.Bound {
+			s.addRoute(l)
+		}
+	}
+	s.Act(nil, s._sweep)
+	go s.listen(sock)
+	s.log.Infof("DHCPv6 prefix delegation server on %s delegating /%d prefixes from %s", iface.Name, length, subnet.String())
+	return nil
+}
+
+// IsStarted returns true if the server has been started.
+func (s *Server) IsStarted() bool {
+	var isOpen bool
+	phony.Block(s, func() {
+		isOpen = s.isOpen
+	})
+	return isOpen
+}
+
+// Stop stops the server. Routes for bound leases are removed, but the leases
+// themselves are kept, so that routers get the same prefix after a restart.
+func (s *Server) Stop() error {
+	phony.Block(s, func() {
+		if !s.isOpen {
+			return
+		}
+		s.isOpen = false
+		if s.timer != nil {
+			s.timer.Stop()
+		}
+		s.sock.Close()
+		for _, l := range s.leases.leases {
+			if l.Bound {
+				s.removeRoute(l)
+			}
+		}
+	})
+	return nil
+}
+
+// Leases returns a copy of all current leases.
+func (s *Server) Leases() []Lease {
+	var leases []Lease
+	phony.Block(s, func() {
+		for _, l := range s.leases.leases {
+			leases = append(leases, *l)
+		}
+	})
+	return leases
+}
+
+func (s *Server) listen(sock *ipv6.PacketConn) {
+	buf := make([]byte, 65535)
+	for {
+		n, cm, from, err := sock.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if cm != nil && cm.IfIndex != s.iface.Index {
+			continue
+		}
+		addr, ok := from.(*net.UDPAddr)
+		if !ok || !addr.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		bs := append([]byte(nil), buf[:n]...)
+		s.Act(nil, func() {
+			if !s.isOpen {
+				return
+			}
+			var req message
+			if err := req.decode(bs); err != nil {
+				return
+			}
+			if res := s._handle(&req, addr.IP, time.Now()); res != nil {
+				_, _ = sock.WriteTo(res.encode(), nil, addr)
+			}
+		})
+	}
+}
+
+func (s *Server) _sweep() {
+	if !s.isOpen {
+		return
+	}
+	expired := s.leases.expire(time.Now())
+	for _, l := range expired {
+		if l.Bound {
+			s.log.Infoln("DHCPv6 prefix delegation lease expired:", l.Prefix)
+			s.removeRoute(l)
+		}
+	}
+	if len(expired) > 0 {
+		if err := s.leases.save(); err != nil {
+			s.log.Warnln("Failed to save DHCPv6 prefix delegation leases:", err)
+		}
+	}
+	s.timer = time.AfterFunc(sweepInterval, func() {
+		s.Act(nil, s._sweep)
+	})
+}
+
+// _handle processes a client message and returns the reply to send, if any.
+func (s *Server) _handle(req *message, from net.IP, now time.Time) *message {
+	clientID := req.get(optClientID)
+	if clientID == nil {
+		return nil
+	}
+	serverID := req.get(optServerID)
+	switch req.msgType {
+	case msgSolicit:
+		if serverID != nil {
+			return nil
+		}
+	case msgRequest, msgRenew, msgRelease:
+		if !bytes.Equal(serverID, s.duid) {
+			return nil
+		}
+	case msgRebind:
+	default:
+		return nil
+	}
+	res := &message{msgType: msgReply, txid: req.txid}
+	res.options = append(res.options, option{optClientID, clientID}, option{optServerID, s.duid})
+	commit := true
+	if req.msgType == msgSolicit {
+		if req.has(optRapidCommit) {
+			res.options = append(res.options, option{optRapidCommit, nil})
+		} else {
+			res.msgType = msgAdvertise
+			commit = false
+		}
+	}
+	client := hex.EncodeToString(clientID)
+	changed := false
+	for _, opt := range req.options {
+		if opt.code != optIAPD {
+			continue
+		}
+		ia, err := parseIAPD(opt.data)
+		if err != nil {
+			continue
+		}
+		if req.msgType == msgRelease {
+			if l := s.leases.get(client, ia.iaid); l != nil {
+				if l.Bound {
+					s.removeRoute(l)
+				}
+				s.leases.remove(l)
+				changed = true
+			}
+			continue
+		}
+		out := &iaPD{iaid: ia.iaid}
+		l := s.leases.allocate(client, ia.iaid)
+		if l == nil {
+			out.status = &statusCode{statusNoPrefix, "no prefixes available"}
+			res.options = append(res.options, out.option())
+			continue
+		}
+		if commit {
+			if l.Bound && l.Via != from.String() {
+				s.removeRoute(l)
+				l.Bound = false
+			}
+			l.Via = from.String()
+			l.Expires = now.Add(validLifetime)
+			if !l.Bound {
+				l.Bound = true
+				s.addRoute(l)
+			}
+			changed = true
+		} else if !l.Bound {
+			l.Expires = now.Add(advertiseLifetime)
+		}
+		out.t1 = uint32(preferredLifetime / 2 / time.Second)
+		out.t2 = uint32(preferredLifetime * 4 / 5 / time.Second)
+		out.prefixes = []iaPrefix{{
+			preferred: uint32(preferredLifetime / time.Second),
+			valid:     uint32(validLifetime / time.Second),
+			prefix:    l.prefix(),
+		}}
+		res.options = append(res.options, out.option())
+	}
+	if req.msgType == msgRelease {
+		res.options = append(res.options, (&statusCode{statusSuccess, "released"}).option())
+	}
+	if changed {
+		if err := s.leases.save(); err != nil {
+			s.log.Warnln("Failed to save DHCPv6 prefix delegation leases:", err)
+		}
+	}
+	return res
+}
+
+func (s *Server) addRoute(l *Lease) {
+	if err := addRoute(s.iface, l.prefix(), net.ParseIP(l.Via)); err != nil {
+		s.log.Warnf("Failed to add route for delegated prefix %s via %s: %v", l.Prefix, l.Via, err)
+		return
+	}
+	s.log.Infof("Delegated prefix %s to %s", l.Prefix, l.Via)
+}
+
+func (s *Server) removeRoute(l *Lease) {
+	if err := removeRoute(s.iface, l.prefix(), net.ParseIP(l.Via)); err != nil {
+		s.log.Debugf("Failed to remove route for delegated prefix %s: %v", l.Prefix, err)
+	}
+}
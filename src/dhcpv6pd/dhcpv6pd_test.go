@@ -0,0 +1,81 @@
+package dhcpv6pd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gologme/log"
+)
+
+func testServer() *Server {
+	_, subnet, _ := net.ParseCIDR("300:1234:5678:9abc::/64")
+	s := &Server{
+		log:   log.New(ioutil.Discard, "", 0),
+		iface: &net.Interface{Name: "test"},
+		duid:  []byte{0, 4, 1, 2, 3, 4},
+	}
+	s.leases.init(subnet, 72, "")
+	s.isOpen = true
+	return s
+}
+
+func clientMessage(msgType uint8, serverID []byte) *message {
+	m := &message{msgType: msgType, txid: [3]byte{1, 2, 3}}
+	m.options = append(m.options, option{optClientID, []byte{0, 3, 0, 1, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}})
+	if serverID != nil {
+		m.options = append(m.options, option{optServerID, serverID})
+	}
+	m.options = append(m.options, (&iaPD{iaid: 7}).option())
+	return m
+}
+
+// delegated returns the prefix delegated in a reply, after a round trip
+// through the wire format.
+func delegated(t *testing.T, res *message) *net.IPNet {
+	var decoded message
+	if err := decoded.decode(res.encode()); err != nil {
+		t.Fatal(err)
+	}
+	ia, err := parseIAPD(decoded.get(optIAPD))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ia.prefixes) != 1 {
+		t.Fatal("expected one delegated prefix, got", len(ia.prefixes))
+	}
+	return ia.prefixes[0].prefix
+}
+
+func TestServer_Delegation(t *testing.T) {
+	s := testServer()
+	from := net.ParseIP("fe80::1")
+	now := time.Now()
+
+	adv := s._handle(clientMessage(msgSolicit, nil), from, now)
+	if adv == nil || adv.msgType != msgAdvertise || !bytes.Equal(adv.get(optServerID), s.duid) {
+		t.Fatal("unexpected response to solicit", adv)
+	}
+	offered := delegated(t, adv)
+	if offered.String() != "300:1234:5678:9abc:100::/72" {
+		t.Fatal("unexpected prefix offered", offered)
+	}
+
+	if res := s._handle(clientMessage(msgRequest, []byte{9, 9}), from, now); res != nil {
+		t.Fatal("request for another server was answered")
+	}
+	reply := s._handle(clientMessage(msgRequest, s.duid), from, now)
+	if reply == nil || reply.msgType != msgReply || delegated(t, reply).String() != offered.String() {
+		t.Fatal("request was not given the offered prefix", reply)
+	}
+	if l := s.leases.byPrefix[offered.String()]; l == nil || !l.Bound || l.Via != from.String() {
+		t.Fatal("lease was not bound", l)
+	}
+
+	s._handle(clientMessage(msgRelease, s.duid), from, now)
+	if len(s.leases.leases) != 0 {
+		t.Fatal("lease was not released")
+	}
+}
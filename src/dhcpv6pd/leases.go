@@ -0,0 +1,149 @@
+package dhcpv6pd
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"time"
+)
+
+// Lease records that a prefix has been delegated to a requesting router. The
+// lease is identified by the client's DUID and the IAID of the IA_PD within
+// the client, as a router may ask for more than one prefix.
+type Lease struct {
+	ClientID string    `json:"client_id"`
+	IAID     uint32    `json:"iaid"`
+	Prefix   string    `json:"prefix"`
+	Via      string    `json:"via"`
+	Expires  time.Time `json:"expires"`
+	Bound    bool      `json:"bound"` // False while only advertised to the client
+}
+
+func (l *Lease) key() string {
+	return fmt.Sprintf("%s/%d", l.ClientID, l.IAID)
+}
+
+func (l *Lease) prefix() *net.IPNet {
+	_, prefix, _ := net.ParseCIDR(l.Prefix)
+	return prefix
+}
+
+type leaseTable struct {
+	subnet   *net.IPNet
+	length   int
+	file     string
+	leases   map[string]*Lease
+	byPrefix map[string]*Lease
+}
+
+func (t *leaseTable) init(subnet *net.IPNet, length int, file string) {
+	t.subnet = subnet
+	t.length = length
+	t.file = file
+	t.leases = make(map[string]*Lease)
+	t.byPrefix = make(map[string]*Lease)
+}
+
+// load reads any leases that were saved by a previous run. Leases that are no
+// longer within the subnet, e.g. because the node's key changed, are dropped.
+func (t *leaseTable) load() error {
+	if t.file == "" {
+		return nil
+	}
+	bs, err := ioutil.ReadFile(t.file)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var leases []*Lease
+	if err := json.Unmarshal(bs, &leases); err != nil {
+		return err
+	}
+	for _, l := range leases {
+		prefix := l.prefix()
+		if prefix == nil || !t.subnet.Contains(prefix.IP) {
+			continue
+		}
+		if ones, _ := prefix.Mask.Size(); ones != t.length {
+			continue
+		}
+		t.add(l)
+	}
+	return nil
+}
+
+func (t *leaseTable) save() error {
+	if t.file == "" {
+		return nil
+	}
+	leases := make([]*Lease, 0, len(t.leases))
+	for _, l := range t.leases {
+		leases = append(leases, l)
+	}
+	bs, err := json.MarshalIndent(leases, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := t.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, bs, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.file)
+}
+
+func (t *leaseTable) add(l *Lease) {
+	t.leases[l.key()] = l
+	t.byPrefix[l.Prefix] = l
+}
+
+func (t *leaseTable) remove(l *Lease) {
+	delete(t.leases, l.key())
+	delete(t.byPrefix, l.Prefix)
+}
+
+func (t *leaseTable) get(clientID string, iaid uint32) *Lease {
+	return t.leases[(&Lease{ClientID: clientID, IAID: iaid}).key()]
+}
+
+// allocate returns the existing lease for the IA_PD, or a new lease for the
+// lowest free prefix. The first prefix of the subnet is never delegated, as it
+// is usually in use on the LAN of the node itself. It returns nil if the
+// subnet is exhausted.
+func (t *leaseTable) allocate(clientID string, iaid uint32) *Lease {
+	if l := t.get(clientID, iaid); l != nil {
+		return l
+	}
+	bits := t.length - 64
+	if bits > 16 {
+		bits = 16 // No need to search more than 65535 prefixes
+	}
+	for n := uint64(1); n < 1<<uint(bits); n++ {
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, t.subnet.IP.To16())
+		binary.BigEndian.PutUint64(ip[8:], n<<uint(128-t.length))
+		prefix := &net.IPNet{IP: ip, Mask: net.CIDRMask(t.length, 128)}
+		if _, ok := t.byPrefix[prefix.String()]; ok {
+			continue
+		}
+		l := &Lease{ClientID: clientID, IAID: iaid, Prefix: prefix.String()}
+		t.add(l)
+		return l
+	}
+	return nil
+}
+
+// expire removes and returns all leases that have expired.
+func (t *leaseTable) expire(now time.Time) []*Lease {
+	var expired []*Lease
+	for _, l := range t.leases {
+		if now.After(l.Expires) {
+			t.remove(l)
+			expired = append(expired, l)
+		}
+	}
+	return expired
+}
@@ -0,0 +1,171 @@
+package dhcpv6pd
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+// Message types and options from RFC 8415 that the server understands.
+const (
+	msgSolicit   = 1
+	msgAdvertise = 2
+	msgRequest   = 3
+	msgRenew     = 5
+	msgRebind    = 6
+	msgReply     = 7
+	msgRelease   = 8
+
+	optClientID    = 1
+	optServerID    = 2
+	optStatusCode  = 13
+	optRapidCommit = 14
+	optIAPD        = 25
+	optIAPrefix    = 26
+	statusSuccess  = 0
+	statusNoPrefix = 6
+)
+
+type option struct {
+	code uint16
+	data []byte
+}
+
+type message struct {
+	msgType uint8
+	txid    [3]byte
+	options []option
+}
+
+func parseOptions(bs []byte) ([]option, error) {
+	var opts []option
+	for len(bs) > 0 {
+		if len(bs) < 4 {
+			return nil, errors.New("truncated option header")
+		}
+		code := binary.BigEndian.Uint16(bs[0:2])
+		length := int(binary.BigEndian.Uint16(bs[2:4]))
+		if len(bs) < 4+length {
+			return nil, errors.New("truncated option")
+		}
+		opts = append(opts, option{code, bs[4 : 4+length]})
+		bs = bs[4+length:]
+	}
+	return opts, nil
+}
+
+func appendOptions(bs []byte, opts []option) []byte {
+	for _, opt := range opts {
+		bs = append(bs, byte(opt.code>>8), byte(opt.code), byte(len(opt.data)>>8), byte(len(opt.data)))
+		bs = append(bs, opt.data...)
+	}
+	return bs
+}
+
+func (m *message) decode(bs []byte) error {
+	if len(bs) < 4 {
+		return errors.New("message too short")
+	}
+	m.msgType = bs[0]
+	copy(m.txid[:], bs[1:4])
+	var err error
+	m.options, err = parseOptions(bs[4:])
+	return err
+}
+
+func (m *message) encode() []byte {
+	bs := []byte{m.msgType, m.txid[0], m.txid[1], m.txid[2]}
+	return appendOptions(bs, m.options)
+}
+
+// get returns the first option with the given code, or nil.
+func (m *message) get(code uint16) []byte {
+	for _, opt := range m.options {
+		if opt.code == code {
+			return opt.data
+		}
+	}
+	return nil
+}
+
+func (m *message) has(code uint16) bool {
+	for _, opt := range m.options {
+		if opt.code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// iaPD is the identity association for prefix delegation, which is what
+// a requesting router asks for and what the server binds a prefix to.
+type iaPD struct {
+	iaid     uint32
+	t1, t2   uint32
+	prefixes []iaPrefix
+	status   *statusCode
+}
+
+type iaPrefix struct {
+	preferred, valid uint32
+	prefix           *net.IPNet
+}
+
+type statusCode struct {
+	code uint16
+	msg  string
+}
+
+func (s *statusCode) option() option {
+	data := []byte{byte(s.code >> 8), byte(s.code)}
+	return option{optStatusCode, append(data, s.msg...)}
+}
+
+func parseIAPD(bs []byte) (*iaPD, error) {
+	if len(bs) < 12 {
+		return nil, errors.New("IA_PD too short")
+	}
+	ia := &iaPD{
+		iaid: binary.BigEndian.Uint32(bs[0:4]),
+		t1:   binary.BigEndian.Uint32(bs[4:8]),
+		t2:   binary.BigEndian.Uint32(bs[8:12]),
+	}
+	opts, err := parseOptions(bs[12:])
+	if err != nil {
+		return nil, err
+	}
+	for _, opt := range opts {
+		if opt.code != optIAPrefix || len(opt.data) < 25 {
+			continue
+		}
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, opt.data[9:25])
+		ia.prefixes = append(ia.prefixes, iaPrefix{
+			preferred: binary.BigEndian.Uint32(opt.data[0:4]),
+			valid:     binary.BigEndian.Uint32(opt.data[4:8]),
+			prefix:    &net.IPNet{IP: ip, Mask: net.CIDRMask(int(opt.data[8]), 128)},
+		})
+	}
+	return ia, nil
+}
+
+func (ia *iaPD) option() option {
+	data := make([]byte, 12)
+	binary.BigEndian.PutUint32(data[0:4], ia.iaid)
+	binary.BigEndian.PutUint32(data[4:8], ia.t1)
+	binary.BigEndian.PutUint32(data[8:12], ia.t2)
+	var opts []option
+	for _, p := range ia.prefixes {
+		bs := make([]byte, 25)
+		binary.BigEndian.PutUint32(bs[0:4], p.preferred)
+		binary.BigEndian.PutUint32(bs[4:8], p.valid)
+		ones, _ := p.prefix.Mask.Size()
+		bs[8] = byte(ones)
+		copy(bs[9:25], p.prefix.IP.To16())
+		opts = append(opts, option{optIAPrefix, bs})
+	}
+	if ia.status != nil {
+		opts = append(opts, ia.status.option())
+	}
+	return option{optIAPD, appendOptions(data, opts)}
+}
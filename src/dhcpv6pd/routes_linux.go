@@ -0,0 +1,32 @@
+//go:build linux
+// +build linux
+
+package dhcpv6pd
+
+import (
+	"net"
+
+	"github.com/vishvananda/netlink"
+)
+
+// Routes to delegated prefixes are tagged with the same protocol number as the
+// routes installed by the TUN adapter, so that routing daemons can find them.
+const routeProtocol = 121
+
+func addRoute(iface *net.Interface, prefix *net.IPNet, via net.IP) error {
+	return netlink.RouteReplace(&netlink.Route{
+		LinkIndex: iface.Index,
+		Dst:       prefix,
+		Gw:        via,
+		Protocol:  routeProtocol,
+	})
+}
+
+func removeRoute(iface *net.Interface, prefix *net.IPNet, via net.IP) error {
+	return netlink.RouteDel(&netlink.Route{
+		LinkIndex: iface.Index,
+		Dst:       prefix,
+		Gw:        via,
+		Protocol:  routeProtocol,
+	})
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package dhcpv6pd
+
+import (
+	"errors"
+	"net"
+)
+
+func addRoute(iface *net.Interface, prefix *net.IPNet, via net.IP) error {
+	return errors.New("routes for delegated prefixes must be added manually on this platform")
+}
+
+func removeRoute(iface *net.Interface, prefix *net.IPNet, via net.IP) error {
+	return nil
+}
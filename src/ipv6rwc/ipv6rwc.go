@@ -339,6 +339,11 @@ func (rwc *ReadWriteCloser) Subnet() address.Subnet {
 	return rwc.subnet
 }
 
+// Core returns the node that packets are read from and written to.
+func (rwc *ReadWriteCloser) Core() *core.Core {
+	return rwc.core
+}
+
 func (rwc *ReadWriteCloser) Read(p []byte) (n int, err error) {
 	return rwc.readPC(p)
 }
@@ -0,0 +1,202 @@
+package metrics
+
+// This package serves the statistics of the node over HTTP in the Prometheus
+// text format, so that nodes can be monitored with standard tooling. It is
+// off unless MetricsListen is set, and it shouldn't be exposed beyond a
+// trusted network, as the link labels include the addresses and keys of
+// peers. Counters are read afresh from the core on every scrape.
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Arceliar/phony"
+	"github.com/gologme/log"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/config"
+	"github.com/yggdrasil-network/yggdrasil-go/src/core"
+	"github.com/yggdrasil-network/yggdrasil-go/src/version"
+)
+
+// Exporter serves the metrics of the node at /metrics on the MetricsListen
+// address from the config.
+type Exporter struct {
+	phony.Inbox
+	core     *core.Core
+	config   *config.NodeConfig
+	log      *log.Logger
+	listener net.Listener
+	server   *http.Server
+}
+
+// Init prepares the exporter for use.
+func (e *Exporter) Init(core *core.Core, nc *config.NodeConfig, log *log.Logger, options interface{}) error {
+	e.core = core
+	e.config = nc
+	e.log = log
+	return nil
+}
+
+// Start starts serving the metrics, if MetricsListen is set.
+func (e *Exporter) Start() error {
+	var err error
+	phony.Block(e, func() {
+		err = e._start()
+	})
+	return err
+}
+
+func (e *Exporter) _start() error {
+	if e.server != nil {
+		return errors.New("metrics are already being served")
+	}
+	e.config.RLock()
+	listen := e.config.MetricsListen
+	e.config.RUnlock()
+	if listen == "" {
+		return nil
+	}
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen for metrics: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", e.handleMetrics)
+	e.listener = listener
+	e.server = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func(server *http.Server) {
+		_ = server.Serve(listener)
+	}(e.server)
+	e.log.Infof("Serving Prometheus metrics at http://%s/metrics", listener.Addr())
+	return nil
+}
+
+// Addr returns the address that the metrics are served on, or nil if they
+// aren't.
+func (e *Exporter) Addr() net.Addr {
+	var addr net.Addr
+	phony.Block(e, func() {
+		if e.listener != nil {
+			addr = e.listener.Addr()
+		}
+	})
+	return addr
+}
+
+// Stop stops serving the metrics.
+func (e *Exporter) Stop() error {
+	var err error
+	phony.Block(e, func() {
+		if e.server == nil {
+			return
+		}
+		err = e.server.Close()
+		e.server, e.listener = nil, nil
+	})
+	return err
+}
+
+func (e *Exporter) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = e.write(w)
+}
+
+// metric is one metric family in the text format.
+type metric struct {
+	name, kind, help string
+	samples          []sample
+}
+
+type sample struct {
+	labels string
+	value  float64
+}
+
+// gauge returns a metric with a single sample.
+func gauge(name, help string, value float64) metric {
+	return metric{name, "gauge", help, []sample{{"", value}}}
+}
+
+// write writes every metric in the text format.
+func (e *Exporter) write(w io.Writer) error {
+	handshakes := e.core.GetHandshakes()
+	metrics := []metric{
+		{"yggdrasil_build_info", "gauge", "The version of the node.", []sample{{
+			labels("name", version.BuildName(), "version", version.BuildVersion()), 1,
+		}}},
+		gauge("yggdrasil_peers", "Links to peers that are up.", float64(len(e.core.GetPeers()))),
+		gauge("yggdrasil_dht_entries", "Entries in the DHT of the node.", float64(len(e.core.GetDHT()))),
+		gauge("yggdrasil_paths", "Paths to other nodes that the node knows about.", float64(len(e.core.GetPaths()))),
+		gauge("yggdrasil_sessions", "Encrypted sessions with other nodes.", float64(len(e.core.GetSessions()))),
+		{"yggdrasil_handshakes_total", "counter", "Handshakes of links that completed.", []sample{{"", float64(handshakes.Completed)}}},
+		{"yggdrasil_handshake_failures_total", "counter", "Handshakes of links that failed.", []sample{{"", float64(handshakes.Failed)}}},
+	}
+	var links []core.Link
+	for _, link := range e.core.GetLinks() {
+		if link.Up {
+			links = append(links, link)
+		}
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].Name < links[j].Name })
+	perLink := []struct {
+		name, kind, help string
+		value            func(core.Link) float64
+	}{
+		{"yggdrasil_link_receive_bytes_total", "counter", "Bytes received on the link.", func(l core.Link) float64 { return float64(l.RXBytes) }},
+		{"yggdrasil_link_transmit_bytes_total", "counter", "Bytes sent on the link.", func(l core.Link) float64 { return float64(l.TXBytes) }},
+		{"yggdrasil_link_receive_packets_total", "counter", "Frames received on the link.", func(l core.Link) float64 { return float64(l.RXPackets) }},
+		{"yggdrasil_link_transmit_packets_total", "counter", "Frames sent on the link.", func(l core.Link) float64 { return float64(l.TXPackets) }},
+		{"yggdrasil_link_uptime_seconds", "gauge", "How long the link has been up.", func(l core.Link) float64 { return l.Uptime.Seconds() }},
+		{"yggdrasil_link_latency_seconds", "gauge", "Smoothed round trip time of the link, or 0 if it isn't measured.", func(l core.Link) float64 { return l.Latency.Seconds() }},
+	}
+	for _, m := range perLink {
+		family := metric{name: m.name, kind: m.kind, help: m.help}
+		for _, link := range links {
+			family.samples = append(family.samples, sample{
+				labels: labels(
+					"name", link.Name,
+					"type", link.Type,
+					"remote", link.Remote,
+					"key", hex.EncodeToString(link.Key),
+					"direction", direction(link.Incoming),
+				),
+				value: m.value(link),
+			})
+		}
+		metrics = append(metrics, family)
+	}
+	var b strings.Builder
+	for _, m := range metrics {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for _, s := range m.samples {
+			fmt.Fprintf(&b, "%s%s %g\n", m.name, s.labels, s.value)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func direction(incoming bool) string {
+	if incoming {
+		return "inbound"
+	}
+	return "outbound"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels formats pairs of label names and values.
+func labels(pairs ...string) string {
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, pairs[i]+`="`+labelEscaper.Replace(pairs[i+1])+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
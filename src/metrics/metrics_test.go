@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gologme/log"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/core"
+	"github.com/yggdrasil-network/yggdrasil-go/src/defaults"
+)
+
+func TestExporter(t *testing.T) {
+	logger := log.New(ioutil.Discard, "", 0)
+	var nodes [2]core.Core
+	for i := range nodes {
+		cfg := defaults.GenerateConfig()
+		cfg.AdminListen = "none"
+		cfg.Listen = nil
+		cfg.IfName = "none"
+		if err := nodes[i].Start(cfg, logger); err != nil {
+			t.Fatal(err)
+		}
+		defer nodes[i].Stop()
+	}
+	listener, err := nodes[0].Listen(&url.URL{Scheme: "tcp", Host: "127.0.0.1:0"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Stop()
+	u, _ := url.Parse("tcp://" + listener.Listener.Addr().String())
+	if err := nodes[1].CallPeer(u, ""); err != nil {
+		t.Fatal(err)
+	}
+	cfg := defaults.GenerateConfig()
+	cfg.MetricsListen = "127.0.0.1:0"
+	var e Exporter
+	if err := e.Init(&nodes[0], cfg, logger, nil); err != nil {
+		t.Fatal(err)
+	} else if err := e.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer e.Stop()
+	key := hex.EncodeToString(nodes[1].PublicKey())
+	for i := 0; ; i++ {
+		res, err := http.Get("http://" + e.Addr().String() + "/metrics")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		metrics := string(body)
+		if strings.Contains(metrics, "yggdrasil_peers 1\n") {
+			for _, want := range []string{
+				"# TYPE yggdrasil_link_receive_bytes_total counter\n",
+				`key="` + key + `"`,
+				`direction="inbound"`,
+				"yggdrasil_handshakes_total 1\n",
+				"yggdrasil_handshake_failures_total 0\n",
+			} {
+				if !strings.Contains(metrics, want) {
+					t.Errorf("metrics have no %q:\n%s", want, metrics)
+				}
+			}
+			return
+		}
+		if i == 50 {
+			t.Fatalf("peer never appeared in the metrics:\n%s", metrics)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
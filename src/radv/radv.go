@@ -0,0 +1,228 @@
+package radv
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/Arceliar/phony"
+	"github.com/gologme/log"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/address"
+	"github.com/yggdrasil-network/yggdrasil-go/src/config"
+	"github.com/yggdrasil-network/yggdrasil-go/src/core"
+)
+
+const (
+	advertLifetime    = 30 * time.Minute // Lifetime of the prefix and route
+	minAdvertInterval = 200 * time.Second
+	maxAdvertInterval = 600 * time.Second
+	minSolicitedDelay = 3 * time.Second // Rate limit for solicited adverts
+)
+
+var allNodes = net.ParseIP("ff02::1")
+var allRouters = net.ParseIP("ff02::2")
+
+// Advertiser sends router advertisements on a LAN interface. The node's subnet
+// is advertised as an on-link prefix for stateless autoconfiguration, and the
+// Yggdrasil prefix is advertised as a route through this node with a route
+// information option, so that LAN devices can reach the mesh without having
+// Yggdrasil installed. The node is not advertised as a default router.
+type Advertiser struct {
+	phony.Inbox
+	core   *core.Core
+	config *config.NodeConfig
+	log    *log.Logger
+	iface  *net.Interface
+	sock   *ipv6.PacketConn
+	subnet net.IPNet
+	timer  *time.Timer
+	last   time.Time // When we last sent an advert
+	isOpen bool
+}
+
+// Init prepares the router advertisement sender for use.
+func (a *Advertiser) Init(core *core.Core, nc *config.NodeConfig, log *log.Logger, options interface{}) error {
+	a.core = core
+	a.config = nc
+	a.log = log
+	return nil
+}
+
+// Start starts sending router advertisements, if an interface has been
+// configured.
+func (a *Advertiser) Start() error {
+	var err error
+	phony.Block(a, func() {
+		err = a._start()
+	})
+	return err
+}
+
+func (a *Advertiser) _start() error {
+	if a.isOpen {
+		return errors.New("router advertisements are already started")
+	}
+	a.config.RLock()
+	name := a.config.RAInterface
+	a.config.RUnlock()
+	if name == "" {
+		return nil
+	}
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return err
+	}
+	conn, err := icmp.ListenPacket("ip6:ipv6-icmp", "::")
+	if err != nil {
+		return err
+	}
+	sock := conn.IPv6PacketConn()
+	var filter ipv6.ICMPFilter
+	filter.SetAll(true)
+	filter.Accept(ipv6.ICMPTypeRouterSolicitation)
+	for _, fn := range []func() error{
+		func() error { return sock.SetICMPFilter(&filter) },
+		func() error { return sock.SetMulticastHopLimit(255) },
+		func() error { return sock.SetHopLimit(255) },
+		func() error { return sock.SetMulticastLoopback(false) },
+		func() error { return sock.SetMulticastInterface(iface) },
+		func() error { return sock.JoinGroup(iface, &net.IPAddr{IP: allRouters}) },
+		func() error { return sock.SetControlMessage(ipv6.FlagInterface|ipv6.FlagHopLimit, true) },
+	} {
+		if err := fn(); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	subnet := a.core.Subnet()
+	if err := addRoute(iface, &subnet); err != nil {
+		a.log.Warnf("Failed to add route for %s on %s: %v", subnet.String(), iface.Name, err)
+	}
+	if !forwardingEnabled() {
+		a.log.Warnln("IPv6 forwarding is not enabled, LAN devices will not be able to reach the mesh")
+	}
+	a.iface = iface
+	a.sock = sock
+	a.subnet = subnet
+	a.isOpen = true
+	a.Act(nil, a._advertise)
+	go a.listen(sock)
+	a.log.Infof("Sending router advertisements for %s on %s", subnet.String(), iface.Name)
+	return nil
+}
+
+// IsStarted returns true if router advertisements are being sent.
+func (a *Advertiser) IsStarted() bool {
+	var isOpen bool
+	phony.Block(a, func() {
+		isOpen = a.isOpen
+	})
+	return isOpen
+}
+
+// Stop stops sending router advertisements. A final advert with zero
+// lifetimes is sent first, so that LAN devices stop using the prefix and route
+// straight away instead of waiting for them to expire.
+func (a *Advertiser) Stop() error {
+	phony.Block(a, func() {
+		if !a.isOpen {
+			return
+		}
+		a.isOpen = false
+		if a.timer != nil {
+			a.timer.Stop()
+		}
+		a._send(0)
+		a.sock.Close()
+		if err := removeRoute(a.iface, &a.subnet); err != nil {
+			a.log.Debugf("Failed to remove route for %s: %v", a.subnet.String(), err)
+		}
+	})
+	return nil
+}
+
+func (a *Advertiser) listen(sock *ipv6.PacketConn) {
+	buf := make([]byte, 1500)
+	for {
+		n, cm, _, err := sock.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if cm == nil || cm.IfIndex != a.iface.Index || cm.HopLimit != 255 {
+			continue // RFC 4861 requires solicitations to come from on-link
+		}
+		if n < 8 || buf[0] != byte(ipv6.ICMPTypeRouterSolicitation) || buf[1] != 0 {
+			continue
+		}
+		a.Act(nil, func() {
+			if !a.isOpen || time.Since(a.last) < minSolicitedDelay {
+				return
+			}
+			a._send(advertLifetime)
+		})
+	}
+}
+
+// _advertise sends an unsolicited advert and schedules the next one for a
+// random interval later, as recommended by RFC 4861.
+func (a *Advertiser) _advertise() {
+	if !a.isOpen {
+		return
+	}
+	a._send(advertLifetime)
+	interval := minAdvertInterval + time.Duration(rand.Int63n(int64(maxAdvertInterval-minAdvertInterval)))
+	a.timer = time.AfterFunc(interval, func() {
+		a.Act(nil, a._advertise)
+	})
+}
+
+func (a *Advertiser) _send(lifetime time.Duration) {
+	cm := &ipv6.ControlMessage{IfIndex: a.iface.Index}
+	dst := &net.IPAddr{IP: allNodes, Zone: a.iface.Name}
+	if _, err := a.sock.WriteTo(a.advertisement(lifetime), cm, dst); err != nil {
+		a.log.Debugln("Failed to send router advertisement:", err)
+		return
+	}
+	a.last = time.Now()
+}
+
+// advertisement builds a router advertisement with the given lifetime for the
+// prefix and route. The checksum is left for the kernel to fill in.
+func (a *Advertiser) advertisement(lifetime time.Duration) []byte {
+	seconds := uint32(lifetime / time.Second)
+	bs := make([]byte, 16)
+	bs[0] = byte(ipv6.ICMPTypeRouterAdvertisement)
+	// Hop limit, flags, router lifetime, reachable time and retransmit timer
+	// are all left unspecified, as we are not a default router.
+	if len(a.iface.HardwareAddr) == 6 {
+		bs = append(bs, 1, 1) // Source link-layer address
+		bs = append(bs, a.iface.HardwareAddr...)
+	}
+	if a.iface.MTU > 0 {
+		mtu := make([]byte, 8)
+		mtu[0], mtu[1] = 5, 1
+		binary.BigEndian.PutUint32(mtu[4:], uint32(a.iface.MTU))
+		bs = append(bs, mtu...)
+	}
+	ones, _ := a.subnet.Mask.Size()
+	pio := make([]byte, 32)
+	pio[0], pio[1] = 3, 4
+	pio[2] = byte(ones)
+	pio[3] = 0xc0 // On-link and autonomous
+	binary.BigEndian.PutUint32(pio[4:8], seconds)
+	binary.BigEndian.PutUint32(pio[8:12], seconds/2)
+	copy(pio[16:32], a.subnet.IP.To16())
+	bs = append(bs, pio...)
+	prefix := address.GetPrefix()
+	rio := make([]byte, 16)
+	rio[0], rio[1] = 24, 2
+	rio[2] = byte(8*len(prefix) - 1)
+	binary.BigEndian.PutUint32(rio[4:8], seconds)
+	copy(rio[8:], prefix[:])
+	return append(bs, rio...)
+}
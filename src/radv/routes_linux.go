@@ -0,0 +1,37 @@
+//go:build linux
+// +build linux
+
+package radv
+
+import (
+	"io/ioutil"
+	"net"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+)
+
+// The route to our subnet is tagged with the same protocol number as the
+// routes installed by the TUN adapter, so that routing daemons can find it.
+const routeProtocol = 121
+
+func addRoute(iface *net.Interface, subnet *net.IPNet) error {
+	return netlink.RouteReplace(&netlink.Route{
+		LinkIndex: iface.Index,
+		Dst:       subnet,
+		Protocol:  routeProtocol,
+	})
+}
+
+func removeRoute(iface *net.Interface, subnet *net.IPNet) error {
+	return netlink.RouteDel(&netlink.Route{
+		LinkIndex: iface.Index,
+		Dst:       subnet,
+		Protocol:  routeProtocol,
+	})
+}
+
+func forwardingEnabled() bool {
+	bs, err := ioutil.ReadFile("/proc/sys/net/ipv6/conf/all/forwarding")
+	return err != nil || strings.TrimSpace(string(bs)) == "1"
+}
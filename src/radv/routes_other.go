@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+package radv
+
+import (
+	"errors"
+	"net"
+)
+
+func addRoute(iface *net.Interface, subnet *net.IPNet) error {
+	return errors.New("the route to the subnet must be added manually on this platform")
+}
+
+func removeRoute(iface *net.Interface, subnet *net.IPNet) error {
+	return nil
+}
+
+func forwardingEnabled() bool {
+	return true // We don't know how to check, so don't warn
+}
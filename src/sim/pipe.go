@@ -0,0 +1,87 @@
+package sim
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Links use a buffered pipe rather than net.Pipe, as both ends of a link send
+// their metadata before reading the other's, which would deadlock on a pipe
+// where each write waits for a matching read.
+func pipe(a, b string) (net.Conn, net.Conn) {
+	ab, ba := newPipeBuffer(), newPipeBuffer()
+	return &pipeConn{r: ba, w: ab, local: pipeAddr(a), remote: pipeAddr(b)},
+		&pipeConn{r: ab, w: ba, local: pipeAddr(b), remote: pipeAddr(a)}
+}
+
+type pipeBuffer struct {
+	mutex  sync.Mutex
+	cond   *sync.Cond
+	buf    bytes.Buffer
+	closed bool
+}
+
+func newPipeBuffer() *pipeBuffer {
+	p := new(pipeBuffer)
+	p.cond = sync.NewCond(&p.mutex)
+	return p
+}
+
+func (p *pipeBuffer) read(bs []byte) (int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for p.buf.Len() == 0 && !p.closed {
+		p.cond.Wait()
+	}
+	if p.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return p.buf.Read(bs)
+}
+
+func (p *pipeBuffer) write(bs []byte) (int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return 0, io.ErrClosedPipe
+	}
+	p.cond.Broadcast()
+	return p.buf.Write(bs)
+}
+
+func (p *pipeBuffer) close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.closed = true
+	p.cond.Broadcast()
+}
+
+type pipeConn struct {
+	r, w          *pipeBuffer
+	local, remote pipeAddr
+}
+
+func (c *pipeConn) Read(bs []byte) (int, error)  { return c.r.read(bs) }
+func (c *pipeConn) Write(bs []byte) (int, error) { return c.w.write(bs) }
+
+func (c *pipeConn) Close() error {
+	c.r.close()
+	c.w.close()
+	return nil
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return c.local }
+func (c *pipeConn) RemoteAddr() net.Addr { return c.remote }
+
+// Deadlines aren't used by the core, so they are ignored.
+func (c *pipeConn) SetDeadline(t time.Time) error      { return nil }
+func (c *pipeConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *pipeConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "sim" }
+func (a pipeAddr) String() string  { return string(a) }
@@ -0,0 +1,7 @@
+//go:build race
+// +build race
+
+package sim
+
+// raceEnabled is true when the tests are built with the race detector.
+const raceEnabled = true
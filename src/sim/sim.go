@@ -0,0 +1,410 @@
+// Package sim runs a network of Yggdrasil nodes within a single process, with
+// the nodes peered over in-memory pipes rather than sockets. It is intended
+// for integration tests of routing behaviour, where a topology is built up,
+// changed and then checked for convergence and reachability.
+package sim
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	iwt "github.com/Arceliar/ironwood/types"
+	"github.com/gologme/log"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/core"
+	"github.com/yggdrasil-network/yggdrasil-go/src/defaults"
+)
+
+const pollInterval = 50 * time.Millisecond
+
+// The first bytes of the packets sent by Reachable, so that they can be told
+// apart from traffic injected with Send.
+var probeMagic = []byte("ygg-sim-probe")
+
+// Network is a set of simulated nodes and the links between them.
+type Network struct {
+	out   io.Writer
+	log   *log.Logger
+	mutex sync.Mutex // protects the below
+	nodes map[string]*Node
+	links map[[2]string]*simLink
+}
+
+// Node is a single simulated node. The embedded Core can be used directly,
+// e.g. to query peers or paths, but packets should be read with Receive
+// rather than Core.ReadFrom, as the network reads from every node so that
+// protocol traffic is processed.
+type Node struct {
+	*core.Core
+	Name     string
+	received chan Packet
+	probes   chan []byte
+}
+
+// Packet is traffic that was delivered to a node.
+type Packet struct {
+	From []byte // Public key of the sender
+	Data []byte
+}
+
+type simLink struct {
+	a, b net.Conn
+}
+
+// New creates an empty network. Node logs are written to the given writer,
+// with each line prefixed by the node name, or discarded if it is nil.
+func New(out io.Writer) *Network {
+	if out == nil {
+		out = ioutil.Discard
+	}
+	return &Network{
+		out:   out,
+		log:   newLogger(out, "sim: "),
+		nodes: make(map[string]*Node),
+		links: make(map[[2]string]*simLink),
+	}
+}
+
+func newLogger(out io.Writer, prefix string) *log.Logger {
+	logger := log.New(out, prefix, log.Flags())
+	logger.SetCallDepth(2) // Otherwise the first lines logged race to set it
+	logger.EnableLevel("info")
+	logger.EnableLevel("warn")
+	logger.EnableLevel("error")
+	return logger
+}
+
+// AddNode starts a new node with a freshly generated key and no peers.
+func (n *Network) AddNode(name string) (*Node, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if _, ok := n.nodes[name]; ok {
+		return nil, fmt.Errorf("node %q already exists", name)
+	}
+	cfg := defaults.GenerateConfig()
+	cfg.AdminListen = "none"
+	cfg.Listen = []string{}
+	cfg.IfName = "none"
+	cfg.MulticastInterfaces = nil
+	node := &Node{
+		Core:     new(core.Core),
+		Name:     name,
+		received: make(chan Packet, 64),
+		probes:   make(chan []byte, 64),
+	}
+	if err := node.Start(cfg, newLogger(n.out, name+": ")); err != nil {
+		return nil, err
+	}
+	go node.read()
+	n.nodes[name] = node
+	return node, nil
+}
+
+// AddNodes starts the given number of nodes, named "0", "1" and so on, after
+// any nodes that already exist.
+func (n *Network) AddNodes(count int) ([]*Node, error) {
+	n.mutex.Lock()
+	first := len(n.nodes)
+	n.mutex.Unlock()
+	nodes := make([]*Node, 0, count)
+	for i := first; i < first+count; i++ {
+		node, err := n.AddNode(fmt.Sprint(i))
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// Node returns the node with the given name, or nil.
+func (n *Network) Node(name string) *Node {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.nodes[name]
+}
+
+// Nodes returns all nodes, sorted by name.
+func (n *Network) Nodes() []*Node {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	nodes := make([]*Node, 0, len(n.nodes))
+	for _, node := range n.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	return nodes
+}
+
+func linkKey(a, b string) [2]string {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]string{a, b}
+}
+
+// Connect peers two nodes with an in-memory link.
+func (n *Network) Connect(a, b string) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	nodeA, nodeB := n.nodes[a], n.nodes[b]
+	if nodeA == nil || nodeB == nil {
+		return fmt.Errorf("cannot connect unknown nodes %q and %q", a, b)
+	}
+	key := linkKey(a, b)
+	if _, ok := n.links[key]; ok {
+		return fmt.Errorf("nodes %q and %q are already connected", a, b)
+	}
+	l := new(simLink)
+	l.a, l.b = pipe(a, b)
+	n.links[key] = l
+	run := func(node *Node, conn net.Conn, name string, incoming bool) {
+		if err := node.AddConn(conn, "sim://"+name, incoming); err != nil {
+			n.log.Debugf("%s: link to %s closed: %v", node.Name, name, err)
+		}
+		n.mutex.Lock()
+		if n.links[key] == l {
+			delete(n.links, key)
+		}
+		n.mutex.Unlock()
+		l.a.Close()
+		l.b.Close()
+	}
+	go run(nodeA, l.a, b, false)
+	go run(nodeB, l.b, a, true)
+	return nil
+}
+
+// Disconnect removes the link between two nodes.
+func (n *Network) Disconnect(a, b string) error {
+	n.mutex.Lock()
+	l := n.links[linkKey(a, b)]
+	delete(n.links, linkKey(a, b))
+	n.mutex.Unlock()
+	if l == nil {
+		return fmt.Errorf("nodes %q and %q are not connected", a, b)
+	}
+	l.a.Close()
+	return l.b.Close()
+}
+
+// RemoveNode stops a node and removes all of its links.
+func (n *Network) RemoveNode(name string) error {
+	n.mutex.Lock()
+	node := n.nodes[name]
+	delete(n.nodes, name)
+	var links []*simLink
+	for key, l := range n.links {
+		if key[0] == name || key[1] == name {
+			links = append(links, l)
+			delete(n.links, key)
+		}
+	}
+	n.mutex.Unlock()
+	if node == nil {
+		return fmt.Errorf("node %q does not exist", name)
+	}
+	for _, l := range links {
+		l.a.Close()
+		l.b.Close()
+	}
+	node.Stop()
+	return nil
+}
+
+// Stop stops every node in the network. All of the links are closed first,
+// and the nodes are then given a moment to finish handling anything that was
+// already in flight, as ironwood doesn't expect traffic once it is closed.
+func (n *Network) Stop() {
+	n.mutex.Lock()
+	links := n.links
+	n.links = make(map[[2]string]*simLink)
+	n.mutex.Unlock()
+	for _, l := range links {
+		l.a.Close()
+		l.b.Close()
+	}
+	time.Sleep(10 * pollInterval)
+	for _, node := range n.Nodes() {
+		_ = n.RemoveNode(node.Name)
+	}
+}
+
+// SetChaos injects faults into the frames sent in both directions on the link
+// between two nodes, as described by core.Chaos. A zero Chaos removes them.
+func (n *Network) SetChaos(a, b string, chaos core.Chaos) error {
+	nodeA, nodeB := n.Node(a), n.Node(b)
+	if nodeA == nil || nodeB == nil {
+		return fmt.Errorf("cannot set chaos between unknown nodes %q and %q", a, b)
+	}
+	nodeA.SetChaos("sim://"+b, chaos)
+	nodeB.SetChaos("sim://"+a, chaos)
+	return nil
+}
+
+// Send injects a packet from one node to another. Delivery is not guaranteed,
+// in particular before the network has converged; use Receive on the
+// destination to check for it.
+func (n *Network) Send(from, to string, data []byte) error {
+	src, dst := n.Node(from), n.Node(to)
+	if src == nil || dst == nil {
+		return fmt.Errorf("cannot send between unknown nodes %q and %q", from, to)
+	}
+	_, err := src.WriteTo(data, iwt.Addr(dst.PublicKey()))
+	return err
+}
+
+// Receive waits for the next packet delivered to the node.
+func (node *Node) Receive(timeout time.Duration) (Packet, error) {
+	select {
+	case p := <-node.received:
+		return p, nil
+	case <-time.After(timeout):
+		return Packet{}, errors.New("timed out waiting for a packet")
+	}
+}
+
+// read delivers packets from the core until it is stopped.
+func (node *Node) read() {
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := node.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		data := append([]byte(nil), buf[:n]...)
+		if bytes.HasPrefix(data, probeMagic) {
+			select {
+			case node.probes <- data:
+			default:
+			}
+			continue
+		}
+		select {
+		case node.received <- Packet{From: append([]byte(nil), from.(iwt.Addr)...), Data: data}:
+		default: // Drop if the test isn't reading, as a real network would
+		}
+	}
+}
+
+// Reachable returns true if a packet sent from one node arrives at the other
+// within the timeout. Probes are sent repeatedly, as the first may be lost
+// while the path to the destination is being looked up.
+func (n *Network) Reachable(from, to string, timeout time.Duration) bool {
+	dst := n.Node(to)
+	if dst == nil {
+		return false
+	}
+	nonce := make([]byte, 8)
+	_, _ = rand.Read(nonce)
+	probe := append(append([]byte(nil), probeMagic...), nonce...)
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(10 * pollInterval)
+	defer ticker.Stop()
+	for {
+		if err := n.Send(from, to, probe); err != nil {
+			return false
+		}
+		for waiting := true; waiting; {
+			select {
+			case got := <-dst.probes:
+				if bytes.Equal(got, probe) {
+					return true
+				}
+			case <-ticker.C:
+				waiting = false
+			case <-deadline:
+				return false
+			}
+		}
+	}
+}
+
+// FullyReachable returns true if every node can reach every other node.
+func (n *Network) FullyReachable(timeout time.Duration) error {
+	nodes := n.Nodes()
+	for _, a := range nodes {
+		for _, b := range nodes {
+			if a != b && !n.Reachable(a.Name, b.Name, timeout) {
+				return fmt.Errorf("node %q cannot reach node %q", a.Name, b.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// WaitConverged waits until every node has a peer for each of its links, all
+// of the nodes that can reach each other agree on the root of the tree and
+// each node has found its successor in the DHT.
+func (n *Network) WaitConverged(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := n.converged()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+func (n *Network) converged() error {
+	n.mutex.Lock()
+	degree := make(map[string]int)
+	adjacent := make(map[string][]string)
+	for key := range n.links {
+		degree[key[0]]++
+		degree[key[1]]++
+		adjacent[key[0]] = append(adjacent[key[0]], key[1])
+		adjacent[key[1]] = append(adjacent[key[1]], key[0])
+	}
+	n.mutex.Unlock()
+	nodes := n.Nodes()
+	roots := make(map[string][]byte, len(nodes))
+	for _, node := range nodes {
+		if peers := len(node.GetPeers()); peers != degree[node.Name] {
+			return fmt.Errorf("node %q has %d peers, expected %d", node.Name, peers, degree[node.Name])
+		}
+		roots[node.Name] = node.GetSelf().Root
+	}
+	for _, node := range nodes {
+		for _, other := range adjacent[node.Name] {
+			if !bytes.Equal(roots[node.Name], roots[other]) {
+				return fmt.Errorf("nodes %q and %q disagree on the root", node.Name, other)
+			}
+		}
+	}
+	// Each node should know the next highest key in its part of the network,
+	// otherwise lookups may fail and ironwood remembers the failed path for a
+	// while, so sending traffic too early would make convergence look slower.
+	for _, node := range nodes {
+		var next []byte
+		for _, other := range nodes {
+			key, own := other.PublicKey(), node.PublicKey()
+			if bytes.Equal(roots[node.Name], roots[other.Name]) && bytes.Compare(key, own) > 0 && (next == nil || bytes.Compare(key, next) < 0) {
+				next = key
+			}
+		}
+		if next == nil {
+			continue
+		}
+		found := false
+		for _, entry := range node.GetDHT() {
+			found = found || bytes.Equal(entry.Key, next)
+		}
+		if !found {
+			return fmt.Errorf("node %q is missing its successor from the DHT", node.Name)
+		}
+	}
+	return nil
+}
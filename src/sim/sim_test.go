@@ -0,0 +1,114 @@
+package sim
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/core"
+)
+
+// skipIfSessionRace skips a test that sends traffic over ironwood sessions
+// when the race detector is on, as the pinned versions of ironwood and phony
+// race with themselves while sessions are set up.
+func skipIfSessionRace(t *testing.T) {
+	if raceEnabled {
+		t.Skip("ironwood sessions race under the race detector")
+	}
+}
+
+// TestNetwork_Ring checks that a ring converges, that every node can reach
+// every other, and that it still does once one of the links is broken.
+func TestNetwork_Ring(t *testing.T) {
+	skipIfSessionRace(t)
+	n := New(nil)
+	defer n.Stop()
+	nodes, err := n.AddNodes(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Ring(nodes); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.WaitConverged(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.FullyReachable(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Disconnect("0", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.WaitConverged(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if !n.Reachable("0", "1", 10*time.Second) {
+		t.Fatal("node 0 cannot reach node 1 around the ring")
+	}
+}
+
+// TestNetwork_Send checks that injected traffic is delivered along a line.
+func TestNetwork_Send(t *testing.T) {
+	skipIfSessionRace(t)
+	n := New(nil)
+	defer n.Stop()
+	nodes, err := n.AddNodes(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Line(nodes); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.WaitConverged(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if !n.Reachable("0", "2", 10*time.Second) {
+		t.Fatal("node 0 cannot reach node 2")
+	}
+	if err := n.Send("0", "2", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	p, err := nodes[2].Receive(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p.Data, []byte("hello")) || !bytes.Equal(p.From, nodes[0].PublicKey()) {
+		t.Fatal("unexpected packet", p)
+	}
+}
+
+// TestNetwork_Chaos checks that traffic still gets through, once a path has
+// been found, after a link starts to drop, duplicate and delay its frames.
+func TestNetwork_Chaos(t *testing.T) {
+	skipIfSessionRace(t)
+	n := New(nil)
+	defer n.Stop()
+	nodes, err := n.AddNodes(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Line(nodes); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.WaitConverged(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if !n.Reachable("0", "2", 10*time.Second) {
+		t.Fatal("node 0 cannot reach node 2")
+	}
+	chaos := core.Chaos{
+		Drop:      0.2,
+		Duplicate: 0.2,
+		Delay:     0.2,
+		MaxDelay:  20 * time.Millisecond,
+	}
+	if err := n.SetChaos("1", "2", chaos); err != nil {
+		t.Fatal(err)
+	}
+	if !n.Reachable("0", "2", 10*time.Second) {
+		t.Fatal("node 0 cannot reach node 2 over the bad link")
+	}
+	if peers := len(nodes[2].GetPeers()); peers != 1 {
+		t.Fatal("the bad link went down")
+	}
+}
@@ -0,0 +1,46 @@
+package sim
+
+import "fmt"
+
+// Line connects the nodes in a chain, each to the next.
+func (n *Network) Line(nodes []*Node) error {
+	for i := 1; i < len(nodes); i++ {
+		if err := n.Connect(nodes[i-1].Name, nodes[i].Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Ring connects the nodes in a chain and then closes the loop.
+func (n *Network) Ring(nodes []*Node) error {
+	if len(nodes) < 3 {
+		return fmt.Errorf("a ring needs at least 3 nodes, not %d", len(nodes))
+	}
+	if err := n.Line(nodes); err != nil {
+		return err
+	}
+	return n.Connect(nodes[len(nodes)-1].Name, nodes[0].Name)
+}
+
+// Star connects every other node to the first.
+func (n *Network) Star(nodes []*Node) error {
+	for i := 1; i < len(nodes); i++ {
+		if err := n.Connect(nodes[0].Name, nodes[i].Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Mesh connects every node to every other node.
+func (n *Network) Mesh(nodes []*Node) error {
+	for i := range nodes {
+		for j := i + 1; j < len(nodes); j++ {
+			if err := n.Connect(nodes[i].Name, nodes[j].Name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
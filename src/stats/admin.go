@@ -0,0 +1,33 @@
+package stats
+
+import (
+	"encoding/json"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/admin"
+)
+
+type GetStatsReportRequest struct{}
+type GetStatsReportResponse struct {
+	Enabled bool   `json:"enabled"`
+	Report  Report `json:"report"`
+}
+
+func (r *Reporter) getStatsReportHandler(req *GetStatsReportRequest, res *GetStatsReportResponse) error {
+	res.Enabled = r.IsStarted()
+	res.Report = r.Report()
+	return nil
+}
+
+func (r *Reporter) SetupAdminHandlers(a *admin.AdminSocket) {
+	_ = a.AddHandler("getStatsReport", []string{}, func(in json.RawMessage) (interface{}, error) {
+		req := &GetStatsReportRequest{}
+		res := &GetStatsReportResponse{}
+		if err := json.Unmarshal(in, &req); err != nil {
+			return nil, err
+		}
+		if err := r.getStatsReportHandler(req, res); err != nil {
+			return nil, err
+		}
+		return res, nil
+	})
+}
@@ -0,0 +1,178 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"runtime"
+	"time"
+
+	"github.com/Arceliar/phony"
+	"github.com/gologme/log"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/config"
+	"github.com/yggdrasil-network/yggdrasil-go/src/core"
+	"github.com/yggdrasil-network/yggdrasil-go/src/version"
+)
+
+const (
+	reportInterval = 6 * time.Hour
+	reportTimeout  = 30 * time.Second
+)
+
+// Report is the whole of what is sent to the collector. It deliberately
+// contains nothing that identifies the node, such as its key, address or
+// peers, and the uptime is rounded to the hour so that reports from the same
+// node can't easily be linked together.
+type Report struct {
+	BuildName     string `json:"buildname"`
+	BuildVersion  string `json:"buildversion"`
+	BuildPlatform string `json:"buildplatform"`
+	BuildArch     string `json:"buildarch"`
+	Peers         int    `json:"peers"`
+	Uptime        uint64 `json:"uptime"` // Hours
+}
+
+// Reporter periodically sends anonymous statistics about the node to the
+// collector in the config. It does nothing unless a collector is configured.
+type Reporter struct {
+	phony.Inbox
+	core      *core.Core
+	config    *config.NodeConfig
+	log       *log.Logger
+	collector string
+	client    http.Client
+	started   time.Time
+	timer     *time.Timer
+	isOpen    bool
+}
+
+// Init prepares the reporter for use.
+func (r *Reporter) Init(core *core.Core, nc *config.NodeConfig, log *log.Logger, options interface{}) error {
+	r.core = core
+	r.config = nc
+	r.log = log
+	r.client.Timeout = reportTimeout
+	return nil
+}
+
+// Start starts sending reports, if a collector has been configured.
+func (r *Reporter) Start() error {
+	var err error
+	phony.Block(r, func() {
+		err = r._start()
+	})
+	return err
+}
+
+func (r *Reporter) _start() error {
+	if r.isOpen {
+		return errors.New("statistics reporting is already started")
+	}
+	r.config.RLock()
+	collector := r.config.StatsCollector
+	r.config.RUnlock()
+	if collector == "" {
+		return nil
+	}
+	u, err := url.Parse(collector)
+	if err != nil {
+		return fmt.Errorf("invalid StatsCollector: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("StatsCollector must be an HTTP or HTTPS URL, not %q", collector)
+	}
+	r.collector = collector
+	r.started = time.Now()
+	r.isOpen = true
+	// The first report is sent at a random point in the first interval, so
+	// that the collector doesn't learn when the node started and so that
+	// nodes which start together don't all report at once.
+	r.timer = time.AfterFunc(time.Duration(rand.Int63n(int64(reportInterval))), func() {
+		r.Act(nil, r._report)
+	})
+	r.log.Infoln("Sending anonymous statistics to", collector)
+	return nil
+}
+
+// IsStarted returns true if reports are being sent.
+func (r *Reporter) IsStarted() bool {
+	var isOpen bool
+	phony.Block(r, func() {
+		isOpen = r.isOpen
+	})
+	return isOpen
+}
+
+// Stop stops sending reports.
+func (r *Reporter) Stop() error {
+	phony.Block(r, func() {
+		if !r.isOpen {
+			return
+		}
+		r.isOpen = false
+		r.timer.Stop()
+	})
+	return nil
+}
+
+// Report returns the statistics that would be sent to the collector now, so
+// that they can be checked before opting in.
+func (r *Reporter) Report() Report {
+	var report Report
+	phony.Block(r, func() {
+		report = r._build()
+	})
+	return report
+}
+
+func (r *Reporter) _build() Report {
+	var uptime time.Duration
+	if r.isOpen {
+		uptime = time.Since(r.started)
+	}
+	return Report{
+		BuildName:     version.BuildName(),
+		BuildVersion:  version.BuildVersion(),
+		BuildPlatform: runtime.GOOS,
+		BuildArch:     runtime.GOARCH,
+		Peers:         len(r.core.GetPeers()),
+		Uptime:        uint64(uptime / time.Hour),
+	}
+}
+
+func (r *Reporter) _report() {
+	if !r.isOpen {
+		return
+	}
+	go r.send(r.collector, r._build())
+	r.timer = time.AfterFunc(reportInterval, func() {
+		r.Act(nil, r._report)
+	})
+}
+
+func (r *Reporter) send(collector string, report Report) {
+	bs, err := json.Marshal(report)
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, collector, bytes.NewReader(bs))
+	if err != nil {
+		r.log.Debugln("Failed to send statistics:", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := r.client.Do(req)
+	if err != nil {
+		r.log.Debugln("Failed to send statistics:", err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		r.log.Debugln("Statistics collector returned", res.Status)
+	}
+}
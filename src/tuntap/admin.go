@@ -2,6 +2,8 @@ package tuntap
 
 import (
 	"encoding/json"
+	"errors"
+	"net"
 
 	"github.com/yggdrasil-network/yggdrasil-go/src/admin"
 )
@@ -22,6 +24,37 @@ func (t *TunAdapter) getTUNHandler(req *GetTUNRequest, res *GetTUNResponse) erro
 	return nil
 }
 
+type AddIPv4MappingRequest struct {
+	Address string `json:"address"`
+	IPv4    string `json:"ipv4,omitempty"`
+}
+type AddIPv4MappingResponse struct {
+	IPv4 string `json:"ipv4"`
+}
+
+func (t *TunAdapter) addIPv4MappingHandler(req *AddIPv4MappingRequest, res *AddIPv4MappingResponse) error {
+	var v4 net.IP
+	if req.IPv4 != "" {
+		if v4 = net.ParseIP(req.IPv4); v4 == nil {
+			return errors.New("invalid IPv4 address")
+		}
+	}
+	mapped, err := t.ipv4.add(v4, net.ParseIP(req.Address))
+	if err != nil {
+		return err
+	}
+	res.IPv4 = mapped.String()
+	return nil
+}
+
+type GetIPv4MappingsRequest struct{}
+type GetIPv4MappingsResponse map[string]string
+
+func (t *TunAdapter) getIPv4MappingsHandler(req *GetIPv4MappingsRequest, res *GetIPv4MappingsResponse) error {
+	*res = t.ipv4.mappings()
+	return nil
+}
+
 func (t *TunAdapter) SetupAdminHandlers(a *admin.AdminSocket) {
 	_ = a.AddHandler("getTunTap", []string{}, func(in json.RawMessage) (interface{}, error) {
 		req := &GetTUNRequest{}
@@ -34,4 +67,26 @@ func (t *TunAdapter) SetupAdminHandlers(a *admin.AdminSocket) {
 		}
 		return res, nil
 	})
+	_ = a.AddHandler("addIPv4Mapping", []string{"address", "ipv4"}, func(in json.RawMessage) (interface{}, error) {
+		req := &AddIPv4MappingRequest{}
+		res := &AddIPv4MappingResponse{}
+		if err := json.Unmarshal(in, &req); err != nil {
+			return nil, err
+		}
+		if err := t.addIPv4MappingHandler(req, res); err != nil {
+			return nil, err
+		}
+		return res, nil
+	})
+	_ = a.AddHandler("getIPv4Mappings", []string{}, func(in json.RawMessage) (interface{}, error) {
+		req := &GetIPv4MappingsRequest{}
+		res := &GetIPv4MappingsResponse{}
+		if err := json.Unmarshal(in, &req); err != nil {
+			return nil, err
+		}
+		if err := t.getIPv4MappingsHandler(req, res); err != nil {
+			return nil, err
+		}
+		return res, nil
+	})
 }
@@ -17,6 +17,20 @@ func (tun *TunAdapter) read() {
 		begin := TUN_OFFSET_BYTES
 		end := begin + n
 		bs := buf[begin:end]
+		if bs[0]>>4 == 4 && tun.ipv4.enabled() {
+			mtu := int(tun.rwc.MTU())
+			if len(bs)+20 > mtu {
+				if reply := fragmentationNeeded(bs, mtu-20); reply != nil {
+					_, _ = tun.iface.Write(append(make([]byte, TUN_OFFSET_BYTES), reply...), TUN_OFFSET_BYTES)
+				}
+				continue
+			}
+			var err error
+			if bs, err = tun.ipv4.toIPv6(bs); err != nil {
+				tun.log.Debugln("Unable to translate IPv4 packet:", err)
+				continue
+			}
+		}
 		if _, err := tun.rwc.Write(bs); err != nil {
 			tun.log.Debugln("Unable to send packet:", err)
 		}
@@ -35,6 +49,12 @@ func (tun *TunAdapter) write() {
 		if !tun.isEnabled {
 			continue // Nothing to do, the tun isn't enabled
 		}
+		if out, mapped := tun.ipv4.fromIPv6(bs[:n]); mapped {
+			if out == nil {
+				continue // Addressed to the IPv4 range but not translatable
+			}
+			n = copy(bs, out)
+		}
 		bs = buf[:TUN_OFFSET_BYTES+n]
 		if _, err = tun.iface.Write(bs, TUN_OFFSET_BYTES); err != nil {
 			tun.Act(nil, func() {
@@ -0,0 +1,345 @@
+package tuntap
+
+// This translates between IPv4 packets on the TUN adapter and IPv6 packets to
+// and from the mesh, so that IPv4-only applications can reach mesh services
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/address"
+)
+
+// Translated traffic is sent from subnet::ffff:a.b.c.d, where a.b.c.d is the
+// IPv4 source address, so that replies can be told apart from native traffic
+// and translated back without keeping any per-flow state.
+var ipv4MappedInfix = []byte{0, 0, 0xff, 0xff}
+
+type ipv4Map struct {
+	mutex  sync.RWMutex
+	prefix *net.IPNet // The IPv4 range, nil if translation is disabled
+	local  [4]byte    // The IPv4 address of the TUN adapter
+	subnet address.Subnet
+	to     map[[4]byte][16]byte
+	from   map[[16]byte][4]byte
+}
+
+func (m *ipv4Map) init(cidr string, table map[string]string, subnet address.Subnet) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.prefix = nil
+	if cidr == "" {
+		return nil
+	}
+	ip, prefix, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	if ip.To4() == nil {
+		return fmt.Errorf("%s is not an IPv4 address", cidr)
+	}
+	copy(m.local[:], ip.To4())
+	m.prefix = prefix
+	m.subnet = subnet
+	m.to = make(map[[4]byte][16]byte)
+	m.from = make(map[[16]byte][4]byte)
+	for v4, v6 := range table {
+		if _, err := m._add(net.ParseIP(v4), net.ParseIP(v6)); err != nil {
+			return fmt.Errorf("IPv4 mapping %s: %w", v4, err)
+		}
+	}
+	return nil
+}
+
+func (m *ipv4Map) enabled() bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.prefix != nil
+}
+
+func (m *ipv4Map) address() *net.IPNet {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return &net.IPNet{IP: net.IP(append([]byte(nil), m.local[:]...)), Mask: m.prefix.Mask}
+}
+
+// add maps the IPv4 address to the Yggdrasil address, or maps the Yggdrasil
+// address to a free IPv4 address in the range if v4 is nil. It returns the
+// IPv4 address that the Yggdrasil address is mapped to.
+func (m *ipv4Map) add(v4, v6 net.IP) (net.IP, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.prefix == nil {
+		return nil, errors.New("IPv4 translation is not enabled")
+	}
+	return m._add(v4, v6)
+}
+
+func (m *ipv4Map) _add(v4, v6 net.IP) (net.IP, error) {
+	var key [16]byte
+	if v6 == nil || v6.To4() != nil {
+		return nil, errors.New("invalid Yggdrasil address")
+	}
+	copy(key[:], v6.To16())
+	var addr address.Address
+	var snet address.Subnet
+	copy(addr[:], key[:])
+	copy(snet[:], key[:])
+	if !addr.IsValid() && !snet.IsValid() {
+		return nil, fmt.Errorf("%s is not a Yggdrasil address", v6)
+	}
+	if existing, ok := m.from[key]; ok {
+		if v4 == nil || v4.To4().Equal(net.IP(existing[:])) {
+			return net.IP(append([]byte(nil), existing[:]...)), nil
+		}
+		return nil, fmt.Errorf("%s is already mapped to %s", v6, net.IP(existing[:]))
+	}
+	if v4 == nil {
+		if v4 = m._free(); v4 == nil {
+			return nil, errors.New("no free IPv4 addresses")
+		}
+	}
+	var mapped [4]byte
+	if v4.To4() == nil || !m.prefix.Contains(v4) {
+		return nil, fmt.Errorf("%s is not within %s", v4, m.prefix)
+	}
+	copy(mapped[:], v4.To4())
+	if mapped == m.local {
+		return nil, fmt.Errorf("%s is the address of the TUN adapter", v4)
+	}
+	if _, ok := m.to[mapped]; ok {
+		return nil, fmt.Errorf("%s is already mapped", v4)
+	}
+	m.to[mapped] = key
+	m.from[key] = mapped
+	return net.IP(append([]byte(nil), mapped[:]...)), nil
+}
+
+// _free returns the lowest unmapped host address in the range.
+func (m *ipv4Map) _free() net.IP {
+	base := binary.BigEndian.Uint32(m.prefix.IP.To4())
+	ones, bits := m.prefix.Mask.Size()
+	size := uint32(1) << uint(bits-ones)
+	for n := uint32(1); n+1 < size && n < 1<<16; n++ {
+		var candidate [4]byte
+		binary.BigEndian.PutUint32(candidate[:], base+n)
+		if _, ok := m.to[candidate]; ok || candidate == m.local {
+			continue
+		}
+		return net.IP(candidate[:])
+	}
+	return nil
+}
+
+func (m *ipv4Map) mappings() map[string]string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	mappings := make(map[string]string, len(m.to))
+	for v4, v6 := range m.to {
+		mappings[net.IP(v4[:]).String()] = net.IP(v6[:]).String()
+	}
+	return mappings
+}
+
+// toIPv6 translates an IPv4 packet from the TUN adapter into an IPv6 packet.
+// Only TCP, UDP and ICMP echo are translated, and fragments are rejected.
+func (m *ipv4Map) toIPv6(bs []byte) ([]byte, error) {
+	if len(bs) < 20 || bs[0]>>4 != 4 {
+		return nil, errors.New("not an IPv4 packet")
+	}
+	ihl := int(bs[0]&0x0f) * 4
+	total := int(binary.BigEndian.Uint16(bs[2:4]))
+	if ihl < 20 || total < ihl || total > len(bs) {
+		return nil, errors.New("malformed IPv4 packet")
+	}
+	bs = bs[:total]
+	if binary.BigEndian.Uint16(bs[6:8])&0x3fff != 0 {
+		return nil, errors.New("fragmented IPv4 packets are not translated")
+	}
+	var src, dst [4]byte
+	copy(src[:], bs[12:16])
+	copy(dst[:], bs[16:20])
+	m.mutex.RLock()
+	v6, ok := m.to[dst]
+	inRange := m.prefix != nil && m.prefix.Contains(net.IP(src[:]))
+	subnet := m.subnet
+	m.mutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no mapping for %s", net.IP(dst[:]))
+	}
+	if !inRange {
+		return nil, fmt.Errorf("incorrect source address: %s", net.IP(src[:]))
+	}
+	payload := bs[ihl:]
+	out := make([]byte, 40+len(payload))
+	out[0] = 0x60 | bs[1]>>4 // Traffic class from the type of service
+	out[1] = bs[1] << 4
+	binary.BigEndian.PutUint16(out[4:6], uint16(len(payload)))
+	out[6] = bs[9]
+	out[7] = bs[8]
+	copy(out[8:16], subnet[:])
+	copy(out[16:20], ipv4MappedInfix)
+	copy(out[20:24], src[:])
+	copy(out[24:40], v6[:])
+	copy(out[40:], payload)
+	body := out[40:]
+	switch bs[9] {
+	case 6: // TCP
+		if len(body) < 20 {
+			return nil, errors.New("truncated TCP header")
+		}
+		setChecksum(body, 16, pseudoHeader6(out, 6))
+	case 17: // UDP
+		if len(body) < 8 {
+			return nil, errors.New("truncated UDP header")
+		}
+		setChecksum(body, 6, pseudoHeader6(out, 17))
+		if body[6] == 0 && body[7] == 0 {
+			body[6], body[7] = 0xff, 0xff
+		}
+	case 1: // ICMP
+		if len(body) < 8 || body[1] != 0 {
+			return nil, errors.New("untranslatable ICMP message")
+		}
+		switch body[0] {
+		case 8:
+			body[0] = 128 // Echo request
+		case 0:
+			body[0] = 129 // Echo reply
+		default:
+			return nil, errors.New("untranslatable ICMP message")
+		}
+		out[6] = 58
+		setChecksum(body, 2, pseudoHeader6(out, 58))
+	default:
+		return nil, fmt.Errorf("protocol %d is not translated", bs[9])
+	}
+	return out, nil
+}
+
+// fromIPv6 translates an IPv6 packet from the mesh into an IPv4 packet for
+// the TUN adapter. If the packet is not addressed to the translated range
+// then mapped is false and the packet should be delivered as it is.
+func (m *ipv4Map) fromIPv6(bs []byte) (out []byte, mapped bool) {
+	if len(bs) < 40 || bs[0]>>4 != 6 {
+		return nil, false
+	}
+	m.mutex.RLock()
+	if m.prefix == nil || string(bs[24:32]) != string(m.subnet[:]) || string(bs[32:36]) != string(ipv4MappedInfix) {
+		m.mutex.RUnlock()
+		return nil, false
+	}
+	var key [16]byte
+	copy(key[:], bs[8:24])
+	src, ok := m.from[key]
+	inRange := m.prefix.Contains(net.IP(bs[36:40]))
+	m.mutex.RUnlock()
+	length := int(binary.BigEndian.Uint16(bs[4:6]))
+	if !ok || !inRange || 40+length > len(bs) {
+		return nil, true
+	}
+	payload := bs[40 : 40+length]
+	out = make([]byte, 20+len(payload))
+	out[0] = 0x45
+	out[1] = bs[0]<<4 | bs[1]>>4
+	binary.BigEndian.PutUint16(out[2:4], uint16(len(out)))
+	out[6] = 0x40 // Don't fragment
+	out[8] = bs[7]
+	out[9] = bs[6]
+	copy(out[12:16], src[:])
+	copy(out[16:20], bs[36:40])
+	copy(out[20:], payload)
+	body := out[20:]
+	switch bs[6] {
+	case 6:
+		if len(body) < 20 {
+			return nil, true
+		}
+		setChecksum(body, 16, pseudoHeader4(out, 6))
+	case 17:
+		if len(body) < 8 {
+			return nil, true
+		}
+		setChecksum(body, 6, pseudoHeader4(out, 17))
+		if body[6] == 0 && body[7] == 0 {
+			body[6], body[7] = 0xff, 0xff
+		}
+	case 58:
+		if len(body) < 8 || body[1] != 0 {
+			return nil, true
+		}
+		switch body[0] {
+		case 128:
+			body[0] = 8
+		case 129:
+			body[0] = 0
+		default:
+			return nil, true
+		}
+		out[9] = 1
+		setChecksum(body, 2, 0)
+	default:
+		return nil, true
+	}
+	setChecksum(out[:20], 10, 0)
+	return out, true
+}
+
+// fragmentationNeeded builds the ICMP error that tells the sender of an IPv4
+// packet that it is too large to translate, so that path MTU discovery works.
+func fragmentationNeeded(bs []byte, mtu int) []byte {
+	if len(bs) < 20 || bs[6]&0x40 == 0 {
+		return nil // Only packets with the don't fragment bit set
+	}
+	quote := int(bs[0]&0x0f)*4 + 8
+	if quote > len(bs) {
+		quote = len(bs)
+	}
+	out := make([]byte, 28+quote)
+	out[0] = 0x45
+	binary.BigEndian.PutUint16(out[2:4], uint16(len(out)))
+	out[8] = 64
+	out[9] = 1
+	copy(out[12:16], bs[16:20])
+	copy(out[16:20], bs[12:16])
+	setChecksum(out[:20], 10, 0)
+	body := out[20:]
+	body[0], body[1] = 3, 4 // Destination unreachable, fragmentation needed
+	binary.BigEndian.PutUint16(body[6:8], uint16(mtu))
+	copy(body[8:], bs[:quote])
+	setChecksum(body, 2, 0)
+	return out
+}
+
+func pseudoHeader6(packet []byte, proto byte) uint32 {
+	sum := checksumAdd(0, packet[8:40])
+	return sum + uint32(len(packet)-40) + uint32(proto)
+}
+
+func pseudoHeader4(packet []byte, proto byte) uint32 {
+	sum := checksumAdd(0, packet[12:20])
+	return sum + uint32(len(packet)-20) + uint32(proto)
+}
+
+func checksumAdd(sum uint32, bs []byte) uint32 {
+	for i := 0; i+1 < len(bs); i += 2 {
+		sum += uint32(bs[i])<<8 | uint32(bs[i+1])
+	}
+	if len(bs)%2 == 1 {
+		sum += uint32(bs[len(bs)-1]) << 8
+	}
+	return sum
+}
+
+// setChecksum fills in the internet checksum at the given offset in bs.
+func setChecksum(bs []byte, offset int, initial uint32) {
+	bs[offset], bs[offset+1] = 0, 0
+	sum := checksumAdd(initial, bs)
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	binary.BigEndian.PutUint16(bs[offset:offset+2], ^uint16(sum))
+}
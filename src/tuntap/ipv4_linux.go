@@ -0,0 +1,20 @@
+//go:build !mobile
+// +build !mobile
+
+package tuntap
+
+import (
+	"net"
+
+	"github.com/vishvananda/netlink"
+)
+
+// Adds the IPv4 address to the TUN adapter, which also gives us the route to
+// the rest of the translated range.
+func (tun *TunAdapter) setupIPv4Address(addr *net.IPNet) error {
+	link, err := netlink.LinkByName(tun.Name())
+	if err != nil {
+		return err
+	}
+	return netlink.AddrReplace(link, &netlink.Addr{IPNet: addr})
+}
@@ -0,0 +1,13 @@
+//go:build !linux || mobile
+// +build !linux mobile
+
+package tuntap
+
+import (
+	"errors"
+	"net"
+)
+
+func (tun *TunAdapter) setupIPv4Address(addr *net.IPNet) error {
+	return errors.New("IPv4 addresses must be added to the TUN adapter manually on this platform")
+}
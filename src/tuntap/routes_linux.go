@@ -0,0 +1,205 @@
+//go:build !mobile
+// +build !mobile
+
+package tuntap
+
+import (
+	"encoding/hex"
+	"net"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/address"
+	"github.com/yggdrasil-network/yggdrasil-go/src/core"
+)
+
+// Routes installed by the route manager are tagged with this protocol number,
+// so that they can be told apart from routes added by the kernel, by other
+// daemons or by hand, and so that only our own routes are ever removed.
+const routeProtocol = 121
+
+const routeSyncInterval = 10 * time.Second
+
+// The route manager keeps kernel routes for the Yggdrasil prefix and for each
+// directly connected peer pointing at the TUN adapter, for when IfRoutes is
+// enabled. If IfExportTable is set, it also keeps the routes that a routing
+// daemon should redistribute in that table. Routes are synced periodically,
+// so that peers coming and going are reflected and any routes removed by
+// something else are put back.
+type routeManager struct {
+	tun     *TunAdapter
+	link    netlink.Link
+	main    bool
+	export  int
+	allowed map[string]struct{} // Hex keys from AllowedPublicKeys, if any
+	stop    chan struct{}
+}
+
+func (r *routeManager) start(tun *TunAdapter) error {
+	link, err := netlink.LinkByName(tun.Name())
+	if err != nil {
+		return err
+	}
+	r.tun = tun
+	r.link = link
+	r.main = tun.config.IfRoutes
+	r.export = tun.config.IfExportTable
+	r.allowed = make(map[string]struct{})
+	for _, key := range tun.config.AllowedPublicKeys {
+		r.allowed[key] = struct{}{}
+	}
+	r.stop = make(chan struct{})
+	if err := r.syncAll(); err != nil {
+		return err
+	}
+	go func() {
+		ticker := time.NewTicker(routeSyncInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+				if err := r.syncAll(); err != nil {
+					r.tun.log.Warnln("Failed to sync TUN routes:", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (r *routeManager) syncAll() error {
+	peers := r.tun.rwc.Core().GetPeers()
+	if r.main {
+		if err := r.sync(unix.RT_TABLE_MAIN, r.mainRoutes(peers)); err != nil {
+			return err
+		}
+	}
+	if r.export != 0 {
+		if err := r.sync(r.export, r.exportRoutes(peers)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func overlayPrefix() *net.IPNet {
+	prefix := address.GetPrefix()
+	return &net.IPNet{
+		IP:   append(prefix[:], make([]byte, net.IPv6len-len(prefix))...),
+		Mask: net.CIDRMask(8*len(prefix)-1, 8*net.IPv6len),
+	}
+}
+
+func subnetPrefix(snet *address.Subnet) *net.IPNet {
+	return &net.IPNet{
+		IP:   append(snet[:], make([]byte, net.IPv6len-len(snet))...),
+		Mask: net.CIDRMask(8*len(snet), 8*net.IPv6len),
+	}
+}
+
+// mainRoutes returns the routes that send traffic for the Yggdrasil prefix and
+// for each peer address to the TUN adapter.
+func (r *routeManager) mainRoutes(peers []core.Peer) []*netlink.Route {
+	routes := []*netlink.Route{r.tunRoute(overlayPrefix())}
+	for _, peer := range peers {
+		addr := address.AddrForKey(peer.Key)
+		dst := &net.IPNet{IP: net.IP(addr[:]), Mask: net.CIDRMask(8*net.IPv6len, 8*net.IPv6len)}
+		routes = append(routes, r.tunRoute(dst))
+	}
+	return routes
+}
+
+// exportRoutes returns the routes that a routing daemon should redistribute:
+// the Yggdrasil prefix, the subnet of each permitted peer and our own subnet.
+// Our own subnet is normally routed onto a LAN rather than to the TUN adapter,
+// so it is exported as unreachable and only serves as an announcement.
+func (r *routeManager) exportRoutes(peers []core.Peer) []*netlink.Route {
+	routes := []*netlink.Route{r.tunRoute(overlayPrefix())}
+	for _, peer := range peers {
+		if _, ok := r.allowed[hex.EncodeToString(peer.Key)]; len(r.allowed) > 0 && !ok {
+			continue
+		}
+		routes = append(routes, r.tunRoute(subnetPrefix(address.SubnetForKey(peer.Key))))
+	}
+	snet := r.tun.rwc.Subnet()
+	routes = append(routes, &netlink.Route{
+		Dst:      subnetPrefix(&snet),
+		Type:     unix.RTN_UNREACHABLE,
+		Protocol: routeProtocol,
+	})
+	for _, route := range routes {
+		route.Table = r.export
+	}
+	return routes
+}
+
+func (r *routeManager) tunRoute(dst *net.IPNet) *netlink.Route {
+	return &netlink.Route{
+		LinkIndex: r.link.Attrs().Index,
+		Dst:       dst,
+		Protocol:  routeProtocol,
+	}
+}
+
+func (r *routeManager) installed(table int) ([]netlink.Route, error) {
+	filter := &netlink.Route{
+		Table:    table,
+		Protocol: routeProtocol,
+	}
+	return netlink.RouteListFiltered(netlink.FAMILY_V6, filter, netlink.RT_FILTER_TABLE|netlink.RT_FILTER_PROTOCOL)
+}
+
+// sync makes the routes we have installed in the table match those given.
+func (r *routeManager) sync(table int, routes []*netlink.Route) error {
+	want := make(map[string]*netlink.Route, len(routes))
+	for _, route := range routes {
+		want[route.Dst.String()] = route
+	}
+	current, err := r.installed(table)
+	if err != nil {
+		return err
+	}
+	for i := range current {
+		route := &current[i]
+		if route.Dst != nil {
+			if w, ok := want[route.Dst.String()]; ok && (w.LinkIndex == 0 || w.LinkIndex == route.LinkIndex) {
+				delete(want, route.Dst.String())
+				continue
+			}
+		}
+		if err := netlink.RouteDel(route); err != nil {
+			return err
+		}
+	}
+	for _, route := range want {
+		if err := netlink.RouteReplace(route); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Removes every route that the route manager installed.
+func (r *routeManager) shutdown() {
+	if r.stop == nil {
+		return
+	}
+	close(r.stop)
+	r.stop = nil
+	for _, table := range []int{unix.RT_TABLE_MAIN, r.export} {
+		if table == 0 {
+			continue
+		}
+		current, err := r.installed(table)
+		if err != nil {
+			continue
+		}
+		for i := range current {
+			_ = netlink.RouteDel(&current[i])
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux || mobile
+// +build !linux mobile
+
+package tuntap
+
+type routeManager struct{}
+
+func (r *routeManager) start(tun *TunAdapter) error {
+	tun.log.Warnln("Warning: IfRoutes and IfExportTable are not supported on this platform, you must manage routes for", tun.Name(), "yourself")
+	return nil
+}
+
+func (r *routeManager) shutdown() {}
@@ -39,6 +39,8 @@ type TunAdapter struct {
 	subnet      address.Subnet
 	mtu         uint64
 	iface       tun.Device
+	routes      routeManager
+	ipv4        ipv4Map
 	phony.Inbox // Currently only used for _handlePacket from the reader, TODO: all the stuff that currently needs a mutex below
 	//mutex        sync.RWMutex // Protects the below
 	isOpen    bool
@@ -139,6 +141,20 @@ func (tun *TunAdapter) _start() error {
 		tun.log.Warnf("Warning: Interface MTU %d automatically adjusted to %d (supported range is 1280-%d)", tun.config.IfMTU, tun.MTU(), MaximumMTU())
 	}
 	tun.rwc.SetMTU(tun.MTU())
+	if err := tun.ipv4.init(tun.config.IfIPv4Address, tun.config.IfIPv4Map, tun.subnet); err != nil {
+		tun.log.Errorln("Failed to set up IPv4 translation:", err)
+	} else if tun.ipv4.enabled() {
+		if err := tun.setupIPv4Address(tun.ipv4.address()); err != nil {
+			tun.log.Errorln("Failed to add IPv4 address to TUN:", err)
+		} else {
+			tun.log.Infof("Interface IPv4: %s", tun.ipv4.address())
+		}
+	}
+	if tun.config.IfRoutes || tun.config.IfExportTable != 0 {
+		if err := tun.routes.start(tun); err != nil {
+			tun.log.Errorln("Failed to set up TUN routes:", err)
+		}
+	}
 	tun.isOpen = true
 	tun.isEnabled = true
 	go tun.read()
@@ -167,6 +183,7 @@ func (tun *TunAdapter) Stop() error {
 
 func (tun *TunAdapter) _stop() error {
 	tun.isOpen = false
+	tun.routes.shutdown()
 	// by TUN, e.g. readers/writers, sessions
 	if tun.iface != nil {
 		// Just in case we failed to start up the iface for some reason, this can apparently happen on Windows
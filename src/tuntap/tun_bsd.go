@@ -4,7 +4,7 @@
 package tuntap
 
 import (
-	"encoding/binary"
+	"net"
 	"os/exec"
 	"strconv"
 	"strings"
@@ -77,7 +77,7 @@ type in6_ifreq_lifetime struct {
 func (tun *TunAdapter) setup(ifname string, addr string, mtu uint64) error {
 	iface, err := wgtun.CreateTUN(ifname, int(mtu))
 	if err != nil {
-		panic(err)
+		return err
 	}
 	tun.iface = iface
 	if mtu, err := iface.MTU(); err == nil {
@@ -97,6 +97,7 @@ func (tun *TunAdapter) setupAddress(addr string) error {
 		tun.log.Printf("Create AF_INET socket failed: %v.", err)
 		return err
 	}
+	defer unix.Close(sfd)
 
 	// Friendly output
 	tun.log.Infof("Interface name: %s", tun.Name())
@@ -114,7 +115,7 @@ func (tun *TunAdapter) setupAddress(addr string) error {
 		tun.log.Errorf("Error in SIOCSIFMTU: %v", errno)
 
 		// Fall back to ifconfig to set the MTU
-		cmd := exec.Command("ifconfig", tun.Name(), "mtu", string(tun.mtu))
+		cmd := exec.Command("ifconfig", tun.Name(), "mtu", strconv.FormatUint(tun.mtu, 10))
 		tun.log.Warnf("Using ifconfig as fallback: %v", strings.Join(cmd.Args, " "))
 		output, err := cmd.CombinedOutput()
 		if err != nil {
@@ -129,13 +130,12 @@ func (tun *TunAdapter) setupAddress(addr string) error {
 	copy(ar.ifr_name[:], tun.Name())
 	ar.ifru_addr.sin6_len = uint8(unsafe.Sizeof(ar.ifru_addr))
 	ar.ifru_addr.sin6_family = unix.AF_INET6
-	parts := strings.Split(strings.Split(addr, "/")[0], ":")
-	for i := 0; i < 8; i++ {
-		addr, _ := strconv.ParseUint(parts[i], 16, 16)
-		b := make([]byte, 16)
-		binary.LittleEndian.PutUint16(b, uint16(addr))
-		ar.ifru_addr.sin6_addr[i] = uint16(binary.BigEndian.Uint16(b))
+	ip, _, err := net.ParseCIDR(addr)
+	if err != nil {
+		return err
 	}
+	// The address must be stored in network byte order, whatever the host
+	copy((*[16]byte)(unsafe.Pointer(&ar.ifru_addr.sin6_addr))[:], ip.To16())
 
 	// Set the interface address
 	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(sfd), uintptr(SIOCSIFADDR_IN6), uintptr(unsafe.Pointer(&ar))); errno != 0 {
@@ -7,6 +7,8 @@ package tuntap
 
 import (
 	"encoding/binary"
+	"net"
+	"os/exec"
 	"strconv"
 	"strings"
 	"unsafe"
@@ -23,7 +25,7 @@ func (tun *TunAdapter) setup(ifname string, addr string, mtu uint64) error {
 	}
 	iface, err := wgtun.CreateTUN(ifname, int(mtu))
 	if err != nil {
-		panic(err)
+		return err
 	}
 	tun.iface = iface
 	if m, err := iface.MTU(); err == nil {
@@ -31,7 +33,31 @@ func (tun *TunAdapter) setup(ifname string, addr string, mtu uint64) error {
 	} else {
 		tun.mtu = 0
 	}
-	return tun.setupAddress(addr)
+	if err := tun.setupAddress(addr); err != nil {
+		return err
+	}
+	return tun.setupRoute(addr)
+}
+
+// Routes the Yggdrasil prefix, e.g. 200::/7, to the utun adapter. The kernel
+// normally adds this route along with the address, but not if another utun
+// adapter already has it, e.g. one left over from a crashed process, so we add
+// it explicitly. The route goes away with the adapter when it is closed.
+func (tun *TunAdapter) setupRoute(addr string) error {
+	_, ipnet, err := net.ParseCIDR(addr)
+	if err != nil {
+		return err
+	}
+	ones, _ := ipnet.Mask.Size()
+	cmd := exec.Command("route", "-q", "-n", "change", "-inet6", ipnet.IP.String(), "-prefixlen", strconv.Itoa(ones), "-interface", tun.Name())
+	if output, err := cmd.CombinedOutput(); err != nil {
+		cmd = exec.Command("route", "-q", "-n", "add", "-inet6", ipnet.IP.String(), "-prefixlen", strconv.Itoa(ones), "-interface", tun.Name())
+		if output, err = cmd.CombinedOutput(); err != nil {
+			tun.log.Errorf("Error adding route for %s: %v: %s", ipnet, err, strings.TrimSpace(string(output)))
+			return err
+		}
+	}
+	return nil
 }
 
 const (
@@ -84,6 +110,7 @@ func (tun *TunAdapter) setupAddress(addr string) error {
 		tun.log.Printf("Create AF_SYSTEM socket failed: %v.", err)
 		return err
 	}
+	defer unix.Close(fd)
 
 	var ar in6_aliasreq
 	copy(ar.ifra_name[:], tun.Name())
@@ -43,6 +43,10 @@ func (tun *TunAdapter) setup(ifname string, addr string, mtu uint64) error {
 			tun.log.Errorln("Failed to set up TUN MTU:", err)
 			return err
 		}
+		if err = tun.setupRoute(addr); err != nil {
+			tun.log.Errorln("Failed to set up TUN route:", err)
+			return err
+		}
 		if mtu, err := iface.MTU(); err == nil {
 			tun.mtu = uint64(mtu)
 		}
@@ -107,6 +111,30 @@ func (tun *TunAdapter) setupAddress(addr string) error {
 	return nil
 }
 
+// Routes the Yggdrasil prefix, e.g. 200::/7, to the TUN adapter. Windows
+// normally creates an on-link route when the address is assigned, but it will
+// not replace one left behind on a stale adapter, so we install it explicitly.
+// The route is removed again by Windows when the adapter is closed.
+func (tun *TunAdapter) setupRoute(addr string) error {
+	if tun.iface == nil || tun.Name() == "" {
+		return errors.New("Can't configure route as TUN adapter is not present")
+	}
+	intf, ok := tun.iface.(*wgtun.NativeTun)
+	if !ok {
+		return errors.New("unable to get NativeTUN")
+	}
+	_, ipnet, err := net.ParseCIDR(addr)
+	if err != nil {
+		return err
+	}
+	luid := winipcfg.LUID(intf.LUID())
+	err = luid.AddRoute(*ipnet, net.IPv6zero, 0)
+	if err == windows.ERROR_OBJECT_ALREADY_EXISTS {
+		err = nil
+	}
+	return err
+}
+
 /*
  * cleanupAddressesOnDisconnectedInterfaces
  * SPDX-License-Identifier: MIT